@@ -0,0 +1,128 @@
+package assembler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/router"
+	"github.com/zkropotkine/agent-trace/internal/wal"
+)
+
+// App is the assembled server: its routes plus the background workers and
+// connections that must be stopped on shutdown.
+type App struct {
+	Registry *router.RouteRegistry
+
+	// ingest workers write queued traces and publish them to the other
+	// workers, so they are stopped first.
+	ingest  workerGroup
+	workers workerGroup
+	broker  *pubsub.Broker
+	wal     *wal.Log
+	client  *mongo.Client
+}
+
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	aborts []func()
+}
+
+func (g *workerGroup) init(ctx context.Context) {
+	g.ctx, g.cancel = context.WithCancel(context.WithoutCancel(ctx))
+}
+
+// start runs a worker until stop.
+func (g *workerGroup) start(run func(context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		run(g.ctx)
+	}()
+}
+
+// onAbort registers a function cutting a worker's remaining work short.
+func (g *workerGroup) onAbort(abort func()) {
+	g.aborts = append(g.aborts, abort)
+}
+
+// abort cuts the remaining work short and waits for the workers to return.
+func (g *workerGroup) abort() {
+	for _, abort := range g.aborts {
+		abort()
+	}
+	g.wg.Wait()
+}
+
+// stop cancels the workers and waits for them to return or ctx to be done.
+func (g *workerGroup) stop(ctx context.Context) error {
+	g.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newApp(ctx context.Context, client *mongo.Client) *App {
+	app := &App{client: client}
+	app.ingest.init(ctx)
+	app.workers.init(ctx)
+	return app
+}
+
+// start runs a background worker until Shutdown.
+func (a *App) start(run func(context.Context)) {
+	a.workers.start(run)
+}
+
+// CloseStreams ends live tail connections so they do not hold up the drain
+// of in-flight requests.
+func (a *App) CloseStreams() {
+	if a.broker != nil {
+		a.broker.Close()
+	}
+}
+
+// Shutdown flushes the ingestion queue, spooling what cannot be written to
+// the write-ahead log, and closes the log. It then stops the background workers,
+// letting them flush queued traces and notifications, and finally
+// disconnects from MongoDB. Call it after the HTTP server has drained so
+// nothing is published to a stopped worker. If ctx expires first, the
+// queue's remaining writes are aborted and spooled, the other workers are
+// abandoned and the connection closed anyway.
+func (a *App) Shutdown(ctx context.Context) error {
+	var errs []error
+	if err := a.ingest.stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flush ingestion queue: %w", err))
+		// The log must stay open until the queue has spooled what is left.
+		a.ingest.abort()
+	}
+	if a.wal != nil {
+		if err := a.wal.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close write-ahead log: %w", err))
+		}
+	}
+	if err := a.workers.stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("stop background workers: %w", err))
+	}
+
+	if err := a.client.Disconnect(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("disconnect from MongoDB: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -2,32 +2,357 @@ package assembler
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/zkropotkine/agent-trace/config"
+	"github.com/zkropotkine/agent-trace/internal/alert"
+	"github.com/zkropotkine/agent-trace/internal/archive"
+	"github.com/zkropotkine/agent-trace/internal/blob"
 	"github.com/zkropotkine/agent-trace/internal/db"
+	"github.com/zkropotkine/agent-trace/internal/encryption"
 	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/health"
+	"github.com/zkropotkine/agent-trace/internal/importer"
+	"github.com/zkropotkine/agent-trace/internal/ingest"
+	"github.com/zkropotkine/agent-trace/internal/middleware"
+	"github.com/zkropotkine/agent-trace/internal/otlp"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/ratelimit"
+	"github.com/zkropotkine/agent-trace/internal/replay"
 	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/retention"
 	"github.com/zkropotkine/agent-trace/internal/router"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+	"github.com/zkropotkine/agent-trace/internal/wal"
 )
 
-func BuildApp(ctx context.Context, cfg *config.Config) *router.RouteRegistry {
-	mongo := cfg.Mongo
+// BuildApp connects to MongoDB, wires the handlers and starts the background
+// workers. Workers run until App.Shutdown, independently of ctx, so they can
+// flush what in-flight requests publish while the HTTP server drains.
+func BuildApp(ctx context.Context, cfg *config.Config) (app *App, err error) {
+	// storeRepo talks to Mongo directly; archives keep the stored (possibly
+	// encrypted and offloaded) representation so they can be restored byte
+	// for byte.
+	collection, err := connectCollection(ctx, cfg.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	client := collection.Database().Client()
+	// Error returns set app to nil before this runs, so shut down through a
+	// separate reference.
+	built := newApp(ctx, client)
+	app = built
+	defer func() {
+		if err != nil {
+			_ = built.Shutdown(context.WithoutCancel(ctx))
+			app = nil
+		}
+	}()
+
+	// Merging relies on the unique trace id index to apply concurrent and
+	// retried fragments once, so it cannot run without it.
+	if err := repository.EnsureIndexes(ctx, collection, cfg.Tracing.MergeSpans); err != nil {
+		if cfg.Tracing.MergeSpans {
+			return nil, fmt.Errorf("create trace indexes: %w", err)
+		}
+		log.Printf("failed to create trace indexes: %v", err)
+	}
+	storeRepo := repository.NewMongoTraceRepository(collection)
+	var store blob.Store
+	if cfg.Offload.Enabled || cfg.Archive.Enabled {
+		if store, err = buildBlobStore(cfg.Blob); err != nil {
+			return nil, err
+		}
+	}
+	// Payloads are encrypted before they are offloaded, so the blob store
+	// only ever holds ciphertext when encryption is enabled.
+	payloadRepo := storeRepo
+	if cfg.Offload.Enabled {
+		payloadRepo = repository.NewOffloadedTraceRepository(storeRepo, store, cfg.Offload.Threshold)
+	}
+	keyring, err := buildKeyring(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	traceRepo := payloadRepo
+	if keyring != nil {
+		traceRepo = repository.NewEncryptedTraceRepository(payloadRepo, keyring)
+	}
+	validator := buildValidator(cfg.Metadata, cfg.Limits)
+
+	checker := health.NewChecker(cfg.Health.Timeout)
+	checker.Register("mongo", func(ctx context.Context) error { return db.Ping(ctx, client) })
+	if store != nil {
+		checker.RegisterOptional("blob", func(ctx context.Context) error { return blob.Ping(ctx, store) })
+	}
+
+	broker := pubsub.NewBroker(cfg.Stream.Buffer)
+	app.broker = broker
+	publishers := []pubsub.Publisher{broker}
+	handlerOpts := []handler.TraceHandlerOption{
+		handler.WithBroker(broker),
+		handler.WithValidator(validator),
+	}
+	if cfg.Tracing.MergeSpans {
+		handlerOpts = append(handlerOpts, handler.WithSpanMerging())
+	}
+
+	var alertHandler handler.AlertHandler
+	if cfg.Alerting.Enabled {
+		rules, err := alert.LoadRules(cfg.Alerting.RulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("load alert rules: %w", err)
+		}
+		notifier := alert.NewWebhookNotifier(cfg.Alerting.WebhookURLs, cfg.Alerting.WebhookSecret, cfg.Alerting.MaxRetries, nil)
+		engine := alert.NewEngine(rules, notifier, cfg.Alerting.EvalInterval)
+		app.start(engine.Run)
+		publishers = append(publishers, engine)
+
+		handlerOpts = append(handlerOpts, handler.WithPublishers(engine))
+		alertHandler = handler.NewAlertHandler(engine)
+	}
+
+	if cfg.OTLP.Enabled {
+		exporter := otlp.NewExporter(otlp.Options{
+			Endpoint:       cfg.OTLP.Endpoint,
+			Headers:        cfg.OTLP.Headers,
+			ServiceName:    cfg.OTLP.ServiceName,
+			CaptureContent: cfg.OTLP.CaptureContent,
+			BatchSize:      cfg.OTLP.BatchSize,
+			FlushInterval:  cfg.OTLP.FlushInterval,
+			QueueSize:      cfg.OTLP.QueueSize,
+			MaxRetries:     cfg.OTLP.MaxRetries,
+			Client:         &http.Client{Timeout: cfg.OTLP.Timeout},
+		})
+		app.start(exporter.Run)
+		checker.RegisterOptional("otlp", exporter.Ping)
+		publishers = append(publishers, exporter)
+
+		handlerOpts = append(handlerOpts, handler.WithPublishers(exporter))
+	}
+
+	if cfg.Replay.Endpoint != "" {
+		handlerOpts = append(handlerOpts, handler.WithReplayer(replay.NewReplayer(replay.Options{
+			Endpoint: cfg.Replay.Endpoint,
+			Headers:  cfg.Replay.Headers,
+			Client:   &http.Client{Timeout: cfg.Replay.Timeout},
+		})))
+	}
+
+	var fallback ingest.Fallback
+	if cfg.WAL.Enabled {
+		walLog, err := openWAL(cfg.WAL, keyring)
+		if err != nil {
+			return nil, err
+		}
+		app.wal = walLog
+		replayer := wal.NewReplayer(walLog, traceRepo, wal.ReplayOptions{
+			Interval:    cfg.WAL.ReplayInterval,
+			MergeSpans:  cfg.Tracing.MergeSpans,
+			Publishers:  publishers,
+			MaxAttempts: cfg.WAL.MaxAttempts,
+		})
+		app.ingest.start(replayer.Run)
+
+		fallback = walLog
+		handlerOpts = append(handlerOpts, handler.WithWAL(walLog))
+	}
+
+	var ingestHandler handler.IngestHandler
+	if cfg.Ingest.Async {
+		queue := ingest.NewQueue(traceRepo, ingest.Options{
+			Size:       cfg.Ingest.QueueSize,
+			Workers:    cfg.Ingest.Workers,
+			BatchSize:  cfg.Ingest.BatchSize,
+			MaxRetries: cfg.Ingest.MaxRetries,
+			MergeSpans: cfg.Tracing.MergeSpans,
+			Fallback:   fallback,
+			Publishers: publishers,
+		})
+		app.ingest.start(queue.Run)
+		app.ingest.onAbort(queue.Abort)
+		checker.Register("queue", queue.Check)
+
+		handlerOpts = append(handlerOpts, handler.WithQueue(queue))
+		ingestHandler = handler.NewIngestHandler(queue)
+	}
+
+	traceHandler := handler.NewTraceHandler(traceRepo, handlerOpts...)
+
+	sweeper := retention.NewSweeper(traceRepo, retention.Policy{
+		MaxAge:        cfg.Retention.MaxAge,
+		AgentMaxAge:   cfg.Retention.AgentMaxAge,
+		PayloadMaxAge: cfg.Retention.PayloadMaxAge,
+	}, cfg.Retention.SweepInterval)
+	if cfg.Retention.Enabled {
+		if cfg.Retention.SweepInterval <= 0 {
+			return nil, fmt.Errorf("retention sweep interval must be positive, got %s", cfg.Retention.SweepInterval)
+		}
+		app.start(sweeper.Run)
+	}
+
+	if cfg.Archive.Enabled {
+		archiver, err := archive.NewArchiver(storeRepo, store, archive.Options{
+			Codec:     cfg.Archive.Codec,
+			OlderThan: cfg.Archive.OlderThan,
+			Interval:  cfg.Archive.Interval,
+			BatchSize: cfg.Archive.BatchSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("build archiver: %w", err)
+		}
+		app.start(archiver.Run)
+	}
+
+	ingestLimits, importLimits := buildIngestLimits(cfg.RateLimit, cfg.Limits)
+	app.Registry = &router.RouteRegistry{
+		TraceHandler:     traceHandler,
+		RetentionHandler: handler.NewRetentionHandler(sweeper),
+		AlertHandler:     alertHandler,
+		SchemaHandler:    handler.NewSchemaHandler(),
+		ImportHandler:    handler.NewImportHandler(importer.NewImporter(traceRepo, validator, cfg.Import.BatchSize)),
+		HealthHandler:    handler.NewHealthHandler(checker),
+		IngestHandler:    ingestHandler,
+		IngestLimits:     ingestLimits,
+		ImportLimits:     importLimits,
+		TrustedProxies:   cfg.Server.TrustedProxies,
+	}
+
+	return app, nil
+}
 
-	client, err := db.NewMongoClient(mongo.URI)
+// BuildRestorer wires the archive restorer used by the restore command.
+func BuildRestorer(ctx context.Context, cfg *config.Config) (*archive.Restorer, error) {
+	store, err := buildBlobStore(cfg.Blob)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := connectCollection(ctx, cfg.Mongo)
 	if err != nil {
-		log.Fatalf("failed to connect to MongoDB: %v", err)
+		return nil, err
 	}
 
-	dbClient := client.Database(mongo.DB)
-	collection := dbClient.Collection(mongo.Collection)
+	return archive.NewRestorer(repository.NewMongoTraceRepository(collection), store), nil
+}
 
-	traceRepo := repository.NewMongoTraceRepository(collection)
-	traceHandler := handler.NewTraceHandler(traceRepo)
+// buildKeyring returns the encryption keyring, or nil when encryption is
+// disabled.
+func buildKeyring(cfg config.Encryption) (*encryption.Keyring, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
 
-	registry := &router.RouteRegistry{
-		TraceHandler: traceHandler,
+	keys, err := encryption.ParseKeys(cfg.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("parse encryption keys: %w", err)
+	}
+	keyring, err := encryption.NewKeyring(cfg.ActiveKeyID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("build encryption keyring: %w", err)
 	}
 
-	return registry
+	return keyring, nil
+}
+
+func buildValidator(cfg config.Metadata, limits config.Limits) *validation.Validator {
+	return validation.New(validation.Limits{
+		MaxKeys:        cfg.MaxKeys,
+		MaxKeyLength:   cfg.MaxKeyLength,
+		MaxValueLength: cfg.MaxValueLength,
+		MaxTags:        cfg.MaxTags,
+		MaxTagLength:   cfg.MaxTagLength,
+		MaxInputBytes:  limits.MaxInputBytes,
+		MaxOutputBytes: limits.MaxOutputBytes,
+	})
+}
+
+// buildIngestLimits returns the middleware limiting request rates and body
+// sizes on the ingestion endpoints and on bulk imports. Both share the same
+// rate limits; import bodies are capped while streaming instead of buffered.
+func buildIngestLimits(rl config.RateLimit, limits config.Limits) (ingest, imports []gin.HandlerFunc) {
+	if rl.Enabled {
+		overrides := make(map[string]ratelimit.Limit, len(rl.Projects))
+		for key, rate := range rl.Projects {
+			burst := rl.Burst
+			if rl.Rate > 0 {
+				burst = max(1, int(math.Ceil(rate*float64(rl.Burst)/rl.Rate)))
+			}
+			overrides[ratelimit.APIKey(key)] = ratelimit.Limit{Rate: rate, Burst: burst}
+		}
+		limiter := ratelimit.New(ratelimit.Limit{Rate: rl.Rate, Burst: rl.Burst}, overrides)
+		rateLimit := middleware.RateLimit(limiter)
+		ingest = append(ingest, rateLimit)
+		imports = append(imports, rateLimit)
+	}
+	if limits.MaxBodyBytes > 0 {
+		ingest = append(ingest, middleware.BodyLimit(limits.MaxBodyBytes))
+	}
+	if limits.MaxImportBytes > 0 {
+		imports = append(imports, middleware.StreamLimit(limits.MaxImportBytes))
+	}
+	return ingest, imports
+}
+
+func connectCollection(ctx context.Context, cfg config.Mongo) (*mongo.Collection, error) {
+	client, err := db.NewMongoClient(ctx, cfg.URI, cfg.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Database(cfg.DB).Collection(cfg.Collection), nil
+}
+
+func buildBlobStore(cfg config.Blob) (blob.Store, error) {
+	switch cfg.Driver {
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+		}, nil), nil
+	case "fs":
+		store, err := blob.NewFSStore(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("open blob directory: %w", err)
+		}
+		return store, nil
+	}
+
+	return nil, fmt.Errorf("unknown blob driver %q", cfg.Driver)
+}
+
+// openWAL opens the write-ahead log that keeps traces while MongoDB is
+// unavailable. With a keyring, its records are encrypted like stored traces.
+func openWAL(cfg config.WAL, keyring *encryption.Keyring) (*wal.Log, error) {
+	policy, err := wal.ParseSyncPolicy(cfg.Sync)
+	if err != nil {
+		return nil, err
+	}
+	l, err := wal.Open(cfg.Dir, wal.Options{
+		SegmentBytes: cfg.SegmentBytes,
+		Sync:         policy,
+		SyncInterval: cfg.SyncInterval,
+		Cipher:       cipher(keyring),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	return l, nil
+}
+
+// cipher returns keyring as a wal.Cipher, keeping a nil keyring a nil
+// interface.
+func cipher(keyring *encryption.Keyring) wal.Cipher {
+	if keyring == nil {
+		return nil
+	}
+	return keyring
 }
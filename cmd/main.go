@@ -2,14 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
 
-	"github.com/zkropotkine/agent-trace/assembler"
 	"github.com/zkropotkine/agent-trace/config"
-	"github.com/zkropotkine/agent-trace/internal/router"
+	"github.com/zkropotkine/agent-trace/internal/cli"
 	"github.com/zkropotkine/agent-trace/pkg/logger"
 )
 
+var serverCommands = []string{
+	"serve    Run the AgentTrace server (default)",
+	"restore  Re-import archived traces",
+}
+
 func main() {
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch {
+	case slices.Contains(cli.Commands(), command):
+		os.Exit(runClient(os.Args[1:]))
+	case command == "help" || command == "-h" || command == "--help":
+		cli.Usage(os.Stdout, serverCommands...)
+		return
+	case command != "serve" && command != "restore":
+		fmt.Fprintf(os.Stderr, "agenttrace: unknown command %q\n\n", command)
+		cli.Usage(os.Stderr, serverCommands...)
+		os.Exit(2)
+	}
+
 	cfg := config.LoadConfig()
 
 	// Create logger and context manager
@@ -23,14 +50,27 @@ func main() {
 	logger.DefaultLogger = baseLogger
 	ctx = logger.WithLogger(ctx, baseLogger)
 
-	// Build app dependencies
-	registry := assembler.BuildApp(ctx, cfg)
+	if command == "restore" {
+		runRestore(ctx, cfg, os.Args[2:])
+		return
+	}
 
-	// Setup router
-	app := router.SetupRouter(ctx, *registry)
+	runServe(ctx, cfg)
+}
 
-	baseLogger.Infof("AgentTrace running on %s", cfg.Port)
-	if err := app.Run(cfg.Port); err != nil {
-		baseLogger.Fatalf("failed to start server: %v", err)
+// runClient runs a command of the HTTP API client and returns the exit code.
+func runClient(args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := cli.Run(ctx, args, cli.DefaultEnv())
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 2
 	}
+
+	fmt.Fprintf(os.Stderr, "agenttrace %s: %v\n", args[0], err)
+	return 1
 }
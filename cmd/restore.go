@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/zkropotkine/agent-trace/assembler"
+	"github.com/zkropotkine/agent-trace/config"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// runRestore re-imports archived traces, e.g. `agenttrace restore -prefix 2025/05/01`.
+func runRestore(ctx context.Context, cfg *config.Config, args []string) {
+	log := logger.FromContext(ctx)
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "archive partition to restore, e.g. 2025/05/01 or 2025/05/01/DocumentAgent")
+	_ = fs.Parse(args)
+
+	restorer, err := assembler.BuildRestorer(ctx, cfg)
+	if err != nil {
+		log.Fatalf("failed to start: %v", err)
+	}
+	result, err := restorer.Restore(ctx, *prefix)
+	if err != nil {
+		log.Fatalf("restore failed: %v", err)
+	}
+
+	log.Infof("restored %d traces from %d archives (%d already present)", result.Restored, result.Objects, result.Skipped)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zkropotkine/agent-trace/assembler"
+	"github.com/zkropotkine/agent-trace/config"
+	"github.com/zkropotkine/agent-trace/internal/router"
+	"github.com/zkropotkine/agent-trace/internal/server"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// runServe starts the API server and dashboard. On SIGINT or SIGTERM it
+// drains in-flight requests, flushes the background pipelines and
+// disconnects from MongoDB before returning.
+func runServe(ctx context.Context, cfg *config.Config) {
+	log := logger.FromContext(ctx)
+
+	// Build app dependencies
+	app, err := assembler.BuildApp(ctx, cfg)
+	if err != nil {
+		log.Fatalf("failed to start: %v", err)
+	}
+
+	// Setup router
+	engine, err := router.SetupRouter(ctx, *app.Registry)
+	if err != nil {
+		log.Fatalf("failed to start: %v", err)
+	}
+	srv := server.New(cfg.Port, engine, server.Options{
+		DrainTimeout:      cfg.Server.DrainTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		OnShutdown:        app.CloseStreams,
+	})
+
+	signalCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("AgentTrace running on %s", cfg.Port)
+	if err := srv.Run(signalCtx); err != nil {
+		if signalCtx.Err() == nil {
+			log.Fatalf("failed to start server: %v", err)
+		}
+		log.Errorf("server did not drain cleanly: %v", err)
+	}
+
+	log.Info("shutting down background workers")
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Server.DrainTimeout)
+	defer cancel()
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("shutdown incomplete: %v", err)
+		return
+	}
+	log.Info("AgentTrace stopped")
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -9,16 +10,217 @@ import (
 const envPrefix = "AGENT_TRACE"
 
 type Config struct {
-	Env   string `envconfig:"ENV" default:"dev"`
-	Log   Log    `envconfig:"LOG"`
-	Mongo Mongo  `envconfig:"MONGO"`
-	Port  string `envconfig:"PORT" default:":8080"`
+	Env        string     `envconfig:"ENV" default:"dev"`
+	Log        Log        `envconfig:"LOG"`
+	Mongo      Mongo      `envconfig:"MONGO"`
+	Encryption Encryption `envconfig:"ENCRYPTION"`
+	Retention  Retention  `envconfig:"RETENTION"`
+	Archive    Archive    `envconfig:"ARCHIVE"`
+	Blob       Blob       `envconfig:"BLOB"`
+	Stream     Stream     `envconfig:"STREAM"`
+	Alerting   Alerting   `envconfig:"ALERTING"`
+	Tracing    Tracing    `envconfig:"TRACING"`
+	Metadata   Metadata   `envconfig:"METADATA"`
+	OTLP       OTLP       `envconfig:"OTLP"`
+	Import     Import     `envconfig:"IMPORT"`
+	Ingest     Ingest     `envconfig:"INGEST"`
+	WAL        WAL        `envconfig:"WAL"`
+	RateLimit  RateLimit  `envconfig:"RATE_LIMIT"`
+	Limits     Limits     `envconfig:"LIMITS"`
+	Offload    Offload    `envconfig:"OFFLOAD"`
+	Replay     Replay     `envconfig:"REPLAY"`
+	Server     Server     `envconfig:"SERVER"`
+	Health     Health     `envconfig:"HEALTH"`
+	Port       string     `envconfig:"PORT" default:":8080"`
 }
 
+// Server configures the HTTP server lifecycle. On SIGINT or SIGTERM the server
+// stops accepting connections and waits up to DrainTimeout for in-flight
+// requests, then up to DrainTimeout again for background pipelines to flush.
+// Client IPs are taken from X-Forwarded-For only behind TrustedProxies, IPs or
+// CIDRs; by default no proxy is trusted.
+type Server struct {
+	DrainTimeout      time.Duration `envconfig:"DRAIN_TIMEOUT" default:"20s"`
+	ReadHeaderTimeout time.Duration `envconfig:"READ_HEADER_TIMEOUT" default:"10s"`
+	TrustedProxies    []string      `envconfig:"TRUSTED_PROXIES"`
+}
+
+// Mongo configures the database. At startup the connection is retried with
+// backoff for up to ConnectTimeout before giving up.
 type Mongo struct {
-	URI        string `envconfig:"URI" default:"mongodb://localhost:27017"`
-	DB         string `envconfig:"DB" default:"agentTrace"`
-	Collection string `envconfig:"COLLECTION" default:"traces"`
+	URI            string        `envconfig:"URI" default:"mongodb://localhost:27017"`
+	DB             string        `envconfig:"DB" default:"agentTrace"`
+	Collection     string        `envconfig:"COLLECTION" default:"traces"`
+	ConnectTimeout time.Duration `envconfig:"CONNECT_TIMEOUT" default:"1m"`
+}
+
+// Encryption configures field-level encryption of prompt and output content.
+// Keys maps key IDs to base64-encoded 32-byte keys, e.g. "k1:<base64>,k2:<base64>".
+type Encryption struct {
+	Enabled     bool              `envconfig:"ENABLED" default:"false"`
+	ActiveKeyID string            `envconfig:"ACTIVE_KEY_ID"`
+	Keys        map[string]string `envconfig:"KEYS"`
+}
+
+// Retention configures automatic trace expiry. Zero durations keep data forever.
+// AgentMaxAge overrides MaxAge per agent, e.g. "DocumentAgent:168h,Planner:720h".
+type Retention struct {
+	Enabled       bool                     `envconfig:"ENABLED" default:"false"`
+	MaxAge        time.Duration            `envconfig:"MAX_AGE" default:"0"`
+	AgentMaxAge   map[string]time.Duration `envconfig:"AGENT_MAX_AGE"`
+	PayloadMaxAge time.Duration            `envconfig:"PAYLOAD_MAX_AGE" default:"0"`
+	SweepInterval time.Duration            `envconfig:"SWEEP_INTERVAL" default:"1h"`
+}
+
+// Archive configures periodic archival of old traces into the blob store.
+type Archive struct {
+	Enabled   bool          `envconfig:"ENABLED" default:"false"`
+	OlderThan time.Duration `envconfig:"OLDER_THAN" default:"720h"`
+	Interval  time.Duration `envconfig:"INTERVAL" default:"1h"`
+	BatchSize int64         `envconfig:"BATCH_SIZE" default:"500"`
+	Codec     string        `envconfig:"CODEC" default:"gzip"`
+}
+
+// Blob configures the object store shared by archival and other features.
+// Driver is either "fs" (local directory) or "s3" (any S3-compatible endpoint).
+type Blob struct {
+	Driver string `envconfig:"DRIVER" default:"fs"`
+	Dir    string `envconfig:"DIR" default:"./data/blobs"`
+	S3     S3     `envconfig:"S3"`
+}
+
+type S3 struct {
+	Endpoint  string `envconfig:"ENDPOINT"`
+	Bucket    string `envconfig:"BUCKET"`
+	Region    string `envconfig:"REGION" default:"us-east-1"`
+	AccessKey string `envconfig:"ACCESS_KEY"`
+	SecretKey string `envconfig:"SECRET_KEY"`
+}
+
+// Stream configures the live tail endpoint. Buffer is the number of traces
+// queued per client before a slow client is disconnected.
+type Stream struct {
+	Buffer int `envconfig:"BUFFER" default:"64"`
+}
+
+// Alerting configures the alert rule engine. RulesFile points to a JSON array
+// of rules; state changes are posted to every URL in WebhookURLs.
+type Alerting struct {
+	Enabled       bool          `envconfig:"ENABLED" default:"false"`
+	RulesFile     string        `envconfig:"RULES_FILE"`
+	WebhookURLs   []string      `envconfig:"WEBHOOK_URLS"`
+	WebhookSecret string        `envconfig:"WEBHOOK_SECRET"`
+	MaxRetries    int           `envconfig:"MAX_RETRIES" default:"3"`
+	EvalInterval  time.Duration `envconfig:"EVAL_INTERVAL" default:"30s"`
+}
+
+// Tracing configures distributed traces. With MergeSpans, traces posted with
+// the same trace_id by different services are stored as a single trace and
+// trace ids are unique.
+type Tracing struct {
+	MergeSpans bool `envconfig:"MERGE_SPANS" default:"false"`
+}
+
+// Metadata bounds the metadata and tags accepted on each trace and substep.
+// Lengths are in bytes.
+type Metadata struct {
+	MaxKeys        int `envconfig:"MAX_KEYS" default:"32"`
+	MaxKeyLength   int `envconfig:"MAX_KEY_LENGTH" default:"64"`
+	MaxValueLength int `envconfig:"MAX_VALUE_LENGTH" default:"256"`
+	MaxTags        int `envconfig:"MAX_TAGS" default:"32"`
+	MaxTagLength   int `envconfig:"MAX_TAG_LENGTH" default:"64"`
+}
+
+// OTLP configures forwarding of ingested traces to an OpenTelemetry collector
+// over OTLP/HTTP. Endpoint is the full traces URL, e.g.
+// "http://collector:4318/v1/traces"; Headers are sent with every request, e.g.
+// "Authorization:Bearer abc". CaptureContent includes prompts and messages in
+// span attributes.
+type OTLP struct {
+	Enabled        bool              `envconfig:"ENABLED" default:"false"`
+	Endpoint       string            `envconfig:"ENDPOINT" default:"http://localhost:4318/v1/traces"`
+	Headers        map[string]string `envconfig:"HEADERS"`
+	ServiceName    string            `envconfig:"SERVICE_NAME" default:"agent-trace"`
+	CaptureContent bool              `envconfig:"CAPTURE_CONTENT" default:"false"`
+	BatchSize      int               `envconfig:"BATCH_SIZE" default:"100"`
+	FlushInterval  time.Duration     `envconfig:"FLUSH_INTERVAL" default:"5s"`
+	QueueSize      int               `envconfig:"QUEUE_SIZE" default:"1000"`
+	MaxRetries     int               `envconfig:"MAX_RETRIES" default:"3"`
+	Timeout        time.Duration     `envconfig:"TIMEOUT" default:"10s"`
+}
+
+// Import configures bulk JSONL imports. BatchSize is the number of traces
+// inserted per write.
+type Import struct {
+	BatchSize int `envconfig:"BATCH_SIZE" default:"500"`
+}
+
+// Ingest configures asynchronous ingestion. With Async, validated traces are
+// queued and acknowledged with 202, and Workers write them in batches of up to
+// BatchSize; requests get 429 while QueueSize traces are waiting.
+type Ingest struct {
+	Async      bool `envconfig:"ASYNC" default:"false"`
+	QueueSize  int  `envconfig:"QUEUE_SIZE" default:"10000"`
+	Workers    int  `envconfig:"WORKERS" default:"4"`
+	BatchSize  int  `envconfig:"BATCH_SIZE" default:"500"`
+	MaxRetries int  `envconfig:"MAX_RETRIES" default:"3"`
+}
+
+// WAL configures the write-ahead log that keeps traces on local disk while
+// MongoDB is unavailable. Sync is "always", "interval" (fsync at most once per
+// SyncInterval) or "never". A record that fails MaxAttempts replays for any
+// reason but an outage is moved to a dead-letter file.
+type WAL struct {
+	Enabled        bool          `envconfig:"ENABLED" default:"false"`
+	Dir            string        `envconfig:"DIR" default:"./data/wal"`
+	SegmentBytes   int64         `envconfig:"SEGMENT_BYTES" default:"67108864"`
+	Sync           string        `envconfig:"SYNC" default:"interval"`
+	SyncInterval   time.Duration `envconfig:"SYNC_INTERVAL" default:"1s"`
+	ReplayInterval time.Duration `envconfig:"REPLAY_INTERVAL" default:"5s"`
+	MaxAttempts    int           `envconfig:"MAX_ATTEMPTS" default:"5"`
+}
+
+// RateLimit configures token buckets limiting ingestion requests per client,
+// identified by its API key or else its IP: Rate requests per second with
+// bursts of up to Burst. Projects overrides Rate per API key, e.g.
+// "team-a-key:200"; the burst scales with the rate.
+type RateLimit struct {
+	Enabled  bool               `envconfig:"ENABLED" default:"false"`
+	Rate     float64            `envconfig:"RATE" default:"20"`
+	Burst    int                `envconfig:"BURST" default:"40"`
+	Projects map[string]float64 `envconfig:"PROJECTS"`
+}
+
+// Limits bound ingestion requests, in bytes. MaxImportBytes applies to bulk
+// imports, which are streamed rather than buffered, MaxBodyBytes to the other
+// ingestion bodies. MaxInputBytes applies to input_prompt and substep inputs,
+// MaxOutputBytes to outputs. Zero disables a limit.
+type Limits struct {
+	MaxBodyBytes   int64 `envconfig:"MAX_BODY_BYTES" default:"10485760"`
+	MaxImportBytes int64 `envconfig:"MAX_IMPORT_BYTES" default:"1073741824"`
+	MaxInputBytes  int   `envconfig:"MAX_INPUT_BYTES" default:"1048576"`
+	MaxOutputBytes int   `envconfig:"MAX_OUTPUT_BYTES" default:"1048576"`
+}
+
+// Offload configures moving payloads larger than Threshold bytes (prompts,
+// outputs, message contents and tool call arguments and results) to the blob
+// store, keeping a reference in the trace.
+type Offload struct {
+	Enabled   bool `envconfig:"ENABLED" default:"false"`
+	Threshold int  `envconfig:"THRESHOLD" default:"262144"`
+}
+
+// Replay configures re-running recorded traces against a live agent. Replay is
+// disabled while Endpoint is empty; Headers are sent with every request.
+type Replay struct {
+	Endpoint string            `envconfig:"ENDPOINT"`
+	Headers  map[string]string `envconfig:"HEADERS"`
+	Timeout  time.Duration     `envconfig:"TIMEOUT" default:"60s"`
+}
+
+// Health configures the readiness endpoint. Timeout bounds each dependency check.
+type Health struct {
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"2s"`
 }
 
 type Log struct {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -46,6 +47,40 @@ func TestLoadConfig(t *testing.T) {
 				assert.Equal(t, "info", c.Log.Level)
 				assert.Equal(t, "text", c.Log.Format)
 				assert.Equal(t, "dev", c.Env)
+				assert.False(t, c.Encryption.Enabled)
+				assert.False(t, c.Retention.Enabled)
+				assert.Equal(t, time.Hour, c.Retention.SweepInterval)
+				assert.False(t, c.Archive.Enabled)
+				assert.Equal(t, "gzip", c.Archive.Codec)
+				assert.Equal(t, "fs", c.Blob.Driver)
+				assert.Equal(t, "./data/blobs", c.Blob.Dir)
+				assert.Equal(t, 64, c.Stream.Buffer)
+				assert.False(t, c.Alerting.Enabled)
+				assert.Equal(t, 3, c.Alerting.MaxRetries)
+				assert.Equal(t, 30*time.Second, c.Alerting.EvalInterval)
+				assert.False(t, c.Tracing.MergeSpans)
+				assert.Equal(t, 32, c.Metadata.MaxKeys)
+				assert.Equal(t, 256, c.Metadata.MaxValueLength)
+				assert.False(t, c.OTLP.Enabled)
+				assert.Equal(t, "http://localhost:4318/v1/traces", c.OTLP.Endpoint)
+				assert.Equal(t, 100, c.OTLP.BatchSize)
+				assert.Equal(t, 5*time.Second, c.OTLP.FlushInterval)
+				assert.Equal(t, 500, c.Import.BatchSize)
+				assert.Empty(t, c.Replay.Endpoint)
+				assert.Equal(t, time.Minute, c.Replay.Timeout)
+				assert.Equal(t, 20*time.Second, c.Server.DrainTimeout)
+				assert.Equal(t, time.Minute, c.Mongo.ConnectTimeout)
+				assert.Equal(t, 2*time.Second, c.Health.Timeout)
+				assert.False(t, c.Ingest.Async)
+				assert.Equal(t, 10000, c.Ingest.QueueSize)
+				assert.False(t, c.WAL.Enabled)
+				assert.Equal(t, "interval", c.WAL.Sync)
+				assert.False(t, c.RateLimit.Enabled)
+				assert.Equal(t, int64(10<<20), c.Limits.MaxBodyBytes)
+				assert.Equal(t, int64(1<<30), c.Limits.MaxImportBytes)
+				assert.Equal(t, 1<<20, c.Limits.MaxInputBytes)
+				assert.False(t, c.Offload.Enabled)
+				assert.Equal(t, 256<<10, c.Offload.Threshold)
 			},
 		},
 		{
@@ -88,6 +123,205 @@ func TestLoadConfig(t *testing.T) {
 				assert.Equal(t, "dev", c.Env)
 			},
 		},
+		{
+			name: "loads encryption keyring",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_ENCRYPTION_ENABLED":       "true",
+					"AGENT_TRACE_ENCRYPTION_ACTIVE_KEY_ID": "k2",
+					"AGENT_TRACE_ENCRYPTION_KEYS":          "k1:a2V5MQ==,k2:a2V5Mg==",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Encryption.Enabled)
+				assert.Equal(t, "k2", c.Encryption.ActiveKeyID)
+				assert.Equal(t, map[string]string{"k1": "a2V5MQ==", "k2": "a2V5Mg=="}, c.Encryption.Keys)
+			},
+		},
+		{
+			name: "loads retention policy",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_RETENTION_ENABLED":         "true",
+					"AGENT_TRACE_RETENTION_MAX_AGE":         "720h",
+					"AGENT_TRACE_RETENTION_AGENT_MAX_AGE":   "DocumentAgent:168h,Planner:0s",
+					"AGENT_TRACE_RETENTION_PAYLOAD_MAX_AGE": "72h",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Retention.Enabled)
+				assert.Equal(t, 720*time.Hour, c.Retention.MaxAge)
+				assert.Equal(t, 72*time.Hour, c.Retention.PayloadMaxAge)
+				assert.Equal(t, map[string]time.Duration{"DocumentAgent": 168 * time.Hour, "Planner": 0}, c.Retention.AgentMaxAge)
+			},
+		},
+		{
+			name: "loads archive and s3 blob store",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_ARCHIVE_ENABLED":    "true",
+					"AGENT_TRACE_ARCHIVE_OLDER_THAN": "168h",
+					"AGENT_TRACE_ARCHIVE_CODEC":      "zstd",
+					"AGENT_TRACE_BLOB_DRIVER":        "s3",
+					"AGENT_TRACE_BLOB_S3_ENDPOINT":   "http://localhost:9000",
+					"AGENT_TRACE_BLOB_S3_BUCKET":     "traces",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Archive.Enabled)
+				assert.Equal(t, 168*time.Hour, c.Archive.OlderThan)
+				assert.Equal(t, "zstd", c.Archive.Codec)
+				assert.Equal(t, "s3", c.Blob.Driver)
+				assert.Equal(t, "http://localhost:9000", c.Blob.S3.Endpoint)
+				assert.Equal(t, "traces", c.Blob.S3.Bucket)
+				assert.Equal(t, "us-east-1", c.Blob.S3.Region)
+			},
+		},
+		{
+			name: "loads alerting webhooks",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_ALERTING_ENABLED":        "true",
+					"AGENT_TRACE_ALERTING_RULES_FILE":     "/etc/agent-trace/rules.json",
+					"AGENT_TRACE_ALERTING_WEBHOOK_URLS":   "http://a.example/hook,http://b.example/hook",
+					"AGENT_TRACE_ALERTING_WEBHOOK_SECRET": "s3cret",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Alerting.Enabled)
+				assert.Equal(t, "/etc/agent-trace/rules.json", c.Alerting.RulesFile)
+				assert.Equal(t, []string{"http://a.example/hook", "http://b.example/hook"}, c.Alerting.WebhookURLs)
+				assert.Equal(t, "s3cret", c.Alerting.WebhookSecret)
+			},
+		},
+		{
+			name: "enables span merging",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{"AGENT_TRACE_TRACING_MERGE_SPANS": "true"}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Tracing.MergeSpans)
+			},
+		},
+		{
+			name: "loads metadata limits",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_METADATA_MAX_KEYS": "8",
+					"AGENT_TRACE_METADATA_MAX_TAGS": "4",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.Equal(t, 8, c.Metadata.MaxKeys)
+				assert.Equal(t, 4, c.Metadata.MaxTags)
+				assert.Equal(t, 64, c.Metadata.MaxTagLength)
+			},
+		},
+		{
+			name: "loads otlp exporter",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_OTLP_ENABLED":  "true",
+					"AGENT_TRACE_OTLP_ENDPOINT": "https://otel.example.com/v1/traces",
+					"AGENT_TRACE_OTLP_HEADERS":  "Authorization:Bearer abc,X-Tenant:ops",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.OTLP.Enabled)
+				assert.Equal(t, "https://otel.example.com/v1/traces", c.OTLP.Endpoint)
+				assert.Equal(t, map[string]string{"Authorization": "Bearer abc", "X-Tenant": "ops"}, c.OTLP.Headers)
+				assert.Equal(t, "agent-trace", c.OTLP.ServiceName)
+			},
+		},
+		{
+			name: "loads async ingestion",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_INGEST_ASYNC":      "true",
+					"AGENT_TRACE_INGEST_QUEUE_SIZE": "500",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Ingest.Async)
+				assert.Equal(t, 500, c.Ingest.QueueSize)
+				assert.Equal(t, 4, c.Ingest.Workers)
+			},
+		},
+		{
+			name: "loads write-ahead log",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_WAL_ENABLED":       "true",
+					"AGENT_TRACE_WAL_DIR":           "/var/lib/agent-trace/wal",
+					"AGENT_TRACE_WAL_SEGMENT_BYTES": "1048576",
+					"AGENT_TRACE_WAL_SYNC":          "always",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.WAL.Enabled)
+				assert.Equal(t, "/var/lib/agent-trace/wal", c.WAL.Dir)
+				assert.Equal(t, int64(1048576), c.WAL.SegmentBytes)
+				assert.Equal(t, "always", c.WAL.Sync)
+				assert.Equal(t, 5*time.Second, c.WAL.ReplayInterval)
+				assert.Equal(t, 5, c.WAL.MaxAttempts)
+			},
+		},
+		{
+			name: "loads rate limits per project",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_RATE_LIMIT_ENABLED":     "true",
+					"AGENT_TRACE_RATE_LIMIT_RATE":        "2.5",
+					"AGENT_TRACE_RATE_LIMIT_PROJECTS":    "team-a:100,batch-job:0.5",
+					"AGENT_TRACE_LIMITS_MAX_INPUT_BYTES": "4096",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.RateLimit.Enabled)
+				assert.Equal(t, 2.5, c.RateLimit.Rate)
+				assert.Equal(t, 40, c.RateLimit.Burst)
+				assert.Equal(t, map[string]float64{"team-a": 100, "batch-job": 0.5}, c.RateLimit.Projects)
+				assert.Equal(t, 4096, c.Limits.MaxInputBytes)
+			},
+		},
+		{
+			name: "loads payload offloading",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_OFFLOAD_ENABLED":   "true",
+					"AGENT_TRACE_OFFLOAD_THRESHOLD": "65536",
+					"AGENT_TRACE_BLOB_DRIVER":       "s3",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.True(t, c.Offload.Enabled)
+				assert.Equal(t, 65536, c.Offload.Threshold)
+				assert.Equal(t, "s3", c.Blob.Driver)
+			},
+		},
+		{
+			name: "loads server drain timeout",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{"AGENT_TRACE_SERVER_DRAIN_TIMEOUT": "45s"}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.Equal(t, 45*time.Second, c.Server.DrainTimeout)
+				assert.Equal(t, 10*time.Second, c.Server.ReadHeaderTimeout)
+			},
+		},
+		{
+			name: "loads replay endpoint",
+			envs: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"AGENT_TRACE_REPLAY_ENDPOINT": "http://agent:9000/run",
+					"AGENT_TRACE_REPLAY_TIMEOUT":  "2m",
+				}
+			},
+			assert: func(t *testing.T, c *Config) {
+				assert.Equal(t, "http://agent:9000/run", c.Replay.Endpoint)
+				assert.Equal(t, 2*time.Minute, c.Replay.Timeout)
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,488 @@
+package langsmith
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+)
+
+const (
+	runTypeLLM  = "llm"
+	runTypeTool = "tool"
+)
+
+// textKeys are the input and output keys LangChain uses for the main text of
+// a run, in order of preference.
+var textKeys = []string{"input", "question", "query", "prompt", "output", "answer", "text", "result"}
+
+// sessionKeys are metadata keys LangChain apps commonly use for a conversation ID.
+var sessionKeys = []string{"session_id", "thread_id", "conversation_id"}
+
+// Convert maps a run tree onto a trace: the root run becomes the trace and
+// every descendant becomes a substep, parented by run ID. Token usage of the
+// trace is the root's own usage or, failing that, the sum of its LLM runs.
+// Metadata is cut down to limits so runs with verbose metadata still validate.
+func Convert(root Run, limits validation.Limits) model.Trace {
+	trace := model.Trace{
+		TraceID:     firstNonEmpty(root.TraceID, root.ID),
+		AgentName:   root.Name,
+		SessionID:   sessionID(root.Extra.Metadata),
+		Status:      status(root),
+		InputPrompt: text(root.Inputs),
+		Output:      text(root.Outputs),
+		LatencyMS:   latencyMS(root),
+		CreatedAt:   root.StartTime.Time,
+		Error:       root.Error,
+		Tags:        root.Tags,
+		Metadata:    metadata(root.Extra.Metadata),
+		SubSteps:    []model.SubStep{},
+	}
+	if root.SessionName != "" {
+		if trace.Metadata == nil {
+			trace.Metadata = map[string]string{}
+		}
+		trace.Metadata["langsmith_project"] = root.SessionName
+	}
+	trace.Metadata = fitMetadata(trace.Metadata, limits, "langsmith_project")
+
+	switch root.RunType {
+	case runTypeLLM:
+		trace.Messages = messages(root)
+		trace.ModelParams = modelParams(root.Extra.InvocationParams)
+	case runTypeTool:
+		trace.ToolCalls = toolCalls(root)
+	}
+
+	var llmUsage model.TokenUsage
+	var walk func(parent Run)
+	walk = func(parent Run) {
+		for _, child := range parent.ChildRuns {
+			step := convertStep(child, parent.ID, limits)
+			if child.RunType == runTypeLLM && step.TokenUsage != nil {
+				llmUsage.Input += step.TokenUsage.Input
+				llmUsage.Output += step.TokenUsage.Output
+			}
+			trace.SubSteps = append(trace.SubSteps, step)
+			walk(child)
+		}
+	}
+	walk(root)
+
+	if usage := tokenUsage(root); usage != nil {
+		trace.TokenUsage = *usage
+	} else {
+		llmUsage.Total = llmUsage.Input + llmUsage.Output
+		trace.TokenUsage = llmUsage
+	}
+
+	return trace
+}
+
+func convertStep(run Run, parentID string, limits validation.Limits) model.SubStep {
+	step := model.SubStep{
+		Name:       run.Name,
+		Input:      text(run.Inputs),
+		Output:     text(run.Outputs),
+		Status:     status(run),
+		Start:      run.StartTime.Time,
+		End:        run.EndTime.Time,
+		SpanID:     run.ID,
+		ParentID:   firstNonEmpty(run.ParentRunID, parentID),
+		Error:      run.Error,
+		TokenUsage: tokenUsage(run),
+		Tags:       run.Tags,
+		Metadata:   metadata(run.Extra.Metadata),
+	}
+	if run.RunType != "" {
+		if step.Metadata == nil {
+			step.Metadata = map[string]string{}
+		}
+		step.Metadata["run_type"] = run.RunType
+	}
+	step.Metadata = fitMetadata(step.Metadata, limits, "run_type")
+
+	switch run.RunType {
+	case runTypeLLM:
+		step.Messages = messages(run)
+		step.ModelParams = modelParams(run.Extra.InvocationParams)
+	case runTypeTool:
+		step.ToolCalls = toolCalls(run)
+	}
+
+	return step
+}
+
+func status(run Run) string {
+	if run.Error != "" {
+		return "error"
+	}
+	return "success"
+}
+
+func latencyMS(run Run) int {
+	if run.StartTime.IsZero() || run.EndTime.IsZero() {
+		return 0
+	}
+	return int(run.EndTime.Sub(run.StartTime.Time).Milliseconds())
+}
+
+// tokenUsage looks for token counts wherever LangChain versions put them. The
+// total is recomputed from its parts so the trace passes validation.
+func tokenUsage(run Run) *model.TokenUsage {
+	usage := run.Extra.TokenUsage
+	if usage == nil {
+		if llmOutput, ok := run.Outputs["llm_output"]; ok {
+			var out struct {
+				TokenUsage *Usage `json:"token_usage"`
+			}
+			if json.Unmarshal(llmOutput, &out) == nil {
+				usage = out.TokenUsage
+			}
+		}
+	}
+	if usage == nil {
+		if raw, ok := run.Outputs["usage_metadata"]; ok {
+			var u Usage
+			if json.Unmarshal(raw, &u) == nil {
+				usage = &u
+			}
+		}
+	}
+	if usage == nil && (run.PromptTokens > 0 || run.CompletionTokens > 0) {
+		usage = &Usage{PromptTokens: run.PromptTokens, CompletionTokens: run.CompletionTokens}
+	}
+	if usage == nil {
+		return nil
+	}
+
+	input := max(usage.PromptTokens, usage.InputTokens)
+	output := max(usage.CompletionTokens, usage.OutputTokens)
+
+	return &model.TokenUsage{Input: input, Output: output, Total: input + output}
+}
+
+// text returns the main text of a run's inputs or outputs: a single string
+// value, a well-known text key, or else the whole object as compact JSON.
+func text(values map[string]json.RawMessage) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	if len(values) == 1 {
+		for _, raw := range values {
+			if s, ok := asString(raw); ok {
+				return s
+			}
+		}
+	}
+	for _, key := range textKeys {
+		if s, ok := asString(values[key]); ok {
+			return s
+		}
+	}
+
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+func asString(raw json.RawMessage) (string, bool) {
+	var s string
+	if len(raw) == 0 || json.Unmarshal(raw, &s) != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func sessionID(meta map[string]any) string {
+	for _, key := range sessionKeys {
+		if s, ok := meta[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// metadata stringifies LangSmith metadata, dropping keys AgentTrace cannot
+// store.
+func metadata(meta map[string]any) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+
+	out := make(map[string]string, len(meta))
+	for key, value := range meta {
+		if !validation.ValidMetadataKey(key) {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			out[key] = v
+		case nil:
+		default:
+			encoded, _ := json.Marshal(v)
+			out[key] = string(encoded)
+		}
+	}
+
+	return out
+}
+
+// fitMetadata drops keys longer than limits allow, truncates values and keeps
+// at most MaxKeys keys: the keys in own first, then the rest in order.
+func fitMetadata(meta map[string]string, limits validation.Limits, own ...string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iOwn, jOwn := slices.Contains(own, keys[i]), slices.Contains(own, keys[j])
+		if iOwn != jOwn {
+			return iOwn
+		}
+		return keys[i] < keys[j]
+	})
+
+	out := make(map[string]string, min(len(keys), limits.MaxKeys))
+	for _, key := range keys {
+		if len(out) == limits.MaxKeys {
+			break
+		}
+		if len(key) > limits.MaxKeyLength {
+			continue
+		}
+		out[key] = truncateUTF8(meta[key], limits.MaxValueLength)
+	}
+
+	return out
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func modelParams(params map[string]any) *model.ModelParams {
+	if len(params) == 0 {
+		return nil
+	}
+
+	var mp model.ModelParams
+	if name, ok := params["model"].(string); ok {
+		mp.Model = name
+	} else if name, ok := params["model_name"].(string); ok {
+		mp.Model = name
+	}
+	if v, ok := params["temperature"].(float64); ok {
+		mp.Temperature = &v
+	}
+	if v, ok := params["top_p"].(float64); ok {
+		mp.TopP = &v
+	}
+	if v, ok := params["max_tokens"].(float64); ok && v > 0 {
+		n := int(v)
+		mp.MaxTokens = &n
+	}
+
+	if mp == (model.ModelParams{}) {
+		return nil
+	}
+	return &mp
+}
+
+func toolCalls(run Run) []model.ToolCall {
+	call := model.ToolCall{
+		ID:    run.ID,
+		Name:  run.Name,
+		Error: run.Error,
+	}
+	if len(run.Inputs) > 0 {
+		call.Arguments, _ = json.Marshal(run.Inputs)
+	}
+	if raw, ok := run.Outputs["output"]; ok && len(run.Outputs) == 1 {
+		call.Result = raw
+	} else if len(run.Outputs) > 0 {
+		call.Result, _ = json.Marshal(run.Outputs)
+	}
+
+	return []model.ToolCall{call}
+}
+
+// messages reads the chat history of an LLM run from inputs.messages (or
+// inputs.prompts for completion models) and appends the first generation as
+// the assistant reply.
+func messages(run Run) []model.Message {
+	var out []model.Message
+
+	if raw, ok := run.Inputs["messages"]; ok {
+		for _, m := range flattenMessages(raw) {
+			if msg, ok := parseMessage(m); ok {
+				out = append(out, msg)
+			}
+		}
+	} else if raw, ok := run.Inputs["prompts"]; ok {
+		var prompts []string
+		if json.Unmarshal(raw, &prompts) == nil {
+			for _, p := range prompts {
+				out = append(out, model.Message{Role: "user", Content: p})
+			}
+		}
+	}
+
+	if raw, ok := run.Outputs["generations"]; ok {
+		if msg, ok := firstGeneration(raw); ok {
+			out = append(out, msg)
+		}
+	}
+
+	return out
+}
+
+// flattenMessages accepts both a message list and the batched list of lists
+// that LangChain chat models record.
+func flattenMessages(raw json.RawMessage) []json.RawMessage {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) != nil {
+		return nil
+	}
+	if len(list) > 0 && bytes.HasPrefix(bytes.TrimSpace(list[0]), []byte("[")) {
+		var inner []json.RawMessage
+		if json.Unmarshal(list[0], &inner) == nil {
+			return inner
+		}
+	}
+	return list
+}
+
+// serializedMessage covers the three shapes LangChain uses for messages:
+// OpenAI style {role, content}, message_to_dict {type, data} and the lc
+// constructor form {lc, id, kwargs}.
+type serializedMessage struct {
+	Role       string             `json:"role"`
+	Content    json.RawMessage    `json:"content"`
+	Name       string             `json:"name"`
+	ToolCallID string             `json:"tool_call_id"`
+	Type       string             `json:"type"`
+	Data       *serializedMessage `json:"data"`
+	ID         []string           `json:"id"`
+	Kwargs     *serializedMessage `json:"kwargs"`
+}
+
+var roleByType = map[string]string{
+	"system":    "system",
+	"human":     "user",
+	"user":      "user",
+	"ai":        "assistant",
+	"assistant": "assistant",
+	"tool":      "tool",
+	"function":  "tool",
+}
+
+var roleByClass = map[string]string{
+	"SystemMessage":   "system",
+	"HumanMessage":    "user",
+	"AIMessage":       "assistant",
+	"ToolMessage":     "tool",
+	"FunctionMessage": "tool",
+}
+
+func parseMessage(raw json.RawMessage) (model.Message, bool) {
+	var m serializedMessage
+	if json.Unmarshal(raw, &m) != nil {
+		return model.Message{}, false
+	}
+
+	var role string
+	body := &m
+	switch {
+	case m.Kwargs != nil && len(m.ID) > 0:
+		role = roleByClass[m.ID[len(m.ID)-1]]
+		body = m.Kwargs
+	case m.Data != nil:
+		role = roleByType[m.Type]
+		body = m.Data
+	default:
+		role = roleByType[m.Role]
+	}
+	if role == "" {
+		return model.Message{}, false
+	}
+
+	msg := model.Message{
+		Role:       role,
+		Content:    content(body.Content),
+		Name:       body.Name,
+		ToolCallID: body.ToolCallID,
+	}
+	if msg.Role == "tool" && msg.ToolCallID == "" && msg.Name == "" {
+		msg.Name = "tool"
+	}
+
+	return msg, true
+}
+
+// content flattens multi-part content into its text parts.
+func content(raw json.RawMessage) string {
+	if s, ok := asString(raw); ok {
+		return s
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &parts) == nil {
+		var texts []string
+		for _, p := range parts {
+			if p.Text != "" {
+				texts = append(texts, p.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+
+	return string(raw)
+}
+
+func firstGeneration(raw json.RawMessage) (model.Message, bool) {
+	var generations [][]struct {
+		Text    string          `json:"text"`
+		Message json.RawMessage `json:"message"`
+	}
+	if json.Unmarshal(raw, &generations) != nil || len(generations) == 0 || len(generations[0]) == 0 {
+		return model.Message{}, false
+	}
+
+	gen := generations[0][0]
+	if len(gen.Message) > 0 {
+		if msg, ok := parseMessage(gen.Message); ok {
+			return msg, true
+		}
+	}
+
+	return model.Message{Role: "assistant", Content: gen.Text}, gen.Text != ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
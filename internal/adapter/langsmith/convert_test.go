@@ -0,0 +1,170 @@
+package langsmith
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+)
+
+func loadFixture(t *testing.T, name string) []Run {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	runs, err := DecodeRuns(data)
+	require.NoError(t, err)
+	return runs
+}
+
+func TestConvert_AgentRunTree(t *testing.T) {
+	runs := loadFixture(t, "agent_run.json")
+	require.Len(t, runs, 1)
+
+	trace := Convert(runs[0], validation.DefaultLimits)
+	require.Nil(t, validation.New(validation.DefaultLimits).Validate(trace))
+
+	t.Run("root run becomes the trace", func(t *testing.T) {
+		assert.Equal(t, "8f0f6c0e-7c1a-4c55-9a1e-5d2c7f1a0001", trace.TraceID)
+		assert.Equal(t, "ResearchAgent", trace.AgentName)
+		assert.Equal(t, "thread-42", trace.SessionID)
+		assert.Equal(t, "success", trace.Status)
+		assert.Equal(t, "What changed in the privacy policy?", trace.InputPrompt)
+		assert.Equal(t, "Data retention dropped to 30 days.", trace.Output)
+		assert.Equal(t, 4500, trace.LatencyMS)
+		assert.Equal(t, time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC), trace.CreatedAt)
+		assert.Equal(t, []string{"prod"}, trace.Tags)
+		assert.Equal(t, map[string]string{
+			"thread_id":         "thread-42",
+			"ls_run_depth":      "0",
+			"experiment":        "B",
+			"langsmith_project": "research-prod",
+		}, trace.Metadata, "keys that cannot be stored are dropped")
+	})
+
+	t.Run("token usage is summed over llm runs", func(t *testing.T) {
+		assert.Equal(t, model.TokenUsage{Input: 420, Output: 30, Total: 450}, trace.TokenUsage)
+	})
+
+	t.Run("descendants become nested substeps", func(t *testing.T) {
+		require.Len(t, trace.SubSteps, 5)
+		names := make([]string, len(trace.SubSteps))
+		for i, s := range trace.SubSteps {
+			names[i] = s.Name
+		}
+		assert.Equal(t, []string{"ChatOpenAI", "web_search", "Summarize", "ChatAnthropic", "cite_sources"}, names)
+
+		summarize := trace.SubSteps[2]
+		assert.Equal(t, trace.TraceID, summarize.ParentID)
+		assert.Equal(t, summarize.SpanID, trace.SubSteps[3].ParentID)
+		assert.Equal(t, summarize.SpanID, trace.SubSteps[4].ParentID)
+		assert.Equal(t, "What changed?", summarize.Input, "well-known text keys are preferred")
+		assert.Equal(t, "chain", summarize.Metadata["run_type"])
+	})
+
+	t.Run("llm runs keep messages, params and usage", func(t *testing.T) {
+		openai := trace.SubSteps[0]
+		assert.Equal(t, []model.Message{
+			{Role: "system", Content: "You are a research assistant."},
+			{Role: "user", Content: "What changed in the privacy policy?"},
+			{Role: "assistant", Content: ""},
+		}, openai.Messages)
+		require.NotNil(t, openai.ModelParams)
+		assert.Equal(t, "gpt-4o", openai.ModelParams.Model)
+		assert.Equal(t, 0.0, *openai.ModelParams.Temperature)
+		assert.Equal(t, 512, *openai.ModelParams.MaxTokens)
+		assert.Equal(t, &model.TokenUsage{Input: 120, Output: 18, Total: 138}, openai.TokenUsage)
+		assert.Equal(t, time.Date(2025, 5, 1, 10, 0, 0, 100_000_000, time.UTC), openai.Start)
+
+		anthropic := trace.SubSteps[3]
+		assert.Equal(t, []model.Message{
+			{Role: "user", Content: "Summarize the change."},
+			{Role: "assistant", Content: "Data retention dropped to 30 days."},
+		}, anthropic.Messages)
+		assert.Equal(t, "claude-3-5-sonnet", anthropic.ModelParams.Model)
+		assert.Equal(t, &model.TokenUsage{Input: 300, Output: 12, Total: 312}, anthropic.TokenUsage)
+	})
+
+	t.Run("tool runs become tool calls", func(t *testing.T) {
+		search := trace.SubSteps[1]
+		require.Len(t, search.ToolCalls, 1)
+		assert.Equal(t, "web_search", search.ToolCalls[0].Name)
+		assert.JSONEq(t, `{"query":"privacy policy changelog"}`, string(search.ToolCalls[0].Arguments))
+		assert.JSONEq(t, `["https://example.com/policy"]`, string(search.ToolCalls[0].Result))
+
+		cite := trace.SubSteps[4]
+		assert.Equal(t, "error", cite.Status)
+		assert.Equal(t, "TimeoutError: citation service unavailable", cite.Error)
+		assert.Equal(t, cite.Error, cite.ToolCalls[0].Error)
+		assert.Empty(t, cite.ToolCalls[0].Result)
+	})
+}
+
+func TestConvert_ChatRuns(t *testing.T) {
+	runs := loadFixture(t, "chat_runs.json")
+	require.Len(t, runs, 2)
+
+	chat := Convert(runs[0], validation.DefaultLimits)
+	require.Nil(t, validation.New(validation.DefaultLimits).Validate(chat))
+	assert.Equal(t, "c0ffee00-0000-4000-8000-000000000001", chat.TraceID, "run id is used when trace_id is absent")
+	assert.Equal(t, "s-1", chat.SessionID)
+	assert.Equal(t, 750, chat.LatencyMS)
+	assert.Equal(t, model.TokenUsage{Input: 20, Output: 3, Total: 23}, chat.TokenUsage)
+	assert.Equal(t, []model.Message{
+		{Role: "system", Content: "Answer in French."},
+		{Role: "user", Content: "Hello"},
+		{Role: "tool", Content: `{"ok":true}`, ToolCallID: "call_1"},
+		{Role: "assistant", Content: "Bonjour"},
+	}, chat.Messages)
+	assert.Equal(t, "gpt-4o-mini", chat.ModelParams.Model)
+	assert.Equal(t, 0.9, *chat.ModelParams.TopP)
+	assert.Empty(t, chat.SubSteps)
+
+	legacy := Convert(runs[1], validation.DefaultLimits)
+	require.Nil(t, validation.New(validation.DefaultLimits).Validate(legacy))
+	assert.Equal(t, []model.Message{
+		{Role: "user", Content: "Translate: cat"},
+		{Role: "assistant", Content: "chat"},
+	}, legacy.Messages)
+	assert.Equal(t, model.TokenUsage{Input: 4, Output: 1, Total: 5}, legacy.TokenUsage)
+}
+
+func TestConvert_FitsMetadataToLimits(t *testing.T) {
+	meta := map[string]any{
+		"description":           strings.Repeat("é", 200),
+		strings.Repeat("k", 65): "dropped",
+	}
+	for i := range 40 {
+		meta[fmt.Sprintf("key_%02d", i)] = i
+	}
+	run := Run{
+		ID:          "root",
+		Name:        "agent",
+		SessionName: "evals",
+		Extra:       Extra{Metadata: meta},
+		ChildRuns:   []Run{{ID: "child", Name: "llm", RunType: runTypeLLM, Extra: Extra{Metadata: meta}}},
+	}
+
+	trace := Convert(run, validation.DefaultLimits)
+
+	assert.Empty(t, validation.New(validation.DefaultLimits).Validate(trace))
+	assert.Len(t, trace.Metadata, 32)
+	assert.Equal(t, "evals", trace.Metadata["langsmith_project"])
+	assert.Equal(t, strings.Repeat("é", 128), trace.Metadata["description"])
+	assert.NotContains(t, trace.Metadata, strings.Repeat("k", 65))
+	assert.Len(t, trace.SubSteps[0].Metadata, 32)
+	assert.Equal(t, runTypeLLM, trace.SubSteps[0].Metadata["run_type"])
+}
+
+func TestDecodeRuns_RejectsBadTimestamps(t *testing.T) {
+	_, err := DecodeRuns([]byte(`{"id":"x","start_time":"yesterday"}`))
+	assert.ErrorContains(t, err, "unrecognised timestamp")
+}
@@ -0,0 +1,99 @@
+// Package langsmith converts LangSmith run trees, as exported by LangChain
+// apps, into AgentTrace traces.
+package langsmith
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Run is a LangSmith run with its nested child runs. Only the fields used by
+// the conversion are decoded.
+type Run struct {
+	ID          string                     `json:"id"`
+	TraceID     string                     `json:"trace_id"`
+	ParentRunID string                     `json:"parent_run_id"`
+	Name        string                     `json:"name"`
+	RunType     string                     `json:"run_type"`
+	StartTime   Time                       `json:"start_time"`
+	EndTime     Time                       `json:"end_time"`
+	Inputs      map[string]json.RawMessage `json:"inputs"`
+	Outputs     map[string]json.RawMessage `json:"outputs"`
+	Error       string                     `json:"error"`
+	Extra       Extra                      `json:"extra"`
+	Tags        []string                   `json:"tags"`
+	SessionName string                     `json:"session_name"`
+	ChildRuns   []Run                      `json:"child_runs"`
+
+	// Newer SDKs report token counts on the run itself.
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type Extra struct {
+	Metadata         map[string]any `json:"metadata"`
+	InvocationParams map[string]any `json:"invocation_params"`
+	TokenUsage       *Usage         `json:"token_usage"`
+}
+
+// Usage accepts both the OpenAI (prompt/completion) and the LangChain
+// usage_metadata (input/output) spellings.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Time parses LangSmith timestamps, which are ISO 8601 with or without a zone
+// offset. Timestamps without an offset are UTC.
+type Time struct {
+	time.Time
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed.UTC()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("langsmith: unrecognised timestamp %q", s)
+}
+
+// DecodeRuns accepts a single run tree or a JSON array of them.
+func DecodeRuns(data []byte) ([]Run, error) {
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		var runs []Run
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return nil, err
+		}
+		return runs, nil
+	}
+
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+
+	return []Run{run}, nil
+}
@@ -0,0 +1,264 @@
+package alert
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// drainTimeout bounds the delivery of pending notifications on shutdown.
+const drainTimeout = 5 * time.Second
+
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is the current state of a rule for one agent.
+type Alert struct {
+	Fingerprint string     `json:"fingerprint"`
+	Rule        string     `json:"rule"`
+	Agent       string     `json:"agent"`
+	Metric      Metric     `json:"metric"`
+	State       string     `json:"state"`
+	Value       float64    `json:"value"`
+	Threshold   float64    `json:"threshold"`
+	StartsAt    time.Time  `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at,omitempty"`
+}
+
+// Notifier delivers alert state changes.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Engine evaluates rules over sliding windows of ingested traces. Traces are
+// handed over through Publish and processed asynchronously by Run, so
+// ingestion never waits on evaluation or notification delivery.
+type Engine struct {
+	rules     []Rule
+	notifier  Notifier
+	interval  time.Duration
+	maxWindow time.Duration
+	now       func() time.Time
+
+	incoming      chan model.Trace
+	notifications chan Alert
+
+	mu      sync.Mutex
+	samples map[string][]sample
+	alerts  map[string]*Alert
+}
+
+// DefaultEvalInterval is used when NewEngine is given a non-positive interval.
+const DefaultEvalInterval = 30 * time.Second
+
+func NewEngine(rules []Rule, notifier Notifier, interval time.Duration) *Engine {
+	if interval <= 0 {
+		interval = DefaultEvalInterval
+	}
+	e := &Engine{
+		rules:         rules,
+		notifier:      notifier,
+		interval:      interval,
+		now:           time.Now,
+		incoming:      make(chan model.Trace, 1024),
+		notifications: make(chan Alert, 256),
+		samples:       make(map[string][]sample),
+		alerts:        make(map[string]*Alert),
+	}
+	for _, r := range rules {
+		if time.Duration(r.Window) > e.maxWindow {
+			e.maxWindow = time.Duration(r.Window)
+		}
+	}
+
+	return e
+}
+
+// Publish queues a trace for evaluation. Traces are dropped if the engine is
+// saturated; alerting is best effort and must not slow down ingestion.
+func (e *Engine) Publish(trace model.Trace) {
+	select {
+	case e.incoming <- trace:
+	default:
+	}
+}
+
+// Run processes published traces, re-evaluates every interval so alerts
+// resolve once traffic stops, and delivers notifications until ctx is done.
+// It then evaluates the traces still queued and delivers the pending
+// notifications, waiting at most drainTimeout, before returning.
+func (e *Engine) Run(ctx context.Context) {
+	delivered := make(chan struct{})
+	go func() {
+		e.deliver(ctx)
+		close(delivered)
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			<-delivered
+			e.drain(ctx)
+			return
+		case trace := <-e.incoming:
+			e.Observe(trace)
+		case <-ticker.C:
+			e.EvaluateAll()
+		}
+	}
+}
+
+// Observe records trace and re-evaluates the rules for its agent.
+func (e *Engine) Observe(trace model.Trace) {
+	now := e.now()
+
+	e.mu.Lock()
+	e.samples[trace.AgentName] = append(e.prune(e.samples[trace.AgentName], now), newSample(trace, now))
+	changed := e.evaluateAgent(trace.AgentName, now)
+	e.mu.Unlock()
+
+	e.enqueue(changed)
+}
+
+// EvaluateAll re-evaluates every rule for every agent seen within the largest window.
+func (e *Engine) EvaluateAll() {
+	now := e.now()
+	var changed []Alert
+
+	e.mu.Lock()
+	for agent, samples := range e.samples {
+		e.samples[agent] = e.prune(samples, now)
+		changed = append(changed, e.evaluateAgent(agent, now)...)
+		if len(e.samples[agent]) == 0 {
+			delete(e.samples, agent)
+		}
+	}
+	e.mu.Unlock()
+
+	e.enqueue(changed)
+}
+
+// Alerts returns the known alert states, firing alerts first.
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		alerts = append(alerts, *a)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == StateFiring
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+
+	return alerts
+}
+
+// evaluateAgent must be called with e.mu held. It returns the alerts whose
+// state changed; unchanged alerts are not reported again, which deduplicates
+// notifications while a condition persists.
+func (e *Engine) evaluateAgent(agent string, now time.Time) []Alert {
+	var changed []Alert
+
+	for _, rule := range e.rules {
+		if rule.Agent != "" && rule.Agent != agent {
+			continue
+		}
+
+		value, ok := evaluate(rule, e.samples[agent], now.Add(-time.Duration(rule.Window)))
+		breached := ok && value > rule.Threshold
+		fingerprint := rule.Name + "/" + agent
+		current := e.alerts[fingerprint]
+
+		switch {
+		case breached && (current == nil || current.State == StateResolved):
+			a := &Alert{
+				Fingerprint: fingerprint,
+				Rule:        rule.Name,
+				Agent:       agent,
+				Metric:      rule.Metric,
+				State:       StateFiring,
+				Value:       value,
+				Threshold:   rule.Threshold,
+				StartsAt:    now,
+			}
+			e.alerts[fingerprint] = a
+			changed = append(changed, *a)
+		case breached:
+			current.Value = value
+		case current != nil && current.State == StateFiring:
+			ended := now
+			current.State = StateResolved
+			current.EndsAt = &ended
+			if ok {
+				current.Value = value
+			}
+			changed = append(changed, *current)
+		}
+	}
+
+	return changed
+}
+
+// prune drops samples older than the largest rule window.
+func (e *Engine) prune(samples []sample, now time.Time) []sample {
+	cutoff := now.Add(-e.maxWindow)
+	i := 0
+	for i < len(samples) && samples[i].at.Before(cutoff) {
+		i++
+	}
+
+	return samples[i:]
+}
+
+func (e *Engine) enqueue(alerts []Alert) {
+	for _, a := range alerts {
+		select {
+		case e.notifications <- a:
+		default:
+		}
+	}
+}
+
+func (e *Engine) drain(ctx context.Context) {
+	for len(e.incoming) > 0 {
+		e.Observe(<-e.incoming)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancel()
+	log := logger.FromContext(ctx)
+	for len(e.notifications) > 0 && ctx.Err() == nil {
+		a := <-e.notifications
+		if err := e.notifier.Notify(ctx, a); err != nil {
+			log.WithError(err).Errorf("failed to deliver alert %s (%s)", a.Fingerprint, a.State)
+		}
+	}
+}
+
+func (e *Engine) deliver(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-e.notifications:
+			if err := e.notifier.Notify(ctx, a); err != nil {
+				log.WithError(err).Errorf("failed to deliver alert %s (%s)", a.Fingerprint, a.State)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package alert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestEngine(rules ...Rule) (*Engine, *clock) {
+	c := &clock{t: time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)}
+	e := NewEngine(rules, &recordingNotifier{}, time.Minute)
+	e.now = c.now
+	return e, c
+}
+
+func drain(e *Engine) []Alert {
+	var out []Alert
+	for {
+		select {
+		case a := <-e.notifications:
+			out = append(out, a)
+		default:
+			return out
+		}
+	}
+}
+
+func TestEngine_ErrorRateFiresAndResolves(t *testing.T) {
+	e, c := newTestEngine(Rule{Name: "errors", Metric: MetricErrorRate, Threshold: 0.5, Window: Duration(5 * time.Minute), MinSamples: 2})
+
+	e.Observe(model.Trace{AgentName: "A", Status: "error"})
+	assert.Empty(t, drain(e), "below min samples")
+
+	e.Observe(model.Trace{AgentName: "A", Status: "error"})
+	fired := drain(e)
+	require.Len(t, fired, 1)
+	assert.Equal(t, StateFiring, fired[0].State)
+	assert.Equal(t, "errors/A", fired[0].Fingerprint)
+	assert.Equal(t, 1.0, fired[0].Value)
+
+	e.Observe(model.Trace{AgentName: "A", Status: "error"})
+	assert.Empty(t, drain(e), "still firing, deduplicated")
+
+	e.Observe(model.Trace{AgentName: "B", Status: "success"})
+	e.Observe(model.Trace{AgentName: "B", Status: "success"})
+	assert.Empty(t, drain(e), "agents are evaluated independently")
+
+	c.advance(6 * time.Minute)
+	e.EvaluateAll()
+	resolved := drain(e)
+	require.Len(t, resolved, 1)
+	assert.Equal(t, StateResolved, resolved[0].State)
+	require.NotNil(t, resolved[0].EndsAt)
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateResolved, alerts[0].State)
+}
+
+func TestEngine_Metrics(t *testing.T) {
+	traces := []model.Trace{
+		{AgentName: "A", LatencyMS: 100, TokenUsage: model.TokenUsage{Total: 10}, SubSteps: []model.SubStep{{Status: "success"}, {Status: "failed"}}},
+		{AgentName: "A", LatencyMS: 200, TokenUsage: model.TokenUsage{Total: 20}, SubSteps: []model.SubStep{{Status: "success"}}},
+		{AgentName: "A", LatencyMS: 900, TokenUsage: model.TokenUsage{Total: 3000}, SubSteps: []model.SubStep{{Status: "error"}}},
+	}
+
+	tests := []struct {
+		rule  Rule
+		value float64
+	}{
+		{Rule{Metric: MetricLatency, Percentile: 95}, 900},
+		{Rule{Metric: MetricLatency, Percentile: 50}, 200},
+		{Rule{Metric: MetricLatency}, 400},
+		{Rule{Metric: MetricTokens}, 1010},
+		{Rule{Metric: MetricSubStepFailureRate}, 0.5},
+		{Rule{Metric: MetricErrorRate}, 0},
+	}
+
+	now := time.Now()
+	var samples []sample
+	for _, trace := range traces {
+		samples = append(samples, newSample(trace, now))
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.rule.Metric), func(t *testing.T) {
+			value, ok := evaluate(tt.rule, samples, now.Add(-time.Minute))
+			assert.True(t, ok)
+			assert.InDelta(t, tt.value, value, 0.0001)
+		})
+	}
+}
+
+func TestEngine_RuleScopedToAgent(t *testing.T) {
+	e, _ := newTestEngine(Rule{Name: "slow", Agent: "A", Metric: MetricLatency, Percentile: 95, Threshold: 500, Window: Duration(time.Minute)})
+
+	e.Observe(model.Trace{AgentName: "B", LatencyMS: 1000})
+	assert.Empty(t, drain(e))
+
+	e.Observe(model.Trace{AgentName: "A", LatencyMS: 1000})
+	require.Len(t, drain(e), 1)
+}
+
+func TestEngine_RunDeliversNotifications(t *testing.T) {
+	notifier := &recordingNotifier{}
+	e := NewEngine([]Rule{{Name: "errors", Metric: MetricErrorRate, Threshold: 0, Window: Duration(time.Minute)}}, notifier, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	e.Publish(model.Trace{AgentName: "A", Status: "error"})
+
+	require.Eventually(t, func() bool {
+		notifier.mu.Lock()
+		defer notifier.mu.Unlock()
+		return len(notifier.alerts) == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestNewEngine_DefaultsNonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		e := NewEngine(nil, &recordingNotifier{}, interval)
+		assert.Equal(t, DefaultEvalInterval, e.interval)
+	}
+}
+
+func TestEngine_RunFlushesOnShutdown(t *testing.T) {
+	notifier := &recordingNotifier{}
+	e := NewEngine([]Rule{{Name: "errors", Metric: MetricErrorRate, Threshold: 0, Window: Duration(time.Minute)}}, notifier, time.Hour)
+
+	e.Publish(model.Trace{AgentName: "A", Status: "error"})
+	e.Publish(model.Trace{AgentName: "B", Status: "error"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e.Run(ctx)
+
+	assert.Len(t, notifier.alerts, 2, "queued traces are evaluated and their alerts delivered")
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "rules.json")
+	require.NoError(t, os.WriteFile(valid, []byte(`[
+		{"name": "p95", "agent": "A", "metric": "latency_ms", "percentile": 95, "threshold": 2000, "window": "5m", "min_samples": 10}
+	]`), 0o600))
+
+	rules, err := LoadRules(valid)
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{
+		Name: "p95", Agent: "A", Metric: MetricLatency, Percentile: 95, Threshold: 2000,
+		Window: Duration(5 * time.Minute), MinSamples: 10,
+	}}, rules)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`[{"name": "x", "metric": "nope", "window": "1m"}]`), 0o600))
+
+	_, err = LoadRules(invalid)
+	assert.EqualError(t, err, `rule "x": unknown metric "nope"`)
+}
@@ -0,0 +1,95 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Metric is the quantity a rule evaluates over its window.
+type Metric string
+
+const (
+	// MetricErrorRate is the fraction of traces whose status is a failure.
+	MetricErrorRate Metric = "error_rate"
+	// MetricLatency is the trace latency in milliseconds.
+	MetricLatency Metric = "latency_ms"
+	// MetricTokens is the total token usage per trace.
+	MetricTokens Metric = "total_tokens"
+	// MetricSubStepFailureRate is the fraction of failed substeps across all traces.
+	MetricSubStepFailureRate Metric = "substep_failure_rate"
+)
+
+// Duration is a time.Duration that unmarshals from strings such as "5m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule fires when Metric for an agent exceeds Threshold over the sliding Window.
+// Latency and token rules aggregate with Percentile (e.g. 95) or the mean when
+// Percentile is zero. An empty Agent evaluates the rule for every agent.
+type Rule struct {
+	Name       string   `json:"name"`
+	Agent      string   `json:"agent,omitempty"`
+	Metric     Metric   `json:"metric"`
+	Percentile float64  `json:"percentile,omitempty"`
+	Threshold  float64  `json:"threshold"`
+	Window     Duration `json:"window"`
+	MinSamples int      `json:"min_samples,omitempty"`
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("rule name is required")
+	case r.Window <= 0:
+		return fmt.Errorf("rule %q: window must be positive", r.Name)
+	case r.Percentile < 0 || r.Percentile > 100:
+		return fmt.Errorf("rule %q: percentile must be between 0 and 100", r.Name)
+	}
+
+	switch r.Metric {
+	case MetricErrorRate, MetricLatency, MetricTokens, MetricSubStepFailureRate:
+		return nil
+	}
+
+	return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse alert rules: %w", err)
+	}
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,130 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-AgentTrace-Signature"
+	TimestampHeader = "X-AgentTrace-Timestamp"
+)
+
+// WebhookNotifier posts alerts as JSON to generic webhooks. When a secret is
+// configured each request carries an HMAC-SHA256 signature of
+// "<timestamp>.<body>" so receivers can verify origin and reject replays.
+type WebhookNotifier struct {
+	urls       []string
+	secret     []byte
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	now        func() time.Time
+}
+
+func NewWebhookNotifier(urls []string, secret string, maxRetries int, client *http.Client) *WebhookNotifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &WebhookNotifier{
+		urls:       urls,
+		secret:     []byte(secret),
+		client:     client,
+		maxRetries: maxRetries,
+		backoff:    500 * time.Millisecond,
+		now:        time.Now,
+	}
+}
+
+// Notify delivers alert to every configured URL, returning the first error.
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, url := range n.urls {
+		if err := n.send(ctx, url, body); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts body to url, retrying network errors, 429 and 5xx responses
+// with exponential backoff.
+func (n *WebhookNotifier) send(ctx context.Context, url string, body []byte) error {
+	var lastErr error
+	delay := n.backoff
+
+	for attempt := 0; attempt <= n.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		retry, err := n.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return fmt.Errorf("webhook %s: %w", url, lastErr)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return false, fmt.Errorf("status %d", resp.StatusCode)
+}
@@ -0,0 +1,70 @@
+package alert
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier_SignsAndRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var signature, timestamp string
+	var body []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		signature = r.Header.Get(SignatureHeader)
+		timestamp = r.Header.Get(TimestampHeader)
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier([]string{server.URL}, "s3cret", 3, server.Client())
+	n.backoff = time.Millisecond
+
+	err := n.Notify(context.Background(), Alert{Fingerprint: "errors/A", State: StateFiring})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(3), attempts.Load())
+	assert.JSONEq(t, `{"fingerprint":"errors/A","rule":"","agent":"","metric":"","state":"firing","value":0,"threshold":0,"starts_at":"0001-01-01T00:00:00Z"}`, string(body))
+	assert.Equal(t, Sign([]byte("s3cret"), timestamp, body), signature)
+}
+
+func TestWebhookNotifier_Failures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		attempts int32
+	}{
+		{"gives up after max retries", http.StatusInternalServerError, 3},
+		{"does not retry client errors", http.StatusBadRequest, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			n := NewWebhookNotifier([]string{server.URL}, "", 2, server.Client())
+			n.backoff = time.Millisecond
+
+			err := n.Notify(context.Background(), Alert{Fingerprint: "x"})
+			assert.Error(t, err)
+			assert.Equal(t, tt.attempts, attempts.Load())
+		})
+	}
+}
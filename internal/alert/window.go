@@ -0,0 +1,100 @@
+package alert
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// sample is the part of a trace the engine keeps for window evaluation.
+type sample struct {
+	at             time.Time
+	failed         bool
+	latencyMS      float64
+	tokens         float64
+	subSteps       int
+	subStepsFailed int
+}
+
+func newSample(trace model.Trace, at time.Time) sample {
+	s := sample{
+		at:        at,
+		failed:    model.IsFailure(trace.Status),
+		latencyMS: float64(trace.LatencyMS),
+		tokens:    float64(trace.TokenUsage.Total),
+		subSteps:  len(trace.SubSteps),
+	}
+	for _, step := range trace.SubSteps {
+		if model.IsFailure(step.Status) {
+			s.subStepsFailed++
+		}
+	}
+
+	return s
+}
+
+// evaluate computes rule's metric over samples recorded after since. ok is
+// false when there are fewer samples than the rule requires.
+func evaluate(rule Rule, samples []sample, since time.Time) (value float64, ok bool) {
+	var inWindow []sample
+	for _, s := range samples {
+		if !s.at.Before(since) {
+			inWindow = append(inWindow, s)
+		}
+	}
+	if len(inWindow) == 0 || len(inWindow) < rule.MinSamples {
+		return 0, false
+	}
+
+	switch rule.Metric {
+	case MetricErrorRate:
+		failed := 0
+		for _, s := range inWindow {
+			if s.failed {
+				failed++
+			}
+		}
+		return float64(failed) / float64(len(inWindow)), true
+	case MetricSubStepFailureRate:
+		total, failed := 0, 0
+		for _, s := range inWindow {
+			total += s.subSteps
+			failed += s.subStepsFailed
+		}
+		if total == 0 {
+			return 0, false
+		}
+		return float64(failed) / float64(total), true
+	case MetricLatency:
+		return aggregate(inWindow, rule.Percentile, func(s sample) float64 { return s.latencyMS }), true
+	case MetricTokens:
+		return aggregate(inWindow, rule.Percentile, func(s sample) float64 { return s.tokens }), true
+	}
+
+	return 0, false
+}
+
+// aggregate returns the nearest-rank percentile of the values, or their mean
+// when percentile is zero.
+func aggregate(samples []sample, percentile float64, value func(sample) float64) float64 {
+	values := make([]float64, len(samples))
+	sum := 0.0
+	for i, s := range samples {
+		values[i] = value(s)
+		sum += values[i]
+	}
+
+	if percentile == 0 {
+		return sum / float64(len(values))
+	}
+
+	sort.Float64s(values)
+	rank := int(math.Ceil(percentile / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return values[rank-1]
+}
@@ -0,0 +1,177 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/blob"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// KeyPrefix is the blob store prefix under which archives are written.
+const KeyPrefix = "archive/"
+
+// Options controls which traces are archived and how.
+type Options struct {
+	Codec     string
+	OlderThan time.Duration
+	Interval  time.Duration
+	BatchSize int64
+}
+
+// Result summarises an archiving pass.
+type Result struct {
+	Archived int
+	Objects  []string
+}
+
+// Archiver moves traces older than a threshold out of the repository into
+// compressed JSONL objects partitioned by day and agent:
+//
+//	archive/<yyyy>/<mm>/<dd>/<agent>/<run>-<seq>.jsonl.gz
+type Archiver struct {
+	repo  repository.TraceRepository
+	store blob.Store
+	opts  Options
+	ext   string
+	now   func() time.Time
+}
+
+func NewArchiver(repo repository.TraceRepository, store blob.Store, opts Options) (*Archiver, error) {
+	ext, err := extension(opts.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("archive interval must be positive, got %s", opts.Interval)
+	}
+
+	return &Archiver{
+		repo:  repo,
+		store: store,
+		opts:  opts,
+		ext:   ext,
+		now:   time.Now,
+	}, nil
+}
+
+// Archive writes every trace older than the threshold to the store and then
+// removes it from the repository. Traces are only deleted once the objects
+// containing them have been stored.
+func (a *Archiver) Archive(ctx context.Context) (Result, error) {
+	var result Result
+	cutoff := a.now().Add(-a.opts.OlderThan)
+	run := a.now().UTC().Format("20060102T150405")
+
+	for batchNo := 0; ; batchNo++ {
+		batch, err := a.repo.GetTraces(ctx, repository.TraceFilter{To: &cutoff, Limit: a.opts.BatchSize})
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			return result, nil
+		}
+
+		keys, err := a.writePartitions(ctx, batch, fmt.Sprintf("%s-%d", run, batchNo))
+		if err != nil {
+			return result, err
+		}
+		result.Objects = append(result.Objects, keys...)
+
+		ids := make([]string, 0, len(batch))
+		for _, trace := range batch {
+			ids = append(ids, trace.ID.Hex())
+		}
+		deleted, err := a.repo.DeleteByIDs(ctx, ids)
+		if err != nil {
+			return result, err
+		}
+		result.Archived += len(batch)
+
+		// Stop when nothing could be deleted to avoid re-archiving the same batch forever.
+		if deleted == 0 || int64(len(batch)) < a.opts.BatchSize {
+			return result, nil
+		}
+	}
+}
+
+// Run archives on every interval until ctx is cancelled.
+func (a *Archiver) Run(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := a.Archive(ctx)
+			if err != nil {
+				log.WithError(err).Error("trace archiving failed")
+				continue
+			}
+			if result.Archived > 0 {
+				log.Infof("archived %d traces into %d objects", result.Archived, len(result.Objects))
+			}
+		}
+	}
+}
+
+func (a *Archiver) writePartitions(ctx context.Context, traces []model.Trace, run string) ([]string, error) {
+	partitions := map[string][]model.Trace{}
+	for _, trace := range traces {
+		agent := trace.AgentName
+		if agent == "" {
+			agent = "unknown"
+		}
+		dir := KeyPrefix + trace.Timestamp.UTC().Format("2006/01/02") + "/" + url.PathEscape(agent)
+		partitions[dir] = append(partitions[dir], trace)
+	}
+
+	dirs := make([]string, 0, len(partitions))
+	for dir := range partitions {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	keys := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		var buf bytes.Buffer
+		if err := a.encode(&buf, partitions[dir]); err != nil {
+			return nil, err
+		}
+
+		key := dir + "/" + run + a.ext
+		if err := a.store.Put(ctx, key, &buf); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (a *Archiver) encode(buf *bytes.Buffer, traces []model.Trace) error {
+	w, err := newWriter(a.opts.Codec, buf)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, trace := range traces {
+		if err := enc.Encode(trace); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	return w.Close()
+}
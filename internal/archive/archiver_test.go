@@ -0,0 +1,138 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/blob"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+// memoryTraceRepo keeps traces in memory, newest first like the Mongo repository.
+type memoryTraceRepo struct {
+	repository.TraceRepository
+	traces []model.Trace
+}
+
+func (m *memoryTraceRepo) InsertTrace(_ context.Context, trace model.Trace) error {
+	for _, t := range m.traces {
+		if t.ID == trace.ID {
+			return repository.ErrDuplicateTrace
+		}
+	}
+	m.traces = append(m.traces, trace)
+	sort.Slice(m.traces, func(i, j int) bool { return m.traces[i].Timestamp.After(m.traces[j].Timestamp) })
+	return nil
+}
+
+func (m *memoryTraceRepo) GetTraces(_ context.Context, filter repository.TraceFilter) ([]model.Trace, error) {
+	var out []model.Trace
+	for _, t := range m.traces {
+		if filter.To != nil && t.Timestamp.After(*filter.To) {
+			continue
+		}
+		out = append(out, t)
+		if filter.Limit > 0 && int64(len(out)) == filter.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryTraceRepo) DeleteByIDs(_ context.Context, ids []string) (int64, error) {
+	remove := map[string]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	var kept []model.Trace
+	for _, t := range m.traces {
+		if !remove[t.ID.Hex()] {
+			kept = append(kept, t)
+		}
+	}
+	deleted := int64(len(m.traces) - len(kept))
+	m.traces = kept
+	return deleted, nil
+}
+
+var now = time.Date(2025, 5, 10, 12, 0, 0, 0, time.UTC)
+
+func seed(repo *memoryTraceRepo) {
+	add := func(agent string, ts time.Time) {
+		_ = repo.InsertTrace(context.Background(), model.Trace{
+			ID:          primitive.NewObjectID(),
+			TraceID:     agent + ts.Format(time.RFC3339),
+			AgentName:   agent,
+			Timestamp:   ts,
+			InputPrompt: "prompt",
+		})
+	}
+	add("Planner", time.Date(2025, 5, 1, 8, 0, 0, 0, time.UTC))
+	add("Planner", time.Date(2025, 5, 1, 9, 0, 0, 0, time.UTC))
+	add("Doc Agent", time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC))
+	add("Planner", time.Date(2025, 5, 2, 8, 0, 0, 0, time.UTC))
+	add("Planner", now.Add(-time.Hour))
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := blob.NewFSStore(t.TempDir())
+			require.NoError(t, err)
+
+			repo := &memoryTraceRepo{}
+			seed(repo)
+			original := append([]model.Trace(nil), repo.traces...)
+
+			archiver, err := NewArchiver(repo, store, Options{Codec: codec, OlderThan: 24 * time.Hour, Interval: time.Hour, BatchSize: 2})
+			require.NoError(t, err)
+			archiver.now = func() time.Time { return now }
+
+			result, err := archiver.Archive(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 4, result.Archived)
+			require.Len(t, repo.traces, 1, "only the recent trace stays in the repository")
+			assert.Equal(t, original[0].ID, repo.traces[0].ID)
+
+			keys, err := store.List(ctx, KeyPrefix)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, result.Objects, keys)
+			for _, key := range keys {
+				assert.Regexp(t, `^archive/2025/05/0[12]/(Planner|Doc%20Agent)/20250510T120000-\d+`+extensions[codec]+`$`, key)
+			}
+
+			restorer := NewRestorer(repo, store)
+			restored, err := restorer.Restore(ctx, "2025/05/01")
+			require.NoError(t, err)
+			assert.Equal(t, 3, restored.Restored)
+			assert.Len(t, repo.traces, 4)
+
+			restored, err = restorer.Restore(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, 1, restored.Restored)
+			assert.Equal(t, 3, restored.Skipped)
+			assert.ElementsMatch(t, original, repo.traces)
+		})
+	}
+}
+
+func TestNewArchiver_UnknownCodec(t *testing.T) {
+	_, err := NewArchiver(&memoryTraceRepo{}, nil, Options{Codec: "lz4", Interval: time.Hour})
+	assert.EqualError(t, err, `unsupported archive codec "lz4"`)
+}
+
+func TestNewArchiver_NonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Minute} {
+		_, err := NewArchiver(&memoryTraceRepo{}, nil, Options{Codec: "gzip", Interval: interval})
+		assert.EqualError(t, err, fmt.Sprintf("archive interval must be positive, got %s", interval))
+	}
+}
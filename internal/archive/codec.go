@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+var extensions = map[string]string{
+	CodecGzip: ".jsonl.gz",
+	CodecZstd: ".jsonl.zst",
+}
+
+func extension(codec string) (string, error) {
+	ext, ok := extensions[codec]
+	if !ok {
+		return "", fmt.Errorf("unsupported archive codec %q", codec)
+	}
+
+	return ext, nil
+}
+
+// codecForKey infers the compression codec from an archive object key.
+func codecForKey(key string) (string, bool) {
+	for codec, ext := range extensions {
+		if strings.HasSuffix(key, ext) {
+			return codec, true
+		}
+	}
+
+	return "", false
+}
+
+func newWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecZstd:
+		return zstd.NewWriter(w)
+	}
+
+	return nil, fmt.Errorf("unsupported archive codec %q", codec)
+}
+
+func newReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported archive codec %q", codec)
+}
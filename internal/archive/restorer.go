@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zkropotkine/agent-trace/internal/blob"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+// RestoreResult summarises a restore run.
+type RestoreResult struct {
+	Objects  int `json:"objects"`
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+}
+
+// Restorer re-imports archived traces into a repository.
+type Restorer struct {
+	repo  repository.TraceRepository
+	store blob.Store
+}
+
+func NewRestorer(repo repository.TraceRepository, store blob.Store) *Restorer {
+	return &Restorer{repo: repo, store: store}
+}
+
+// Restore re-imports every archive object whose key starts with
+// KeyPrefix+prefix, e.g. "2025/05/01" for a single day. Traces that are
+// already present are skipped, so restoring the same archive twice is safe.
+func (r *Restorer) Restore(ctx context.Context, prefix string) (RestoreResult, error) {
+	var result RestoreResult
+
+	keys, err := r.store.List(ctx, KeyPrefix+prefix)
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range keys {
+		codec, ok := codecForKey(key)
+		if !ok {
+			continue
+		}
+		if err := r.restoreObject(ctx, key, codec, &result); err != nil {
+			return result, fmt.Errorf("restore %s: %w", key, err)
+		}
+		result.Objects++
+	}
+
+	return result, nil
+}
+
+func (r *Restorer) restoreObject(ctx context.Context, key, codec string, result *RestoreResult) error {
+	rc, err := r.store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec, err := newReader(codec, rc)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	scanner := bufio.NewScanner(dec)
+	scanner.Buffer(make([]byte, 0, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		var trace model.Trace
+		if err := json.Unmarshal(scanner.Bytes(), &trace); err != nil {
+			return err
+		}
+
+		err := r.repo.InsertTrace(ctx, trace)
+		switch {
+		case errors.Is(err, repository.ErrDuplicateTrace):
+			result.Skipped++
+		case err != nil:
+			return err
+		default:
+			result.Restored++
+		}
+	}
+
+	return scanner.Err()
+}
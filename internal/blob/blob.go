@@ -0,0 +1,34 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a key does not exist in the store.
+var ErrNotFound = errors.New("blob not found")
+
+// Store is a minimal object store. Keys are slash-separated paths.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// List returns every key starting with prefix, in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// probeKey is read by Ping; it does not need to exist.
+const probeKey = ".healthz"
+
+// Ping checks that store answers requests. A missing key counts as an answer.
+func Ping(ctx context.Context, store Store) error {
+	r, err := store.Get(ctx, probeKey)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
@@ -0,0 +1,133 @@
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a local stand-in for an S3-compatible endpoint holding a single bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+f.bucket), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+		}
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key string `xml:"Key"`
+			}{k})
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(body)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newStores(t *testing.T) map[string]Store {
+	fsStore, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+
+	server := httptest.NewServer(&fakeS3{bucket: "traces", objects: map[string][]byte{}})
+	t.Cleanup(server.Close)
+
+	return map[string]Store{
+		"fs": fsStore,
+		"s3": NewS3Store(S3Config{
+			Endpoint:  server.URL,
+			Bucket:    "traces",
+			Region:    "us-east-1",
+			AccessKey: "AK",
+			SecretKey: "SK",
+		}, server.Client()),
+	}
+}
+
+func TestStores(t *testing.T) {
+	for name, store := range newStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			require.NoError(t, store.Put(ctx, "archive/2025/05/01/a.jsonl.gz", strings.NewReader("one")))
+			require.NoError(t, store.Put(ctx, "archive/2025/05/02/b c.jsonl.gz", strings.NewReader("two")))
+			require.NoError(t, store.Put(ctx, "payloads/x", strings.NewReader("three")))
+
+			keys, err := store.List(ctx, "archive/")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"archive/2025/05/01/a.jsonl.gz", "archive/2025/05/02/b c.jsonl.gz"}, keys)
+
+			rc, err := store.Get(ctx, "archive/2025/05/02/b c.jsonl.gz")
+			require.NoError(t, err)
+			body, _ := io.ReadAll(rc)
+			rc.Close()
+			assert.Equal(t, "two", string(body))
+
+			require.NoError(t, store.Delete(ctx, "payloads/x"))
+			require.NoError(t, store.Delete(ctx, "payloads/x"))
+
+			_, err = store.Get(ctx, "payloads/x")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, Ping(ctx, store), "a missing probe key still means the store answered")
+		})
+	}
+}
+
+func TestFSStore_RejectsEscapingKeys(t *testing.T) {
+	store, err := NewFSStore(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../etc/passwd", "a/../../b", "/abs"} {
+		assert.Error(t, store.Put(context.Background(), key, strings.NewReader("x")), key)
+	}
+}
+
+func TestURIEncode(t *testing.T) {
+	assert.Equal(t, "a%20b%2Bc~d", uriEncode("a b+c~d"))
+	assert.Equal(t, "/bucket/a%3Db/c", escapePath("/bucket/a=b/c"))
+}
@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/diff"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// ErrTailDropped is returned by Tail when the server disconnected the stream
+// because the client fell too far behind.
+var ErrTailDropped = errors.New("agenttrace: live tail dropped, client too slow")
+
+// APIError is a non-2xx response from the AgentTrace API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("agenttrace: server responded %d", e.StatusCode)
+	}
+	return fmt.Sprintf("agenttrace: server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Query selects traces. Zero fields are not sent.
+type Query struct {
+	Agent    string
+	Session  string
+	Status   string
+	Tags     []string
+	Metadata map[string]string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+func (q Query) values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("agent", q.Agent)
+	set("session", q.Session)
+	set("status", q.Status)
+	for _, tag := range q.Tags {
+		v.Add("tag", tag)
+	}
+	keys := make([]string, 0, len(q.Metadata))
+	for k := range q.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.Set("meta."+k, q.Metadata[k])
+	}
+	if !q.From.IsZero() {
+		v.Set("from", q.From.UTC().Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		v.Set("to", q.To.UTC().Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return v
+}
+
+// ImportResult mirrors the response of POST /api/import.
+type ImportResult struct {
+	Imported     int          `json:"imported"`
+	Skipped      int          `json:"skipped"`
+	Invalid      int          `json:"invalid"`
+	SkippedLines []ImportLine `json:"skipped_lines,omitempty"`
+	InvalidLines []ImportLine `json:"invalid_lines,omitempty"`
+}
+
+// ImportLine explains why a line of an import was not stored.
+type ImportLine struct {
+	Line    int    `json:"line"`
+	TraceID string `json:"trace_id,omitempty"`
+	Reason  string `json:"reason"`
+	Errors  []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors,omitempty"`
+}
+
+// API queries a running AgentTrace server on behalf of the commands.
+type API struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewAPI returns a client for the server at endpoint, e.g.
+// http://localhost:8080. httpClient defaults to http.DefaultClient; requests
+// are bounded by their context rather than a client timeout so long exports
+// and tails are not cut off.
+func NewAPI(endpoint string, httpClient *http.Client) *API {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &API{endpoint: strings.TrimRight(endpoint, "/"), client: httpClient}
+}
+
+// ListTraces returns one page of traces, newest first.
+func (a *API) ListTraces(ctx context.Context, q Query) ([]model.Trace, error) {
+	var res struct {
+		Traces []model.Trace `json:"traces"`
+	}
+	if err := a.getJSON(ctx, "/api/traces?"+q.values().Encode(), &res); err != nil {
+		return nil, err
+	}
+	return res.Traces, nil
+}
+
+// GetTrace returns the trace with storage ID id.
+func (a *API) GetTrace(ctx context.Context, id string) (*model.Trace, error) {
+	var trace model.Trace
+	if err := a.getJSON(ctx, "/api/traces/"+url.PathEscape(id), &trace); err != nil {
+		return nil, err
+	}
+	return &trace, nil
+}
+
+// Diff compares the traces with storage IDs a and b.
+func (a *API) Diff(ctx context.Context, idA, idB string) (diff.Result, error) {
+	var res diff.Result
+	v := url.Values{"a": {idA}, "b": {idB}}
+	return res, a.getJSON(ctx, "/api/traces/diff?"+v.Encode(), &res)
+}
+
+// Export streams every trace matching q in format (csv, jsonl or parquet).
+// The caller must close the returned body.
+func (a *API) Export(ctx context.Context, q Query, format string) (io.ReadCloser, error) {
+	v := q.values()
+	v.Set("format", format)
+	resp, err := a.do(ctx, http.MethodGet, "/api/traces/export?"+v.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// EachTrace calls fn for every trace matching q, streaming them from the
+// export endpoint so arbitrarily many traces can be scanned.
+func (a *API) EachTrace(ctx context.Context, q Query, fn func(model.Trace) error) error {
+	body, err := a.Export(ctx, q, "jsonl")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var trace model.Trace
+		if err := dec.Decode(&trace); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(trace); err != nil {
+			return err
+		}
+	}
+}
+
+// Tail calls fn for each newly ingested trace matching q's agent and status
+// until ctx is done or the stream ends.
+func (a *API) Tail(ctx context.Context, q Query, fn func(model.Trace) error) error {
+	v := url.Values{}
+	if q.Agent != "" {
+		v.Set("agent", q.Agent)
+	}
+	if q.Status != "" {
+		v.Set("status", q.Status)
+	}
+	resp, err := a.do(ctx, http.MethodGet, "/api/traces/stream?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 32*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:") && event == "trace":
+			var trace model.Trace
+			if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &trace); err != nil {
+				return err
+			}
+			if err := fn(trace); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:") && event == "dropped":
+			return ErrTailDropped
+		case line == "":
+			event = ""
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// Import uploads a JSONL dump, plain or gzipped, to POST /api/import.
+func (a *API) Import(ctx context.Context, r io.Reader) (ImportResult, error) {
+	var result ImportResult
+	resp, err := a.do(ctx, http.MethodPost, "/api/import", r)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	return result, json.NewDecoder(resp.Body).Decode(&result)
+}
+
+func (a *API) getJSON(ctx context.Context, path string, out any) error {
+	resp, err := a.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a request and converts non-2xx responses into an *APIError.
+func (a *API) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, a.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&res)
+	return nil, &APIError{StatusCode: resp.StatusCode, Message: res.Error}
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/importer"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/router"
+)
+
+func newAPIServer(t *testing.T, traces ...model.Trace) (*API, *memoryRepo, *pubsub.Broker) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	repo := &memoryRepo{traces: traces}
+	broker := pubsub.NewBroker(8)
+	engine := gin.New()
+	router.RegisterRoutes(engine, router.RouteRegistry{
+		TraceHandler:  handler.NewTraceHandler(repo, handler.WithBroker(broker)),
+		ImportHandler: handler.NewImportHandler(importer.NewImporter(repo, nil, 0)),
+	})
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+
+	return NewAPI(srv.URL+"/", nil), repo, broker
+}
+
+func TestAPI_ListAndGet(t *testing.T) {
+	id := primitive.NewObjectID()
+	api, _, _ := newAPIServer(t,
+		model.Trace{ID: id, TraceID: "a", AgentName: "Planner"},
+		model.Trace{ID: primitive.NewObjectID(), TraceID: "b", AgentName: "Writer"},
+	)
+	ctx := context.Background()
+
+	traces, err := api.ListTraces(ctx, Query{Agent: "Planner"})
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+	assert.Equal(t, "a", traces[0].TraceID)
+
+	trace, err := api.GetTrace(ctx, id.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "a", trace.TraceID)
+
+	_, err = api.GetTrace(ctx, "missing")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "trace not found", apiErr.Message)
+}
+
+func TestAPI_Diff(t *testing.T) {
+	idA, idB := primitive.NewObjectID(), primitive.NewObjectID()
+	api, _, _ := newAPIServer(t,
+		model.Trace{ID: idA, TraceID: "a", Status: "success"},
+		model.Trace{ID: idB, TraceID: "b", Status: "error"},
+	)
+
+	res, err := api.Diff(context.Background(), idA.Hex(), idB.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "a", res.A)
+	assert.Equal(t, "error", res.Status.To)
+	assert.False(t, res.Identical)
+}
+
+func TestAPI_EachTraceAndExport(t *testing.T) {
+	api, _, _ := newAPIServer(t, model.Trace{TraceID: "a"}, model.Trace{TraceID: "b"})
+	ctx := context.Background()
+
+	var ids []string
+	require.NoError(t, api.EachTrace(ctx, Query{}, func(trace model.Trace) error {
+		ids = append(ids, trace.TraceID)
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b"}, ids)
+
+	body, err := api.Export(ctx, Query{}, "csv")
+	require.NoError(t, err)
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 3)
+
+	_, err = api.Export(ctx, Query{}, "xlsx")
+	assert.ErrorContains(t, err, "format must be csv, jsonl or parquet")
+}
+
+func TestAPI_Tail(t *testing.T) {
+	api, _, broker := newAPIServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	received := make(chan model.Trace, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- api.Tail(ctx, Query{Agent: "Planner"}, func(trace model.Trace) error {
+			received <- trace
+			return errors.New("stop")
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		broker.Publish(model.Trace{TraceID: "live", AgentName: "Planner"})
+		return len(received) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "live", (<-received).TraceID)
+	assert.EqualError(t, <-done, "stop")
+}
+
+func TestAPI_Import(t *testing.T) {
+	api, repo, _ := newAPIServer(t)
+
+	result, err := api.Import(context.Background(), strings.NewReader(`{"trace_id":"a"}`+"\n"+`{"agent_name":"x"}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 1, result.Invalid)
+	assert.Equal(t, 2, result.InvalidLines[0].Line)
+	assert.Equal(t, "trace_id", result.InvalidLines[0].Errors[0].Field)
+	assert.Len(t, repo.inserted, 1)
+}
+
+func TestQueryValues(t *testing.T) {
+	q := Query{
+		Agent:    "Planner",
+		Tags:     []string{"beta", "eu"},
+		Metadata: map[string]string{"tier": "gold"},
+		From:     time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+		Limit:    10,
+	}
+
+	assert.Equal(t, "agent=Planner&from=2025-05-01T00%3A00%3A00Z&limit=10&meta.tier=gold&tag=beta&tag=eu", q.values().Encode())
+}
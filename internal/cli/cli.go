@@ -0,0 +1,476 @@
+// Package cli implements the agenttrace command-line client, which queries a
+// running AgentTrace server over its HTTP API.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// ServerEnv names the environment variable holding the default server URL.
+const ServerEnv = "AGENT_TRACE_URL"
+
+const defaultServer = "http://localhost:8080"
+
+// ErrUnknownCommand is returned by Run for a command it does not implement.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// errStop ends a trace iteration early without reporting an error.
+var errStop = errors.New("stop")
+
+// Env is the process environment a command runs in.
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Getenv func(string) string
+	Now    func() time.Time
+}
+
+// DefaultEnv uses the process's standard streams and environment.
+func DefaultEnv() Env {
+	return Env{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr, Getenv: os.Getenv, Now: time.Now}
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, c *cmdContext, args []string) error
+}
+
+var commands = []command{
+	{"list", "[flags]", "List recent traces", runList},
+	{"get", "[flags] <id>", "Show a trace and its substep tree", runGet},
+	{"tail", "[flags]", "Follow newly ingested traces", runTail},
+	{"diff", "[flags] <id-a> <id-b>", "Compare two traces step by step", runDiff},
+	{"search", "[flags] <text>", "Find traces whose prompts, outputs or errors contain text", runSearch},
+	{"export", "[flags]", "Download traces as jsonl, csv or parquet", runExport},
+	{"import", "[flags]", "Upload a JSONL dump, optionally gzipped", runImport},
+	{"stats", "[flags]", "Summarise error rate, latency and tokens per agent", runStats},
+}
+
+// Commands returns the names of the client commands handled by Run.
+func Commands() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Usage writes a summary of every command, with extra lines for commands
+// implemented elsewhere (such as serve).
+func Usage(w io.Writer, extra ...string) {
+	fmt.Fprintln(w, "Usage: agenttrace <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, line := range extra {
+		fmt.Fprintln(w, "  "+line)
+	}
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Client commands talk to -server, default $%s or %s.\n", ServerEnv, defaultServer)
+	fmt.Fprintln(w, "Run 'agenttrace <command> -h' for its flags.")
+}
+
+// Run executes the client command args[0] with the remaining arguments.
+func Run(ctx context.Context, args []string, env Env) error {
+	if len(args) == 0 {
+		Usage(env.Stderr)
+		return ErrUnknownCommand
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(ctx, &cmdContext{env: env, name: c.name, usage: c.args}, args[1:])
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownCommand, args[0])
+}
+
+// cmdContext carries what every command needs: its flag set, the API client
+// built from -server, and the output mode.
+type cmdContext struct {
+	env    Env
+	name   string
+	usage  string
+	server string
+	json   bool
+	api    *API
+}
+
+func (c *cmdContext) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(c.env.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.env.Stderr, "Usage: agenttrace %s %s\n\nFlags:\n", c.name, c.usage)
+		fs.PrintDefaults()
+	}
+
+	server := c.env.Getenv(ServerEnv)
+	if server == "" {
+		server = defaultServer
+	}
+	fs.StringVar(&c.server, "server", server, "AgentTrace server URL")
+	fs.BoolVar(&c.json, "json", false, "print JSON instead of tables")
+	return fs
+}
+
+// parse parses args and builds the API client.
+func (c *cmdContext) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	c.api = NewAPI(c.server, nil)
+	return nil
+}
+
+func (c *cmdContext) writeJSON(v any) error {
+	enc := json.NewEncoder(c.env.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// queryFlags are the trace filters shared by list, search, export and stats.
+type queryFlags struct {
+	agent, session, status string
+	from, to               string
+	tags                   listFlag
+	meta                   listFlag
+	limit, offset          int
+}
+
+func (q *queryFlags) register(fs *flag.FlagSet, limit int, from string) {
+	fs.StringVar(&q.agent, "agent", "", "filter by agent name")
+	fs.StringVar(&q.session, "session", "", "filter by session ID")
+	fs.StringVar(&q.status, "status", "", "filter by status, e.g. error")
+	fs.StringVar(&q.from, "from", from, "start time: RFC3339, a date, or an age such as 30m, 6h or 7d")
+	fs.StringVar(&q.to, "to", "", "end time, same formats as -from")
+	fs.Var(&q.tags, "tag", "require a tag (repeatable)")
+	fs.Var(&q.meta, "meta", "require metadata key=value (repeatable)")
+	fs.IntVar(&q.limit, "limit", limit, "maximum number of traces (0 for no limit)")
+	fs.IntVar(&q.offset, "offset", 0, "number of traces to skip")
+}
+
+func (q *queryFlags) query(now time.Time) (Query, error) {
+	query := Query{
+		Agent:   q.agent,
+		Session: q.session,
+		Status:  q.status,
+		Tags:    q.tags,
+		Limit:   q.limit,
+		Offset:  q.offset,
+	}
+
+	var err error
+	if query.From, err = parseTime(q.from, now); err != nil {
+		return query, fmt.Errorf("-from: %w", err)
+	}
+	if query.To, err = parseTime(q.to, now); err != nil {
+		return query, fmt.Errorf("-to: %w", err)
+	}
+	for _, kv := range q.meta {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return query, fmt.Errorf("-meta %q: want key=value", kv)
+		}
+		if query.Metadata == nil {
+			query.Metadata = make(map[string]string)
+		}
+		query.Metadata[key] = value
+	}
+	return query, nil
+}
+
+// parseTime accepts RFC3339, a plain date, or an age relative to now such as
+// "90m" or "7d".
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * 24 * time.Hour), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
+func runList(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	var q queryFlags
+	q.register(fs, 20, "")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	query, err := q.query(c.env.Now())
+	if err != nil {
+		return err
+	}
+
+	traces, err := c.api.ListTraces(ctx, query)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(nonNil(traces))
+	}
+	return writeTable(c.env.Stdout, traces)
+}
+
+func runGet(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("get needs exactly one trace ID")
+	}
+
+	trace, err := c.api.GetTrace(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(trace)
+	}
+	return writeTrace(c.env.Stdout, *trace)
+}
+
+func runDiff(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("diff needs exactly two trace IDs")
+	}
+
+	res, err := c.api.Diff(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(res)
+	}
+	return writeDiff(c.env.Stdout, res)
+}
+
+func runTail(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	var agent, status string
+	fs.StringVar(&agent, "agent", "", "filter by agent name")
+	fs.StringVar(&status, "status", "", "filter by status, e.g. error")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(c.env.Stdout)
+	for {
+		err := c.api.Tail(ctx, Query{Agent: agent, Status: status}, func(trace model.Trace) error {
+			if c.json {
+				return enc.Encode(trace)
+			}
+			_, err := fmt.Fprintln(c.env.Stdout, tailLine(trace))
+			return err
+		})
+		if errors.Is(err, ErrTailDropped) {
+			fmt.Fprintln(c.env.Stderr, "agenttrace: fell behind the live stream, reconnecting; some traces were missed")
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+}
+
+func runSearch(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	var q queryFlags
+	q.register(fs, 0, "7d")
+	matches := fs.Int("max", 50, "stop after this many matches")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("search needs text to look for")
+	}
+	query, err := q.query(c.env.Now())
+	if err != nil {
+		return err
+	}
+
+	needle := strings.ToLower(strings.Join(fs.Args(), " "))
+	var found []model.Trace
+	err = c.api.EachTrace(ctx, query, func(trace model.Trace) error {
+		if !containsText(trace, needle) {
+			return nil
+		}
+		found = append(found, trace)
+		if *matches > 0 && len(found) >= *matches {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return err
+	}
+
+	if c.json {
+		return c.writeJSON(nonNil(found))
+	}
+	return writeTable(c.env.Stdout, found)
+}
+
+// containsText reports whether needle (lower case) appears in the trace's
+// prompt, output, error, messages or any substep's input, output or error.
+func containsText(trace model.Trace, needle string) bool {
+	texts := []string{trace.InputPrompt, trace.Output, trace.Error}
+	for _, m := range trace.Messages {
+		texts = append(texts, m.Content)
+	}
+	for _, step := range trace.SubSteps {
+		texts = append(texts, step.Name, step.Input, step.Output, step.Error)
+		for _, m := range step.Messages {
+			texts = append(texts, m.Content)
+		}
+	}
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func runExport(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	var q queryFlags
+	q.register(fs, 0, "")
+	format := fs.String("format", "jsonl", "jsonl, csv or parquet")
+	output := fs.String("o", "-", "output file; - writes to stdout")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	query, err := q.query(c.env.Now())
+	if err != nil {
+		return err
+	}
+
+	body, err := c.api.Export(ctx, query, *format)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	out := c.env.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	n, err := io.Copy(out, body)
+	if err != nil {
+		return err
+	}
+	if *output != "-" {
+		fmt.Fprintf(c.env.Stderr, "wrote %d bytes to %s\n", n, *output)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	file := fs.String("file", "-", "JSONL file to import, optionally gzipped; - reads stdin")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	input := c.env.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	result, err := c.api.Import(ctx, input)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.writeJSON(result)
+	}
+	return writeImportResult(c.env.Stdout, result)
+}
+
+func runStats(ctx context.Context, c *cmdContext, args []string) error {
+	fs := c.flags()
+	var q queryFlags
+	q.register(fs, 0, "24h")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	query, err := q.query(c.env.Now())
+	if err != nil {
+		return err
+	}
+
+	var agg aggregator
+	if err := c.api.EachTrace(ctx, query, func(trace model.Trace) error {
+		agg.add(trace)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	stats := agg.stats()
+	if c.json {
+		return c.writeJSON(stats)
+	}
+	return writeStats(c.env.Stdout, stats)
+}
+
+// nonNil keeps JSON output an array when nothing matched.
+func nonNil(traces []model.Trace) []model.Trace {
+	if traces == nil {
+		return []model.Trace{}
+	}
+	return traces
+}
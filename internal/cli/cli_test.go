@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/importer"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/router"
+)
+
+var (
+	now     = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	traceID = primitive.NewObjectID()
+)
+
+type memoryRepo struct {
+	repository.TraceRepository
+	traces   []model.Trace
+	inserted []model.Trace
+	filters  []repository.TraceFilter
+}
+
+func (m *memoryRepo) GetTraces(_ context.Context, f repository.TraceFilter) ([]model.Trace, error) {
+	m.filters = append(m.filters, f)
+	var out []model.Trace
+	for _, t := range m.traces {
+		if f.AgentName == "" || t.AgentName == f.AgentName {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryRepo) IterateTraces(ctx context.Context, f repository.TraceFilter, fn func(model.Trace) error) error {
+	traces, _ := m.GetTraces(ctx, f)
+	for _, t := range traces {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) GetByID(_ context.Context, id string) (*model.Trace, error) {
+	for _, t := range m.traces {
+		if t.ID.Hex() == id {
+			return &t, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *memoryRepo) ExistingTraceIDs(context.Context, []string) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+func (m *memoryRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	m.inserted = append(m.inserted, traces...)
+	return nil
+}
+
+func fixtures() []model.Trace {
+	start := now.Add(-time.Minute)
+	return []model.Trace{
+		{
+			ID: traceID, TraceID: "t1", AgentName: "Planner", Status: "error", Error: "search timed out",
+			Timestamp: start, LatencyMS: 1500, TokenUsage: model.TokenUsage{Input: 10, Output: 5, Total: 15},
+			InputPrompt: "Plan a trip to Lisbon",
+			SubSteps: []model.SubStep{
+				{Name: "plan", SpanID: "a", Status: "success", Start: start, End: start.Add(time.Second),
+					ModelParams: &model.ModelParams{Model: "gpt-4o"}, TokenUsage: &model.TokenUsage{Total: 15}},
+				{Name: "search", SpanID: "b", ParentID: "a", Status: "error", Error: "timeout",
+					ToolCalls: []model.ToolCall{{Name: "web_search"}}},
+				{Name: "answer", SpanID: "c", Status: "success"},
+			},
+		},
+		{ID: primitive.NewObjectID(), TraceID: "t2", AgentName: "Writer", Status: "success", Timestamp: start, LatencyMS: 200, TokenUsage: model.TokenUsage{Total: 30}},
+		{ID: primitive.NewObjectID(), TraceID: "t3", AgentName: "Writer", Status: "success", Timestamp: start, LatencyMS: 400, TokenUsage: model.TokenUsage{Total: 20}},
+	}
+}
+
+// run executes a CLI command against an in-process server.
+func run(t *testing.T, repo *memoryRepo, stdin string, args ...string) (string, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	router.RegisterRoutes(engine, router.RouteRegistry{
+		TraceHandler:  handler.NewTraceHandler(repo),
+		ImportHandler: handler.NewImportHandler(importer.NewImporter(repo, nil, 0)),
+	})
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	env := Env{
+		Stdin:  strings.NewReader(stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Getenv: func(key string) string {
+			if key == ServerEnv {
+				return srv.URL
+			}
+			return ""
+		},
+		Now: func() time.Time { return now },
+	}
+	err := Run(context.Background(), args, env)
+	return stdout.String(), err
+}
+
+func TestList(t *testing.T) {
+	repo := &memoryRepo{traces: fixtures()}
+
+	out, err := run(t, repo, "", "list", "-agent", "Writer", "-from", "2h", "-tag", "beta", "-meta", "tier=gold")
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^ID\s+TRACE ID\s+AGENT\s+STATUS`, lines[0])
+	assert.Contains(t, lines[1], "t2")
+	assert.Contains(t, lines[1], "200ms")
+
+	f := repo.filters[0]
+	assert.Equal(t, int64(20), f.Limit)
+	assert.Equal(t, now.Add(-2*time.Hour), *f.From)
+	assert.Equal(t, []string{"beta"}, f.Tags)
+	assert.Equal(t, map[string]string{"tier": "gold"}, f.Metadata)
+}
+
+func TestListJSON(t *testing.T) {
+	out, err := run(t, &memoryRepo{}, "", "list", "-json")
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, out)
+}
+
+func TestGet(t *testing.T) {
+	out, err := run(t, &memoryRepo{traces: fixtures()}, "", "get", traceID.Hex())
+	require.NoError(t, err)
+
+	assert.Regexp(t, `Trace:\s+t1`, out)
+	assert.Regexp(t, `Error:\s+search timed out`, out)
+	assert.Contains(t, out, strings.Join([]string{
+		"Planner (3 steps)",
+		"├── plan  [success]  1s  gpt-4o  15 tok",
+		"│   └── search  [ERROR]  tool:web_search  error: timeout",
+		"└── answer  [success]",
+	}, "\n"))
+}
+
+func TestGetErrors(t *testing.T) {
+	_, err := run(t, &memoryRepo{}, "", "get")
+	assert.ErrorContains(t, err, "exactly one trace ID")
+
+	_, err = run(t, &memoryRepo{}, "", "get", "missing")
+	assert.ErrorContains(t, err, "404: trace not found")
+}
+
+func TestDiff(t *testing.T) {
+	traces := fixtures()
+	replay := traces[0]
+	replay.ID = primitive.NewObjectID()
+	replay.TraceID = "r1"
+	replay.Status = "success"
+	replay.Error = ""
+	replay.LatencyMS = 1200
+	replay.Output = "Day 1: Alfama\nDay 2: Belém"
+	replay.SubSteps = []model.SubStep{replay.SubSteps[0], {Name: "search", Status: "success"}, replay.SubSteps[2]}
+	repo := &memoryRepo{traces: append(traces, replay)}
+
+	out, err := run(t, repo, "", "diff", traceID.Hex(), replay.ID.Hex())
+	require.NoError(t, err)
+
+	assert.Contains(t, out, "t1 → r1: differ")
+	assert.Regexp(t, `Latency:\s+1.50s → 1.20s \(-300ms\)`, out)
+	assert.Regexp(t, `Status:\s+"error" → "success"`, out)
+	assert.Contains(t, out, "Output:\n  + Day 1: Alfama\n  + Day 2: Belém\n")
+	assert.Contains(t, out, strings.Join([]string{
+		"Steps:",
+		"  = plan",
+		`  ~ search  status "error" → "success"`,
+		`      error: "timeout" → ""`,
+		"  = answer",
+	}, "\n"))
+
+	_, err = run(t, repo, "", "diff", traceID.Hex())
+	assert.ErrorContains(t, err, "exactly two trace IDs")
+}
+
+func TestSearch(t *testing.T) {
+	out, err := run(t, &memoryRepo{traces: fixtures()}, "", "search", "-json", "LISBON")
+	require.NoError(t, err)
+
+	var traces []model.Trace
+	require.NoError(t, json.Unmarshal([]byte(out), &traces))
+	require.Len(t, traces, 1)
+	assert.Equal(t, "t1", traces[0].TraceID)
+
+	out, err = run(t, &memoryRepo{traces: fixtures()}, "", "search", "web")
+	require.NoError(t, err)
+	assert.Contains(t, out, "no traces found")
+}
+
+func TestStats(t *testing.T) {
+	out, err := run(t, &memoryRepo{traces: fixtures()}, "", "stats", "-json")
+	require.NoError(t, err)
+
+	var stats Stats
+	require.NoError(t, json.Unmarshal([]byte(out), &stats))
+	assert.Equal(t, 3, stats.Traces)
+	assert.Equal(t, 1, stats.Errors)
+	assert.Equal(t, 400, stats.LatencyP50)
+	assert.Equal(t, 1500, stats.LatencyMax)
+	assert.Equal(t, 65, stats.TotalTokens)
+	require.Len(t, stats.Agents, 2)
+	assert.Equal(t, "Writer", stats.Agents[0].Agent)
+	assert.Equal(t, 2, stats.Agents[0].Traces)
+
+	out, err = run(t, &memoryRepo{traces: fixtures()}, "", "stats")
+	require.NoError(t, err)
+	assert.Regexp(t, `all\s+3\s+1\s+33.3%`, out)
+}
+
+func TestExport(t *testing.T) {
+	out, err := run(t, &memoryRepo{traces: fixtures()}, "", "export", "-format", "csv")
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "trace_id,"))
+}
+
+func TestImport(t *testing.T) {
+	repo := &memoryRepo{}
+	out, err := run(t, repo, `{"trace_id":"a"}`+"\n"+`{"agent_name":"x"}`, "import")
+	require.NoError(t, err)
+
+	assert.Equal(t, "line 2: invalid: invalid trace (trace_id: is required)\nimported 1, skipped 0, invalid 1\n", out)
+	assert.Len(t, repo.inserted, 1)
+}
+
+func TestUnknownCommand(t *testing.T) {
+	_, err := run(t, &memoryRepo{}, "", "frobnicate")
+	assert.ErrorIs(t, err, ErrUnknownCommand)
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"30m", now.Add(-30 * time.Minute)},
+		{"7d", now.Add(-7 * 24 * time.Hour)},
+		{"2025-05-01", time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"2025-05-01T10:00:00Z", time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.in, now)
+		require.NoError(t, err, tt.in)
+		assert.True(t, tt.want.Equal(got), tt.in)
+	}
+
+	_, err := parseTime("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, 5, percentile(values, 50))
+	assert.Equal(t, 10, percentile(values, 95))
+	assert.Equal(t, 7, percentile([]int{7}, 99))
+}
@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/diff"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// maxCell bounds free text shown in tables and trace headers.
+const maxCell = 60
+
+// writeTable prints one row per trace.
+func writeTable(w io.Writer, traces []model.Trace) error {
+	if len(traces) == 0 {
+		_, err := fmt.Fprintln(w, "no traces found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTRACE ID\tAGENT\tSTATUS\tLATENCY\tTOKENS\tSTEPS\tTIME")
+	for _, t := range traces {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			storageID(t), t.TraceID, t.AgentName, statusLabel(t.Status), latency(t.LatencyMS),
+			t.TokenUsage.Total, len(t.SubSteps), timestamp(t.Timestamp))
+	}
+	return tw.Flush()
+}
+
+// tailLine formats a trace as a single line for the live tail.
+func tailLine(t model.Trace) string {
+	line := fmt.Sprintf("%s  %-20s %-7s %8s %7d tok  %s",
+		t.Timestamp.Local().Format("15:04:05"), t.AgentName, statusLabel(t.Status),
+		latency(t.LatencyMS), t.TokenUsage.Total, t.TraceID)
+	if t.Error != "" {
+		line += "  " + truncate(t.Error, maxCell)
+	}
+	return line
+}
+
+// writeTrace prints a trace header followed by its substeps as a tree.
+func writeTrace(w io.Writer, t model.Trace) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", name, value)
+		}
+	}
+	field("Trace", t.TraceID)
+	field("ID", storageID(t))
+	field("Agent", t.AgentName)
+	field("Session", t.SessionID)
+	field("Status", statusLabel(t.Status))
+	field("Time", timestamp(t.Timestamp))
+	field("Latency", latency(t.LatencyMS))
+	field("Tokens", fmt.Sprintf("%d (in %d, out %d)", t.TokenUsage.Total, t.TokenUsage.Input, t.TokenUsage.Output))
+	if t.ModelParams != nil {
+		field("Model", t.ModelParams.Model)
+	}
+	if len(t.Services) > 1 {
+		field("Services", strings.Join(t.Services, ", "))
+	}
+	field("Tags", strings.Join(t.Tags, ", "))
+	for _, k := range sortedKeys(t.Metadata) {
+		field("Meta "+k, t.Metadata[k])
+	}
+	field("Input", truncate(t.InputPrompt, maxCell))
+	field("Output", truncate(t.Output, maxCell))
+	field("Error", t.Error)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(t.SubSteps) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%s (%d steps)\n", t.AgentName, len(t.SubSteps))
+	writeTree(w, t.SubSteps)
+	return nil
+}
+
+// writeTree prints steps nested by ParentID. Steps whose parent is unknown
+// are shown at the top level, in recorded order.
+func writeTree(w io.Writer, steps []model.SubStep) {
+	known := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		if s.SpanID != "" {
+			known[s.SpanID] = true
+		}
+	}
+
+	children := make(map[string][]int)
+	var roots []int
+	for i, s := range steps {
+		if s.ParentID != "" && s.ParentID != s.SpanID && known[s.ParentID] {
+			children[s.ParentID] = append(children[s.ParentID], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+
+	visited := make(map[int]bool, len(steps))
+	var walk func(prefix string, idxs []int)
+	walk = func(prefix string, idxs []int) {
+		for n, i := range idxs {
+			if visited[i] {
+				continue
+			}
+			visited[i] = true
+
+			branch, indent := "├── ", "│   "
+			if n == len(idxs)-1 {
+				branch, indent = "└── ", "    "
+			}
+			fmt.Fprintf(w, "%s%s%s\n", prefix, branch, stepLine(steps[i]))
+			if id := steps[i].SpanID; id != "" {
+				walk(prefix+indent, children[id])
+			}
+		}
+	}
+	walk("", roots)
+}
+
+func stepLine(s model.SubStep) string {
+	parts := []string{s.Name, "[" + statusLabel(s.Status) + "]"}
+	if !s.Start.IsZero() && !s.End.IsZero() {
+		parts = append(parts, s.End.Sub(s.Start).Round(time.Millisecond).String())
+	}
+	if s.ModelParams != nil && s.ModelParams.Model != "" {
+		parts = append(parts, s.ModelParams.Model)
+	}
+	if s.TokenUsage != nil && s.TokenUsage.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%d tok", s.TokenUsage.Total))
+	}
+	for _, call := range s.ToolCalls {
+		parts = append(parts, "tool:"+call.Name)
+	}
+	if s.Error != "" {
+		parts = append(parts, "error: "+truncate(s.Error, maxCell))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// writeDiff prints the trace-level changes followed by one line per aligned
+// substep, marked = unchanged, ~ changed, - only in A and + only in B.
+func writeDiff(w io.Writer, d diff.Result) error {
+	verdict := "differ"
+	if d.Identical {
+		verdict = "identical"
+	}
+	fmt.Fprintf(w, "%s → %s: %s\n", d.A, d.B, verdict)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Latency:\t%s\n", latencyChange(d.LatencyMS))
+	if d.TokenUsage != nil {
+		fmt.Fprintf(tw, "Tokens:\t%s\n", intChange(d.TokenUsage.Total))
+	}
+	for _, f := range []struct {
+		name   string
+		change *diff.StringChange
+	}{{"Status", d.Status}, {"Input", d.Input}, {"Output", d.Output}, {"Error", d.Error}} {
+		if f.change != nil && f.change.Lines == nil {
+			fmt.Fprintf(tw, "%s:\t%s\n", f.name, stringChange(f.change))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name   string
+		change *diff.StringChange
+	}{{"Input", d.Input}, {"Output", d.Output}, {"Error", d.Error}} {
+		if f.change != nil && f.change.Lines != nil {
+			fmt.Fprintf(w, "%s:\n", f.name)
+			writeLines(w, "  ", f.change.Lines)
+		}
+	}
+
+	if len(d.SubSteps) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Steps:")
+	for _, s := range d.SubSteps {
+		fmt.Fprintln(w, stepDiffLine(s))
+		for _, f := range []struct {
+			name   string
+			change *diff.StringChange
+		}{{"input", s.Input}, {"output", s.Output}, {"error", s.Error}} {
+			if f.change == nil {
+				continue
+			}
+			if f.change.Lines == nil {
+				fmt.Fprintf(w, "      %s: %s\n", f.name, stringChange(f.change))
+				continue
+			}
+			fmt.Fprintf(w, "      %s:\n", f.name)
+			writeLines(w, "        ", f.change.Lines)
+		}
+	}
+	return nil
+}
+
+func stepDiffLine(s diff.StepDiff) string {
+	mark := map[string]string{diff.Unchanged: "=", diff.Changed: "~", diff.Removed: "-", diff.Added: "+"}[s.Change]
+	parts := []string{"  " + mark + " " + s.Name}
+	if s.Status != nil {
+		parts = append(parts, "status "+stringChange(s.Status))
+	}
+	if s.DurationMS != nil {
+		parts = append(parts, "duration "+latencyChange(s.DurationMS))
+	}
+	if s.TokenUsage != nil && s.TokenUsage.Total != nil {
+		parts = append(parts, "tokens "+intChange(s.TokenUsage.Total))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func writeLines(w io.Writer, indent string, lines []diff.Line) {
+	for _, l := range lines {
+		switch {
+		case l.Skipped > 0:
+			fmt.Fprintf(w, "%s  … %d unchanged lines\n", indent, l.Skipped)
+		case l.Change == diff.Removed:
+			fmt.Fprintf(w, "%s- %s\n", indent, l.Text)
+		case l.Change == diff.Added:
+			fmt.Fprintf(w, "%s+ %s\n", indent, l.Text)
+		default:
+			fmt.Fprintf(w, "%s  %s\n", indent, l.Text)
+		}
+	}
+}
+
+func stringChange(c *diff.StringChange) string {
+	return fmt.Sprintf("%q → %q", truncate(c.From, maxCell/2), truncate(c.To, maxCell/2))
+}
+
+func intChange(c *diff.IntChange) string {
+	if c == nil {
+		return "unchanged"
+	}
+	return fmt.Sprintf("%d → %d (%+d)", c.From, c.To, c.Delta)
+}
+
+func latencyChange(c *diff.IntChange) string {
+	if c == nil {
+		return "unchanged"
+	}
+	sign := "+"
+	if c.Delta < 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s → %s (%s%s)", latency(c.From), latency(c.To), sign, latency(abs(c.Delta)))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func writeImportResult(w io.Writer, r ImportResult) error {
+	for _, line := range r.InvalidLines {
+		var details []string
+		for _, e := range line.Errors {
+			details = append(details, e.Field+": "+e.Message)
+		}
+		msg := fmt.Sprintf("line %d: invalid: %s", line.Line, line.Reason)
+		if len(details) > 0 {
+			msg += " (" + strings.Join(details, "; ") + ")"
+		}
+		fmt.Fprintln(w, msg)
+	}
+	for _, line := range r.SkippedLines {
+		fmt.Fprintf(w, "line %d: skipped %s: %s\n", line.Line, line.TraceID, line.Reason)
+	}
+	_, err := fmt.Fprintf(w, "imported %d, skipped %d, invalid %d\n", r.Imported, r.Skipped, r.Invalid)
+	return err
+}
+
+func storageID(t model.Trace) string {
+	if t.ID.IsZero() {
+		return "-"
+	}
+	return t.ID.Hex()
+}
+
+func statusLabel(status string) string {
+	switch status {
+	case "":
+		return "-"
+	case "error":
+		return "ERROR"
+	}
+	return status
+}
+
+func latency(ms int) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return fmt.Sprintf("%.2fs", float64(ms)/1000)
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncate shortens s to n runes on a single line.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
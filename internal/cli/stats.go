@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// Stats summarises a set of traces overall and per agent.
+type Stats struct {
+	Summary
+	Agents []AgentStats `json:"agents"`
+}
+
+type AgentStats struct {
+	Agent string `json:"agent"`
+	Summary
+}
+
+type Summary struct {
+	Traces       int     `json:"traces"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyP50   int     `json:"latency_p50_ms"`
+	LatencyP95   int     `json:"latency_p95_ms"`
+	LatencyP99   int     `json:"latency_p99_ms"`
+	LatencyMax   int     `json:"latency_max_ms"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+}
+
+// aggregator accumulates traces streamed from the server.
+type aggregator struct {
+	all    bucket
+	agents map[string]*bucket
+}
+
+type bucket struct {
+	summary   Summary
+	latencies []int
+}
+
+func (b *bucket) add(t model.Trace) {
+	b.summary.Traces++
+	if model.IsFailure(t.Status) {
+		b.summary.Errors++
+	}
+	b.summary.InputTokens += t.TokenUsage.Input
+	b.summary.OutputTokens += t.TokenUsage.Output
+	b.summary.TotalTokens += t.TokenUsage.Total
+	b.latencies = append(b.latencies, t.LatencyMS)
+}
+
+func (b *bucket) finish() Summary {
+	s := b.summary
+	if s.Traces == 0 {
+		return s
+	}
+	s.ErrorRate = float64(s.Errors) / float64(s.Traces)
+
+	sort.Ints(b.latencies)
+	s.LatencyP50 = percentile(b.latencies, 50)
+	s.LatencyP95 = percentile(b.latencies, 95)
+	s.LatencyP99 = percentile(b.latencies, 99)
+	s.LatencyMax = b.latencies[len(b.latencies)-1]
+	return s
+}
+
+func (a *aggregator) add(t model.Trace) {
+	if a.agents == nil {
+		a.agents = make(map[string]*bucket)
+	}
+	a.all.add(t)
+	b, ok := a.agents[t.AgentName]
+	if !ok {
+		b = &bucket{}
+		a.agents[t.AgentName] = b
+	}
+	b.add(t)
+}
+
+// stats returns the totals with agents ordered by trace count, busiest first.
+func (a *aggregator) stats() Stats {
+	stats := Stats{Summary: a.all.finish(), Agents: []AgentStats{}}
+	for name, b := range a.agents {
+		stats.Agents = append(stats.Agents, AgentStats{Agent: name, Summary: b.finish()})
+	}
+	sort.Slice(stats.Agents, func(i, j int) bool {
+		if stats.Agents[i].Traces != stats.Agents[j].Traces {
+			return stats.Agents[i].Traces > stats.Agents[j].Traces
+		}
+		return stats.Agents[i].Agent < stats.Agents[j].Agent
+	})
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int, p int) int {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func writeStats(w io.Writer, s Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "AGENT\tTRACES\tERRORS\tERROR %\tP50\tP95\tP99\tMAX\tTOKENS\t")
+	row := func(name string, s Summary) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%s\t%s\t%d\t\n",
+			name, s.Traces, s.Errors, s.ErrorRate*100,
+			latency(s.LatencyP50), latency(s.LatencyP95), latency(s.LatencyP99), latency(s.LatencyMax), s.TotalTokens)
+	}
+	for _, a := range s.Agents {
+		row(a.Agent, a.Summary)
+	}
+	row("all", s.Summary)
+	return tw.Flush()
+}
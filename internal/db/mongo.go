@@ -2,18 +2,59 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/zkropotkine/agent-trace/pkg/logger"
 )
 
-func NewMongoClient(uri string) (*mongo.Client, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// Startup retry delays: the first retry waits initialBackoff and each one
+// after doubles, up to maxBackoff. A single ping waits at most pingTimeout.
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+	pingTimeout    = 5 * time.Second
+)
+
+// NewMongoClient connects to uri and pings the server, retrying with
+// exponential backoff while it is unreachable, e.g. while the database
+// container is still starting. It gives up after timeout.
+func NewMongoClient(ctx context.Context, uri string, timeout time.Duration) (*mongo.Client, error) {
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
 		return nil, err
 	}
-	return client, nil
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log := logger.FromContext(ctx)
+	delay := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = Ping(ctx, client)
+		if err == nil {
+			return client, nil
+		}
+		log.Warnf("MongoDB not reachable (attempt %d), retrying in %s: %v", attempt, delay, err)
+
+		select {
+		case <-ctx.Done():
+			_ = client.Disconnect(context.WithoutCancel(ctx))
+			return nil, fmt.Errorf("MongoDB not reachable after %s: %w", timeout, err)
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxBackoff)
+	}
+}
+
+// Ping checks that the primary answers, waiting at most pingTimeout.
+func Ping(ctx context.Context, client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	return client.Ping(ctx, readpref.Primary())
 }
@@ -0,0 +1,18 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMongoClient_GivesUp(t *testing.T) {
+	start := time.Now()
+	client, err := NewMongoClient(context.Background(), "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=50", 700*time.Millisecond)
+
+	assert.Nil(t, client)
+	assert.ErrorContains(t, err, "MongoDB not reachable after 700ms")
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
@@ -0,0 +1,174 @@
+// Package diff compares two traces, for example a recorded run and its replay.
+package diff
+
+import (
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// Step and line change kinds.
+const (
+	Added     = "added"
+	Removed   = "removed"
+	Changed   = "changed"
+	Unchanged = "unchanged"
+)
+
+// Result describes how trace B differs from trace A. Nil fields are equal.
+// Timing differs between any two runs, so latency and step durations are
+// reported but do not make traces differ.
+type Result struct {
+	A          string        `json:"a"`
+	B          string        `json:"b"`
+	Identical  bool          `json:"identical"`
+	Status     *StringChange `json:"status,omitempty"`
+	Input      *StringChange `json:"input_prompt,omitempty"`
+	Output     *StringChange `json:"output,omitempty"`
+	Error      *StringChange `json:"error,omitempty"`
+	LatencyMS  *IntChange    `json:"latency_ms,omitempty"`
+	TokenUsage *TokenChange  `json:"token_usage,omitempty"`
+	SubSteps   []StepDiff    `json:"substeps"`
+}
+
+// StringChange is a changed text value. Lines holds a line-level diff when
+// either side spans several lines.
+type StringChange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Lines []Line `json:"lines,omitempty"`
+}
+
+type IntChange struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Delta int `json:"delta"`
+}
+
+// TokenChange reports the token counts that changed.
+type TokenChange struct {
+	Input  *IntChange `json:"input_tokens,omitempty"`
+	Output *IntChange `json:"output_tokens,omitempty"`
+	Total  *IntChange `json:"total,omitempty"`
+}
+
+// StepDiff compares a substep of A with its counterpart in B. AIndex and
+// BIndex are positions in each trace's substeps, or -1 if the step is missing.
+type StepDiff struct {
+	Name       string        `json:"name"`
+	Change     string        `json:"change"`
+	AIndex     int           `json:"a_index"`
+	BIndex     int           `json:"b_index"`
+	Status     *StringChange `json:"status,omitempty"`
+	Input      *StringChange `json:"input,omitempty"`
+	Output     *StringChange `json:"output,omitempty"`
+	Error      *StringChange `json:"error,omitempty"`
+	DurationMS *IntChange    `json:"duration_ms,omitempty"`
+	TokenUsage *TokenChange  `json:"token_usage,omitempty"`
+}
+
+// Compare reports the differences between a and b.
+func Compare(a, b model.Trace) Result {
+	res := Result{
+		A:          a.TraceID,
+		B:          b.TraceID,
+		Status:     compareString(a.Status, b.Status),
+		Input:      compareString(a.InputPrompt, b.InputPrompt),
+		Output:     compareString(a.Output, b.Output),
+		Error:      compareString(a.Error, b.Error),
+		LatencyMS:  compareInt(a.LatencyMS, b.LatencyMS),
+		TokenUsage: compareTokens(a.TokenUsage, b.TokenUsage),
+		SubSteps:   compareSteps(a.SubSteps, b.SubSteps),
+	}
+
+	res.Identical = res.Status == nil && res.Input == nil && res.Output == nil &&
+		res.Error == nil && res.TokenUsage == nil
+	for _, s := range res.SubSteps {
+		if s.Change != Unchanged {
+			res.Identical = false
+		}
+	}
+	return res
+}
+
+// compareSteps aligns the steps of a and b on the longest common subsequence
+// of their names, so a step inserted or dropped in one run does not shift
+// every later comparison.
+func compareSteps(a, b []model.SubStep) []StepDiff {
+	names := func(steps []model.SubStep) []string {
+		out := make([]string, len(steps))
+		for i, s := range steps {
+			out[i] = s.Name
+		}
+		return out
+	}
+
+	diffs := make([]StepDiff, 0, max(len(a), len(b)))
+	for _, op := range align(names(a), names(b)) {
+		switch op.change {
+		case Removed:
+			diffs = append(diffs, StepDiff{Name: a[op.a].Name, Change: Removed, AIndex: op.a, BIndex: -1})
+		case Added:
+			diffs = append(diffs, StepDiff{Name: b[op.b].Name, Change: Added, AIndex: -1, BIndex: op.b})
+		default:
+			diffs = append(diffs, compareStep(a[op.a], b[op.b], op.a, op.b))
+		}
+	}
+	return diffs
+}
+
+func compareStep(a, b model.SubStep, i, j int) StepDiff {
+	d := StepDiff{
+		Name:       b.Name,
+		AIndex:     i,
+		BIndex:     j,
+		Status:     compareString(a.Status, b.Status),
+		Input:      compareString(a.Input, b.Input),
+		Output:     compareString(a.Output, b.Output),
+		Error:      compareString(a.Error, b.Error),
+		DurationMS: compareInt(durationMS(a), durationMS(b)),
+		TokenUsage: compareTokens(stepTokens(a), stepTokens(b)),
+	}
+	d.Change = Unchanged
+	if d.Status != nil || d.Input != nil || d.Output != nil || d.Error != nil || d.TokenUsage != nil {
+		d.Change = Changed
+	}
+	return d
+}
+
+func durationMS(s model.SubStep) int {
+	if s.Start.IsZero() || s.End.IsZero() {
+		return 0
+	}
+	return int(s.End.Sub(s.Start).Milliseconds())
+}
+
+func stepTokens(s model.SubStep) model.TokenUsage {
+	if s.TokenUsage == nil {
+		return model.TokenUsage{}
+	}
+	return *s.TokenUsage
+}
+
+func compareString(a, b string) *StringChange {
+	if a == b {
+		return nil
+	}
+	return &StringChange{From: a, To: b, Lines: Lines(a, b)}
+}
+
+func compareInt(a, b int) *IntChange {
+	if a == b {
+		return nil
+	}
+	return &IntChange{From: a, To: b, Delta: b - a}
+}
+
+func compareTokens(a, b model.TokenUsage) *TokenChange {
+	if a == b {
+		return nil
+	}
+	return &TokenChange{
+		Input:  compareInt(a.Input, b.Input),
+		Output: compareInt(a.Output, b.Output),
+		Total:  compareInt(a.Total, b.Total),
+	}
+}
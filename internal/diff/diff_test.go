@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func TestCompare(t *testing.T) {
+	a := model.Trace{
+		TraceID: "a", Status: "success", Output: "Lisbon", LatencyMS: 100,
+		TokenUsage: model.TokenUsage{Total: 10},
+		SubSteps: []model.SubStep{
+			{Name: "plan", Status: "success", Output: "1"},
+			{Name: "search", Status: "success"},
+			{Name: "search", Status: "success"},
+			{Name: "answer", Status: "success"},
+		},
+	}
+	b := model.Trace{
+		TraceID: "b", Status: "error", Output: "Porto", LatencyMS: 150,
+		TokenUsage: model.TokenUsage{Total: 10},
+		SubSteps: []model.SubStep{
+			{Name: "plan", Status: "success", Output: "2"},
+			{Name: "search", Status: "error"},
+			{Name: "rerank", Status: "success"},
+		},
+	}
+
+	res := Compare(a, b)
+
+	assert.False(t, res.Identical)
+	assert.Equal(t, &StringChange{From: "success", To: "error"}, res.Status)
+	assert.Equal(t, &StringChange{From: "Lisbon", To: "Porto"}, res.Output)
+	assert.Equal(t, &IntChange{From: 100, To: 150, Delta: 50}, res.LatencyMS)
+	assert.Nil(t, res.TokenUsage)
+	assert.Equal(t, []StepDiff{
+		{Name: "plan", Change: Changed, AIndex: 0, BIndex: 0, Output: &StringChange{From: "1", To: "2"}},
+		{Name: "search", Change: Changed, AIndex: 1, BIndex: 1, Status: &StringChange{From: "success", To: "error"}},
+		{Name: "search", Change: Removed, AIndex: 2, BIndex: -1},
+		{Name: "answer", Change: Removed, AIndex: 3, BIndex: -1},
+		{Name: "rerank", Change: Added, AIndex: -1, BIndex: 2},
+	}, res.SubSteps)
+}
+
+func TestCompareAlignsInsertedSteps(t *testing.T) {
+	start := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	step := func(name string, ms int, tokens int) model.SubStep {
+		return model.SubStep{
+			Name: name, Status: "success", Start: start, End: start.Add(time.Duration(ms) * time.Millisecond),
+			TokenUsage: &model.TokenUsage{Total: tokens},
+		}
+	}
+	a := model.Trace{TraceID: "a", SubSteps: []model.SubStep{step("plan", 10, 5), step("search", 20, 0), step("answer", 30, 50)}}
+	b := model.Trace{TraceID: "b", SubSteps: []model.SubStep{step("plan", 10, 5), step("rewrite", 5, 8), step("search", 25, 0), step("answer", 30, 70)}}
+
+	res := Compare(a, b)
+
+	require.Len(t, res.SubSteps, 4)
+	assert.Equal(t, Unchanged, res.SubSteps[0].Change)
+	assert.Equal(t, StepDiff{Name: "rewrite", Change: Added, AIndex: -1, BIndex: 1}, res.SubSteps[1])
+	assert.Equal(t, Unchanged, res.SubSteps[2].Change, "durations alone do not change a step")
+	assert.Equal(t, &IntChange{From: 20, To: 25, Delta: 5}, res.SubSteps[2].DurationMS)
+	assert.Equal(t, Changed, res.SubSteps[3].Change)
+	assert.Equal(t, &TokenChange{Total: &IntChange{From: 50, To: 70, Delta: 20}}, res.SubSteps[3].TokenUsage)
+}
+
+func TestLines(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten"
+	b := "one\ntwo\nthree\nfour\nfive\nsix\nseven\nEIGHT\nnine\nten"
+
+	assert.Equal(t, []Line{
+		{Change: Unchanged, Skipped: 4},
+		{Change: Unchanged, Text: "five"},
+		{Change: Unchanged, Text: "six"},
+		{Change: Unchanged, Text: "seven"},
+		{Change: Removed, Text: "eight"},
+		{Change: Added, Text: "EIGHT"},
+		{Change: Unchanged, Text: "nine"},
+		{Change: Unchanged, Text: "ten"},
+	}, Lines(a, b))
+	assert.Nil(t, Lines("short", "other"), "single-line values are compared whole")
+}
+
+func TestCompareLongOutput(t *testing.T) {
+	a := model.Trace{Output: "Summary:\n- revenue up\n- costs flat"}
+	b := model.Trace{Output: "Summary:\n- revenue up\n- costs down"}
+
+	res := Compare(a, b)
+
+	require.NotNil(t, res.Output)
+	assert.Equal(t, []Line{
+		{Change: Unchanged, Text: "Summary:"},
+		{Change: Unchanged, Text: "- revenue up"},
+		{Change: Removed, Text: "- costs flat"},
+		{Change: Added, Text: "- costs down"},
+	}, res.Output.Lines)
+}
+
+func TestCompareIdentical(t *testing.T) {
+	trace := model.Trace{TraceID: "a", Output: "x", LatencyMS: 10, SubSteps: []model.SubStep{{Name: "s"}}}
+	replay := trace
+	replay.TraceID = "b"
+	replay.LatencyMS = 12
+
+	res := Compare(trace, replay)
+
+	assert.True(t, res.Identical, "latency alone does not make runs differ")
+	assert.NotNil(t, res.LatencyMS)
+}
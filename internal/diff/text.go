@@ -0,0 +1,160 @@
+package diff
+
+import "strings"
+
+// contextLines is the number of unchanged lines kept around each change in a
+// line diff; longer unchanged runs are collapsed.
+const contextLines = 3
+
+// maxAlignCells bounds the table used to align two sequences. Beyond it the
+// differing middle is reported as removed then added instead of aligned.
+const maxAlignCells = 4 << 20
+
+// Line is one line of a line-level text diff. A collapsed run of unchanged
+// lines has an empty Text and the number of lines it stands for in Skipped.
+type Line struct {
+	Change  string `json:"change"`
+	Text    string `json:"text,omitempty"`
+	Skipped int    `json:"skipped,omitempty"`
+}
+
+// Lines returns a line-level diff of a and b, or nil when neither spans
+// several lines and the values are best compared whole.
+func Lines(a, b string) []Line {
+	if !strings.Contains(a, "\n") && !strings.Contains(b, "\n") {
+		return nil
+	}
+	al, bl := splitLines(a), splitLines(b)
+
+	ops := align(al, bl)
+	lines := make([]Line, 0, len(ops))
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+		switch op.change {
+		case Removed:
+			lines = append(lines, Line{Change: Removed, Text: al[op.a]})
+			continue
+		case Added:
+			lines = append(lines, Line{Change: Added, Text: bl[op.b]})
+			continue
+		}
+
+		end := i
+		for end < len(ops) && ops[end].change == Unchanged {
+			end++
+		}
+		keepBefore, keepAfter := contextLines, contextLines
+		if i == 0 {
+			keepBefore = 0
+		}
+		if end == len(ops) {
+			keepAfter = 0
+		}
+		if end-i <= keepBefore+keepAfter {
+			keepBefore, keepAfter = end-i, 0
+		}
+		for k := i; k < i+keepBefore; k++ {
+			lines = append(lines, Line{Change: Unchanged, Text: al[ops[k].a]})
+		}
+		if skipped := end - i - keepBefore - keepAfter; skipped > 0 {
+			lines = append(lines, Line{Change: Unchanged, Skipped: skipped})
+		}
+		for k := end - keepAfter; k < end; k++ {
+			lines = append(lines, Line{Change: Unchanged, Text: al[ops[k].a]})
+		}
+		i = end - 1
+	}
+	return lines
+}
+
+// splitLines splits s on newlines; an empty string has no lines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// alignOp pairs a[a] with b[b] (Unchanged), or marks a[a] as Removed or b[b]
+// as Added.
+type alignOp struct {
+	change string
+	a, b   int
+}
+
+// align returns the edit script turning a into b that keeps the longest
+// common subsequence, preferring removals before additions.
+func align(a, b []string) []alignOp {
+	ops := make([]alignOp, 0, max(len(a), len(b)))
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, alignOp{Unchanged, prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am)*len(bm) > maxAlignCells {
+		for i := range am {
+			ops = append(ops, alignOp{Removed, prefix + i, -1})
+		}
+		for j := range bm {
+			ops = append(ops, alignOp{Added, -1, prefix + j})
+		}
+	} else {
+		ops = append(ops, lcs(am, bm, prefix)...)
+	}
+
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, alignOp{Unchanged, len(a) - k, len(b) - k})
+	}
+	return ops
+}
+
+// lcs aligns a and b with the classic dynamic programming table; offset is
+// added to every reported index.
+func lcs(a, b []string, offset int) []alignOp {
+	n, m := len(a), len(b)
+	// length[i][j] is the LCS length of a[i:] and b[j:].
+	length := make([][]int, n+1)
+	for i := range length {
+		length[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				length[i][j] = length[i+1][j+1] + 1
+			} else {
+				length[i][j] = max(length[i+1][j], length[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]alignOp, 0, max(n, m))
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, alignOp{Unchanged, offset + i, offset + j})
+			i++
+			j++
+		case length[i+1][j] >= length[i][j+1]:
+			ops = append(ops, alignOp{Removed, offset + i, -1})
+			i++
+		default:
+			ops = append(ops, alignOp{Added, -1, offset + j})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, alignOp{Removed, offset + i, -1})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, alignOp{Added, -1, offset + j})
+	}
+	return ops
+}
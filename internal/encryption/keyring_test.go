@@ -0,0 +1,106 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestKeyring_RoundTrip(t *testing.T) {
+	ring, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+
+	sealed, err := ring.Encrypt("Summarize privacy policy.")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:k1:"))
+	assert.NotContains(t, sealed, "privacy")
+
+	opened, err := ring.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "Summarize privacy policy.", opened)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+	sealed, err := old.Encrypt("secret")
+	require.NoError(t, err)
+
+	rotated, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	require.NoError(t, err)
+
+	opened, err := rotated.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", opened)
+
+	resealed, err := rotated.Encrypt("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resealed, "enc:v1:k2:"))
+
+	_, err = old.Decrypt(resealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeyring_Passthrough(t *testing.T) {
+	ring, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+
+	sealed, err := ring.Encrypt("")
+	require.NoError(t, err)
+	assert.Empty(t, sealed)
+
+	opened, err := ring.Decrypt("legacy plaintext")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy plaintext", opened)
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	ring, err := NewKeyring("k1", map[string][]byte{"k1": testKey(1)})
+	require.NoError(t, err)
+
+	_, err = ring.Decrypt("enc:v1:k1:not-enough-parts")
+	assert.ErrorIs(t, err, ErrMalformedValue)
+
+	sealed, err := ring.Encrypt("secret")
+	require.NoError(t, err)
+	_, err = ring.Decrypt(sealed[:len(sealed)-2] + "AA")
+	assert.Error(t, err)
+}
+
+func TestNewKeyring_Validation(t *testing.T) {
+	tests := []struct {
+		name     string
+		activeID string
+		keys     map[string][]byte
+		err      error
+	}{
+		{"missing active key", "k2", map[string][]byte{"k1": testKey(1)}, ErrNoActiveKey},
+		{"short key", "k1", map[string][]byte{"k1": []byte("short")}, ErrInvalidKeyBytes},
+		{"invalid key id", "k:1", map[string][]byte{"k:1": testKey(1)}, ErrInvalidKeyID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.activeID, tt.keys)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(map[string]string{"k1": base64.StdEncoding.EncodeToString(testKey(7))})
+	require.NoError(t, err)
+	assert.Equal(t, testKey(7), keys["k1"])
+
+	_, err = ParseKeys(map[string]string{"k1": "%%%"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+var csvHeader = []string{
+	"trace_id", "session_id", "agent_name", "status", "timestamp", "created_at", "latency_ms",
+	"input_tokens", "output_tokens", "total_tokens", "substep_count", "substep_failures", "tool_call_count",
+	"model", "input_prompt", "output", "error", "tags", "metadata",
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Write(trace model.Trace) error {
+	if !c.wroteHeader {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+
+	row := Flatten(trace)
+	return c.w.Write([]string{
+		row.TraceID,
+		row.SessionID,
+		row.AgentName,
+		row.Status,
+		csvTime(row.Timestamp),
+		csvTime(row.CreatedAt),
+		strconv.FormatInt(row.LatencyMS, 10),
+		strconv.FormatInt(row.InputTokens, 10),
+		strconv.FormatInt(row.OutputTokens, 10),
+		strconv.FormatInt(row.TotalTokens, 10),
+		strconv.FormatInt(row.SubStepCount, 10),
+		strconv.FormatInt(row.SubStepFailures, 10),
+		strconv.FormatInt(row.ToolCallCount, 10),
+		row.Model,
+		row.InputPrompt,
+		row.Output,
+		row.Error,
+		strings.Join(row.Tags, ";"),
+		row.Metadata,
+	})
+}
+
+// Close writes the header if no trace was written, so an empty export is
+// still a valid CSV file, and flushes buffered rows.
+func (c *csvWriter) Close() error {
+	if !c.wroteHeader {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
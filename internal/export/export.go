@@ -0,0 +1,119 @@
+// Package export writes traces in formats suited to offline analysis.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// Supported formats.
+const (
+	CSV     = "csv"
+	JSONL   = "jsonl"
+	Parquet = "parquet"
+)
+
+// ErrUnknownFormat is returned by NewWriter for an unsupported format.
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Writer encodes traces one at a time. Close must be called once all traces
+// have been written to emit any trailing data, such as the Parquet footer.
+type Writer interface {
+	Write(trace model.Trace) error
+	Close() error
+}
+
+// NewWriter returns a Writer encoding traces to w in format.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSVWriter(w), nil
+	case JSONL:
+		return newJSONLWriter(w), nil
+	case Parquet:
+		return newParquetWriter(w), nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns the MIME type for format.
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case JSONL:
+		return "application/x-ndjson"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	}
+
+	return "application/octet-stream"
+}
+
+// Row is the flattened form of a trace used for tabular formats. Token usage
+// and substep counts get their own columns; metadata is kept as a JSON object.
+type Row struct {
+	TraceID         string    `parquet:"trace_id"`
+	SessionID       string    `parquet:"session_id"`
+	AgentName       string    `parquet:"agent_name"`
+	Status          string    `parquet:"status"`
+	Timestamp       time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	CreatedAt       time.Time `parquet:"created_at,timestamp(millisecond)"`
+	LatencyMS       int64     `parquet:"latency_ms"`
+	InputTokens     int64     `parquet:"input_tokens"`
+	OutputTokens    int64     `parquet:"output_tokens"`
+	TotalTokens     int64     `parquet:"total_tokens"`
+	SubStepCount    int64     `parquet:"substep_count"`
+	SubStepFailures int64     `parquet:"substep_failures"`
+	ToolCallCount   int64     `parquet:"tool_call_count"`
+	Model           string    `parquet:"model"`
+	InputPrompt     string    `parquet:"input_prompt"`
+	Output          string    `parquet:"output"`
+	Error           string    `parquet:"error"`
+	Tags            []string  `parquet:"tags,list"`
+	Metadata        string    `parquet:"metadata"`
+}
+
+// Flatten converts trace to a Row.
+func Flatten(trace model.Trace) Row {
+	row := Row{
+		TraceID:      trace.TraceID,
+		SessionID:    trace.SessionID,
+		AgentName:    trace.AgentName,
+		Status:       trace.Status,
+		Timestamp:    trace.Timestamp,
+		CreatedAt:    trace.CreatedAt,
+		LatencyMS:    int64(trace.LatencyMS),
+		InputTokens:  int64(trace.TokenUsage.Input),
+		OutputTokens: int64(trace.TokenUsage.Output),
+		TotalTokens:  int64(trace.TokenUsage.Total),
+		SubStepCount: int64(len(trace.SubSteps)),
+		InputPrompt:  trace.InputPrompt,
+		Output:       trace.Output,
+		Error:        trace.Error,
+		Tags:         trace.Tags,
+	}
+	if trace.ModelParams != nil {
+		row.Model = trace.ModelParams.Model
+	}
+
+	row.ToolCallCount = int64(len(trace.ToolCalls))
+	for _, step := range trace.SubSteps {
+		if model.IsFailure(step.Status) {
+			row.SubStepFailures++
+		}
+		row.ToolCallCount += int64(len(step.ToolCalls))
+	}
+
+	if len(trace.Metadata) > 0 {
+		encoded, _ := json.Marshal(trace.Metadata)
+		row.Metadata = string(encoded)
+	}
+
+	return row
+}
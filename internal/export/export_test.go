@@ -0,0 +1,129 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func sampleTraces() []model.Trace {
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	return []model.Trace{
+		{
+			TraceID:     "t1",
+			AgentName:   "Planner",
+			Status:      "error",
+			Timestamp:   ts,
+			LatencyMS:   120,
+			TokenUsage:  model.TokenUsage{Input: 10, Output: 5, Total: 15},
+			ModelParams: &model.ModelParams{Model: "gpt-4o"},
+			InputPrompt: "plan, then \"act\"",
+			Tags:        []string{"beta", "eu"},
+			Metadata:    map[string]string{"env": "prod"},
+			SubSteps: []model.SubStep{
+				{Name: "llm", Status: "success"},
+				{Name: "search", Status: "failed", ToolCalls: []model.ToolCall{{Name: "web"}}},
+			},
+		},
+		{TraceID: "t2", AgentName: "Writer", Status: "success", Timestamp: ts.Add(time.Minute)},
+	}
+}
+
+func writeAll(t *testing.T, format string, traces []model.Trace) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	require.NoError(t, err)
+	for _, trace := range traces {
+		require.NoError(t, w.Write(trace))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestFlatten(t *testing.T) {
+	row := Flatten(sampleTraces()[0])
+
+	assert.Equal(t, int64(15), row.TotalTokens)
+	assert.Equal(t, int64(2), row.SubStepCount)
+	assert.Equal(t, int64(1), row.SubStepFailures)
+	assert.Equal(t, int64(1), row.ToolCallCount)
+	assert.Equal(t, "gpt-4o", row.Model)
+	assert.Equal(t, `{"env":"prod"}`, row.Metadata)
+}
+
+func TestCSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(writeAll(t, CSV, sampleTraces()))).ReadAll()
+	require.NoError(t, err)
+
+	require.Len(t, records, 3)
+	assert.Equal(t, csvHeader, records[0])
+	assert.Equal(t, []string{
+		"t1", "", "Planner", "error", "2025-06-01T12:00:00Z", "", "120",
+		"10", "5", "15", "2", "1", "1",
+		"gpt-4o", "plan, then \"act\"", "", "", "beta;eu", `{"env":"prod"}`,
+	}, records[1])
+	assert.Equal(t, "t2", records[2][0])
+}
+
+func TestCSVEmpty(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(writeAll(t, CSV, nil))).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{csvHeader}, records)
+}
+
+func TestJSONL(t *testing.T) {
+	scanner := bufio.NewScanner(bytes.NewReader(writeAll(t, JSONL, sampleTraces())))
+
+	var traces []model.Trace
+	for scanner.Scan() {
+		var trace model.Trace
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &trace))
+		traces = append(traces, trace)
+	}
+
+	require.Len(t, traces, 2)
+	assert.Equal(t, "t1", traces[0].TraceID)
+	assert.Len(t, traces[0].SubSteps, 2, "jsonl keeps the full trace")
+}
+
+func TestParquet(t *testing.T) {
+	data := writeAll(t, Parquet, sampleTraces())
+
+	rows, err := parquet.Read[Row](bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	require.Len(t, rows, 2)
+	assert.Equal(t, "t1", rows[0].TraceID)
+	assert.Equal(t, int64(15), rows[0].TotalTokens)
+	assert.Equal(t, int64(2), rows[0].SubStepCount)
+	assert.Equal(t, []string{"beta", "eu"}, rows[0].Tags)
+	assert.True(t, rows[0].Timestamp.Equal(sampleTraces()[0].Timestamp))
+	assert.Equal(t, "Writer", rows[1].AgentName)
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	traces := make([]model.Trace, parquetRowGroupSize+1)
+	for i := range traces {
+		traces[i] = model.Trace{TraceID: "t"}
+	}
+	data := writeAll(t, Parquet, traces)
+
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(traces)), file.NumRows())
+	assert.Len(t, file.RowGroups(), 2)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewWriter("xlsx", &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
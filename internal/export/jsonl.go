@@ -0,0 +1,29 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// jsonlWriter writes one complete trace per line, in the same JSON shape the
+// ingestion API accepts, so exports can be imported again unchanged.
+type jsonlWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	buf := bufio.NewWriter(w)
+	return &jsonlWriter{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (j *jsonlWriter) Write(trace model.Trace) error {
+	return j.enc.Encode(trace)
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.buf.Flush()
+}
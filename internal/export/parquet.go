@@ -0,0 +1,51 @@
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// parquetRowGroupSize bounds the rows buffered in memory before a row group
+// is written out.
+const parquetRowGroupSize = 1000
+
+type parquetWriter struct {
+	w    *parquet.GenericWriter[Row]
+	rows []Row
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w:    parquet.NewGenericWriter[Row](w, parquet.Compression(&parquet.Snappy)),
+		rows: make([]Row, 0, parquetRowGroupSize),
+	}
+}
+
+func (p *parquetWriter) Write(trace model.Trace) error {
+	p.rows = append(p.rows, Flatten(trace))
+	if len(p.rows) < parquetRowGroupSize {
+		return nil
+	}
+	return p.flush()
+}
+
+func (p *parquetWriter) flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if _, err := p.w.Write(p.rows); err != nil {
+		return err
+	}
+	p.rows = p.rows[:0]
+	return p.w.Flush()
+}
+
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/alert"
+)
+
+// AlertLister exposes the current alert states.
+type AlertLister interface {
+	Alerts() []alert.Alert
+}
+
+type alertHandler struct {
+	alerts AlertLister
+}
+
+func NewAlertHandler(alerts AlertLister) AlertHandler {
+	return &alertHandler{alerts: alerts}
+}
+
+func (h *alertHandler) GetAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"alerts": h.alerts.Alerts()})
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/zkropotkine/agent-trace/internal/alert"
+)
+
+type stubAlerts []alert.Alert
+
+func (s stubAlerts) Alerts() []alert.Alert { return s }
+
+func TestGetAlerts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	h := NewAlertHandler(stubAlerts{{Fingerprint: "errors/A", Rule: "errors", Agent: "A", State: alert.StateFiring}})
+	r := gin.New()
+	r.GET("/api/alerts", h.GetAlerts)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/alerts", nil))
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"alerts":[{"fingerprint":"errors/A","rule":"errors","agent":"A","metric":"","state":"firing","value":0,"threshold":0,"starts_at":"0001-01-01T00:00:00Z"}]}`, resp.Body.String())
+}
@@ -4,6 +4,38 @@ import "github.com/gin-gonic/gin"
 
 type TraceHandler interface {
 	PostTrace(c *gin.Context)
+	PostTraceBatch(c *gin.Context)
+	PostLangSmithRuns(c *gin.Context)
 	GetTraces(c *gin.Context)
+	ExportTraces(c *gin.Context)
 	GetTraceByID(c *gin.Context)
+	GetTraceTimeline(c *gin.Context)
+	ReplayTrace(c *gin.Context)
+	DiffTraces(c *gin.Context)
+	StreamTraces(c *gin.Context)
+}
+
+type RetentionHandler interface {
+	DryRun(c *gin.Context)
+}
+
+type AlertHandler interface {
+	GetAlerts(c *gin.Context)
+}
+
+type SchemaHandler interface {
+	GetSchema(c *gin.Context)
+}
+
+type ImportHandler interface {
+	PostImport(c *gin.Context)
+}
+
+type HealthHandler interface {
+	Live(c *gin.Context)
+	Ready(c *gin.Context)
+}
+
+type IngestHandler interface {
+	GetQueueStats(c *gin.Context)
 }
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/health"
+)
+
+type healthHandler struct {
+	checker *health.Checker
+}
+
+func NewHealthHandler(checker *health.Checker) HealthHandler {
+	return &healthHandler{checker: checker}
+}
+
+// Live reports that the process is up and serving requests. It checks no
+// dependencies, so a database outage does not get the server restarted.
+func (h *healthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Ready pings every dependency and responds 503 with the breakdown if any of
+// them is down, so traffic is only routed to instances that can serve it.
+func (h *healthHandler) Ready(c *gin.Context) {
+	report := h.checker.Run(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, report)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/health"
+)
+
+func TestHealthHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mongoErr := errors.New("server selection timeout")
+
+	tests := []struct {
+		name           string
+		path           string
+		mongo          error
+		expectedStatus int
+		assertBody     func(t *testing.T, report health.Report)
+	}{
+		{
+			name:           "live ignores dependencies",
+			path:           "/healthz",
+			mongo:          mongoErr,
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, report health.Report) {
+				assert.Equal(t, health.StatusUp, report.Status)
+				assert.Empty(t, report.Checks)
+			},
+		},
+		{
+			name:           "ready when every dependency is up",
+			path:           "/readyz",
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, report health.Report) {
+				assert.Equal(t, health.StatusUp, report.Checks["mongo"].Status)
+			},
+		},
+		{
+			name:           "not ready when a dependency is down",
+			path:           "/readyz",
+			mongo:          mongoErr,
+			expectedStatus: http.StatusServiceUnavailable,
+			assertBody: func(t *testing.T, report health.Report) {
+				assert.Equal(t, health.StatusDown, report.Status)
+				assert.Equal(t, "server selection timeout", report.Checks["mongo"].Error)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(time.Second)
+			checker.Register("mongo", func(context.Context) error { return tt.mongo })
+			h := NewHealthHandler(checker)
+			r := gin.New()
+			r.GET("/healthz", h.Live)
+			r.GET("/readyz", h.Ready)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			var report health.Report
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &report))
+			tt.assertBody(t, report)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/importer"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// TraceImporter loads traces from a JSONL stream.
+type TraceImporter interface {
+	Import(ctx context.Context, r io.Reader) (importer.Result, error)
+}
+
+type importHandler struct {
+	importer TraceImporter
+}
+
+func NewImportHandler(importer TraceImporter) ImportHandler {
+	return &importHandler{importer: importer}
+}
+
+// PostImport reads a JSONL body, optionally gzip-compressed, and reports how
+// many lines were imported, skipped or invalid. If storage fails midway the
+// counts so far are returned with a 500, or with a 413 if the body outgrows
+// its limit.
+func (h *importHandler) PostImport(c *gin.Context) {
+	result, err := h.importer.Import(c.Request.Context(), c.Request.Body)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":  "request body exceeds " + strconv.FormatInt(maxErr.Limit, 10) + " bytes",
+			"result": result,
+		})
+		return
+	}
+	if err != nil {
+		logger.FromContext(c.Request.Context()).WithError(err).Error("trace import failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "import failed", "result": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
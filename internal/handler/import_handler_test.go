@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zkropotkine/agent-trace/internal/importer"
+)
+
+type stubImporter struct {
+	result importer.Result
+	err    error
+	body   string
+}
+
+func (s *stubImporter) Import(_ context.Context, r io.Reader) (importer.Result, error) {
+	data, _ := io.ReadAll(r)
+	s.body = string(data)
+	return s.result, s.err
+}
+
+func TestPostImport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		importer       *stubImporter
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "reports counts",
+			importer: &stubImporter{result: importer.Result{
+				Imported:     1,
+				Invalid:      1,
+				InvalidLines: []importer.LineError{{Line: 2, Reason: "invalid JSON"}},
+			}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"imported":1,"skipped":0,"invalid":1,"invalid_lines":[{"line":2,"reason":"invalid JSON"}]}`,
+		},
+		{
+			name:           "returns partial counts on failure",
+			importer:       &stubImporter{result: importer.Result{Imported: 500}, err: errors.New("db down")},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"import failed","result":{"imported":500,"skipped":0,"invalid":0}}`,
+		},
+		{
+			name:           "reports a body over its limit",
+			importer:       &stubImporter{result: importer.Result{Imported: 500}, err: fmt.Errorf("line 501: %w", &http.MaxBytesError{Limit: 1 << 30})},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedBody:   `{"error":"request body exceeds 1073741824 bytes","result":{"imported":500,"skipped":0,"invalid":0}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/api/import", NewImportHandler(tt.importer).PostImport)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(`{"trace_id":"a"}`)))
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+			assert.Equal(t, `{"trace_id":"a"}`, tt.importer.body)
+		})
+	}
+}
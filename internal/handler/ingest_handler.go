@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/ingest"
+)
+
+type ingestHandler struct {
+	queue *ingest.Queue
+}
+
+func NewIngestHandler(queue *ingest.Queue) IngestHandler {
+	return &ingestHandler{queue: queue}
+}
+
+// GetQueueStats reports the depth and counters of the ingestion queue.
+func (h *ingestHandler) GetQueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.queue.Stats())
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/retention"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// RetentionPlanner reports what the retention policy would remove.
+type RetentionPlanner interface {
+	Plan(ctx context.Context) (retention.Report, error)
+}
+
+type retentionHandler struct {
+	planner RetentionPlanner
+}
+
+func NewRetentionHandler(planner RetentionPlanner) RetentionHandler {
+	return &retentionHandler{planner: planner}
+}
+
+func (h *retentionHandler) DryRun(c *gin.Context) {
+	report, err := h.planner.Plan(c.Request.Context())
+	if err != nil {
+		logger.FromContext(c.Request.Context()).WithError(err).Error("retention dry run failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to evaluate retention policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/zkropotkine/agent-trace/internal/retention"
+)
+
+type stubPlanner struct {
+	report retention.Report
+	err    error
+}
+
+func (s stubPlanner) Plan(_ context.Context) (retention.Report, error) {
+	return s.report, s.err
+}
+
+func TestRetentionDryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	before := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		planner        stubPlanner
+		expectedStatus int
+		assertBody     func(t *testing.T, body []byte)
+	}{
+		{
+			name: "returns planned deletions",
+			planner: stubPlanner{report: retention.Report{DryRun: true, Rules: []retention.RuleResult{
+				{Scope: retention.ScopeGlobal, Action: retention.ActionDelete, Before: before, Matched: 4},
+			}}},
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, body []byte) {
+				var report retention.Report
+				assert.NoError(t, json.Unmarshal(body, &report))
+				assert.True(t, report.DryRun)
+				assert.Len(t, report.Rules, 1)
+				assert.Equal(t, int64(4), report.Rules[0].Matched)
+			},
+		},
+		{
+			name:           "returns 500 when planning fails",
+			planner:        stubPlanner{err: errors.New("db down")},
+			expectedStatus: http.StatusInternalServerError,
+			assertBody: func(t *testing.T, body []byte) {
+				assert.JSONEq(t, `{"error":"failed to evaluate retention policy"}`, string(body))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewRetentionHandler(tt.planner)
+			r := gin.New()
+			r.GET("/api/retention/dry-run", h.DryRun)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/retention/dry-run", nil)
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			tt.assertBody(t, resp.Body.Bytes())
+		})
+	}
+}
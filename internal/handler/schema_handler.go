@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/validation"
+)
+
+type schemaHandler struct{}
+
+func NewSchemaHandler() SchemaHandler {
+	return &schemaHandler{}
+}
+
+// GetSchema serves the JSON Schema of the ingestion payload.
+func (h *schemaHandler) GetSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", validation.Schema())
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSchema(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/schema", NewSchemaHandler().GetSchema)
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/schema", nil))
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/schema+json", resp.Header().Get("Content-Type"))
+
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &schema))
+	assert.Equal(t, []any{"trace_id"}, schema["required"])
+}
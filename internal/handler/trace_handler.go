@@ -1,92 +1,662 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"github.com/zkropotkine/agent-trace/internal/adapter/langsmith"
+	"github.com/zkropotkine/agent-trace/internal/diff"
+	"github.com/zkropotkine/agent-trace/internal/export"
+	"github.com/zkropotkine/agent-trace/internal/ingest"
 	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
 	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/timeline"
+	"github.com/zkropotkine/agent-trace/internal/traceparent"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
 )
 
+// maxBatchSize bounds the number of traces accepted by PostTraceBatch.
+const maxBatchSize = 1000
+
+// queueRetryAfter is the Retry-After value, in seconds, sent when the
+// ingestion queue is full.
+const queueRetryAfter = "1"
+
+// streamHeartbeat is how often an SSE comment is sent to keep idle connections open.
+var streamHeartbeat = 15 * time.Second
+
 type traceHandler struct {
-	repo repository.TraceRepository
+	repo       repository.TraceRepository
+	broker     *pubsub.Broker
+	publishers []pubsub.Publisher
+	mergeSpans bool
+	validator  *validation.Validator
+	replayer   Replayer
+	queue      Enqueuer
+	wal        Spooler
+}
+
+// Spooler durably keeps traces the repository failed to store so they can be
+// written once it recovers.
+type Spooler interface {
+	Append(traces []model.Trace) error
+}
+
+// Enqueuer accepts validated traces for asynchronous storage. It returns
+// ingest.ErrQueueFull when it cannot take more and ingest.ErrQueueClosed
+// during shutdown.
+type Enqueuer interface {
+	Enqueue(traces []model.Trace) error
+}
+
+// Replayer re-runs a recorded trace against a live agent.
+type Replayer interface {
+	Replay(ctx context.Context, original model.Trace) (model.Trace, error)
+}
+
+// TraceHandlerOption configures optional traceHandler dependencies.
+type TraceHandlerOption func(*traceHandler)
+
+// WithBroker enables live tailing: saved traces are published to broker and
+// StreamTraces subscribes to it.
+func WithBroker(broker *pubsub.Broker) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.broker = broker
+		h.publishers = append(h.publishers, broker)
+	}
+}
+
+// WithPublishers registers additional consumers of saved traces, such as the
+// alert engine.
+func WithPublishers(publishers ...pubsub.Publisher) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.publishers = append(h.publishers, publishers...)
+	}
+}
+
+// WithSpanMerging stores traces that share a trace_id as one document, so
+// fragments reported by several services form a single distributed trace.
+func WithSpanMerging() TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.mergeSpans = true
+	}
+}
+
+// WithValidator replaces the default validator, e.g. to apply configured limits.
+func WithValidator(v *validation.Validator) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.validator = v
+	}
+}
+
+// WithReplayer enables ReplayTrace.
+func WithReplayer(r Replayer) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.replayer = r
+	}
+}
+
+// WithQueue makes ingestion asynchronous: validated traces are handed to
+// queue and acknowledged with 202 before they are stored.
+func WithQueue(queue Enqueuer) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.queue = queue
+	}
 }
 
-func NewTraceHandler(repo repository.TraceRepository) TraceHandler {
-	return &traceHandler{repo: repo}
+// WithWAL keeps traces that fail to save in wal, acknowledging them with 202
+// instead of 500 while storage is unavailable.
+func WithWAL(wal Spooler) TraceHandlerOption {
+	return func(h *traceHandler) {
+		h.wal = wal
+	}
+}
+
+func NewTraceHandler(repo repository.TraceRepository, opts ...TraceHandlerOption) TraceHandler {
+	h := &traceHandler{repo: repo, validator: validation.New(validation.DefaultLimits)}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
 }
 
 func (h *traceHandler) PostTrace(c *gin.Context) {
 	var trace model.Trace
 	if err := c.ShouldBindJSON(&trace); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid trace payload"})
+		invalidPayload(c, validation.FromDecodeError(err))
+		return
+	}
+	applyTraceparent(&trace, c.GetHeader(traceparent.Header))
+	if errs := h.validator.Validate(trace); errs != nil {
+		invalidPayload(c, errs)
+		return
+	}
+	prepare(&trace, time.Now())
+
+	if h.queue != nil {
+		h.enqueue(c, []model.Trace{trace}, gin.H{"message": "trace accepted"})
 		return
 	}
-	trace.Timestamp = time.Now()
 
-	err := h.repo.InsertTrace(c.Request.Context(), trace)
+	var err error
+	if h.mergeSpans {
+		err = h.repo.MergeTraces(c.Request.Context(), []model.Trace{trace})
+	} else {
+		err = h.repo.InsertTrace(c.Request.Context(), trace)
+	}
 	if err != nil {
 		log.Println(err)
+		if h.spool(c, []model.Trace{trace}, err, gin.H{"message": "trace accepted"}) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save trace"})
 		return
 	}
 
+	for _, p := range h.publishers {
+		p.Publish(trace)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "trace saved"})
 }
 
+// PostTraceBatch ingests a JSON array of traces in a single write, as sent by
+// the client SDK.
+func (h *traceHandler) PostTraceBatch(c *gin.Context) {
+	var traces []model.Trace
+	if err := c.ShouldBindJSON(&traces); err != nil {
+		invalidPayload(c, validation.FromDecodeError(err))
+		return
+	}
+	if len(traces) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many traces in batch"})
+		return
+	}
+	var errs validation.Errors
+	for i, trace := range traces {
+		errs = append(errs, h.validator.Validate(trace).Prefix(fmt.Sprintf("[%d].", i))...)
+	}
+	if len(errs) > 0 {
+		invalidPayload(c, errs)
+		return
+	}
+
+	now := time.Now()
+	for i := range traces {
+		prepare(&traces[i], now)
+	}
+
+	if h.queue != nil {
+		h.enqueue(c, traces, gin.H{"message": "traces accepted", "count": len(traces)})
+		return
+	}
+
+	if err := h.saveBatch(c.Request.Context(), traces); err != nil {
+		log.Println(err)
+		if h.spool(c, traces, err, gin.H{"message": "traces accepted", "count": len(traces)}) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save traces"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "traces saved", "count": len(traces)})
+}
+
+// PostLangSmithRuns ingests LangSmith run trees, a single run or an array,
+// converting each root run into a trace.
+func (h *traceHandler) PostLangSmithRuns(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid run payload"})
+		return
+	}
+	runs, err := langsmith.DecodeRuns(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid run payload"})
+		return
+	}
+	if len(runs) > maxBatchSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many traces in batch"})
+		return
+	}
+
+	traces := make([]model.Trace, len(runs))
+	traceIDs := make([]string, len(runs))
+	var errs validation.Errors
+	now := time.Now()
+	for i, run := range runs {
+		traces[i] = langsmith.Convert(run, h.validator.Limits())
+		errs = append(errs, h.validator.Validate(traces[i]).Prefix(fmt.Sprintf("[%d].", i))...)
+		prepare(&traces[i], now)
+		traceIDs[i] = traces[i].TraceID
+	}
+	if len(errs) > 0 {
+		invalidPayload(c, errs)
+		return
+	}
+
+	if h.queue != nil {
+		h.enqueue(c, traces, gin.H{"message": "traces accepted", "count": len(traces), "trace_ids": traceIDs})
+		return
+	}
+
+	if err := h.saveBatch(c.Request.Context(), traces); err != nil {
+		log.Println(err)
+		if h.spool(c, traces, err, gin.H{"message": "traces accepted", "count": len(traces), "trace_ids": traceIDs}) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save traces"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "traces saved", "count": len(traces), "trace_ids": traceIDs})
+}
+
+// enqueue hands validated traces to the ingestion queue and responds 202 with
+// accepted, 429 when the queue is full or 503 once it is shutting down.
+func (h *traceHandler) enqueue(c *gin.Context, traces []model.Trace, accepted gin.H) {
+	switch err := h.queue.Enqueue(traces); {
+	case err == nil:
+		c.JSON(http.StatusAccepted, accepted)
+	case errors.Is(err, ingest.ErrQueueFull):
+		c.Header("Retry-After", queueRetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "ingestion queue is full, retry later"})
+	case errors.Is(err, ingest.ErrQueueClosed):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+	default:
+		logger.FromContext(c.Request.Context()).WithError(err).Error("failed to enqueue traces")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save traces"})
+	}
+}
+
+// spool appends traces that failed to save with saveErr to the write-ahead
+// log and responds 202 with accepted. It returns false without responding
+// when there is no log, the traces were duplicates, which a retry cannot fix,
+// or the append failed too.
+func (h *traceHandler) spool(c *gin.Context, traces []model.Trace, saveErr error, accepted gin.H) bool {
+	if h.wal == nil || errors.Is(saveErr, repository.ErrDuplicateTrace) {
+		return false
+	}
+	if err := h.wal.Append(traces); err != nil {
+		logger.FromContext(c.Request.Context()).WithError(err).Error("failed to append traces to write-ahead log")
+		return false
+	}
+	c.JSON(http.StatusAccepted, accepted)
+	return true
+}
+
+// invalidPayload responds 400 with one entry per offending field, when known,
+// or 413 when the only problems are fields over their size limit.
+func invalidPayload(c *gin.Context, errs validation.Errors) {
+	if errs.TooLarge() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "trace payload too large", "errors": errs})
+		return
+	}
+	if len(errs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid trace payload"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid trace payload", "errors": errs})
+}
+
+// prepare stamps a validated trace with its ID, ingestion time and schema
+// version. The ID is assigned before the first write attempt so a write
+// retried from the queue or the write-ahead log is recognised as a duplicate.
+func prepare(trace *model.Trace, now time.Time) {
+	trace.ID = primitive.NewObjectID()
+	trace.Timestamp = now
+	if trace.SchemaVersion == 0 {
+		trace.SchemaVersion = validation.CurrentSchemaVersion
+	}
+}
+
+// saveBatch stores validated traces and publishes them. With span merging
+// enabled, traces are merged into existing traces with the same trace_id.
+func (h *traceHandler) saveBatch(ctx context.Context, traces []model.Trace) error {
+	var err error
+	if h.mergeSpans {
+		err = h.repo.MergeTraces(ctx, traces)
+	} else {
+		err = h.repo.InsertTraces(ctx, traces)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, trace := range traces {
+		for _, p := range h.publishers {
+			p.Publish(trace)
+		}
+	}
+
+	return nil
+}
+
+// applyTraceparent attaches a trace posted by a downstream service to its
+// caller: the trace adopts the caller's trace ID and its top-level steps are
+// parented to the calling span. Values already in the payload win, and an
+// invalid header is ignored as the W3C spec requires.
+func applyTraceparent(trace *model.Trace, header string) {
+	if header == "" {
+		return
+	}
+	tc, err := traceparent.Parse(header)
+	if err != nil {
+		return
+	}
+
+	if trace.TraceID == "" {
+		trace.TraceID = tc.TraceID
+	}
+	if trace.TraceID != tc.TraceID || trace.ParentSpanID != "" {
+		return
+	}
+
+	trace.ParentSpanID = tc.ParentID
+	for i := range trace.SubSteps {
+		if trace.SubSteps[i].ParentID == "" {
+			trace.SubSteps[i].ParentID = tc.ParentID
+		}
+	}
+}
+
 func (h *traceHandler) GetTraces(c *gin.Context) {
-	agent := c.Query("agent")
-	fromStr := c.Query("from")
-	toStr := c.Query("to")
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
+	filter, ok := traceFilter(c, "50")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metadata filter"})
+		return
+	}
+
+	ctx, metadataOnly := payloadScope(c)
+	traces, err := h.repo.GetTraces(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch traces"})
+		return
+	}
+	if metadataOnly {
+		for i := range traces {
+			stripPayloads(&traces[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"traces": traces})
+}
+
+// payloadScope returns the context to read traces with. With ?payloads=false
+// the caller wants metadata only, so offloaded payloads are not loaded.
+func payloadScope(c *gin.Context) (context.Context, bool) {
+	if c.Query("payloads") != "false" {
+		return c.Request.Context(), false
+	}
+	return repository.MetadataOnly(c.Request.Context()), true
+}
+
+// stripPayloads clears prompts, outputs, messages and tool calls, keeping
+// the trace's metadata.
+func stripPayloads(trace *model.Trace) {
+	trace.InputPrompt, trace.Output = "", ""
+	trace.Messages, trace.ToolCalls = nil, nil
+	for i := range trace.SubSteps {
+		step := &trace.SubSteps[i]
+		step.Input, step.Output = "", ""
+		step.Messages, step.ToolCalls = nil, nil
+	}
+}
+
+// ExportTraces streams every trace matching the GetTraces filters as CSV,
+// JSONL or Parquet. Unlike GetTraces there is no default limit; traces are
+// read from a cursor and written as they arrive.
+func (h *traceHandler) ExportTraces(c *gin.Context) {
+	format := c.DefaultQuery("format", export.JSONL)
+	filter, ok := traceFilter(c, "0")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metadata filter"})
+		return
+	}
+
+	w, err := export.NewWriter(format, c.Writer)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, jsonl or parquet"})
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="traces.%s"`, format))
+
+	err = h.repo.IterateTraces(c.Request.Context(), filter, w.Write)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export traces"})
+			return
+		}
+		// The status line is already sent, so the body is simply cut short.
+		logger.FromContext(c.Request.Context()).WithError(err).Error("trace export aborted")
+	}
+}
 
+// traceFilter reads the trace query filters shared by GetTraces and
+// ExportTraces. It reports false if the metadata filter is invalid.
+func traceFilter(c *gin.Context, defaultLimit string) (repository.TraceFilter, bool) {
 	var from, to *time.Time
-	if fromStr != "" {
+	if fromStr := c.Query("from"); fromStr != "" {
 		parsed, err := time.Parse(time.RFC3339, fromStr)
 		if err == nil {
 			from = &parsed
 		}
 	}
-	if toStr != "" {
+	if toStr := c.Query("to"); toStr != "" {
 		parsed, err := time.Parse(time.RFC3339, toStr)
 		if err == nil {
 			to = &parsed
 		}
 	}
 
-	limit, _ := strconv.ParseInt(limitStr, 10, 64)
-	offset, _ := strconv.ParseInt(offsetStr, 10, 64)
+	limit, _ := strconv.ParseInt(c.DefaultQuery("limit", defaultLimit), 10, 64)
+	offset, _ := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
 
-	filter := repository.TraceFilter{
-		AgentName: agent,
+	metadata, ok := metadataQuery(c.Request.URL.Query())
+	if !ok {
+		return repository.TraceFilter{}, false
+	}
+
+	return repository.TraceFilter{
+		AgentName: c.Query("agent"),
+		SessionID: c.Query("session"),
+		Status:    c.Query("status"),
 		From:      from,
 		To:        to,
+		Tags:      c.QueryArray("tag"),
+		Metadata:  metadata,
 		Limit:     limit,
 		Offset:    offset,
-	}
+	}, true
+}
 
-	traces, err := h.repo.GetTraces(c.Request.Context(), filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch traces"})
-		return
+// metadataQuery collects meta.<key>=<value> query parameters. It reports false
+// if a key could not have been stored.
+func metadataQuery(values url.Values) (map[string]string, bool) {
+	var metadata map[string]string
+	for param, vals := range values {
+		key, found := strings.CutPrefix(param, "meta.")
+		if !found {
+			continue
+		}
+		if !validation.ValidMetadataKey(key) {
+			return nil, false
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[key] = vals[0]
 	}
 
-	c.JSON(http.StatusOK, gin.H{"traces": traces})
+	return metadata, true
 }
 
 func (h *traceHandler) GetTraceByID(c *gin.Context) {
 	id := c.Param("id")
 
-	trace, err := h.repo.GetByID(c.Request.Context(), id)
+	ctx, metadataOnly := payloadScope(c)
+	trace, err := h.repo.GetByID(ctx, id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "trace not found"})
 		return
 	}
+	if metadataOnly {
+		stripPayloads(trace)
+	}
 
 	c.JSON(http.StatusOK, trace)
 }
+
+// ReplayTrace re-runs a recorded trace's input against the configured agent,
+// stores the new run with replay_of pointing at the original and returns it
+// with a diff against the original.
+func (h *traceHandler) ReplayTrace(c *gin.Context) {
+	if h.replayer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "replay is disabled"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	original, err := h.repo.GetByID(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trace not found"})
+		return
+	}
+	if original.PayloadsPurged {
+		c.JSON(http.StatusConflict, gin.H{"error": "trace payloads were purged by retention"})
+		return
+	}
+
+	replay, err := h.replayer.Replay(ctx, *original)
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Errorf("replay of trace %s failed", original.TraceID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to reach agent endpoint"})
+		return
+	}
+	if errs := h.validator.Validate(replay); errs != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "agent returned an invalid trace", "errors": errs})
+		return
+	}
+	prepare(&replay, time.Now())
+
+	res := gin.H{"replay": replay, "diff": diff.Compare(*original, replay)}
+	if err := h.saveBatch(ctx, []model.Trace{replay}); err != nil {
+		logger.FromContext(ctx).WithError(err).Errorf("failed to save replay of trace %s", original.TraceID)
+		if h.spool(c, []model.Trace{replay}, err, res) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save replay"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+// DiffTraces compares the traces with IDs a and b: status, input, output,
+// token usage and latency, and their substeps aligned by name and order.
+func (h *traceHandler) DiffTraces(c *gin.Context) {
+	idA, idB := c.Query("a"), c.Query("b")
+	if idA == "" || idB == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a and b trace IDs are required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	a, err := h.repo.GetByID(ctx, idA)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trace a not found"})
+		return
+	}
+	b, err := h.repo.GetByID(ctx, idB)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trace b not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff.Compare(*a, *b))
+}
+
+// GetTraceTimeline returns the substeps of a trace laid out as a timeline,
+// or an SVG waterfall when called with format=svg.
+func (h *traceHandler) GetTraceTimeline(c *gin.Context) {
+	trace, err := h.repo.GetByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trace not found"})
+		return
+	}
+
+	tl := timeline.Build(*trace)
+	if c.Query("format") == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", timeline.RenderSVG(tl))
+		return
+	}
+
+	c.JSON(http.StatusOK, tl)
+}
+
+// StreamTraces pushes newly ingested traces to the client as Server-Sent
+// Events. It accepts the same agent and status filters as GetTraces.
+func (h *traceHandler) StreamTraces(c *gin.Context) {
+	if h.broker == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "live tail is disabled"})
+		return
+	}
+
+	sub := h.broker.Subscribe(pubsub.Filter{
+		AgentName: c.Query("agent"),
+		Status:    c.Query("status"),
+	})
+	defer h.broker.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, _ = c.Writer.WriteString(": ping\n\n")
+			c.Writer.Flush()
+		case trace, ok := <-sub.Traces():
+			if !ok {
+				if sub.Dropped() {
+					c.SSEvent("dropped", gin.H{"error": "client too slow, reconnect to resume"})
+					c.Writer.Flush()
+				}
+				return
+			}
+			c.SSEvent("trace", trace)
+			c.Writer.Flush()
+		}
+	}
+}
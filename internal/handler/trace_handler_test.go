@@ -1,20 +1,27 @@
 package handler
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/diff"
+	"github.com/zkropotkine/agent-trace/internal/ingest"
 	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
 	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/validation"
 )
 
 type mockTraceRepo struct {
@@ -26,16 +33,66 @@ func (m *mockTraceRepo) InsertTrace(ctx context.Context, trace model.Trace) erro
 	return args.Error(0)
 }
 
+func (m *mockTraceRepo) InsertTraces(ctx context.Context, traces []model.Trace) error {
+	args := m.Called(ctx, traces)
+	return args.Error(0)
+}
+
+func (m *mockTraceRepo) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	args := m.Called(ctx, traces)
+	return args.Error(0)
+}
+
 func (m *mockTraceRepo) GetTraces(ctx context.Context, filter repository.TraceFilter) ([]model.Trace, error) {
 	args := m.Called(ctx, filter)
 	return args.Get(0).([]model.Trace), args.Error(1)
 }
 
+func (m *mockTraceRepo) IterateTraces(ctx context.Context, filter repository.TraceFilter, fn func(model.Trace) error) error {
+	args := m.Called(ctx, filter)
+	for _, trace := range args.Get(0).([]model.Trace) {
+		if err := fn(trace); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func (m *mockTraceRepo) GetByID(ctx context.Context, id string) (*model.Trace, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*model.Trace), args.Error(1)
 }
 
+func (m *mockTraceRepo) ExistingTraceIDs(ctx context.Context, traceIDs []string) (map[string]bool, error) {
+	args := m.Called(ctx, traceIDs)
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+func (m *mockTraceRepo) CountExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) IterateExpired(ctx context.Context, filter repository.ExpiryFilter, fn func(model.Trace) error) error {
+	args := m.Called(ctx, filter, fn)
+	return args.Error(0)
+}
+
+func (m *mockTraceRepo) DeleteExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) PurgePayloads(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) DeleteByIDs(ctx context.Context, ids []string) (int64, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func TestPostTrace(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -118,10 +175,10 @@ func TestGetTracesHandler(t *testing.T) {
 	}{
 		{
 			name: "returns filtered traces",
-			path: "/api/traces?agent=test-agent",
+			path: "/api/traces?agent=test-agent&status=error&session=s1",
 			setupMock: func(repo *mockTraceRepo) {
 				repo.On("GetTraces", mock.Anything, mock.MatchedBy(func(f repository.TraceFilter) bool {
-					return f.AgentName == "test-agent"
+					return f.AgentName == "test-agent" && f.Status == "error" && f.SessionID == "s1"
 				})).Return([]model.Trace{{TraceID: "1", AgentName: "test-agent", Timestamp: now}}, nil)
 			},
 			expectedStatus: http.StatusOK,
@@ -133,6 +190,22 @@ func TestGetTracesHandler(t *testing.T) {
 				assert.Equal(t, "test-agent", result["traces"][0].AgentName)
 			},
 		},
+		{
+			name: "filters by tags and metadata",
+			path: "/api/traces?tag=beta&tag=eu&meta.region=eu&meta.tier=gold",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetTraces", mock.Anything, mock.MatchedBy(func(f repository.TraceFilter) bool {
+					return assert.ObjectsAreEqual([]string{"beta", "eu"}, f.Tags) &&
+						assert.ObjectsAreEqual(map[string]string{"region": "eu", "tier": "gold"}, f.Metadata)
+				})).Return([]model.Trace{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "rejects operator metadata keys",
+			path:           "/api/traces?meta.$where=1",
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -158,6 +231,96 @@ func TestGetTracesHandler(t *testing.T) {
 	}
 }
 
+func TestExportTraces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	traces := []model.Trace{
+		{TraceID: "1", AgentName: "A", TokenUsage: model.TokenUsage{Input: 1, Output: 2, Total: 3}},
+		{TraceID: "2", AgentName: "A"},
+	}
+	tests := []struct {
+		name           string
+		path           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		contentType    string
+		assertBody     func(t *testing.T, body string)
+	}{
+		{
+			name: "streams jsonl by default without a limit",
+			path: "/api/traces/export?agent=A",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("IterateTraces", mock.Anything, mock.MatchedBy(func(f repository.TraceFilter) bool {
+					return f.AgentName == "A" && f.Limit == 0
+				})).Return(traces, nil)
+			},
+			expectedStatus: http.StatusOK,
+			contentType:    "application/x-ndjson",
+			assertBody: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				assert.Len(t, lines, 2)
+				assert.Contains(t, lines[0], `"trace_id":"1"`)
+			},
+		},
+		{
+			name: "streams csv with flattened columns",
+			path: "/api/traces/export?format=csv&tag=beta",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("IterateTraces", mock.Anything, mock.MatchedBy(func(f repository.TraceFilter) bool {
+					return assert.ObjectsAreEqual([]string{"beta"}, f.Tags)
+				})).Return(traces, nil)
+			},
+			expectedStatus: http.StatusOK,
+			contentType:    "text/csv",
+			assertBody: func(t *testing.T, body string) {
+				lines := strings.Split(strings.TrimSpace(body), "\n")
+				assert.Len(t, lines, 3)
+				assert.True(t, strings.HasPrefix(lines[0], "trace_id,session_id,agent_name"))
+				assert.True(t, strings.HasPrefix(lines[1], "1,,A,,,,0,1,2,3,0,0,0"))
+			},
+		},
+		{
+			name:           "rejects unknown formats",
+			path:           "/api/traces/export?format=xlsx",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "reports errors before streaming starts",
+			path: "/api/traces/export",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("IterateTraces", mock.Anything, mock.Anything).Return([]model.Trace{}, errors.New("db down"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			contentType:    "application/json; charset=utf-8",
+			assertBody: func(t *testing.T, body string) {
+				assert.JSONEq(t, `{"error":"failed to export traces"}`, body)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			r := gin.New()
+			r.GET("/api/traces/export", NewTraceHandler(repo).ExportTraces)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, resp.Header().Get("Content-Type"))
+			}
+			if tt.assertBody != nil {
+				tt.assertBody(t, resp.Body.String())
+			}
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
 func TestGetTraceByIDHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	now := time.Now().UTC()
@@ -182,6 +345,30 @@ func TestGetTraceByIDHandler(t *testing.T) {
 				assert.Equal(t, "abc123", trace.TraceID)
 			},
 		},
+		{
+			name: "returns metadata only",
+			path: "/api/traces/abc123?payloads=false",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc123").Return(&model.Trace{
+					TraceID:     "abc123",
+					AgentName:   "test-agent",
+					InputPrompt: "blob:v1:payloads/0a1b",
+					Messages:    []model.Message{{Role: "user", Content: "hi"}},
+					SubSteps:    []model.SubStep{{Name: "Loader", Output: "blob:v1:payloads/2c3d"}},
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, body []byte) {
+				var trace model.Trace
+				require.NoError(t, json.Unmarshal(body, &trace))
+				assert.Equal(t, "test-agent", trace.AgentName)
+				assert.Empty(t, trace.InputPrompt)
+				assert.Empty(t, trace.Messages)
+				require.Len(t, trace.SubSteps, 1)
+				assert.Equal(t, "Loader", trace.SubSteps[0].Name)
+				assert.Empty(t, trace.SubSteps[0].Output)
+			},
+		},
 		{
 			name: "returns 404 if trace not found",
 			path: "/api/traces/missing",
@@ -220,3 +407,896 @@ func TestGetTraceByIDHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamTraces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("pushes matching traces after they are saved", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		repo.On("InsertTrace", mock.Anything, mock.Anything).Return(nil)
+
+		broker := pubsub.NewBroker(8)
+		h := NewTraceHandler(repo, WithBroker(broker))
+		r := gin.New()
+		r.POST("/api/traces", h.PostTrace)
+		r.GET("/api/traces/stream", h.StreamTraces)
+
+		server := httptest.NewServer(r)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/traces/stream?agent=AgentX&status=error", nil)
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		require.Eventually(t, func() bool { return broker.Subscribers() == 1 }, time.Second, 5*time.Millisecond)
+
+		for _, trace := range []model.Trace{
+			{TraceID: "skip-agent", AgentName: "Other", Status: "error"},
+			{TraceID: "skip-status", AgentName: "AgentX", Status: "success"},
+			{TraceID: "match", AgentName: "AgentX", Status: "error"},
+		} {
+			body, _ := json.Marshal(trace)
+			post, err := server.Client().Post(server.URL+"/api/traces", "application/json", bytes.NewBuffer(body))
+			require.NoError(t, err)
+			post.Body.Close()
+		}
+
+		reader := bufio.NewReader(resp.Body)
+		event, _ := reader.ReadString('\n')
+		data, _ := reader.ReadString('\n')
+		assert.Equal(t, "event:trace\n", event)
+
+		var got model.Trace
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data:")), &got))
+		assert.Equal(t, "match", got.TraceID)
+
+		cancel()
+		require.Eventually(t, func() bool { return broker.Subscribers() == 0 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("returns 404 when live tail is disabled", func(t *testing.T) {
+		h := NewTraceHandler(new(mockTraceRepo))
+		r := gin.New()
+		r.GET("/api/traces/stream", h.StreamTraces)
+
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/traces/stream", nil))
+
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestGetTraceTimelineHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	start := time.Date(2025, 5, 1, 1, 23, 0, 0, time.UTC)
+	trace := &model.Trace{TraceID: "abc123", SubSteps: []model.SubStep{
+		{Name: "Retriever", Start: start, End: start.Add(time.Second)},
+		{Name: "Summarizer", Start: start.Add(time.Second), End: start.Add(1500 * time.Millisecond)},
+	}}
+
+	tests := []struct {
+		name           string
+		path           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		assertBody     func(t *testing.T, resp *httptest.ResponseRecorder)
+	}{
+		{
+			name: "returns timeline json",
+			path: "/api/traces/abc123/timeline",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc123").Return(trace, nil)
+			},
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				assert.JSONEq(t, `{
+					"trace_id": "abc123",
+					"start": "2025-05-01T01:23:00Z",
+					"duration_ms": 1500,
+					"steps": [
+						{"index": 0, "name": "Retriever", "status": "", "offset_ms": 0, "duration_ms": 1000, "depth": 0, "critical": true},
+						{"index": 1, "name": "Summarizer", "status": "", "offset_ms": 1000, "duration_ms": 500, "depth": 0, "critical": true}
+					]
+				}`, resp.Body.String())
+			},
+		},
+		{
+			name: "returns svg",
+			path: "/api/traces/abc123/timeline?format=svg",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc123").Return(trace, nil)
+			},
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				assert.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+				assert.True(t, strings.HasPrefix(resp.Body.String(), "<svg"))
+			},
+		},
+		{
+			name: "returns 404 if trace not found",
+			path: "/api/traces/missing/timeline",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "missing").Return((*model.Trace)(nil), errors.New("not found"))
+			},
+			expectedStatus: http.StatusNotFound,
+			assertBody: func(t *testing.T, resp *httptest.ResponseRecorder) {
+				assert.JSONEq(t, `{"error":"trace not found"}`, resp.Body.String())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			tt.setupMock(repo)
+			h := NewTraceHandler(repo)
+			r := gin.New()
+			r.GET("/api/traces/:id/timeline", h.GetTraceTimeline)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			tt.assertBody(t, resp)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+type stubReplayer struct {
+	replay model.Trace
+	err    error
+}
+
+func (s stubReplayer) Replay(context.Context, model.Trace) (model.Trace, error) {
+	return s.replay, s.err
+}
+
+func TestReplayTrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := &model.Trace{TraceID: "orig", AgentName: "A", Status: "success", Output: "Lisbon"}
+	replay := model.Trace{TraceID: "new", ReplayOf: "orig", AgentName: "A", Status: "success", Output: "Porto"}
+
+	tests := []struct {
+		name           string
+		replayer       Replayer
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		assertBody     func(t *testing.T, body string)
+		spooled        int
+	}{
+		{
+			name:     "stores the replay and returns a diff",
+			replayer: stubReplayer{replay: replay},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return(original, nil)
+				repo.On("InsertTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					return len(traces) == 1 && traces[0].ReplayOf == "orig" && !traces[0].Timestamp.IsZero()
+				})).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+			assertBody: func(t *testing.T, body string) {
+				var res struct {
+					Replay model.Trace `json:"replay"`
+					Diff   diff.Result `json:"diff"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(body), &res))
+				assert.Equal(t, "orig", res.Replay.ReplayOf)
+				assert.Equal(t, &diff.StringChange{From: "Lisbon", To: "Porto"}, res.Diff.Output)
+			},
+		},
+		{
+			name:           "disabled without a replayer",
+			expectedStatus: http.StatusNotFound,
+			assertBody: func(t *testing.T, body string) {
+				assert.JSONEq(t, `{"error":"replay is disabled"}`, body)
+			},
+		},
+		{
+			name:     "unknown trace",
+			replayer: stubReplayer{replay: replay},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return((*model.Trace)(nil), errors.New("not found"))
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:     "purged trace",
+			replayer: stubReplayer{replay: replay},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return(&model.Trace{TraceID: "orig", PayloadsPurged: true}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:     "agent unreachable",
+			replayer: stubReplayer{err: errors.New("connection refused")},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return(original, nil)
+			},
+			expectedStatus: http.StatusBadGateway,
+			assertBody: func(t *testing.T, body string) {
+				assert.JSONEq(t, `{"error":"failed to reach agent endpoint"}`, body)
+			},
+		},
+		{
+			name:     "invalid replay is not stored",
+			replayer: stubReplayer{replay: model.Trace{ReplayOf: "orig", LatencyMS: -1}},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return(original, nil)
+			},
+			expectedStatus: http.StatusBadGateway,
+			assertBody: func(t *testing.T, body string) {
+				assert.JSONEq(t, `{"error":"agent returned an invalid trace","errors":[
+					{"field":"trace_id","message":"is required"},
+					{"field":"latency_ms","message":"must not be negative"}]}`, body)
+			},
+		},
+		{
+			name:     "spools the replay when the write fails",
+			replayer: stubReplayer{replay: replay},
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "abc").Return(original, nil)
+				repo.On("InsertTraces", mock.Anything, mock.Anything).Return(errors.New("server selection timeout"))
+			},
+			expectedStatus: http.StatusAccepted,
+			assertBody: func(t *testing.T, body string) {
+				assert.Contains(t, body, `"replay_of":"orig"`)
+			},
+			spooled: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			wal := &stubSpooler{}
+			opts := []TraceHandlerOption{WithWAL(wal)}
+			if tt.replayer != nil {
+				opts = append(opts, WithReplayer(tt.replayer))
+			}
+			r := gin.New()
+			r.POST("/api/traces/:id/replay", NewTraceHandler(repo, opts...).ReplayTrace)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces/abc/replay", nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.assertBody != nil {
+				tt.assertBody(t, resp.Body.String())
+			}
+			assert.Len(t, wal.spooled, tt.spooled)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDiffTraces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &model.Trace{TraceID: "a", Status: "success", Output: "Lisbon", SubSteps: []model.SubStep{{Name: "plan"}}}
+	b := &model.Trace{TraceID: "b", Status: "success", Output: "Porto", SubSteps: []model.SubStep{{Name: "plan"}, {Name: "search"}}}
+
+	tests := []struct {
+		name           string
+		path           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		assertBody     func(t *testing.T, body string)
+	}{
+		{
+			name: "compares both traces",
+			path: "/api/traces/diff?a=1&b=2",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "1").Return(a, nil)
+				repo.On("GetByID", mock.Anything, "2").Return(b, nil)
+			},
+			expectedStatus: http.StatusOK,
+			assertBody: func(t *testing.T, body string) {
+				var res diff.Result
+				require.NoError(t, json.Unmarshal([]byte(body), &res))
+				assert.False(t, res.Identical)
+				assert.Equal(t, &diff.StringChange{From: "Lisbon", To: "Porto"}, res.Output)
+				require.Len(t, res.SubSteps, 2)
+				assert.Equal(t, diff.Added, res.SubSteps[1].Change)
+			},
+		},
+		{
+			name:           "missing parameter",
+			path:           "/api/traces/diff?a=1",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown trace",
+			path: "/api/traces/diff?a=1&b=2",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("GetByID", mock.Anything, "1").Return(a, nil)
+				repo.On("GetByID", mock.Anything, "2").Return((*model.Trace)(nil), errors.New("not found"))
+			},
+			expectedStatus: http.StatusNotFound,
+			assertBody: func(t *testing.T, body string) {
+				assert.JSONEq(t, `{"error":"trace b not found"}`, body)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			r := gin.New()
+			r.GET("/api/traces/diff", NewTraceHandler(repo).DiffTraces)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.assertBody != nil {
+				tt.assertBody(t, resp.Body.String())
+			}
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+type stubQueue struct {
+	err    error
+	queued []model.Trace
+}
+
+func (q *stubQueue) Enqueue(traces []model.Trace) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.queued = append(q.queued, traces...)
+	return nil
+}
+
+func TestPostTrace_Queue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		queueErr       error
+		expectedStatus int
+		expectedBody   string
+		retryAfter     string
+	}{
+		{
+			name:           "accepted",
+			path:           "/api/traces",
+			body:           `{"trace_id":"a","agent_name":"A"}`,
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"message":"trace accepted"}`,
+		},
+		{
+			name:           "batch accepted",
+			path:           "/api/traces/batch",
+			body:           `[{"trace_id":"a"},{"trace_id":"b"}]`,
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"message":"traces accepted","count":2}`,
+		},
+		{
+			name:           "queue full",
+			path:           "/api/traces",
+			body:           `{"trace_id":"a"}`,
+			queueErr:       ingest.ErrQueueFull,
+			expectedStatus: http.StatusTooManyRequests,
+			expectedBody:   `{"error":"ingestion queue is full, retry later"}`,
+			retryAfter:     "1",
+		},
+		{
+			name:           "shutting down",
+			path:           "/api/traces/batch",
+			body:           `[{"trace_id":"a"}]`,
+			queueErr:       ingest.ErrQueueClosed,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"error":"server is shutting down"}`,
+		},
+		{
+			name:           "invalid traces are not queued",
+			path:           "/api/traces",
+			body:           `{"agent_name":"A"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			queue := &stubQueue{err: tt.queueErr}
+			h := NewTraceHandler(repo, WithQueue(queue))
+			r := gin.New()
+			r.POST("/api/traces", h.PostTrace)
+			r.POST("/api/traces/batch", h.PostTraceBatch)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+			}
+			assert.Equal(t, tt.retryAfter, resp.Header().Get("Retry-After"))
+			if tt.expectedStatus == http.StatusAccepted {
+				require.NotEmpty(t, queue.queued)
+				assert.False(t, queue.queued[0].Timestamp.IsZero(), "traces are prepared before queueing")
+			}
+			repo.AssertNotCalled(t, "InsertTrace", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+type stubSpooler struct {
+	err     error
+	spooled []model.Trace
+}
+
+func (s *stubSpooler) Append(traces []model.Trace) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.spooled = append(s.spooled, traces...)
+	return nil
+}
+
+func TestPostTrace_WAL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		setupMock      func(repo *mockTraceRepo)
+		walErr         error
+		expectedStatus int
+		expectedBody   string
+		spooled        int
+	}{
+		{
+			name: "saved traces are not spooled",
+			path: "/api/traces",
+			body: `{"trace_id":"a"}`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTrace", mock.Anything, mock.Anything).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name: "spools trace when storage fails",
+			path: "/api/traces",
+			body: `{"trace_id":"a"}`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTrace", mock.Anything, mock.Anything).Return(errors.New("server selection timeout"))
+			},
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"message":"trace accepted"}`,
+			spooled:        1,
+		},
+		{
+			name: "spools batch when storage fails",
+			path: "/api/traces/batch",
+			body: `[{"trace_id":"a"},{"trace_id":"b"}]`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTraces", mock.Anything, mock.Anything).Return(errors.New("server selection timeout"))
+			},
+			expectedStatus: http.StatusAccepted,
+			expectedBody:   `{"message":"traces accepted","count":2}`,
+			spooled:        2,
+		},
+		{
+			name: "duplicates are not spooled",
+			path: "/api/traces",
+			body: `{"trace_id":"a"}`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTrace", mock.Anything, mock.Anything).Return(repository.ErrDuplicateTrace)
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "fails when the log fails too",
+			path: "/api/traces",
+			body: `{"trace_id":"a"}`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTrace", mock.Anything, mock.Anything).Return(errors.New("server selection timeout"))
+			},
+			walErr:         errors.New("no space left on device"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to save trace"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			tt.setupMock(repo)
+			wal := &stubSpooler{err: tt.walErr}
+			h := NewTraceHandler(repo, WithWAL(wal))
+			r := gin.New()
+			r.POST("/api/traces", h.PostTrace)
+			r.POST("/api/traces/batch", h.PostTraceBatch)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+			}
+			assert.Len(t, wal.spooled, tt.spooled)
+			for _, trace := range wal.spooled {
+				assert.False(t, trace.ID.IsZero(), "spooled traces keep the ID of the failed write")
+			}
+		})
+	}
+}
+
+func TestPostTraceBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "saves all traces",
+			body: `[{"trace_id":"a","agent_name":"X"},{"trace_id":"b","agent_name":"X"}]`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					return len(traces) == 2 && traces[0].TraceID == "a" && !traces[1].Timestamp.IsZero()
+				})).Return(nil).Once()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"message":"traces saved","count":2}`,
+		},
+		{
+			name:           "rejects non-array payload",
+			body:           `{"trace_id":"a"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid trace payload"}`,
+		},
+		{
+			name: "repo error",
+			body: `[{"trace_id":"a"}]`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTraces", mock.Anything, mock.Anything).Return(errors.New("db error")).Once()
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"failed to save traces"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			h := NewTraceHandler(repo)
+			r := gin.New()
+			r.POST("/api/traces/batch", h.PostTraceBatch)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces/batch", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPostTrace_SpanMerging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name      string
+		body      string
+		header    string
+		setupMock func(repo *mockTraceRepo)
+	}{
+		{
+			name:   "adopts caller trace from traceparent",
+			body:   `{"agent_name":"ToolService","substeps":[{"name":"Lookup"},{"name":"Nested","parent_id":"b7ad6b7169203331"}]}`,
+			header: header,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("MergeTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					tr := traces[0]
+					return len(traces) == 1 &&
+						tr.TraceID == "4bf92f3577b34da6a3ce929d0e0e4736" &&
+						tr.ParentSpanID == "00f067aa0ba902b7" &&
+						tr.SubSteps[0].ParentID == "00f067aa0ba902b7" &&
+						tr.SubSteps[1].ParentID == "b7ad6b7169203331"
+				})).Return(nil).Once()
+			},
+		},
+		{
+			name:   "payload trace id wins over a different header",
+			body:   `{"trace_id":"own","agent_name":"X","substeps":[{"name":"Lookup"}]}`,
+			header: header,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("MergeTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					return traces[0].TraceID == "own" && traces[0].ParentSpanID == "" && traces[0].SubSteps[0].ParentID == ""
+				})).Return(nil).Once()
+			},
+		},
+		{
+			name:   "invalid header is ignored",
+			body:   `{"trace_id":"own","agent_name":"X"}`,
+			header: "00-zz-00f067aa0ba902b7-01",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("MergeTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					return traces[0].TraceID == "own" && traces[0].ParentSpanID == ""
+				})).Return(nil).Once()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			tt.setupMock(repo)
+			h := NewTraceHandler(repo, WithSpanMerging())
+			r := gin.New()
+			r.POST("/api/traces", h.PostTrace)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("traceparent", tt.header)
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusCreated, resp.Code)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPostTraceBatch_SpanMerging(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := new(mockTraceRepo)
+	repo.On("MergeTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+		return len(traces) == 2 && traces[0].TraceID == "a" && traces[1].TraceID == "b"
+	})).Return(nil).Once()
+
+	h := NewTraceHandler(repo, WithSpanMerging())
+	r := gin.New()
+	r.POST("/api/traces/batch", h.PostTraceBatch)
+
+	body := `[{"trace_id":"a"},{"trace_id":"b"}]`
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces/batch", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	repo.AssertExpectations(t)
+}
+
+func TestPostTrace_StructuredFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("stores messages, tool calls and model params as sent", func(t *testing.T) {
+		body := `{"trace_id":"s1","agent_name":"X",
+			"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello","name":"bot"}],
+			"substeps":[{"name":"LLM","tool_calls":[{"id":"c1","name":"search","arguments":{"q":"x","k":3},"result":[1,2]}],
+				"model_params":{"model":"m","temperature":0,"max_tokens":64}}]}`
+
+		repo := new(mockTraceRepo)
+		repo.On("InsertTrace", mock.Anything, mock.MatchedBy(func(tr model.Trace) bool {
+			step := tr.SubSteps[0]
+			return len(tr.Messages) == 2 && tr.Messages[1].Name == "bot" &&
+				string(step.ToolCalls[0].Arguments) == `{"q":"x","k":3}` &&
+				string(step.ToolCalls[0].Result) == `[1,2]` &&
+				step.ModelParams.Temperature != nil && *step.ModelParams.Temperature == 0 &&
+				*step.ModelParams.MaxTokens == 64
+		})).Return(nil).Once()
+
+		r := gin.New()
+		r.POST("/api/traces", NewTraceHandler(repo).PostTrace)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusCreated, resp.Code)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("rejects invalid structure with the offending field", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		r := gin.New()
+		r.POST("/api/traces", NewTraceHandler(repo).PostTrace)
+
+		body := `{"trace_id":"s1","messages":[{"role":"robot","content":"hi"}]}`
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error":"invalid trace payload","errors":[{"field":"messages[0].role","message":"unknown role \"robot\""}]}`, resp.Body.String())
+		repo.AssertNotCalled(t, "InsertTrace", mock.Anything, mock.Anything)
+	})
+
+	t.Run("enforces metadata limits", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		r := gin.New()
+		h := NewTraceHandler(repo, WithValidator(validation.New(validation.Limits{MaxKeys: 1, MaxKeyLength: 16, MaxValueLength: 16, MaxTags: 1, MaxTagLength: 16})))
+		r.POST("/api/traces", h.PostTrace)
+
+		body := `{"trace_id":"s1","tags":["a","b"]}`
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error":"invalid trace payload","errors":[{"field":"tags","message":"at most 1 tags allowed"}]}`, resp.Body.String())
+	})
+
+	t.Run("reports the batch index", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		r := gin.New()
+		r.POST("/api/traces/batch", NewTraceHandler(repo).PostTraceBatch)
+
+		body := `[{"trace_id":"a"},{"trace_id":"b","tool_calls":[{"arguments":{}}]}]`
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces/batch", strings.NewReader(body)))
+
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+		assert.JSONEq(t, `{"error":"invalid trace payload","errors":[{"field":"[1].tool_calls[0].name","message":"is required"}]}`, resp.Body.String())
+	})
+}
+
+func TestPostTrace_Validation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "defaults schema version",
+			body: `{"trace_id":"a","agent_name":"X"}`,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTrace", mock.Anything, mock.MatchedBy(func(tr model.Trace) bool {
+					return tr.SchemaVersion == validation.CurrentSchemaVersion
+				})).Return(nil).Once()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"message":"trace saved"}`,
+		},
+		{
+			name: "reports every invalid field",
+			body: `{"trace_id":"","latency_ms":-5,"token_usage":{"input_tokens":1,"output_tokens":1,"total":3},
+				"substeps":[{"name":"Retriever","start":"2025-05-01T01:23:01Z","end":"2025-05-01T01:23:00Z"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"error":"invalid trace payload","errors":[
+				{"field":"trace_id","message":"is required"},
+				{"field":"latency_ms","message":"must not be negative"},
+				{"field":"token_usage.total","message":"must equal input_tokens + output_tokens (2)"},
+				{"field":"substeps[0].end","message":"must not be before start"}]}`,
+		},
+		{
+			name:           "rejects unsupported schema version",
+			body:           `{"schema_version":2,"trace_id":"a"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid trace payload","errors":[{"field":"schema_version","message":"unsupported version 2"}]}`,
+		},
+		{
+			name:           "reports type mismatches by field",
+			body:           `{"trace_id":"a","latency_ms":"fast"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid trace payload","errors":[{"field":"latency_ms","message":"must be a number, got string"}]}`,
+		},
+		{
+			name:           "rejects oversized fields with 413",
+			body:           `{"trace_id":"a","input_prompt":"` + strings.Repeat("x", 1<<20+1) + `"}`,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedBody:   `{"error":"trace payload too large","errors":[{"field":"input_prompt","message":"exceeds 1048576 bytes"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			r := gin.New()
+			r.POST("/api/traces", NewTraceHandler(repo).PostTrace)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPostLangSmithRuns(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const run = `{"id":"r1","name":"Agent","run_type":"chain","start_time":"2025-05-01T10:00:00","end_time":"2025-05-01T10:00:01",
+		"inputs":{"input":"hi"},"outputs":{"output":"hello"},
+		"child_runs":[{"id":"r2","name":"LLM","run_type":"llm","start_time":"2025-05-01T10:00:00.1","end_time":"2025-05-01T10:00:00.9",
+			"inputs":{"prompts":["hi"]},"outputs":{"generations":[[{"text":"hello"}]]},
+			"extra":{"token_usage":{"prompt_tokens":2,"completion_tokens":1}}}]}`
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(repo *mockTraceRepo)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "converts a run tree",
+			body: run,
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					tr := traces[0]
+					return len(traces) == 1 && tr.TraceID == "r1" && tr.AgentName == "Agent" && tr.LatencyMS == 1000 &&
+						tr.TokenUsage.Total == 3 && len(tr.SubSteps) == 1 && tr.SubSteps[0].ParentID == "r1" &&
+						tr.SchemaVersion == validation.CurrentSchemaVersion
+				})).Return(nil).Once()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"message":"traces saved","count":1,"trace_ids":["r1"]}`,
+		},
+		{
+			name: "accepts an array of runs",
+			body: "[" + run + "," + strings.Replace(run, `"id":"r1"`, `"id":"r3"`, 1) + "]",
+			setupMock: func(repo *mockTraceRepo) {
+				repo.On("InsertTraces", mock.Anything, mock.MatchedBy(func(traces []model.Trace) bool {
+					return len(traces) == 2
+				})).Return(nil).Once()
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"message":"traces saved","count":2,"trace_ids":["r1","r3"]}`,
+		},
+		{
+			name:           "rejects malformed runs",
+			body:           `{"id":"r1","start_time":"soon"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid run payload"}`,
+		},
+		{
+			name:           "reports conversion results that fail validation",
+			body:           `{"name":"no-id"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid trace payload","errors":[{"field":"[0].trace_id","message":"is required"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			if tt.setupMock != nil {
+				tt.setupMock(repo)
+			}
+			r := gin.New()
+			r.POST("/api/ingest/langsmith", NewTraceHandler(repo).PostLangSmithRuns)
+
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/api/ingest/langsmith", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+			repo.AssertExpectations(t)
+		})
+	}
+}
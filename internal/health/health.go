@@ -0,0 +1,127 @@
+// Package health reports whether the server's dependencies are reachable.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check probes one dependency. It must return once ctx is done.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	Optional  bool   `json:"optional,omitempty"`
+}
+
+// Report is the outcome of every check. Status is down if any required check
+// failed; optional checks are reported but do not affect it.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs the registered checks concurrently, each bounded by timeout.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	checks map[string]entry
+}
+
+type entry struct {
+	check    Check
+	optional bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Checker{timeout: timeout, checks: make(map[string]entry)}
+}
+
+// Register adds a check under name, replacing any check with the same name.
+func (c *Checker) Register(name string, check Check) {
+	c.register(name, entry{check: check})
+}
+
+// RegisterOptional adds a check for a dependency the server can serve
+// without, such as an exporter: its failures are reported but leave the
+// report up.
+func (c *Checker) RegisterOptional(name string, check Check) {
+	c.register(name, entry{check: check, optional: true})
+}
+
+func (c *Checker) register(name string, e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks[name] = e
+}
+
+// Run executes every check and returns once all have finished or timed out.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make(map[string]entry, len(c.checks))
+	for name, e := range c.checks {
+		checks[name] = e
+	}
+	c.mu.RUnlock()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(checks))}
+	for name, e := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := c.run(ctx, e.check)
+			res.Optional = e.optional
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = res
+			if res.Status != StatusUp && !e.optional {
+				report.Status = StatusDown
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{Status: StatusUp, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+	}
+	return res
+}
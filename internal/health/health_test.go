@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Run(t *testing.T) {
+	c := NewChecker(50 * time.Millisecond)
+	c.Register("mongo", func(context.Context) error { return nil })
+	c.Register("otlp", func(context.Context) error { return errors.New("connection refused") })
+	c.Register("blob", func(context.Context) error {
+		time.Sleep(time.Second) // ignores ctx; the checker must not wait for it
+		return nil
+	})
+
+	start := time.Now()
+	report := c.Run(context.Background())
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, StatusUp, report.Checks["mongo"].Status)
+	assert.Equal(t, Result{Status: StatusDown, LatencyMS: report.Checks["otlp"].LatencyMS, Error: "connection refused"}, report.Checks["otlp"])
+	assert.Equal(t, "context deadline exceeded", report.Checks["blob"].Error)
+}
+
+func TestChecker_AllUp(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Register("mongo", func(context.Context) error { return nil })
+
+	report := c.Run(context.Background())
+
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Len(t, report.Checks, 1)
+}
+
+func TestChecker_OptionalChecksDoNotFailTheReport(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Register("mongo", func(context.Context) error { return nil })
+	c.RegisterOptional("otlp", func(context.Context) error { return errors.New("connection refused") })
+
+	report := c.Run(context.Background())
+	assert.Equal(t, StatusUp, report.Status)
+	assert.Equal(t, StatusDown, report.Checks["otlp"].Status)
+	assert.True(t, report.Checks["otlp"].Optional)
+	assert.False(t, report.Checks["mongo"].Optional)
+}
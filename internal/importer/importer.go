@@ -0,0 +1,218 @@
+// Package importer loads traces from JSONL dumps, such as those produced by
+// the export endpoint or by an older logging pipeline.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+)
+
+const (
+	// DefaultBatchSize is used when NewImporter is given a non-positive size.
+	DefaultBatchSize = 500
+	// maxLineSize bounds a single JSONL line.
+	maxLineSize = 32 * 1024 * 1024
+	// maxReported bounds the skipped and invalid lines listed in a Result;
+	// the counts are always exact.
+	maxReported = 1000
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Result summarises an import.
+type Result struct {
+	Imported     int         `json:"imported"`
+	Skipped      int         `json:"skipped"`
+	Invalid      int         `json:"invalid"`
+	SkippedLines []LineError `json:"skipped_lines,omitempty"`
+	InvalidLines []LineError `json:"invalid_lines,omitempty"`
+}
+
+// LineError explains why a line was not imported. Line numbers start at 1.
+type LineError struct {
+	Line    int               `json:"line"`
+	TraceID string            `json:"trace_id,omitempty"`
+	Reason  string            `json:"reason"`
+	Errors  validation.Errors `json:"errors,omitempty"`
+}
+
+func (r *Result) skip(e LineError) {
+	r.Skipped++
+	if len(r.SkippedLines) < maxReported {
+		r.SkippedLines = append(r.SkippedLines, e)
+	}
+}
+
+func (r *Result) invalid(e LineError) {
+	r.Invalid++
+	if len(r.InvalidLines) < maxReported {
+		r.InvalidLines = append(r.InvalidLines, e)
+	}
+}
+
+// Importer validates JSONL traces and inserts them in batches. Traces whose
+// trace_id appears earlier in the input or is already stored are skipped, so
+// re-running an interrupted import is safe.
+type Importer struct {
+	repo      repository.TraceRepository
+	validator *validation.Validator
+	batchSize int
+	now       func() time.Time
+}
+
+func NewImporter(repo repository.TraceRepository, validator *validation.Validator, batchSize int) *Importer {
+	if validator == nil {
+		validator = validation.New(validation.DefaultLimits)
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &Importer{repo: repo, validator: validator, batchSize: batchSize, now: time.Now}
+}
+
+// Import reads JSONL from r, which may be gzip-compressed, and stores every
+// valid trace. On a storage error it returns the counts so far together with
+// the error.
+func (i *Importer) Import(ctx context.Context, r io.Reader) (Result, error) {
+	var result Result
+
+	input, err := decompress(r)
+	if err != nil {
+		return result, err
+	}
+
+	b := batch{seen: make(map[string]bool)}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var trace model.Trace
+		if err := json.Unmarshal(data, &trace); err != nil {
+			result.invalid(LineError{Line: line, Reason: "invalid JSON", Errors: validation.FromDecodeError(err)})
+			continue
+		}
+		if errs := i.validator.Validate(trace); errs != nil {
+			result.invalid(LineError{Line: line, TraceID: trace.TraceID, Reason: "invalid trace", Errors: errs})
+			continue
+		}
+		if b.seen[trace.TraceID] {
+			result.skip(LineError{Line: line, TraceID: trace.TraceID, Reason: "duplicate trace_id in input"})
+			continue
+		}
+
+		i.prepare(&trace)
+		b.add(line, trace)
+		if len(b.traces) >= i.batchSize {
+			if err := i.flush(ctx, &b, &result); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("line %d: %w", line+1, err)
+	}
+
+	return result, i.flush(ctx, &b, &result)
+}
+
+// batch holds traces waiting to be inserted with their line numbers.
+type batch struct {
+	traces []model.Trace
+	lines  []int
+	seen   map[string]bool
+}
+
+func (b *batch) add(line int, trace model.Trace) {
+	b.traces = append(b.traces, trace)
+	b.lines = append(b.lines, line)
+	b.seen[trace.TraceID] = true
+}
+
+func (i *Importer) flush(ctx context.Context, b *batch, result *Result) error {
+	if len(b.traces) == 0 {
+		return nil
+	}
+	defer func() {
+		b.traces = b.traces[:0]
+		b.lines = b.lines[:0]
+	}()
+
+	ids := make([]string, len(b.traces))
+	for n, trace := range b.traces {
+		ids[n] = trace.TraceID
+	}
+	existing, err := i.repo.ExistingTraceIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	fresh := make([]model.Trace, 0, len(b.traces))
+	for n, trace := range b.traces {
+		if existing[trace.TraceID] {
+			result.skip(LineError{Line: b.lines[n], TraceID: trace.TraceID, Reason: "trace_id already stored"})
+			continue
+		}
+		fresh = append(fresh, trace)
+	}
+
+	if err := i.repo.InsertTraces(ctx, fresh); err != nil {
+		return fmt.Errorf("insert lines %d-%d: %w", b.lines[0], b.lines[len(b.lines)-1], err)
+	}
+	result.Imported += len(fresh)
+
+	return nil
+}
+
+// prepare fills in what the ingestion API would have set. Historical traces
+// keep their timestamp, falling back to when they were created; storage IDs
+// from another deployment are dropped.
+func (i *Importer) prepare(trace *model.Trace) {
+	trace.ID = primitive.NilObjectID
+	if trace.Timestamp.IsZero() {
+		trace.Timestamp = trace.CreatedAt
+	}
+	if trace.Timestamp.IsZero() {
+		trace.Timestamp = i.now().UTC()
+	}
+	if trace.SchemaVersion == 0 {
+		trace.SchemaVersion = validation.CurrentSchemaVersion
+	}
+}
+
+// decompress transparently gunzips r if it starts with the gzip magic bytes.
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip input: %w", err)
+	}
+	return gz, nil
+}
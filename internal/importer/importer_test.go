@@ -0,0 +1,135 @@
+package importer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+// memoryTraceRepo records inserted batches.
+type memoryTraceRepo struct {
+	repository.TraceRepository
+	batches   [][]model.Trace
+	stored    map[string]bool
+	insertErr error
+}
+
+func (m *memoryTraceRepo) ExistingTraceIDs(_ context.Context, ids []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for _, id := range ids {
+		if m.stored[id] {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+func (m *memoryTraceRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	if m.insertErr != nil {
+		return m.insertErr
+	}
+	m.batches = append(m.batches, append([]model.Trace(nil), traces...))
+	return nil
+}
+
+func (m *memoryTraceRepo) inserted() []model.Trace {
+	var all []model.Trace
+	for _, b := range m.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+func TestImport(t *testing.T) {
+	input := strings.Join([]string{
+		`{"trace_id":"a","agent_name":"A","created_at":"2024-01-02T03:04:05Z"}`,
+		``,
+		`{"trace_id":"b","agent_name":"A","timestamp":"2024-02-01T00:00:00Z"}`,
+		`{not json`,
+		`{"trace_id":"a","agent_name":"A"}`,
+		`{"agent_name":"missing id"}`,
+		`{"trace_id":"stored","agent_name":"A"}`,
+		`{"trace_id":"c","latency_ms":"slow"}`,
+		`{"trace_id":"d","agent_name":"A"}`,
+	}, "\n")
+
+	repo := &memoryTraceRepo{stored: map[string]bool{"stored": true}}
+	imp := NewImporter(repo, nil, 2)
+	imp.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	result, err := imp.Import(context.Background(), strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, result.Imported)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Equal(t, 3, result.Invalid)
+	assert.Equal(t, []LineError{
+		{Line: 5, TraceID: "a", Reason: "duplicate trace_id in input"},
+		{Line: 7, TraceID: "stored", Reason: "trace_id already stored"},
+	}, result.SkippedLines)
+
+	require.Len(t, result.InvalidLines, 3)
+	assert.Equal(t, 4, result.InvalidLines[0].Line)
+	assert.Equal(t, "invalid JSON", result.InvalidLines[0].Reason)
+	assert.Equal(t, 6, result.InvalidLines[1].Line)
+	assert.Equal(t, "trace_id", result.InvalidLines[1].Errors[0].Field)
+	assert.Equal(t, 8, result.InvalidLines[2].Line)
+	assert.Equal(t, "latency_ms", result.InvalidLines[2].Errors[0].Field)
+
+	// Batches of two: [a b], [stored→skipped, d].
+	require.Len(t, repo.batches, 2)
+	traces := repo.inserted()
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), traces[0].Timestamp, "falls back to created_at")
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), traces[1].Timestamp, "keeps the original timestamp")
+	assert.Equal(t, "d", traces[2].TraceID)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), traces[2].Timestamp)
+	assert.Equal(t, 1, traces[2].SchemaVersion)
+}
+
+func TestImportGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write([]byte(`{"trace_id":"a"}` + "\n" + `{"trace_id":"b"}`))
+	require.NoError(t, gz.Close())
+
+	repo := &memoryTraceRepo{}
+	result, err := NewImporter(repo, nil, 0).Import(context.Background(), &buf)
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Imported)
+}
+
+func TestImportClearsStorageIDs(t *testing.T) {
+	repo := &memoryTraceRepo{}
+	_, err := NewImporter(repo, nil, 0).Import(context.Background(),
+		strings.NewReader(`{"id":"665f1c2e8b3e4a0001a1b2c3","trace_id":"a"}`))
+
+	require.NoError(t, err)
+	assert.True(t, repo.inserted()[0].ID.IsZero())
+}
+
+func TestImportStorageError(t *testing.T) {
+	repo := &memoryTraceRepo{insertErr: errors.New("db down")}
+	result, err := NewImporter(repo, nil, 1).Import(context.Background(),
+		strings.NewReader(`{"trace_id":"a"}`+"\n"+`{"trace_id":"b"}`))
+
+	assert.ErrorContains(t, err, "insert lines 1-1: db down")
+	assert.Zero(t, result.Imported)
+}
+
+func TestImportEmpty(t *testing.T) {
+	result, err := NewImporter(&memoryTraceRepo{}, nil, 0).Import(context.Background(), strings.NewReader(""))
+
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, result)
+}
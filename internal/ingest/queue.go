@@ -0,0 +1,297 @@
+// Package ingest implements write-behind buffering of ingested traces.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when the traces do not fit in the
+	// queue; clients should retry later.
+	ErrQueueFull = errors.New("ingestion queue is full")
+	// ErrQueueClosed is returned by Enqueue once the queue is shutting down.
+	ErrQueueClosed = errors.New("ingestion queue is closed")
+)
+
+// Options configures a Queue. Zero values fall back to the defaults below.
+type Options struct {
+	// Size is the maximum number of traces waiting to be written.
+	Size      int
+	Workers   int
+	BatchSize int
+	// MaxRetries is the number of times a failed batch write is retried
+	// before its traces are handed to Fallback, or dropped without one.
+	MaxRetries int
+	// Fallback keeps batches that could not be written, e.g. a write-ahead
+	// log replayed once storage recovers.
+	Fallback Fallback
+	// MergeSpans writes with MergeTraces instead of InsertTraces.
+	MergeSpans bool
+	// Publishers receive each trace once it is stored.
+	Publishers []pubsub.Publisher
+}
+
+// Fallback takes traces the queue failed to write.
+type Fallback interface {
+	Append(traces []model.Trace) error
+}
+
+// Stats are the queue's counters since start.
+type Stats struct {
+	Capacity int   `json:"capacity"`
+	Depth    int   `json:"depth"`
+	Enqueued int64 `json:"enqueued"`
+	Written  int64 `json:"written"`
+	Rejected int64 `json:"rejected"`
+	Failed   int64 `json:"failed"`
+	Spooled  int64 `json:"spooled"`
+	// Duplicates were already stored, e.g. by a write-ahead log replay.
+	Duplicates int64  `json:"duplicates"`
+	Batches    int64  `json:"batches"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// Queue buffers validated traces in memory and writes them to the repository
+// in batches from a pool of workers, so a slow database delays storage rather
+// than every ingestion request. While a write is in flight new traces
+// accumulate, so batches grow with load.
+type Queue struct {
+	repo    repository.TraceRepository
+	opts    Options
+	wake    chan struct{}
+	backoff time.Duration
+
+	// aborted is cancelled by Abort to cut writes short.
+	aborted context.Context
+	abort   context.CancelFunc
+
+	mu      sync.Mutex
+	pending []model.Trace
+	closed  bool
+	lastErr error
+	stats   Stats
+}
+
+func NewQueue(repo repository.TraceRepository, opts Options) *Queue {
+	if opts.Size <= 0 {
+		opts.Size = 10000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	aborted, abort := context.WithCancel(context.Background())
+	return &Queue{
+		repo:    repo,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+		backoff: 200 * time.Millisecond,
+		aborted: aborted,
+		abort:   abort,
+		stats:   Stats{Capacity: opts.Size},
+	}
+}
+
+// Enqueue accepts traces for storage. Either all of them are queued or, if
+// they do not fit, none is and ErrQueueFull is returned.
+func (q *Queue) Enqueue(traces []model.Trace) error {
+	q.mu.Lock()
+	switch {
+	case q.closed:
+		q.mu.Unlock()
+		return ErrQueueClosed
+	case len(q.pending)+len(traces) > q.opts.Size:
+		q.stats.Rejected += int64(len(traces))
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.pending = append(q.pending, traces...)
+	q.stats.Enqueued += int64(len(traces))
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+// Run writes queued traces until ctx is done. It then stops accepting traces,
+// writes everything still queued and returns. Writes are not bounded by ctx,
+// so shutdown does not abort them halfway, but by Abort.
+func (q *Queue) Run(ctx context.Context) {
+	writeCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	defer context.AfterFunc(q.aborted, cancel)()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, writeCtx)
+		}()
+	}
+	wg.Wait()
+
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	for batch := q.take(); len(batch) > 0; batch = q.take() {
+		q.write(writeCtx, batch)
+	}
+}
+
+// Abort cancels the writes of a stopping queue, e.g. once the shutdown
+// deadline has passed. Traces not yet written are handed to the fallback
+// without retrying, so Run returns promptly.
+func (q *Queue) Abort() {
+	q.abort()
+}
+
+// Stats returns a snapshot of the queue's counters.
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.pending)
+	if q.lastErr != nil {
+		stats.LastError = q.lastErr.Error()
+	}
+	return stats
+}
+
+// Check reports the queue as unhealthy while its last batch write failed.
+func (q *Queue) Check(context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.lastErr != nil {
+		return errors.New("last write failed: " + q.lastErr.Error())
+	}
+	return nil
+}
+
+// work writes batches with writeCtx until ctx is done.
+func (q *Queue) work(ctx, writeCtx context.Context) {
+	for {
+		if batch := q.take(); len(batch) > 0 {
+			q.write(writeCtx, batch)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// take removes up to BatchSize traces from the queue, waking another worker
+// if traces remain.
+func (q *Queue) take() []model.Trace {
+	q.mu.Lock()
+	n := min(len(q.pending), q.opts.BatchSize)
+	batch := make([]model.Trace, n)
+	copy(batch, q.pending)
+	q.pending = q.pending[n:]
+	more := len(q.pending) > 0
+	q.mu.Unlock()
+
+	if more {
+		q.signal()
+	}
+	return batch
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// write stores batch, retrying with exponential backoff until ctx is done.
+// Inserts are unordered: traces already stored are skipped as duplicates and
+// the rest of the batch is written. Traces are published once stored; a batch
+// that still fails is handed to the fallback.
+func (q *Queue) write(ctx context.Context, batch []model.Trace) {
+	var err error
+	stored := batch
+	delay := q.backoff
+	for attempt := 0; ; attempt++ {
+		if q.opts.MergeSpans {
+			err = q.repo.MergeTraces(ctx, batch)
+		} else {
+			err = q.repo.InsertTraces(ctx, batch)
+		}
+		var dup *repository.DuplicateError
+		if errors.As(err, &dup) {
+			stored, err = dup.Without(batch), nil
+		}
+		if err == nil || attempt == q.opts.MaxRetries || !sleep(ctx, delay) {
+			break
+		}
+		delay *= 2
+	}
+
+	spooled := false
+	if err != nil && q.opts.Fallback != nil {
+		if ferr := q.opts.Fallback.Append(batch); ferr != nil {
+			logger.FromContext(ctx).WithError(ferr).Errorf("failed to spool %d queued traces", len(batch))
+		} else {
+			spooled = true
+		}
+	}
+
+	q.mu.Lock()
+	q.stats.Batches++
+	q.lastErr = err
+	switch {
+	case spooled:
+		q.stats.Spooled += int64(len(batch))
+	case err != nil:
+		q.stats.Failed += int64(len(batch))
+	default:
+		q.stats.Written += int64(len(stored))
+		q.stats.Duplicates += int64(len(batch) - len(stored))
+	}
+	q.mu.Unlock()
+
+	if spooled {
+		logger.FromContext(ctx).WithError(err).Warnf("failed to write %d queued traces, spooled them to the write-ahead log", len(batch))
+		return
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithError(err).Errorf("failed to write %d queued traces", len(batch))
+		return
+	}
+	for _, trace := range stored {
+		for _, p := range q.opts.Publishers {
+			p.Publish(trace)
+		}
+	}
+}
+
+// sleep waits for d and reports whether ctx is still live.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,294 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+// fakeRepo records written batches. Writes block while gate is held and fail
+// while failures remain. Traces listed in stored are rejected as duplicates
+// without failing the rest of their batch.
+type fakeRepo struct {
+	repository.TraceRepository
+	gate sync.RWMutex
+
+	mu       sync.Mutex
+	batches  [][]model.Trace
+	merged   bool
+	failures int
+	stored   map[string]bool
+}
+
+func (f *fakeRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	f.gate.RLock()
+	defer f.gate.RUnlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection reset")
+	}
+	dup := &repository.DuplicateError{}
+	for i, trace := range traces {
+		if f.stored[trace.TraceID] {
+			dup.Indexes = append(dup.Indexes, i)
+		}
+	}
+	if len(dup.Indexes) > 0 {
+		f.batches = append(f.batches, dup.Without(traces))
+		return dup
+	}
+	f.batches = append(f.batches, traces)
+	return nil
+}
+
+func (f *fakeRepo) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	f.mu.Lock()
+	f.merged = true
+	f.mu.Unlock()
+	return f.InsertTraces(ctx, traces)
+}
+
+func (f *fakeRepo) written() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	traces []string
+}
+
+func (r *recorder) Publish(trace model.Trace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traces = append(r.traces, trace.TraceID)
+}
+
+func traces(ids ...string) []model.Trace {
+	out := make([]model.Trace, len(ids))
+	for i, id := range ids {
+		out[i] = model.Trace{TraceID: id}
+	}
+	return out
+}
+
+func TestQueue_WritesAndPublishes(t *testing.T) {
+	repo := &fakeRepo{}
+	pub := &recorder{}
+	q := NewQueue(repo, Options{Workers: 2, MergeSpans: true, Publishers: []pubsub.Publisher{pub}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	require.NoError(t, q.Enqueue(traces("a")))
+	require.NoError(t, q.Enqueue(traces("b", "c")))
+
+	require.Eventually(t, func() bool { return repo.written() == 3 }, time.Second, 5*time.Millisecond)
+	assert.True(t, repo.merged)
+	require.Eventually(t, func() bool {
+		pub.mu.Lock()
+		defer pub.mu.Unlock()
+		return len(pub.traces) == 3
+	}, time.Second, 5*time.Millisecond)
+
+	stats := q.Stats()
+	assert.Equal(t, int64(3), stats.Enqueued)
+	assert.Equal(t, int64(3), stats.Written)
+	assert.Equal(t, 0, stats.Depth)
+}
+
+func TestQueue_RejectsWhenFull(t *testing.T) {
+	q := NewQueue(&fakeRepo{}, Options{Size: 3})
+
+	require.NoError(t, q.Enqueue(traces("a", "b")))
+	assert.ErrorIs(t, q.Enqueue(traces("c", "d")), ErrQueueFull, "a batch is queued whole or not at all")
+	require.NoError(t, q.Enqueue(traces("c")))
+
+	stats := q.Stats()
+	assert.Equal(t, 3, stats.Depth)
+	assert.Equal(t, int64(2), stats.Rejected)
+}
+
+func TestQueue_BatchesWhileWriteInFlight(t *testing.T) {
+	repo := &fakeRepo{}
+	q := NewQueue(repo, Options{Workers: 1, BatchSize: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	repo.gate.Lock()
+	require.NoError(t, q.Enqueue(traces("first")))
+	require.Eventually(t, func() bool { return q.Stats().Depth == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 25; i++ {
+		require.NoError(t, q.Enqueue(traces("t")))
+	}
+	repo.gate.Unlock()
+
+	require.Eventually(t, func() bool { return repo.written() == 26 }, time.Second, 5*time.Millisecond)
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	sizes := make([]int, len(repo.batches))
+	for i, b := range repo.batches {
+		sizes[i] = len(b)
+	}
+	assert.Equal(t, []int{1, 10, 10, 5}, sizes)
+}
+
+func TestQueue_FlushesOnShutdown(t *testing.T) {
+	repo := &fakeRepo{}
+	q := NewQueue(repo, Options{Workers: 1, BatchSize: 2})
+
+	repo.gate.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+	require.NoError(t, q.Enqueue(traces("a", "b", "c", "d", "e")))
+	cancel()
+	repo.gate.Unlock()
+	<-stopped
+
+	assert.Equal(t, 5, repo.written())
+	assert.ErrorIs(t, q.Enqueue(traces("late")), ErrQueueClosed)
+}
+
+func TestQueue_RetriesThenDrops(t *testing.T) {
+	repo := &fakeRepo{failures: 3}
+	q := NewQueue(repo, Options{Workers: 1, MaxRetries: 1})
+	q.backoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	require.NoError(t, q.Enqueue(traces("a")))
+	require.Eventually(t, func() bool { return q.Stats().Failed == 1 }, time.Second, time.Millisecond)
+	assert.ErrorContains(t, q.Check(ctx), "last write failed: connection reset")
+
+	require.NoError(t, q.Enqueue(traces("b")))
+	require.Eventually(t, func() bool { return q.Stats().Written == 1 }, time.Second, time.Millisecond)
+	assert.NoError(t, q.Check(ctx), "a successful write clears the failure")
+}
+
+type fallbackFunc func([]model.Trace) error
+
+func (f fallbackFunc) Append(traces []model.Trace) error { return f(traces) }
+
+func TestQueue_SpoolsFailedBatchesToFallback(t *testing.T) {
+	repo := &fakeRepo{failures: 2}
+	var mu sync.Mutex
+	var spooled []model.Trace
+	fallback := fallbackFunc(func(traces []model.Trace) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spooled = append(spooled, traces...)
+		return nil
+	})
+	pub := &recorder{}
+	q := NewQueue(repo, Options{Workers: 1, MaxRetries: 1, Fallback: fallback, Publishers: []pubsub.Publisher{pub}})
+	q.backoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	require.NoError(t, q.Enqueue(traces("a", "b")))
+	require.Eventually(t, func() bool { return q.Stats().Spooled == 2 }, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Len(t, spooled, 2)
+	mu.Unlock()
+	assert.Zero(t, q.Stats().Failed)
+	assert.Empty(t, pub.traces, "spooled traces are published when replayed")
+}
+
+func TestQueue_SkipsDuplicatesWithoutFailingTheBatch(t *testing.T) {
+	repo := &fakeRepo{stored: map[string]bool{"b": true}}
+	pub := &recorder{}
+	q := NewQueue(repo, Options{Workers: 1, Publishers: []pubsub.Publisher{pub}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	require.NoError(t, q.Enqueue(traces("a", "b", "c")))
+	require.Eventually(t, func() bool { return q.Stats().Batches == 1 }, time.Second, time.Millisecond)
+
+	stats := q.Stats()
+	assert.Equal(t, int64(2), stats.Written)
+	assert.Equal(t, int64(1), stats.Duplicates)
+	assert.Zero(t, stats.Failed)
+	assert.NoError(t, q.Check(ctx))
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	assert.Equal(t, []string{"a", "c"}, pub.traces, "duplicates were published when first stored")
+}
+
+// hungRepo models an unresponsive database: writes return only once their
+// context is done.
+type hungRepo struct {
+	repository.TraceRepository
+	writing chan struct{}
+}
+
+func (h *hungRepo) InsertTraces(ctx context.Context, _ []model.Trace) error {
+	h.writing <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestQueue_AbortSpoolsRemainingTraces(t *testing.T) {
+	repo := &hungRepo{writing: make(chan struct{}, 10)}
+	var mu sync.Mutex
+	var spooled []model.Trace
+	fallback := fallbackFunc(func(traces []model.Trace) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spooled = append(spooled, traces...)
+		return nil
+	})
+	q := NewQueue(repo, Options{Workers: 1, BatchSize: 2, MaxRetries: 5, Fallback: fallback})
+	q.backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+	require.NoError(t, q.Enqueue(traces("a", "b", "c")))
+	<-repo.writing
+	cancel()
+	q.Abort()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Abort")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, spooled, 3, "the write in flight and the rest of the queue are spooled without retries")
+	assert.Equal(t, int64(3), q.Stats().Spooled)
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/ratelimit"
+)
+
+// APIKeyHeader identifies the project sending a request. Requests without it
+// are rate limited by client IP.
+const APIKeyHeader = "X-API-Key"
+
+// RateLimit rejects requests with 429 and a Retry-After header once the
+// client's token bucket in limiter is empty. Clients are identified by their
+// API key, sent in X-API-Key or as a bearer token, when the limiter knows it,
+// and otherwise by their IP, so inventing keys does not buy fresh buckets.
+func RateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := limiter.Allow(clientKey(c, limiter))
+		if !ok {
+			c.Header("Retry-After", retryAfter(wait))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded, retry later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func clientKey(c *gin.Context, limiter *ratelimit.Limiter) string {
+	if key := c.GetHeader(APIKeyHeader); key != "" && limiter.Known(ratelimit.APIKey(key)) {
+		return ratelimit.APIKey(key)
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && limiter.Known(ratelimit.APIKey(token)) {
+		return ratelimit.APIKey(token)
+	}
+	return ratelimit.IP(c.ClientIP())
+}
+
+// retryAfter rounds wait up to whole seconds, at least one.
+func retryAfter(wait time.Duration) string {
+	secs := math.Ceil(wait.Seconds())
+	if secs > math.MaxInt32 {
+		secs = math.MaxInt32
+	}
+	return strconv.Itoa(max(1, int(secs)))
+}
+
+// BodyLimit rejects requests whose body exceeds maxBytes with 413. The body is
+// read up front so handlers see either the whole body or no request at all.
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			tooLarge(c, maxBytes)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			tooLarge(c, maxBytes)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// StreamLimit rejects requests declaring a body over maxBytes with 413 and
+// caps the rest at maxBytes without buffering them, for endpoints that stream
+// large uploads. Reading past the cap fails with an *http.MaxBytesError.
+func StreamLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			tooLarge(c, maxBytes)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+func tooLarge(c *gin.Context, maxBytes int64) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error": "request body exceeds " + strconv.FormatInt(maxBytes, 10) + " bytes",
+	})
+}
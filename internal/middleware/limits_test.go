@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zkropotkine/agent-trace/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := ratelimit.New(ratelimit.Limit{Rate: 0.5, Burst: 1}, map[string]ratelimit.Limit{
+		ratelimit.APIKey("big-project"): {Rate: 10, Burst: 2},
+	})
+	r := gin.New()
+	r.POST("/api/traces", RateLimit(limiter), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	send := func(setup func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/traces", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		setup(req)
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+	noKey := func(*http.Request) {}
+	apiKey := func(req *http.Request) { req.Header.Set(APIKeyHeader, "big-project") }
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "Bearer big-project") }
+	unknownKey := func(req *http.Request) { req.Header.Set(APIKeyHeader, "made-up") }
+
+	assert.Equal(t, http.StatusCreated, send(noKey).Code)
+	resp := send(noKey)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "2", resp.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"rate limit exceeded, retry later"}`, resp.Body.String())
+
+	// Unconfigured keys share the caller's IP bucket.
+	assert.Equal(t, http.StatusTooManyRequests, send(unknownKey).Code)
+
+	// The project's key has its own, larger bucket, whichever way it is sent.
+	assert.Equal(t, http.StatusCreated, send(apiKey).Code)
+	assert.Equal(t, http.StatusCreated, send(bearer).Code)
+	resp = send(apiKey)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+}
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+	}{
+		{name: "within limit", body: "0123456789", expectedStatus: http.StatusOK},
+		{name: "declared length over limit", body: "0123456789a", expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over limit", body: "0123456789a", chunked: true, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/api/traces", BodyLimit(10), func(c *gin.Context) {
+				body, _ := io.ReadAll(c.Request.Body)
+				c.String(http.StatusOK, string(body))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/traces", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, tt.body, resp.Body.String())
+			} else {
+				assert.JSONEq(t, `{"error":"request body exceeds 10 bytes"}`, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "within limit", body: "0123456789", expectedStatus: http.StatusOK, expectedBody: "0123456789"},
+		{name: "declared length over limit", body: "0123456789a", expectedStatus: http.StatusRequestEntityTooLarge, expectedBody: `{"error":"request body exceeds 10 bytes"}`},
+		{name: "chunked body over limit", body: "0123456789a", chunked: true, expectedStatus: http.StatusRequestEntityTooLarge, expectedBody: "read past 10 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/api/import", StreamLimit(10), func(c *gin.Context) {
+				body, err := io.ReadAll(c.Request.Body)
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					c.String(http.StatusRequestEntityTooLarge, "read past %d bytes", maxErr.Limit)
+					return
+				}
+				c.String(http.StatusOK, string(body))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			resp := httptest.NewRecorder()
+			r.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Equal(t, tt.expectedBody, resp.Body.String())
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type SubStep struct {
 	Name   string    `json:"name" bson:"name"`
@@ -9,6 +14,57 @@ type SubStep struct {
 	Status string    `json:"status" bson:"status"`
 	Start  time.Time `json:"start" bson:"start"`
 	End    time.Time `json:"end" bson:"end"`
+	// SpanID and ParentID describe nesting: a step whose ParentID matches
+	// another step's SpanID is a child of that step.
+	SpanID     string      `json:"span_id,omitempty" bson:"spanId,omitempty"`
+	ParentID   string      `json:"parent_id,omitempty" bson:"parentId,omitempty"`
+	Error      string      `json:"error,omitempty" bson:"error,omitempty"`
+	TokenUsage *TokenUsage `json:"token_usage,omitempty" bson:"tokenUsage,omitempty"`
+	// Messages, ToolCalls and ModelParams capture an LLM call in structured
+	// form; Input and Output remain for plain-text steps.
+	Messages    []Message         `json:"messages,omitempty" bson:"messages,omitempty"`
+	ToolCalls   []ToolCall        `json:"tool_calls,omitempty" bson:"toolCalls,omitempty"`
+	ModelParams *ModelParams      `json:"model_params,omitempty" bson:"modelParams,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// IsFailure reports whether a trace or substep status means it failed. Agents
+// report failures as "error", "failed" or "failure".
+func IsFailure(status string) bool {
+	switch status {
+	case "error", "failed", "failure":
+		return true
+	}
+	return false
+}
+
+// Message is one entry of a chat conversation, in order.
+type Message struct {
+	Role    string `json:"role" bson:"role"`
+	Content string `json:"content" bson:"content"`
+	// Name identifies the participant, or the tool for role "tool".
+	Name       string `json:"name,omitempty" bson:"name,omitempty"`
+	ToolCallID string `json:"tool_call_id,omitempty" bson:"toolCallId,omitempty"`
+}
+
+// ToolCall records a tool invocation requested by the model. Arguments and
+// Result are kept as raw JSON so they are returned exactly as received.
+type ToolCall struct {
+	ID        string          `json:"id,omitempty" bson:"id,omitempty"`
+	Name      string          `json:"name" bson:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty" bson:"arguments,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty" bson:"result,omitempty"`
+	Error     string          `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// ModelParams are the sampling parameters of an LLM call. Pointers distinguish
+// an explicit zero from an unset value.
+type ModelParams struct {
+	Model       string   `json:"model,omitempty" bson:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty" bson:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty" bson:"topP,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" bson:"maxTokens,omitempty"`
 }
 
 type TokenUsage struct {
@@ -18,15 +74,36 @@ type TokenUsage struct {
 }
 
 type Trace struct {
-	TraceID     string     `json:"trace_id" bson:"traceId"`
-	SessionID   string     `json:"session_id" bson:"sessionId"`
-	AgentName   string     `json:"agent_name" bson:"agentName"`
-	Timestamp   time.Time  `json:"timestamp" bson:"timestamp"`
-	Status      string     `json:"status" bson:"status"`
-	InputPrompt string     `json:"input_prompt" bson:"inputPrompt"`
-	Output      string     `json:"output" bson:"output"`
-	LatencyMS   int        `json:"latency_ms" bson:"latencyMs"`
-	TokenUsage  TokenUsage `json:"token_usage" bson:"tokenUsage"`
-	SubSteps    []SubStep  `json:"substeps" bson:"substeps"`
-	CreatedAt   time.Time  `json:"created_at" bson:"createdAt"`
+	ID primitive.ObjectID `json:"id,omitzero" bson:"_id,omitempty"`
+	// SchemaVersion is the ingestion payload format; see /api/schema.
+	SchemaVersion int          `json:"schema_version,omitempty" bson:"schemaVersion,omitempty"`
+	TraceID       string       `json:"trace_id" bson:"traceId"`
+	SessionID     string       `json:"session_id" bson:"sessionId"`
+	AgentName     string       `json:"agent_name" bson:"agentName"`
+	Timestamp     time.Time    `json:"timestamp" bson:"timestamp"`
+	Status        string       `json:"status" bson:"status"`
+	InputPrompt   string       `json:"input_prompt" bson:"inputPrompt"`
+	Output        string       `json:"output" bson:"output"`
+	LatencyMS     int          `json:"latency_ms" bson:"latencyMs"`
+	TokenUsage    TokenUsage   `json:"token_usage" bson:"tokenUsage"`
+	SubSteps      []SubStep    `json:"substeps" bson:"substeps"`
+	CreatedAt     time.Time    `json:"created_at" bson:"createdAt"`
+	Error         string       `json:"error,omitempty" bson:"error,omitempty"`
+	Messages      []Message    `json:"messages,omitempty" bson:"messages,omitempty"`
+	ToolCalls     []ToolCall   `json:"tool_calls,omitempty" bson:"toolCalls,omitempty"`
+	ModelParams   *ModelParams `json:"model_params,omitempty" bson:"modelParams,omitempty"`
+	// Metadata holds free-form attributes such as customer tier, experiment arm
+	// or git SHA; metadata and tags can be used to filter GetTraces.
+	Metadata map[string]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty" bson:"tags,omitempty"`
+	// ParentSpanID is set on trace fragments recorded by a downstream service:
+	// it is the caller's span that the fragment's top-level steps hang under.
+	ParentSpanID string `json:"parent_span_id,omitempty" bson:"parentSpanId,omitempty"`
+	// Services lists the agents that contributed spans to a merged trace.
+	Services []string `json:"services,omitempty" bson:"services,omitempty"`
+	// PayloadsPurged is set once retention has removed prompt, output and
+	// substep payloads, keeping only the trace metadata.
+	PayloadsPurged bool `json:"payloads_purged,omitempty" bson:"payloadsPurged,omitempty"`
+	// ReplayOf is the trace_id of the recorded trace this one re-ran.
+	ReplayOf string `json:"replay_of,omitempty" bson:"replayOf,omitempty"`
 }
@@ -0,0 +1,314 @@
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+const scopeName = "github.com/zkropotkine/agent-trace"
+
+// GenAI semantic convention attribute keys
+// (https://opentelemetry.io/docs/specs/semconv/gen-ai/).
+const (
+	attrOperationName  = "gen_ai.operation.name"
+	attrAgentName      = "gen_ai.agent.name"
+	attrConversationID = "gen_ai.conversation.id"
+	attrRequestModel   = "gen_ai.request.model"
+	attrTemperature    = "gen_ai.request.temperature"
+	attrTopP           = "gen_ai.request.top_p"
+	attrMaxTokens      = "gen_ai.request.max_tokens"
+	attrInputTokens    = "gen_ai.usage.input_tokens"
+	attrOutputTokens   = "gen_ai.usage.output_tokens"
+	attrToolName       = "gen_ai.tool.name"
+	attrToolCallID     = "gen_ai.tool.call.id"
+	attrInputMessages  = "gen_ai.input.messages"
+	attrOutputMessages = "gen_ai.output.messages"
+
+	opInvokeAgent = "invoke_agent"
+	opChat        = "chat"
+	opExecuteTool = "execute_tool"
+)
+
+// ConvertOptions controls how traces are mapped onto spans.
+type ConvertOptions struct {
+	ServiceName string
+	// CaptureContent adds prompts, outputs and messages as span attributes.
+	// It is off by default because they may contain sensitive data.
+	CaptureContent bool
+}
+
+// Convert maps traces onto an OTLP export request: one root span per trace
+// for the agent invocation and one child span per substep.
+func Convert(traces []model.Trace, opts ConvertOptions) ExportRequest {
+	spans := make([]Span, 0, len(traces))
+	for _, trace := range traces {
+		spans = append(spans, traceSpans(trace, opts)...)
+	}
+
+	return ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource: Resource{Attributes: []KeyValue{
+			stringAttr("service.name", opts.ServiceName),
+			stringAttr("telemetry.sdk.name", "agent-trace"),
+		}},
+		ScopeSpans: []ScopeSpans{{
+			Scope: Scope{Name: scopeName},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func traceSpans(trace model.Trace, opts ConvertOptions) []Span {
+	traceID := hexID(trace.TraceID, 16)
+	rootID := hashID(trace.TraceID+"/root", 8)
+
+	start := trace.CreatedAt
+	if start.IsZero() {
+		start = trace.Timestamp
+	}
+	end := start.Add(time.Duration(trace.LatencyMS) * time.Millisecond)
+
+	attrs := []KeyValue{
+		stringAttr(attrOperationName, opInvokeAgent),
+		stringAttr(attrAgentName, trace.AgentName),
+		stringAttr("agenttrace.trace_id", trace.TraceID),
+		intAttr(attrInputTokens, trace.TokenUsage.Input),
+		intAttr(attrOutputTokens, trace.TokenUsage.Output),
+	}
+	if trace.SessionID != "" {
+		attrs = append(attrs, stringAttr(attrConversationID, trace.SessionID))
+	}
+	attrs = append(attrs, llmAttrs(trace.ModelParams, nil)...)
+	attrs = append(attrs, metadataAttrs(trace.Metadata, trace.Tags)...)
+	if opts.CaptureContent {
+		attrs = append(attrs, contentAttrs(trace.Messages, trace.InputPrompt, trace.Output)...)
+	}
+
+	root := Span{
+		TraceID:           traceID,
+		SpanID:            rootID,
+		ParentSpanID:      validHex(trace.ParentSpanID, 8),
+		Name:              opInvokeAgent + " " + trace.AgentName,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        attrs,
+		Status:            status(trace.Status, trace.Error),
+	}
+
+	// Map step span IDs to OTLP span IDs so parent links survive even when
+	// the recorded IDs are not 8-byte hex.
+	ids := make(map[string]string, len(trace.SubSteps))
+	stepIDs := make([]string, len(trace.SubSteps))
+	for i, step := range trace.SubSteps {
+		key := step.SpanID
+		if key == "" {
+			key = strconv.Itoa(i)
+		}
+		stepIDs[i] = hexID(trace.TraceID+"/"+key, 8)
+		if step.SpanID != "" {
+			ids[step.SpanID] = stepIDs[i]
+		}
+	}
+
+	spans := []Span{root}
+	for i, step := range trace.SubSteps {
+		// Steps whose parent is not part of this trace, such as the top-level
+		// steps of a fragment recorded by a downstream service, hang off the root.
+		parent := rootID
+		if id, ok := ids[step.ParentID]; ok {
+			parent = id
+		}
+		spans = append(spans, stepSpan(trace, step, traceID, stepIDs[i], parent, opts))
+	}
+
+	return spans
+}
+
+func stepSpan(trace model.Trace, step model.SubStep, traceID, spanID, parentID string, opts ConvertOptions) Span {
+	kind := spanKindInternal
+	attrs := []KeyValue{}
+
+	switch {
+	case len(step.ToolCalls) > 0:
+		call := step.ToolCalls[0]
+		attrs = append(attrs, stringAttr(attrOperationName, opExecuteTool), stringAttr(attrToolName, call.Name))
+		if call.ID != "" {
+			attrs = append(attrs, stringAttr(attrToolCallID, call.ID))
+		}
+	case step.ModelParams != nil || len(step.Messages) > 0 || step.TokenUsage != nil:
+		kind = spanKindClient
+		attrs = append(attrs, stringAttr(attrOperationName, opChat))
+		attrs = append(attrs, llmAttrs(step.ModelParams, step.TokenUsage)...)
+	}
+	attrs = append(attrs, stringAttr(attrAgentName, trace.AgentName))
+	attrs = append(attrs, metadataAttrs(step.Metadata, step.Tags)...)
+	if opts.CaptureContent {
+		attrs = append(attrs, contentAttrs(step.Messages, step.Input, step.Output)...)
+	}
+
+	name := step.Name
+	if model := modelName(step.ModelParams); model != "" && kind == spanKindClient {
+		name = opChat + " " + model
+	}
+
+	return Span{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		ParentSpanID:      parentID,
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: unixNano(step.Start),
+		EndTimeUnixNano:   unixNano(step.End),
+		Attributes:        attrs,
+		Status:            status(step.Status, step.Error),
+	}
+}
+
+func llmAttrs(params *model.ModelParams, usage *model.TokenUsage) []KeyValue {
+	var attrs []KeyValue
+	if params != nil {
+		if params.Model != "" {
+			attrs = append(attrs, stringAttr(attrRequestModel, params.Model))
+		}
+		if params.Temperature != nil {
+			attrs = append(attrs, doubleAttr(attrTemperature, *params.Temperature))
+		}
+		if params.TopP != nil {
+			attrs = append(attrs, doubleAttr(attrTopP, *params.TopP))
+		}
+		if params.MaxTokens != nil {
+			attrs = append(attrs, intAttr(attrMaxTokens, *params.MaxTokens))
+		}
+	}
+	if usage != nil {
+		attrs = append(attrs, intAttr(attrInputTokens, usage.Input), intAttr(attrOutputTokens, usage.Output))
+	}
+	return attrs
+}
+
+func modelName(params *model.ModelParams) string {
+	if params == nil {
+		return ""
+	}
+	return params.Model
+}
+
+// metadataAttrs exposes metadata as agenttrace.metadata.<key> attributes, in
+// key order so output is deterministic.
+func metadataAttrs(metadata map[string]string, tags []string) []KeyValue {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]KeyValue, 0, len(keys)+1)
+	for _, k := range keys {
+		attrs = append(attrs, stringAttr("agenttrace.metadata."+k, metadata[k]))
+	}
+	if len(tags) > 0 {
+		values := make([]AnyValue, len(tags))
+		for i, tag := range tags {
+			values[i] = AnyValue{StringValue: &tag}
+		}
+		attrs = append(attrs, KeyValue{Key: "agenttrace.tags", Value: AnyValue{ArrayValue: &ArrayValue{Values: values}}})
+	}
+	return attrs
+}
+
+// contentAttrs records the conversation as JSON, falling back to the plain
+// input and output when no structured messages were captured.
+func contentAttrs(messages []model.Message, input, output string) []KeyValue {
+	var in, out []model.Message
+	for _, m := range messages {
+		if m.Role == "assistant" {
+			out = append(out, m)
+		} else {
+			in = append(in, m)
+		}
+	}
+	if len(in) == 0 && input != "" {
+		in = []model.Message{{Role: "user", Content: input}}
+	}
+	if len(out) == 0 && output != "" {
+		out = []model.Message{{Role: "assistant", Content: output}}
+	}
+
+	var attrs []KeyValue
+	if len(in) > 0 {
+		encoded, _ := json.Marshal(in)
+		attrs = append(attrs, stringAttr(attrInputMessages, string(encoded)))
+	}
+	if len(out) > 0 {
+		encoded, _ := json.Marshal(out)
+		attrs = append(attrs, stringAttr(attrOutputMessages, string(encoded)))
+	}
+	return attrs
+}
+
+func status(s, message string) Status {
+	switch {
+	case model.IsFailure(s):
+		return Status{Code: statusError, Message: message}
+	case s == "success":
+		return Status{Code: statusOK}
+	}
+	return Status{Code: statusUnset}
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// hexID returns id if it is already a valid n-byte hex ID, otherwise a stable
+// ID derived from it.
+func hexID(id string, n int) string {
+	if valid := validHex(id, n); valid != "" {
+		return valid
+	}
+	return hashID(id, n)
+}
+
+func validHex(id string, n int) string {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != n || isZero(b) {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashID(seed string, n int) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:n])
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func stringAttr(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int) KeyValue {
+	s := strconv.Itoa(value)
+	return KeyValue{Key: key, Value: AnyValue{IntValue: &s}}
+}
+
+func doubleAttr(key string, value float64) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{DoubleValue: &value}}
+}
@@ -0,0 +1,114 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func attr(span Span, key string) *AnyValue {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return &kv.Value
+		}
+	}
+	return nil
+}
+
+func TestConvert(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	temp := 0.2
+	trace := model.Trace{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SessionID:    "sess-1",
+		AgentName:    "Planner",
+		Status:       "error",
+		Error:        "tool failed",
+		CreatedAt:    start,
+		LatencyMS:    1500,
+		TokenUsage:   model.TokenUsage{Input: 10, Output: 5, Total: 15},
+		ParentSpanID: "00f067aa0ba902b7",
+		Metadata:     map[string]string{"env": "prod"},
+		Tags:         []string{"beta"},
+		InputPrompt:  "plan a trip",
+		SubSteps: []model.SubStep{
+			{
+				Name: "llm", SpanID: "a", Start: start, End: start.Add(time.Second),
+				ModelParams: &model.ModelParams{Model: "gpt-4o", Temperature: &temp},
+				TokenUsage:  &model.TokenUsage{Input: 10, Output: 5, Total: 15},
+				Status:      "success",
+			},
+			{
+				Name: "search", SpanID: "b", ParentID: "a", Status: "error", Error: "timeout",
+				ToolCalls: []model.ToolCall{{ID: "call_1", Name: "web_search"}},
+			},
+		},
+	}
+
+	req := Convert([]model.Trace{trace}, ConvertOptions{ServiceName: "svc"})
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, "svc", *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 3)
+	root, llm, tool := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, trace.TraceID, root.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", root.ParentSpanID)
+	assert.Equal(t, "invoke_agent Planner", root.Name)
+	assert.Equal(t, Status{Code: statusError, Message: "tool failed"}, root.Status)
+	assert.Equal(t, "1748779200000000000", root.StartTimeUnixNano)
+	assert.Equal(t, "1748779201500000000", root.EndTimeUnixNano)
+	assert.Equal(t, "invoke_agent", *attr(root, attrOperationName).StringValue)
+	assert.Equal(t, "sess-1", *attr(root, attrConversationID).StringValue)
+	assert.Equal(t, "10", *attr(root, attrInputTokens).IntValue)
+	assert.Equal(t, "prod", *attr(root, "agenttrace.metadata.env").StringValue)
+	assert.Len(t, attr(root, "agenttrace.tags").ArrayValue.Values, 1)
+	assert.Nil(t, attr(root, attrInputMessages), "content is not captured by default")
+
+	assert.Equal(t, root.SpanID, llm.ParentSpanID)
+	assert.Equal(t, "chat gpt-4o", llm.Name)
+	assert.Equal(t, spanKindClient, llm.Kind)
+	assert.Equal(t, 0.2, *attr(llm, attrTemperature).DoubleValue)
+	assert.Equal(t, "5", *attr(llm, attrOutputTokens).IntValue)
+	assert.Equal(t, Status{Code: statusOK}, llm.Status)
+
+	assert.Equal(t, llm.SpanID, tool.ParentSpanID)
+	assert.Len(t, tool.SpanID, 16)
+	assert.Equal(t, "execute_tool", *attr(tool, attrOperationName).StringValue)
+	assert.Equal(t, "web_search", *attr(tool, attrToolName).StringValue)
+	assert.Equal(t, "call_1", *attr(tool, attrToolCallID).StringValue)
+	assert.Equal(t, "0", tool.StartTimeUnixNano)
+}
+
+func TestConvertDerivesIDs(t *testing.T) {
+	trace := model.Trace{TraceID: "order-42", AgentName: "A", ParentSpanID: "not-hex"}
+
+	first := Convert([]model.Trace{trace}, ConvertOptions{}).ResourceSpans[0].ScopeSpans[0].Spans[0]
+	second := Convert([]model.Trace{trace}, ConvertOptions{}).ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	assert.Len(t, first.TraceID, 32)
+	assert.Len(t, first.SpanID, 16)
+	assert.Empty(t, first.ParentSpanID)
+	assert.Equal(t, first.TraceID, second.TraceID, "derived IDs are stable")
+}
+
+func TestConvertCaptureContent(t *testing.T) {
+	trace := model.Trace{
+		TraceID: "t1",
+		Messages: []model.Message{
+			{Role: "user", Content: "hi"},
+			{Role: "assistant", Content: "hello"},
+		},
+		SubSteps: []model.SubStep{{Name: "step", Input: "in", Output: "out"}},
+	}
+
+	spans := Convert([]model.Trace{trace}, ConvertOptions{CaptureContent: true}).ResourceSpans[0].ScopeSpans[0].Spans
+
+	assert.JSONEq(t, `[{"role":"user","content":"hi"}]`, *attr(spans[0], attrInputMessages).StringValue)
+	assert.JSONEq(t, `[{"role":"assistant","content":"hello"}]`, *attr(spans[0], attrOutputMessages).StringValue)
+	assert.JSONEq(t, `[{"role":"user","content":"in"}]`, *attr(spans[1], attrInputMessages).StringValue)
+}
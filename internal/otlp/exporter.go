@@ -0,0 +1,216 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// Options configures an Exporter. Zero values fall back to the defaults below.
+type Options struct {
+	// Endpoint is the full OTLP/HTTP traces URL, e.g. http://collector:4318/v1/traces.
+	Endpoint       string
+	Headers        map[string]string
+	ServiceName    string
+	CaptureContent bool
+	BatchSize      int
+	FlushInterval  time.Duration
+	QueueSize      int
+	MaxRetries     int
+	Client         *http.Client
+}
+
+// Exporter forwards ingested traces to an OTLP/HTTP collector. Traces are
+// handed over through Publish and exported in batches by Run, so a slow or
+// unavailable collector never slows down ingestion.
+type Exporter struct {
+	opts     Options
+	client   *http.Client
+	incoming chan model.Trace
+	backoff  time.Duration
+
+	exported atomic.Int64
+	dropped  atomic.Int64
+}
+
+func NewExporter(opts Options) *Exporter {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "agent-trace"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Exporter{
+		opts:     opts,
+		client:   client,
+		incoming: make(chan model.Trace, opts.QueueSize),
+		backoff:  500 * time.Millisecond,
+	}
+}
+
+// Publish queues a trace for export. Traces are dropped if the queue is full;
+// export is best effort and must not slow down ingestion.
+func (e *Exporter) Publish(trace model.Trace) {
+	select {
+	case e.incoming <- trace:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Exported returns the number of traces accepted by the collector.
+func (e *Exporter) Exported() int64 { return e.exported.Load() }
+
+// Dropped returns the number of traces discarded because the queue was full
+// or the collector rejected them after all retries.
+func (e *Exporter) Dropped() int64 { return e.dropped.Load() }
+
+// Run exports queued traces whenever a batch fills up or every flush
+// interval, until ctx is done. Traces still queued at that point are exported
+// once more with a short deadline.
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]model.Trace, 0, e.opts.BatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			e.drain(batch)
+			return
+		case trace := <-e.incoming:
+			batch = append(batch, trace)
+			if len(batch) >= e.opts.BatchSize {
+				e.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (e *Exporter) drain(batch []model.Trace) {
+	for len(e.incoming) > 0 {
+		batch = append(batch, <-e.incoming)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e.flush(ctx, batch)
+}
+
+func (e *Exporter) flush(ctx context.Context, batch []model.Trace) {
+	if err := e.Export(ctx, batch); err != nil {
+		e.dropped.Add(int64(len(batch)))
+		logger.FromContext(ctx).WithError(err).Errorf("failed to export %d traces to OTLP collector", len(batch))
+		return
+	}
+	e.exported.Add(int64(len(batch)))
+}
+
+// Export converts traces and posts them to the collector, retrying network
+// errors, 429 and 502-504 responses with exponential backoff. A Retry-After
+// header from the collector takes precedence over the backoff.
+func (e *Exporter) Export(ctx context.Context, traces []model.Trace) error {
+	body, err := json.Marshal(Convert(traces, ConvertOptions{
+		ServiceName:    e.opts.ServiceName,
+		CaptureContent: e.opts.CaptureContent,
+	}))
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	delay := e.backoff
+	for attempt := 0; attempt <= e.opts.MaxRetries; attempt++ {
+		retry, wait, err := e.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == e.opts.MaxRetries {
+			break
+		}
+		if wait == 0 {
+			wait = delay
+			delay *= 2
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	return fmt.Errorf("otlp export: %w", lastErr)
+}
+
+// Ping posts an empty export request, once, to check that the collector is
+// reachable and accepts the configured headers.
+func (e *Exporter) Ping(ctx context.Context) error {
+	_, _, err := e.post(ctx, []byte(`{"resourceSpans":[]}`))
+	return err
+}
+
+func (e *Exporter) post(ctx context.Context, body []byte) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return false, 0, nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, retryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return false, 0, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,179 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// fakeCollector records export requests and answers with the queued status
+// codes, then 200.
+type fakeCollector struct {
+	mu       sync.Mutex
+	requests []ExportRequest
+	headers  []http.Header
+	statuses []int
+	calls    atomic.Int32
+}
+
+func (f *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var req ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, req)
+	f.headers = append(f.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeCollector) spans() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		n += len(req.ResourceSpans[0].ScopeSpans[0].Spans)
+	}
+	return n
+}
+
+func newTestExporter(url string, opts Options) *Exporter {
+	opts.Endpoint = url
+	e := NewExporter(opts)
+	e.backoff = time.Millisecond
+	return e
+}
+
+func TestExporterBatches(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	e := newTestExporter(srv.URL, Options{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { e.Run(ctx); close(done) }()
+
+	for _, id := range []string{"a", "b", "c"} {
+		e.Publish(model.Trace{TraceID: id, AgentName: "A"})
+	}
+
+	require.Eventually(t, func() bool { return e.Exported() == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "Bearer token", collector.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/json", collector.headers[0].Get("Content-Type"))
+
+	// The partial batch is flushed on shutdown.
+	cancel()
+	<-done
+	assert.Equal(t, int64(3), e.Exported())
+	assert.Equal(t, 3, collector.spans())
+}
+
+func TestExporterFlushInterval(t *testing.T) {
+	collector := &fakeCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	e := newTestExporter(srv.URL, Options{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	e.Publish(model.Trace{TraceID: "a"})
+
+	require.Eventually(t, func() bool { return e.Exported() == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestExporterRetries(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	e := newTestExporter(srv.URL, Options{MaxRetries: 3})
+	err := e.Export(context.Background(), []model.Trace{{TraceID: "a"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), collector.calls.Load())
+	assert.Equal(t, 1, collector.spans())
+}
+
+func TestExporterGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int32
+	}{
+		{"retries exhausted", []int{503, 503, 503}, 3},
+		{"client error not retried", []int{400}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &fakeCollector{statuses: tt.statuses}
+			srv := httptest.NewServer(collector)
+			defer srv.Close()
+
+			e := newTestExporter(srv.URL, Options{MaxRetries: 2})
+			err := e.Export(context.Background(), []model.Trace{{TraceID: "a"}})
+
+			assert.Error(t, err)
+			assert.Equal(t, tt.calls, collector.calls.Load())
+		})
+	}
+}
+
+func TestExporterDropsWhenQueueFull(t *testing.T) {
+	e := NewExporter(Options{Endpoint: "http://unused", QueueSize: 1})
+
+	e.Publish(model.Trace{TraceID: "a"})
+	e.Publish(model.Trace{TraceID: "b"})
+
+	assert.Equal(t, int64(1), e.Dropped())
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 2*time.Second, retryAfter("2"))
+	assert.Zero(t, retryAfter(""))
+	assert.Zero(t, retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"))
+}
+
+func TestExporterPing(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{http.StatusOK, http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	e := newTestExporter(srv.URL, Options{MaxRetries: 3, Headers: map[string]string{"X-Tenant": "ops"}})
+
+	require.NoError(t, e.Ping(context.Background()))
+	assert.Equal(t, "ops", collector.headers[0].Get("X-Tenant"))
+	assert.Empty(t, collector.requests[0].ResourceSpans)
+
+	assert.Error(t, e.Ping(context.Background()))
+	assert.Equal(t, int32(2), collector.calls.Load(), "ping is not retried")
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// Publisher receives every trace accepted by the ingestion path.
+type Publisher interface {
+	Publish(trace model.Trace)
+}
+
+// Filter restricts a subscription to matching traces. Empty fields match anything.
+type Filter struct {
+	AgentName string
+	Status    string
+}
+
+func (f Filter) Match(trace model.Trace) bool {
+	if f.AgentName != "" && f.AgentName != trace.AgentName {
+		return false
+	}
+	if f.Status != "" && f.Status != trace.Status {
+		return false
+	}
+
+	return true
+}
+
+// Subscription delivers traces published after it was created. Its channel is
+// closed when the subscriber is dropped for falling behind or unsubscribes.
+type Subscription struct {
+	filter Filter
+	ch     chan model.Trace
+
+	mu      sync.Mutex
+	dropped bool
+}
+
+// Traces returns the channel on which matching traces are delivered.
+func (s *Subscription) Traces() <-chan model.Trace {
+	return s.ch
+}
+
+// Dropped reports whether the broker disconnected the subscriber because its
+// buffer was full.
+func (s *Subscription) Dropped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// Broker fans out published traces to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full is dropped so slow clients cannot stall
+// ingestion.
+type Broker struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBroker(buffer int) *Broker {
+	return &Broker{
+		buffer: buffer,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{filter: filter, ch: make(chan model.Trace, b.buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.ch)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+
+	return sub
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+func (b *Broker) Publish(trace model.Trace) {
+	var slow []*Subscription
+
+	b.mu.RLock()
+	for sub := range b.subs {
+		if !sub.filter.Match(trace) {
+			continue
+		}
+		select {
+		case sub.ch <- trace:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range slow {
+		sub.mu.Lock()
+		sub.dropped = true
+		sub.mu.Unlock()
+		b.remove(sub)
+	}
+}
+
+// Close ends every subscription, so live tails return during shutdown, and
+// makes later subscriptions end immediately.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+// remove must be called with b.mu held.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+
+	delete(b.subs, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,75 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func TestFilter_Match(t *testing.T) {
+	trace := model.Trace{AgentName: "A", Status: "error"}
+
+	assert.True(t, Filter{}.Match(trace))
+	assert.True(t, Filter{AgentName: "A", Status: "error"}.Match(trace))
+	assert.False(t, Filter{AgentName: "B"}.Match(trace))
+	assert.False(t, Filter{Status: "success"}.Match(trace))
+}
+
+func TestBroker_FanOut(t *testing.T) {
+	b := NewBroker(4)
+	all := b.Subscribe(Filter{})
+	onlyA := b.Subscribe(Filter{AgentName: "A"})
+
+	b.Publish(model.Trace{TraceID: "1", AgentName: "A"})
+	b.Publish(model.Trace{TraceID: "2", AgentName: "B"})
+
+	assert.Equal(t, "1", (<-all.Traces()).TraceID)
+	assert.Equal(t, "2", (<-all.Traces()).TraceID)
+	assert.Equal(t, "1", (<-onlyA.Traces()).TraceID)
+	assert.Empty(t, onlyA.Traces())
+
+	b.Unsubscribe(onlyA)
+	_, open := <-onlyA.Traces()
+	assert.False(t, open)
+	assert.False(t, onlyA.Dropped())
+	assert.Equal(t, 1, b.Subscribers())
+
+	b.Unsubscribe(onlyA)
+}
+
+func TestBroker_DropsSlowSubscribers(t *testing.T) {
+	b := NewBroker(1)
+	slow := b.Subscribe(Filter{})
+	fast := b.Subscribe(Filter{})
+
+	for i := 0; i < 3; i++ {
+		b.Publish(model.Trace{TraceID: "t"})
+		<-fast.Traces()
+	}
+
+	assert.True(t, slow.Dropped())
+	assert.False(t, fast.Dropped())
+	assert.Equal(t, 1, b.Subscribers())
+
+	assert.Len(t, slow.Traces(), 1, "buffered trace is still readable after the drop")
+	<-slow.Traces()
+	_, open := <-slow.Traces()
+	assert.False(t, open)
+}
+
+func TestBroker_Close(t *testing.T) {
+	b := NewBroker(1)
+	sub := b.Subscribe(Filter{})
+
+	b.Close()
+
+	_, open := <-sub.Traces()
+	assert.False(t, open)
+	assert.False(t, sub.Dropped(), "closing is not a drop")
+	assert.Equal(t, 0, b.Subscribers())
+
+	_, open = <-b.Subscribe(Filter{}).Traces()
+	assert.False(t, open, "subscriptions after close end immediately")
+	b.Publish(model.Trace{TraceID: "t"})
+}
@@ -0,0 +1,109 @@
+// Package ratelimit implements per-client token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// APIKey and IP return the bucket key of a client identified by its API key
+// or, without one, by its address.
+func APIKey(key string) string { return "key:" + key }
+func IP(ip string) string      { return "ip:" + ip }
+
+// Limit is a bucket refilling at Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// sweepInterval is how often buckets idle long enough to be full again are
+// dropped; a full bucket is indistinguishable from a new one.
+const sweepInterval = time.Minute
+
+// Limiter keeps one token bucket per key. Keys with an override get their own
+// Limit; every other key gets the default.
+type Limiter struct {
+	def       Limit
+	overrides map[string]Limit
+	now       func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func New(def Limit, overrides map[string]Limit) *Limiter {
+	return &Limiter{
+		def:       def,
+		overrides: overrides,
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// Known reports whether key has an override, i.e. belongs to a configured
+// client.
+func (l *Limiter) Known(key string) bool {
+	_, ok := l.overrides[key]
+	return ok
+}
+
+// Allow takes a token from the bucket of key. If the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		limit, ok := l.overrides[key]
+		if !ok {
+			limit = l.def
+		}
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+}
+
+// sweep drops buckets that have refilled completely. Must be called with mu
+// held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(def Limit, overrides map[string]Limit) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(def, overrides)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter_AllowsBurstThenRefills(t *testing.T) {
+	l, clock := newTestLimiter(Limit{Rate: 2, Burst: 3}, nil)
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("ip:10.0.0.1")
+		assert.True(t, ok, "request %d is within the burst", i)
+	}
+	ok, wait := l.Allow("ip:10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	clock.advance(500 * time.Millisecond)
+	ok, _ = l.Allow("ip:10.0.0.1")
+	assert.True(t, ok, "one token refilled")
+	ok, _ = l.Allow("ip:10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestLimiter_KeysAreIndependent(t *testing.T) {
+	l, _ := newTestLimiter(Limit{Rate: 1, Burst: 1}, nil)
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+}
+
+func TestLimiter_Overrides(t *testing.T) {
+	l, _ := newTestLimiter(Limit{Rate: 1, Burst: 1}, map[string]Limit{"key:batch": {Rate: 10, Burst: 5}})
+
+	for i := 0; i < 5; i++ {
+		ok, _ := l.Allow("key:batch")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("key:batch")
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+}
+
+func TestLimiter_SweepsIdleBuckets(t *testing.T) {
+	l, clock := newTestLimiter(Limit{Rate: 1, Burst: 2}, nil)
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+	assert.Len(t, l.buckets, 2)
+
+	clock.advance(sweepInterval)
+	l.Allow("c")
+	assert.Len(t, l.buckets, 1, "a and b are full again and dropped")
+}
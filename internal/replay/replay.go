@@ -0,0 +1,203 @@
+// Package replay re-runs a recorded trace's input against a live agent.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/traceparent"
+	"github.com/zkropotkine/agent-trace/internal/validation"
+)
+
+// ReplayTag is added to every replayed trace.
+const ReplayTag = "replay"
+
+// maxResponseSize bounds the agent response read into memory.
+const maxResponseSize = 10 << 20
+
+// ErrUnreachable is returned when the agent endpoint could not be called at
+// all, as opposed to responding with an error.
+var ErrUnreachable = errors.New("agent endpoint unreachable")
+
+// Request is the JSON body posted to the agent endpoint.
+type Request struct {
+	TraceID     string            `json:"trace_id"`
+	ReplayOf    string            `json:"replay_of"`
+	AgentName   string            `json:"agent_name"`
+	SessionID   string            `json:"session_id,omitempty"`
+	InputPrompt string            `json:"input_prompt"`
+	Messages    []model.Message   `json:"messages,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Options configures a Replayer.
+type Options struct {
+	// Endpoint is the agent URL the recorded input is posted to.
+	Endpoint string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+// Replayer posts a recorded trace's input to an agent endpoint and turns the
+// response into a new trace linked to the original through ReplayOf.
+//
+// The request carries a W3C traceparent header naming the new trace, so an
+// agent instrumented with the SDK records its own spans under the same
+// trace_id and they merge into the replay. Agents may also answer with a
+// trace-shaped JSON body (output, status, error, substeps, token_usage, ...);
+// any other body is taken as the output text.
+type Replayer struct {
+	opts   Options
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewReplayer(opts Options) *Replayer {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &Replayer{opts: opts, client: client, now: time.Now}
+}
+
+// Replay runs original's input again and returns the resulting trace. An
+// agent that responds with a non-2xx status yields a trace with status error;
+// only a failure to reach the agent is returned as an error.
+func (r *Replayer) Replay(ctx context.Context, original model.Trace) (model.Trace, error) {
+	tp := traceparent.Context{TraceID: newID(16), ParentID: newID(8)}
+	body, err := json.Marshal(Request{
+		TraceID:     tp.TraceID,
+		ReplayOf:    original.TraceID,
+		AgentName:   original.AgentName,
+		SessionID:   original.SessionID,
+		InputPrompt: original.InputPrompt,
+		Messages:    inputMessages(original.Messages),
+		Metadata:    original.Metadata,
+	})
+	if err != nil {
+		return model.Trace{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return model.Trace{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(traceparent.Header, traceparent.Format(tp))
+	for k, v := range r.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := r.now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return model.Trace{}, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return model.Trace{}, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	elapsed := r.now().Sub(start)
+
+	replay := decodeResponse(data)
+	replay.TraceID = tp.TraceID
+	replay.ReplayOf = original.TraceID
+	replay.AgentName = original.AgentName
+	replay.SessionID = original.SessionID
+	replay.InputPrompt = original.InputPrompt
+	replay.CreatedAt = start.UTC()
+	replay.SchemaVersion = validation.CurrentSchemaVersion
+	replay.ParentSpanID = ""
+	replay.Metadata = mergeMetadata(original.Metadata, replay.Metadata)
+	replay.Tags = appendTag(original.Tags, ReplayTag)
+	if replay.LatencyMS == 0 {
+		replay.LatencyMS = int(elapsed.Milliseconds())
+	}
+	if replay.SubSteps == nil {
+		replay.SubSteps = []model.SubStep{}
+	}
+
+	switch {
+	case resp.StatusCode >= 300:
+		replay.Status = "error"
+		replay.Error = fmt.Sprintf("agent responded %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(data)), 500))
+	case replay.Status == "":
+		replay.Status = "success"
+		if replay.Error != "" {
+			replay.Status = "error"
+		}
+	}
+
+	return replay, nil
+}
+
+// decodeResponse reads a trace-shaped JSON object, falling back to treating
+// the body as plain output text.
+func decodeResponse(data []byte) model.Trace {
+	var trace model.Trace
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' && json.Unmarshal(trimmed, &trace) == nil {
+		trace.ID = primitive.NilObjectID
+		return trace
+	}
+	return model.Trace{Output: string(data)}
+}
+
+// inputMessages drops the recorded answer, so the agent is given only what
+// it was originally asked.
+func inputMessages(messages []model.Message) []model.Message {
+	last := len(messages)
+	for last > 0 && messages[last-1].Role == "assistant" {
+		last--
+	}
+	return messages[:last]
+}
+
+func mergeMetadata(original, replay map[string]string) map[string]string {
+	if len(original) == 0 && len(replay) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(original)+len(replay))
+	for k, v := range original {
+		merged[k] = v
+	}
+	for k, v := range replay {
+		merged[k] = v
+	}
+	return merged
+}
+
+func appendTag(tags []string, tag string) []string {
+	if slices.Contains(tags, tag) {
+		return slices.Clone(tags)
+	}
+	return append(slices.Clone(tags), tag)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// newID returns n random bytes hex-encoded, matching W3C trace (16) and span (8) ID sizes.
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
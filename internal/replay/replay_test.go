@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/traceparent"
+)
+
+func original() model.Trace {
+	return model.Trace{
+		TraceID:     "orig",
+		AgentName:   "Planner",
+		SessionID:   "s1",
+		InputPrompt: "Plan a trip",
+		Output:      "Go to Lisbon",
+		Metadata:    map[string]string{"tier": "gold"},
+		Tags:        []string{"beta"},
+		Messages: []model.Message{
+			{Role: "user", Content: "Plan a trip"},
+			{Role: "assistant", Content: "Go to Lisbon"},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var got Request
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(traceparent.Header)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"output":"Go to Porto","token_usage":{"input_tokens":3,"output_tokens":2,"total":5},
+			"substeps":[{"name":"plan","status":"success"}],"metadata":{"build":"abc"}}`))
+	}))
+	defer srv.Close()
+
+	r := NewReplayer(Options{Endpoint: srv.URL, Headers: map[string]string{"X-Api-Key": "secret"}})
+	replay, err := r.Replay(context.Background(), original())
+	require.NoError(t, err)
+
+	tp, err := traceparent.Parse(header)
+	require.NoError(t, err)
+	assert.Equal(t, tp.TraceID, got.TraceID)
+	assert.Equal(t, "orig", got.ReplayOf)
+	assert.Equal(t, "Plan a trip", got.InputPrompt)
+	assert.Equal(t, []model.Message{{Role: "user", Content: "Plan a trip"}}, got.Messages, "recorded answer is not sent")
+
+	assert.Equal(t, tp.TraceID, replay.TraceID)
+	assert.Equal(t, "orig", replay.ReplayOf)
+	assert.Equal(t, "Planner", replay.AgentName)
+	assert.Equal(t, "Plan a trip", replay.InputPrompt)
+	assert.Equal(t, "Go to Porto", replay.Output)
+	assert.Equal(t, "success", replay.Status)
+	assert.Equal(t, 5, replay.TokenUsage.Total)
+	assert.Len(t, replay.SubSteps, 1)
+	assert.Equal(t, map[string]string{"tier": "gold", "build": "abc"}, replay.Metadata)
+	assert.Equal(t, []string{"beta", ReplayTag}, replay.Tags)
+	assert.Equal(t, []string{"beta"}, original().Tags)
+}
+
+func TestReplayPlainText(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Go to Porto"))
+	}))
+	defer srv.Close()
+
+	r := NewReplayer(Options{Endpoint: srv.URL})
+	step := 0
+	r.now = func() time.Time {
+		step++
+		return time.Date(2025, 1, 1, 0, 0, step, 0, time.UTC)
+	}
+	replay, err := r.Replay(context.Background(), original())
+
+	require.NoError(t, err)
+	assert.Equal(t, "Go to Porto", replay.Output)
+	assert.Equal(t, 1000, replay.LatencyMS)
+	assert.NotNil(t, replay.SubSteps)
+}
+
+func TestReplayAgentError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model overloaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	replay, err := NewReplayer(Options{Endpoint: srv.URL}).Replay(context.Background(), original())
+
+	require.NoError(t, err)
+	assert.Equal(t, "error", replay.Status)
+	assert.Equal(t, "agent responded 503: model overloaded", replay.Error)
+}
+
+func TestReplayUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := NewReplayer(Options{Endpoint: srv.URL}).Replay(context.Background(), original())
+
+	assert.ErrorIs(t, err, ErrUnreachable)
+}
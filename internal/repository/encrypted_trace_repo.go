@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/zkropotkine/agent-trace/internal/encryption"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// encryptedTraceRepository encrypts prompt and output content before it reaches
+// the wrapped repository and decrypts it on reads. Metadata such as agent name,
+// status, latency and token usage is stored in clear so it remains queryable.
+type encryptedTraceRepository struct {
+	TraceRepository
+	keyring *encryption.Keyring
+}
+
+func NewEncryptedTraceRepository(inner TraceRepository, keyring *encryption.Keyring) TraceRepository {
+	return &encryptedTraceRepository{
+		TraceRepository: inner,
+		keyring:         keyring,
+	}
+}
+
+func (r *encryptedTraceRepository) InsertTrace(ctx context.Context, trace model.Trace) error {
+	sealed, err := r.encrypt(trace)
+	if err != nil {
+		return err
+	}
+
+	return r.TraceRepository.InsertTrace(ctx, sealed)
+}
+
+func (r *encryptedTraceRepository) InsertTraces(ctx context.Context, traces []model.Trace) error {
+	sealed := make([]model.Trace, len(traces))
+	for i, trace := range traces {
+		var err error
+		if sealed[i], err = r.encrypt(trace); err != nil {
+			return err
+		}
+	}
+
+	return r.TraceRepository.InsertTraces(ctx, sealed)
+}
+
+func (r *encryptedTraceRepository) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	sealed := make([]model.Trace, len(traces))
+	for i, trace := range traces {
+		var err error
+		if sealed[i], err = r.encrypt(trace); err != nil {
+			return err
+		}
+	}
+
+	return r.TraceRepository.MergeTraces(ctx, sealed)
+}
+
+func (r *encryptedTraceRepository) GetTraces(ctx context.Context, filter TraceFilter) ([]model.Trace, error) {
+	traces, err := r.TraceRepository.GetTraces(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range traces {
+		if traces[i], err = r.decrypt(traces[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return traces, nil
+}
+
+func (r *encryptedTraceRepository) IterateTraces(ctx context.Context, filter TraceFilter, fn func(model.Trace) error) error {
+	return r.TraceRepository.IterateTraces(ctx, filter, func(trace model.Trace) error {
+		opened, err := r.decrypt(trace)
+		if err != nil {
+			return err
+		}
+		return fn(opened)
+	})
+}
+
+func (r *encryptedTraceRepository) GetByID(ctx context.Context, id string) (*model.Trace, error) {
+	trace, err := r.TraceRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	opened, err := r.decrypt(*trace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &opened, nil
+}
+
+// encrypt returns a copy of trace with its sensitive fields sealed.
+func (r *encryptedTraceRepository) encrypt(trace model.Trace) (model.Trace, error) {
+	return transformPayloads(trace, payloadCodec{text: r.keyring.Encrypt, raw: r.encryptRaw})
+}
+
+// decrypt returns a copy of trace with its sensitive fields opened.
+func (r *encryptedTraceRepository) decrypt(trace model.Trace) (model.Trace, error) {
+	return transformPayloads(trace, payloadCodec{text: r.keyring.Decrypt, raw: r.decryptRaw})
+}
+
+// encryptRaw seals a JSON value and stores the envelope as a JSON string so the
+// field stays valid JSON.
+func (r *encryptedTraceRepository) encryptRaw(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	sealed, err := r.keyring.Encrypt(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealed)
+}
+
+// decryptRaw opens a value sealed by encryptRaw. Anything else, such as data
+// written before encryption was enabled, is returned unchanged.
+func (r *encryptedTraceRepository) decryptRaw(raw json.RawMessage) (json.RawMessage, error) {
+	var sealed string
+	if err := json.Unmarshal(raw, &sealed); err != nil || !encryption.IsEncrypted(sealed) {
+		return raw, nil
+	}
+	opened, err := r.keyring.Decrypt(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(opened), nil
+}
+
+// payloadCodec transforms sensitive values: text for plain strings, raw for
+// JSON values such as tool call arguments.
+type payloadCodec struct {
+	text func(string) (string, error)
+	raw  func(json.RawMessage) (json.RawMessage, error)
+}
+
+// transformPayloads applies c to every sensitive field of trace. Slices are
+// copied so the caller's trace is never modified.
+func transformPayloads(trace model.Trace, c payloadCodec) (model.Trace, error) {
+	var err error
+	if trace.InputPrompt, err = c.text(trace.InputPrompt); err != nil {
+		return trace, err
+	}
+	if trace.Output, err = c.text(trace.Output); err != nil {
+		return trace, err
+	}
+	if trace.Messages, err = transformMessages(trace.Messages, c); err != nil {
+		return trace, err
+	}
+	if trace.ToolCalls, err = transformToolCalls(trace.ToolCalls, c); err != nil {
+		return trace, err
+	}
+
+	if trace.SubSteps != nil {
+		steps := make([]model.SubStep, len(trace.SubSteps))
+		for i, step := range trace.SubSteps {
+			if step.Input, err = c.text(step.Input); err != nil {
+				return trace, err
+			}
+			if step.Output, err = c.text(step.Output); err != nil {
+				return trace, err
+			}
+			if step.Messages, err = transformMessages(step.Messages, c); err != nil {
+				return trace, err
+			}
+			if step.ToolCalls, err = transformToolCalls(step.ToolCalls, c); err != nil {
+				return trace, err
+			}
+			steps[i] = step
+		}
+		trace.SubSteps = steps
+	}
+
+	return trace, nil
+}
+
+func transformMessages(messages []model.Message, c payloadCodec) ([]model.Message, error) {
+	if messages == nil {
+		return nil, nil
+	}
+
+	out := make([]model.Message, len(messages))
+	for i, msg := range messages {
+		var err error
+		if msg.Content, err = c.text(msg.Content); err != nil {
+			return nil, err
+		}
+		out[i] = msg
+	}
+
+	return out, nil
+}
+
+func transformToolCalls(calls []model.ToolCall, c payloadCodec) ([]model.ToolCall, error) {
+	if calls == nil {
+		return nil, nil
+	}
+
+	out := make([]model.ToolCall, len(calls))
+	for i, call := range calls {
+		var err error
+		if call.Arguments, err = c.raw(call.Arguments); err != nil {
+			return nil, err
+		}
+		if call.Result, err = c.raw(call.Result); err != nil {
+			return nil, err
+		}
+		out[i] = call
+	}
+
+	return out, nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/encryption"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// memoryTraceRepo is an in-memory TraceRepository used to observe what the
+// decorators hand to the underlying storage.
+type memoryTraceRepo struct {
+	TraceRepository
+	traces []model.Trace
+}
+
+func (m *memoryTraceRepo) InsertTrace(_ context.Context, trace model.Trace) error {
+	m.traces = append(m.traces, trace)
+	return nil
+}
+
+func (m *memoryTraceRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	m.traces = append(m.traces, traces...)
+	return nil
+}
+
+func (m *memoryTraceRepo) MergeTraces(_ context.Context, traces []model.Trace) error {
+	m.traces = append(m.traces, traces...)
+	return nil
+}
+
+func (m *memoryTraceRepo) GetTraces(_ context.Context, _ TraceFilter) ([]model.Trace, error) {
+	return append([]model.Trace(nil), m.traces...), nil
+}
+
+func (m *memoryTraceRepo) GetByID(_ context.Context, id string) (*model.Trace, error) {
+	for _, trace := range m.traces {
+		if trace.TraceID == id {
+			return &trace, nil
+		}
+	}
+	return nil, assert.AnError
+}
+
+func (m *memoryTraceRepo) IterateExpired(_ context.Context, _ ExpiryFilter, fn func(model.Trace) error) error {
+	for _, trace := range m.traces {
+		if err := fn(trace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryTraceRepo) DeleteExpired(_ context.Context, _ ExpiryFilter) (int64, error) {
+	n := int64(len(m.traces))
+	m.traces = nil
+	return n, nil
+}
+
+func (m *memoryTraceRepo) PurgePayloads(_ context.Context, _ ExpiryFilter) (int64, error) {
+	for i := range m.traces {
+		m.traces[i].InputPrompt, m.traces[i].Output, m.traces[i].SubSteps = "", "", nil
+		m.traces[i].PayloadsPurged = true
+	}
+	return int64(len(m.traces)), nil
+}
+
+func newTestKeyring(t *testing.T) *encryption.Keyring {
+	ring, err := encryption.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	return ring
+}
+
+func TestEncryptedTraceRepository(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	repo := NewEncryptedTraceRepository(inner, newTestKeyring(t))
+
+	trace := model.Trace{
+		TraceID:     "t1",
+		AgentName:   "DocumentAgent",
+		InputPrompt: "Summarize privacy policy.",
+		Output:      "Privacy policy summary...",
+		LatencyMS:   350,
+		Messages:    []model.Message{{Role: "user", Content: "Summarize privacy policy."}},
+		SubSteps: []model.SubStep{
+			{Name: "Retriever", Input: "privacy", Output: "[doc1, doc2]"},
+			{
+				Name:      "LLM",
+				Messages:  []model.Message{{Role: "system", Content: "Be brief."}},
+				ToolCalls: []model.ToolCall{{Name: "search", Arguments: json.RawMessage(`{"q": "privacy"}`), Result: json.RawMessage(`["doc1"]`)}},
+			},
+		},
+	}
+
+	require.NoError(t, repo.InsertTrace(context.Background(), trace))
+
+	t.Run("stores sensitive fields encrypted", func(t *testing.T) {
+		stored := inner.traces[0]
+		assert.True(t, encryption.IsEncrypted(stored.InputPrompt))
+		assert.True(t, encryption.IsEncrypted(stored.Output))
+		assert.True(t, encryption.IsEncrypted(stored.SubSteps[0].Input))
+		assert.True(t, encryption.IsEncrypted(stored.SubSteps[0].Output))
+		assert.True(t, encryption.IsEncrypted(stored.Messages[0].Content))
+		assert.True(t, encryption.IsEncrypted(stored.SubSteps[1].Messages[0].Content))
+		assert.Equal(t, "user", stored.Messages[0].Role)
+		assert.Equal(t, "search", stored.SubSteps[1].ToolCalls[0].Name)
+
+		var sealedArgs string
+		require.NoError(t, json.Unmarshal(stored.SubSteps[1].ToolCalls[0].Arguments, &sealedArgs))
+		assert.True(t, encryption.IsEncrypted(sealedArgs))
+		assert.Equal(t, "DocumentAgent", stored.AgentName)
+		assert.Equal(t, "Retriever", stored.SubSteps[0].Name)
+		assert.Equal(t, 350, stored.LatencyMS)
+	})
+
+	t.Run("does not modify the caller's trace", func(t *testing.T) {
+		assert.Equal(t, "privacy", trace.SubSteps[0].Input)
+		assert.Equal(t, "Be brief.", trace.SubSteps[1].Messages[0].Content)
+		assert.JSONEq(t, `{"q": "privacy"}`, string(trace.SubSteps[1].ToolCalls[0].Arguments))
+	})
+
+	t.Run("decrypts on GetByID", func(t *testing.T) {
+		got, err := repo.GetByID(context.Background(), "t1")
+		require.NoError(t, err)
+		assert.Equal(t, trace, *got)
+	})
+
+	t.Run("decrypts on GetTraces", func(t *testing.T) {
+		got, err := repo.GetTraces(context.Background(), TraceFilter{})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, trace, got[0])
+	})
+
+	t.Run("encrypts batch inserts", func(t *testing.T) {
+		require.NoError(t, repo.InsertTraces(context.Background(), []model.Trace{{TraceID: "t2", InputPrompt: "batch secret"}}))
+
+		stored := inner.traces[len(inner.traces)-1]
+		assert.True(t, encryption.IsEncrypted(stored.InputPrompt))
+
+		got, err := repo.GetByID(context.Background(), "t2")
+		require.NoError(t, err)
+		assert.Equal(t, "batch secret", got.InputPrompt)
+	})
+
+	t.Run("encrypts merged fragments", func(t *testing.T) {
+		fragment := model.Trace{
+			TraceID:      "t3",
+			ParentSpanID: "00f067aa0ba902b7",
+			SubSteps:     []model.SubStep{{Name: "Tool", Input: "remote secret"}},
+		}
+		require.NoError(t, repo.MergeTraces(context.Background(), []model.Trace{fragment}))
+
+		stored := inner.traces[len(inner.traces)-1]
+		assert.True(t, encryption.IsEncrypted(stored.SubSteps[0].Input))
+	})
+
+	t.Run("fails reads with an unknown key", func(t *testing.T) {
+		other, err := encryption.NewKeyring("k9", map[string][]byte{"k9": bytes.Repeat([]byte{9}, 32)})
+		require.NoError(t, err)
+
+		_, err = NewEncryptedTraceRepository(inner, other).GetByID(context.Background(), "t1")
+		assert.ErrorIs(t, err, encryption.ErrUnknownKey)
+	})
+}
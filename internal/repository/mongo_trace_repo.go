@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/zkropotkine/agent-trace/internal/model"
 	"go.mongodb.org/mongo-driver/bson"
@@ -10,6 +12,10 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// iterateBatchSize is the number of documents fetched per round trip when
+// iterating over large result sets.
+const iterateBatchSize = 500
+
 type mongoTraceRepository struct {
 	collection *mongo.Collection
 }
@@ -22,25 +28,229 @@ func NewMongoTraceRepository(collection *mongo.Collection) TraceRepository {
 
 func (r *mongoTraceRepository) InsertTrace(ctx context.Context, trace model.Trace) error {
 	_, err := r.collection.InsertOne(ctx, trace)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", ErrDuplicateTrace, err)
+	}
+
 	return err
 }
 
-func (r *mongoTraceRepository) GetTraces(ctx context.Context, filter TraceFilter) ([]model.Trace, error) {
-	mongoFilter := bson.M{}
-	if filter.AgentName != "" {
-		mongoFilter["agent_name"] = filter.AgentName
+func (r *mongoTraceRepository) InsertTraces(ctx context.Context, traces []model.Trace) error {
+	if len(traces) == 0 {
+		return nil
 	}
-	if filter.From != nil || filter.To != nil {
-		timeRange := bson.M{}
-		if filter.From != nil {
-			timeRange["$gte"] = *filter.From
+
+	docs := make([]interface{}, len(traces))
+	for i, trace := range traces {
+		docs[i] = trace
+	}
+
+	_, err := r.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if indexes, ok := duplicateIndexes(err); ok {
+		return &DuplicateError{Indexes: indexes, cause: err}
+	}
+
+	return err
+}
+
+// duplicateIndexes returns the positions of the writes rejected by err if
+// every one of them failed on a duplicate key, the others having succeeded.
+func duplicateIndexes(err error) ([]int, bool) {
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return nil, false
+	}
+
+	indexes := make([]int, len(bulkErr.WriteErrors))
+	for i, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return nil, false
 		}
-		if filter.To != nil {
-			timeRange["$lte"] = *filter.To
+		indexes[i] = writeErr.Index
+	}
+
+	return indexes, true
+}
+
+func (r *mongoTraceRepository) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	// A fragment that hits a duplicate key either lost a race with a
+	// concurrent upsert creating the trace, and is applied by the retry, or
+	// was already applied, in which case the retry hits the duplicate again.
+	pending := traces
+	for attempt := 0; attempt < 2 && len(pending) > 0; attempt++ {
+		var err error
+		if pending, err = r.merge(ctx, pending); err != nil {
+			return err
 		}
-		mongoFilter["timestamp"] = timeRange
 	}
 
+	return nil
+}
+
+// merge upserts each fragment and returns those rejected with a duplicate key.
+func (r *mongoTraceRepository) merge(ctx context.Context, traces []model.Trace) ([]model.Trace, error) {
+	models := make([]mongo.WriteModel, len(traces))
+	for i, trace := range traces {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(mergeFilter(trace)).
+			SetUpdate(mergeUpdate(trace)).
+			SetUpsert(true)
+	}
+
+	_, err := r.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err == nil {
+		return nil, nil
+	}
+	indexes, ok := duplicateIndexes(err)
+	if !ok {
+		return nil, err
+	}
+
+	duplicates := make([]model.Trace, len(indexes))
+	for i, index := range indexes {
+		duplicates[i] = traces[index]
+	}
+
+	return duplicates, nil
+}
+
+// EnsureIndexes creates the indexes used to filter traces by tags and by
+// arbitrary metadata keys. It is idempotent and safe to call on every start.
+// With uniqueTraceID, as required to merge spans, trace ids are unique.
+func EnsureIndexes(ctx context.Context, collection *mongo.Collection, uniqueTraceID bool) error {
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "traceId", Value: 1}}, Options: options.Index().SetUnique(uniqueTraceID)},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "metadata.$**", Value: 1}}},
+	})
+
+	return err
+}
+
+// mergeFilter matches the trace a fragment belongs to, unless the fragment
+// was already applied to it. The upsert then attempts to create the trace
+// again and fails on the unique trace id, so a retried fragment is neither
+// counted nor appended twice.
+func mergeFilter(trace model.Trace) bson.M {
+	filter := bson.M{"traceId": trace.TraceID}
+	if keys := fragmentKeys(trace); len(keys) > 0 {
+		filter["fragments"] = bson.M{"$nin": keys}
+	}
+
+	return filter
+}
+
+// fragmentKeys identifies a fragment by the ID assigned at ingestion, which
+// a write retried from the queue or write-ahead log keeps, and by the span
+// ids of its steps, which a client retry keeps. Fragments with neither cannot
+// be told apart and are always applied.
+func fragmentKeys(trace model.Trace) []string {
+	var keys []string
+	if !trace.ID.IsZero() {
+		keys = append(keys, "id:"+trace.ID.Hex())
+	}
+	for _, step := range trace.SubSteps {
+		if step.SpanID != "" {
+			keys = append(keys, "span:"+step.SpanID)
+		}
+	}
+
+	return keys
+}
+
+// mergeUpdate folds a trace fragment into the stored trace. Steps are appended,
+// token usage is summed and an error anywhere marks the whole trace as failed.
+// The root fragment (no ParentSpanID) owns the trace-level fields and metadata;
+// fragments from downstream services only fill them in until the root arrives.
+// Tags from every fragment are kept.
+func mergeUpdate(trace model.Trace) bson.M {
+	header := bson.M{
+		"agentName":   trace.AgentName,
+		"sessionId":   trace.SessionID,
+		"inputPrompt": trace.InputPrompt,
+		"output":      trace.Output,
+		"createdAt":   trace.CreatedAt,
+	}
+
+	set := bson.M{}
+	setOnInsert := bson.M{}
+	update := bson.M{
+		"$push": bson.M{"substeps": bson.M{"$each": nonNilSteps(trace.SubSteps)}},
+		"$inc": bson.M{
+			"tokenUsage.inputTokens":  trace.TokenUsage.Input,
+			"tokenUsage.outputTokens": trace.TokenUsage.Output,
+			"tokenUsage.total":        trace.TokenUsage.Total,
+		},
+		"$max":      bson.M{"latencyMs": trace.LatencyMS},
+		"$addToSet": bson.M{"services": bson.M{"$each": []string{trace.AgentName}}},
+	}
+	if len(trace.Tags) > 0 {
+		update["$addToSet"].(bson.M)["tags"] = bson.M{"$each": trace.Tags}
+	}
+	if keys := fragmentKeys(trace); len(keys) > 0 {
+		update["$addToSet"].(bson.M)["fragments"] = bson.M{"$each": keys}
+	}
+	for k, v := range trace.Metadata {
+		header["metadata."+k] = v
+	}
+	if trace.ReplayOf != "" {
+		header["replayOf"] = trace.ReplayOf
+	}
+	if trace.SchemaVersion != 0 {
+		header["schemaVersion"] = trace.SchemaVersion
+	}
+	if len(trace.Messages) > 0 {
+		header["messages"] = trace.Messages
+	}
+	if len(trace.ToolCalls) > 0 {
+		header["toolCalls"] = trace.ToolCalls
+	}
+	if trace.ModelParams != nil {
+		header["modelParams"] = trace.ModelParams
+	}
+
+	if trace.ParentSpanID == "" {
+		for k, v := range header {
+			set[k] = v
+		}
+		update["$unset"] = bson.M{"parentSpanId": ""}
+	} else {
+		for k, v := range header {
+			setOnInsert[k] = v
+		}
+		setOnInsert["parentSpanId"] = trace.ParentSpanID
+	}
+
+	if model.IsFailure(trace.Status) {
+		set["status"] = trace.Status
+		set["error"] = trace.Error
+	} else {
+		setOnInsert["status"] = trace.Status
+	}
+
+	if !trace.Timestamp.IsZero() {
+		update["$min"] = bson.M{"timestamp": trace.Timestamp}
+	}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(setOnInsert) > 0 {
+		update["$setOnInsert"] = setOnInsert
+	}
+
+	return update
+}
+
+func nonNilSteps(steps []model.SubStep) []model.SubStep {
+	if steps == nil {
+		return []model.SubStep{}
+	}
+	return steps
+}
+
+func (r *mongoTraceRepository) GetTraces(ctx context.Context, filter TraceFilter) ([]model.Trace, error) {
+	mongoFilter := traceQuery(filter)
+
 	opts := options.Find().SetLimit(filter.Limit).SetSkip(filter.Offset).SetSort(bson.D{{Key: "timestamp", Value: -1}})
 	cursor, err := r.collection.Find(ctx, mongoFilter, opts)
 	if err != nil {
@@ -56,6 +266,100 @@ func (r *mongoTraceRepository) GetTraces(ctx context.Context, filter TraceFilter
 	return results, nil
 }
 
+func (r *mongoTraceRepository) IterateTraces(ctx context.Context, filter TraceFilter, fn func(model.Trace) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetBatchSize(iterateBatchSize)
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		opts.SetSkip(filter.Offset)
+	}
+
+	return r.iterate(ctx, traceQuery(filter), opts, fn)
+}
+
+func (r *mongoTraceRepository) IterateExpired(ctx context.Context, filter ExpiryFilter, fn func(model.Trace) error) error {
+	return r.iterate(ctx, expiryQuery(filter), options.Find().SetBatchSize(iterateBatchSize), fn)
+}
+
+func (r *mongoTraceRepository) iterate(ctx context.Context, query bson.M, opts *options.FindOptions, fn func(model.Trace) error) error {
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var trace model.Trace
+		if err := cursor.Decode(&trace); err != nil {
+			return err
+		}
+		if err := fn(trace); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (r *mongoTraceRepository) ExistingTraceIDs(ctx context.Context, traceIDs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	if len(traceIDs) == 0 {
+		return existing, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"traceId": 1, "_id": 0})
+	cursor, err := r.collection.Find(ctx, bson.M{"traceId": bson.M{"$in": traceIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			TraceID string `bson:"traceId"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		existing[doc.TraceID] = true
+	}
+
+	return existing, cursor.Err()
+}
+
+// traceQuery translates a TraceFilter into a Mongo query document.
+func traceQuery(filter TraceFilter) bson.M {
+	mongoFilter := bson.M{}
+	if filter.AgentName != "" {
+		mongoFilter["agentName"] = filter.AgentName
+	}
+	if filter.SessionID != "" {
+		mongoFilter["sessionId"] = filter.SessionID
+	}
+	if filter.Status != "" {
+		mongoFilter["status"] = filter.Status
+	}
+	if len(filter.Tags) > 0 {
+		mongoFilter["tags"] = bson.M{"$all": filter.Tags}
+	}
+	for key, value := range filter.Metadata {
+		mongoFilter["metadata."+key] = value
+	}
+	if filter.From != nil || filter.To != nil {
+		timeRange := bson.M{}
+		if filter.From != nil {
+			timeRange["$gte"] = *filter.From
+		}
+		if filter.To != nil {
+			timeRange["$lte"] = *filter.To
+		}
+		mongoFilter["timestamp"] = timeRange
+	}
+
+	return mongoFilter
+}
+
 func (r *mongoTraceRepository) GetByID(ctx context.Context, id string) (*model.Trace, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -70,3 +374,90 @@ func (r *mongoTraceRepository) GetByID(ctx context.Context, id string) (*model.T
 
 	return &trace, nil
 }
+
+func (r *mongoTraceRepository) CountExpired(ctx context.Context, filter ExpiryFilter) (int64, error) {
+	return r.collection.CountDocuments(ctx, expiryQuery(filter))
+}
+
+func (r *mongoTraceRepository) DeleteExpired(ctx context.Context, filter ExpiryFilter) (int64, error) {
+	res, err := r.collection.DeleteMany(ctx, expiryQuery(filter))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+// PurgePayloads clears step payloads first, then trace payloads, marking the
+// trace purged only once both are gone. Steps are cleared separately because
+// the all-positional operator fails on documents whose substeps are null.
+func (r *mongoTraceRepository) PurgePayloads(ctx context.Context, filter ExpiryFilter) (int64, error) {
+	filter.WithPayloads = true
+
+	steps := expiryQuery(filter)
+	steps["substeps"] = bson.M{"$type": "array"}
+	_, err := r.collection.UpdateMany(ctx, steps, bson.M{
+		"$set": bson.M{
+			"substeps.$[].input":  "",
+			"substeps.$[].output": "",
+		},
+		"$unset": bson.M{
+			"substeps.$[].messages":  "",
+			"substeps.$[].toolCalls": "",
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.collection.UpdateMany(ctx, expiryQuery(filter), bson.M{
+		"$set": bson.M{
+			"inputPrompt":    "",
+			"output":         "",
+			"payloadsPurged": true,
+		},
+		"$unset": bson.M{
+			"messages":  "",
+			"toolCalls": "",
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+func (r *mongoTraceRepository) DeleteByIDs(ctx context.Context, ids []string) (int64, error) {
+	objIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return 0, err
+		}
+		objIDs = append(objIDs, objID)
+	}
+
+	res, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+func expiryQuery(filter ExpiryFilter) bson.M {
+	query := bson.M{"timestamp": bson.M{"$lt": filter.Before}}
+
+	switch {
+	case filter.AgentName != "":
+		query["agentName"] = filter.AgentName
+	case len(filter.ExcludeAgents) > 0:
+		query["agentName"] = bson.M{"$nin": filter.ExcludeAgents}
+	}
+	if filter.WithPayloads {
+		query["payloadsPurged"] = bson.M{"$ne": true}
+	}
+
+	return query
+}
@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/blob"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// blobRefPrefix marks a payload stored in the blob store. The rest of the
+// value is the object key.
+const blobRefPrefix = "blob:v1:"
+
+// payloadKeyPrefix is where offloaded payloads live in the blob store.
+const payloadKeyPrefix = "payloads/"
+
+// payloadKey matches the keys of offloaded payloads. References to any other
+// key are never resolved, so a reference cannot expose e.g. an archive.
+var payloadKey = regexp.MustCompile(`^payloads/[0-9a-f]{64}$`)
+
+// IsBlobRef reports whether value is a reference to an offloaded payload.
+func IsBlobRef(value string) bool {
+	return strings.HasPrefix(value, blobRefPrefix)
+}
+
+type metadataOnlyKey struct{}
+
+// MetadataOnly returns a context under which reads skip loading offloaded
+// payloads, leaving their references in place.
+func MetadataOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, metadataOnlyKey{}, true)
+}
+
+func isMetadataOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(metadataOnlyKey{}).(bool)
+	return only
+}
+
+// offloadedTraceRepository moves prompts, outputs, message contents and tool
+// call arguments and results larger than threshold bytes to a blob store,
+// keeping a reference in their place, so
+// documents stay well below MongoDB's 16MB limit. Reads load them back.
+// Objects are keyed by the trace's ID and the payload's position in it:
+// rewriting a trace, e.g. when a write is retried or replayed, overwrites its
+// objects, and no object is shared between traces, so objects are deleted
+// with the traces or payloads referencing them.
+type offloadedTraceRepository struct {
+	TraceRepository
+	store     blob.Store
+	threshold int
+}
+
+func NewOffloadedTraceRepository(inner TraceRepository, store blob.Store, threshold int) TraceRepository {
+	return &offloadedTraceRepository{
+		TraceRepository: inner,
+		store:           store,
+		threshold:       threshold,
+	}
+}
+
+func (r *offloadedTraceRepository) InsertTrace(ctx context.Context, trace model.Trace) error {
+	stored, err := r.offload(ctx, trace)
+	if err != nil {
+		return err
+	}
+
+	return r.TraceRepository.InsertTrace(ctx, stored)
+}
+
+func (r *offloadedTraceRepository) InsertTraces(ctx context.Context, traces []model.Trace) error {
+	stored, err := r.offloadAll(ctx, traces)
+	if err != nil {
+		return err
+	}
+
+	return r.TraceRepository.InsertTraces(ctx, stored)
+}
+
+func (r *offloadedTraceRepository) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	stored, err := r.offloadAll(ctx, traces)
+	if err != nil {
+		return err
+	}
+
+	return r.TraceRepository.MergeTraces(ctx, stored)
+}
+
+func (r *offloadedTraceRepository) GetTraces(ctx context.Context, filter TraceFilter) ([]model.Trace, error) {
+	traces, err := r.TraceRepository.GetTraces(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range traces {
+		if traces[i], err = r.load(ctx, traces[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return traces, nil
+}
+
+func (r *offloadedTraceRepository) IterateTraces(ctx context.Context, filter TraceFilter, fn func(model.Trace) error) error {
+	return r.TraceRepository.IterateTraces(ctx, filter, func(trace model.Trace) error {
+		loaded, err := r.load(ctx, trace)
+		if err != nil {
+			return err
+		}
+		return fn(loaded)
+	})
+}
+
+func (r *offloadedTraceRepository) GetByID(ctx context.Context, id string) (*model.Trace, error) {
+	trace, err := r.TraceRepository.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := r.load(ctx, *trace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loaded, nil
+}
+
+func (r *offloadedTraceRepository) DeleteExpired(ctx context.Context, filter ExpiryFilter) (int64, error) {
+	keys, err := r.expiredKeys(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := r.TraceRepository.DeleteExpired(ctx, filter)
+	if err != nil {
+		return deleted, err
+	}
+
+	return deleted, r.deleteObjects(ctx, keys)
+}
+
+func (r *offloadedTraceRepository) PurgePayloads(ctx context.Context, filter ExpiryFilter) (int64, error) {
+	filter.WithPayloads = true
+	keys, err := r.expiredKeys(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := r.TraceRepository.PurgePayloads(ctx, filter)
+	if err != nil {
+		return purged, err
+	}
+
+	return purged, r.deleteObjects(ctx, keys)
+}
+
+// expiredKeys returns the objects referenced by the traces matching filter.
+func (r *offloadedTraceRepository) expiredKeys(ctx context.Context, filter ExpiryFilter) ([]string, error) {
+	var keys []string
+	err := r.TraceRepository.IterateExpired(ctx, filter, func(trace model.Trace) error {
+		_, err := transformPayloads(trace, payloadCodec{
+			text: func(value string) (string, error) {
+				if key, ok := refKey(value); ok {
+					keys = append(keys, key)
+				}
+				return value, nil
+			},
+			raw: func(value json.RawMessage) (json.RawMessage, error) {
+				if key, ok := rawRefKey(value); ok {
+					keys = append(keys, key)
+				}
+				return value, nil
+			},
+		})
+		return err
+	})
+
+	return keys, err
+}
+
+// deleteObjects deletes the objects of traces that are gone. It is called
+// once the traces are deleted, so a failure leaves orphaned objects rather
+// than references to missing ones.
+func (r *offloadedTraceRepository) deleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := r.store.Delete(ctx, key); err != nil && !errors.Is(err, blob.ErrNotFound) {
+			return fmt.Errorf("delete offloaded payload %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *offloadedTraceRepository) offloadAll(ctx context.Context, traces []model.Trace) ([]model.Trace, error) {
+	stored := make([]model.Trace, len(traces))
+	for i, trace := range traces {
+		var err error
+		if stored[i], err = r.offload(ctx, trace); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+// offload returns a copy of trace with its large payloads replaced by
+// references to blob objects. A trace without an ID is given one to key its
+// objects. Values that look like references are offloaded whatever their
+// size, so a reference read back always points to an object this repository
+// wrote. Tool call arguments and results are JSON, so their references are
+// stored as JSON strings.
+func (r *offloadedTraceRepository) offload(ctx context.Context, trace model.Trace) (model.Trace, error) {
+	if trace.ID.IsZero() {
+		trace.ID = primitive.NewObjectID()
+	}
+
+	texts, raws := 0, 0
+	return transformPayloads(trace, payloadCodec{
+		text: func(value string) (string, error) {
+			texts++
+			if len(value) <= r.threshold && !IsBlobRef(value) {
+				return value, nil
+			}
+			return r.put(ctx, trace.ID.Hex()+"/"+strconv.Itoa(texts), value)
+		},
+		raw: func(value json.RawMessage) (json.RawMessage, error) {
+			raws++
+			if _, isRef := rawRef(value); len(value) <= r.threshold && !isRef {
+				return value, nil
+			}
+			ref, err := r.put(ctx, trace.ID.Hex()+"/tool/"+strconv.Itoa(raws), string(value))
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(ref)
+		},
+	})
+}
+
+// put stores payload under a key derived from position and returns its
+// reference.
+func (r *offloadedTraceRepository) put(ctx context.Context, position, payload string) (string, error) {
+	sum := sha256.Sum256([]byte(position))
+	key := payloadKeyPrefix + hex.EncodeToString(sum[:])
+	if err := r.store.Put(ctx, key, strings.NewReader(payload)); err != nil {
+		return "", err
+	}
+	return blobRefPrefix + key, nil
+}
+
+// load returns a copy of trace with its offloaded payloads read back, unless
+// ctx asks for metadata only. A missing object fails the read.
+func (r *offloadedTraceRepository) load(ctx context.Context, trace model.Trace) (model.Trace, error) {
+	if isMetadataOnly(ctx) {
+		return trace, nil
+	}
+
+	loaded := map[string]string{}
+	fetch := func(key string) (string, error) {
+		if payload, ok := loaded[key]; ok {
+			return payload, nil
+		}
+		payload, err := r.get(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("load offloaded payload %s: %w", key, err)
+		}
+		loaded[key] = payload
+		return payload, nil
+	}
+
+	return transformPayloads(trace, payloadCodec{
+		text: func(value string) (string, error) {
+			key, ok := refKey(value)
+			if !ok {
+				return value, nil
+			}
+			return fetch(key)
+		},
+		raw: func(value json.RawMessage) (json.RawMessage, error) {
+			key, ok := rawRefKey(value)
+			if !ok {
+				return value, nil
+			}
+			payload, err := fetch(key)
+			return json.RawMessage(payload), err
+		},
+	})
+}
+
+// refKey returns the object key of a reference to an offloaded payload.
+func refKey(value string) (string, bool) {
+	key, ok := strings.CutPrefix(value, blobRefPrefix)
+	if !ok || !payloadKey.MatchString(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// rawRefKey returns the object key of a reference to an offloaded JSON
+// payload.
+func rawRefKey(value json.RawMessage) (string, bool) {
+	ref, ok := rawRef(value)
+	if !ok {
+		return "", false
+	}
+	return refKey(ref)
+}
+
+// rawRef returns value as a reference if it is a JSON string that looks like
+// one.
+func rawRef(value json.RawMessage) (string, bool) {
+	var ref string
+	if len(value) == 0 || value[0] != '"' || json.Unmarshal(value, &ref) != nil {
+		return "", false
+	}
+	return ref, IsBlobRef(ref)
+}
+
+func (r *offloadedTraceRepository) get(ctx context.Context, key string) (string, error) {
+	rc, err := r.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	payload, err := io.ReadAll(rc)
+	return string(payload), err
+}
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/blob"
+	"github.com/zkropotkine/agent-trace/internal/encryption"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func newTestStore(t *testing.T) blob.Store {
+	store, err := blob.NewFSStore(t.TempDir())
+	require.NoError(t, err)
+	return store
+}
+
+func payloadKeys(t *testing.T, store blob.Store) []string {
+	keys, err := store.List(context.Background(), payloadKeyPrefix)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestOffloadedTraceRepository(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewOffloadedTraceRepository(inner, store, 16)
+
+	document := strings.Repeat("lorem ipsum ", 10)
+	trace := model.Trace{
+		ID:          primitive.NewObjectID(),
+		TraceID:     "t1",
+		AgentName:   "DocumentAgent",
+		InputPrompt: "Summarize this.",
+		Output:      document,
+		Messages:    []model.Message{{Role: "user", Content: document}},
+		SubSteps: []model.SubStep{
+			{Name: "Loader", Input: "doc.pdf", Output: document + "page 2"},
+		},
+	}
+	require.NoError(t, repo.InsertTrace(context.Background(), trace))
+
+	t.Run("stores large payloads as references", func(t *testing.T) {
+		stored := inner.traces[0]
+		assert.Equal(t, "Summarize this.", stored.InputPrompt)
+		assert.Equal(t, "doc.pdf", stored.SubSteps[0].Input)
+		assert.True(t, IsBlobRef(stored.Output))
+		assert.True(t, IsBlobRef(stored.Messages[0].Content))
+		assert.True(t, IsBlobRef(stored.SubSteps[0].Output))
+		assert.Len(t, payloadKeys(t, store), 3)
+	})
+
+	t.Run("does not modify the caller's trace", func(t *testing.T) {
+		assert.Equal(t, document, trace.Messages[0].Content)
+		assert.Equal(t, document+"page 2", trace.SubSteps[0].Output)
+	})
+
+	t.Run("loads payloads on GetByID", func(t *testing.T) {
+		got, err := repo.GetByID(context.Background(), "t1")
+		require.NoError(t, err)
+		assert.Equal(t, trace, *got)
+	})
+
+	t.Run("loads payloads on GetTraces", func(t *testing.T) {
+		got, err := repo.GetTraces(context.Background(), TraceFilter{})
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, trace, got[0])
+	})
+
+	t.Run("keeps references when reading metadata only", func(t *testing.T) {
+		got, err := repo.GetByID(MetadataOnly(context.Background()), "t1")
+		require.NoError(t, err)
+		assert.True(t, IsBlobRef(got.Output))
+		assert.Equal(t, "DocumentAgent", got.AgentName)
+	})
+
+	t.Run("a retried write overwrites its objects", func(t *testing.T) {
+		require.NoError(t, repo.InsertTraces(context.Background(), []model.Trace{trace}))
+
+		assert.Len(t, payloadKeys(t, store), 3)
+		assert.Equal(t, inner.traces[0], inner.traces[1])
+		inner.traces = inner.traces[:1]
+	})
+}
+
+func TestOffloadedTraceRepository_ObjectsAreNotShared(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewOffloadedTraceRepository(inner, store, 16)
+
+	document := strings.Repeat("lorem ipsum ", 10)
+	require.NoError(t, repo.InsertTraces(context.Background(), []model.Trace{
+		{TraceID: "t1", Output: document},
+		{TraceID: "t2", Output: document},
+	}))
+
+	assert.NotEqual(t, inner.traces[0].Output, inner.traces[1].Output)
+	assert.Len(t, payloadKeys(t, store), 2)
+}
+
+func TestOffloadedTraceRepository_References(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewOffloadedTraceRepository(inner, store, 1024)
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "archive/2025/05/01/a.jsonl.gz", strings.NewReader("archived traces")))
+
+	t.Run("references sent by clients are stored as payloads", func(t *testing.T) {
+		forged := blobRefPrefix + "archive/2025/05/01/a.jsonl.gz"
+		require.NoError(t, repo.InsertTrace(ctx, model.Trace{TraceID: "t1", Output: forged}))
+
+		stored := inner.traces[0].Output
+		assert.NotEqual(t, forged, stored)
+		got, err := repo.GetByID(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, forged, got.Output)
+	})
+
+	t.Run("references outside payloads are never resolved", func(t *testing.T) {
+		forged := blobRefPrefix + "archive/2025/05/01/a.jsonl.gz"
+		inner.traces = append(inner.traces, model.Trace{TraceID: "t2", Output: forged})
+
+		got, err := repo.GetByID(ctx, "t2")
+		require.NoError(t, err)
+		assert.Equal(t, forged, got.Output)
+	})
+
+	t.Run("missing objects fail the read", func(t *testing.T) {
+		require.NoError(t, repo.InsertTrace(ctx, model.Trace{TraceID: "t3", Output: strings.Repeat("x", 2048)}))
+		key, ok := refKey(inner.traces[2].Output)
+		require.True(t, ok)
+		require.NoError(t, store.Delete(ctx, key))
+
+		_, err := repo.GetByID(ctx, "t3")
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+	})
+}
+
+func TestOffloadedTraceRepository_ToolCalls(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewOffloadedTraceRepository(inner, store, 64)
+	ctx := context.Background()
+
+	result := json.RawMessage(`{"hits":["` + strings.Repeat("lorem ipsum ", 10) + `"]}`)
+	forged := json.RawMessage(`"` + blobRefPrefix + `archive/2025/05/01/a.jsonl.gz"`)
+	trace := model.Trace{
+		TraceID:   "t1",
+		ToolCalls: []model.ToolCall{{Name: "search", Arguments: json.RawMessage(`{"q":"docs"}`), Result: result}},
+		SubSteps: []model.SubStep{
+			{Name: "Search", ToolCalls: []model.ToolCall{{Name: "fetch", Arguments: forged, Result: result}}},
+		},
+	}
+	require.NoError(t, repo.InsertTrace(ctx, trace))
+
+	stored := inner.traces[0]
+	assert.JSONEq(t, `{"q":"docs"}`, string(stored.ToolCalls[0].Arguments))
+	for _, raw := range []json.RawMessage{stored.ToolCalls[0].Result, stored.SubSteps[0].ToolCalls[0].Arguments, stored.SubSteps[0].ToolCalls[0].Result} {
+		_, ok := rawRefKey(raw)
+		assert.True(t, ok, "%s is stored as a reference", raw)
+	}
+	assert.Len(t, payloadKeys(t, store), 3)
+
+	got, err := repo.GetByID(ctx, "t1")
+	require.NoError(t, err)
+	trace.ID = stored.ID
+	assert.Equal(t, trace, *got)
+
+	deleted, err := repo.DeleteExpired(ctx, ExpiryFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Empty(t, payloadKeys(t, store))
+}
+
+func TestOffloadedTraceRepository_DeletesObjectsWithTraces(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewOffloadedTraceRepository(inner, store, 16)
+	ctx := context.Background()
+
+	document := strings.Repeat("lorem ipsum ", 10)
+	require.NoError(t, repo.InsertTrace(ctx, model.Trace{TraceID: "t1", Output: document, SubSteps: []model.SubStep{{Input: document}}}))
+	require.Len(t, payloadKeys(t, store), 2)
+
+	purged, err := repo.PurgePayloads(ctx, ExpiryFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Empty(t, payloadKeys(t, store), "purged payloads are deleted")
+
+	require.NoError(t, repo.InsertTrace(ctx, model.Trace{TraceID: "t2", Output: document}))
+	inner.traces = inner.traces[1:]
+	require.Len(t, payloadKeys(t, store), 1)
+
+	deleted, err := repo.DeleteExpired(ctx, ExpiryFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Empty(t, payloadKeys(t, store), "deleted traces take their payloads with them")
+}
+
+func TestOffloadedTraceRepository_StoresEncryptedPayloads(t *testing.T) {
+	inner := &memoryTraceRepo{}
+	store := newTestStore(t)
+	repo := NewEncryptedTraceRepository(NewOffloadedTraceRepository(inner, store, 64), newTestKeyring(t))
+
+	trace := model.Trace{TraceID: "t1", InputPrompt: "a secret document"}
+	require.NoError(t, repo.InsertTrace(context.Background(), trace))
+
+	stored := inner.traces[0]
+	require.True(t, IsBlobRef(stored.InputPrompt), "ciphertext is over the threshold")
+	rc, err := store.Get(context.Background(), strings.TrimPrefix(stored.InputPrompt, blobRefPrefix))
+	require.NoError(t, err)
+	defer rc.Close()
+	object, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(string(object)))
+
+	got, err := repo.GetByID(context.Background(), "t1")
+	require.NoError(t, err)
+	assert.Equal(t, "a secret document", got.InputPrompt)
+}
@@ -2,22 +2,104 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"time"
 
+	"go.mongodb.org/mongo-driver/mongo"
+
 	"github.com/zkropotkine/agent-trace/internal/model"
 )
 
+// ErrDuplicateTrace is returned when inserting a trace whose ID already exists.
+var ErrDuplicateTrace = errors.New("trace already exists")
+
+// DuplicateError is returned by InsertTraces when some of the traces already
+// exist. Inserts are unordered, so every other trace was stored. It matches
+// ErrDuplicateTrace.
+type DuplicateError struct {
+	// Indexes are the positions of the duplicates in the inserted slice.
+	Indexes []int
+	cause   error
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrDuplicateTrace, e.cause)
+}
+
+func (e *DuplicateError) Unwrap() error { return ErrDuplicateTrace }
+
+// Without returns traces minus the duplicates, i.e. the traces stored.
+func (e *DuplicateError) Without(traces []model.Trace) []model.Trace {
+	duplicate := make(map[int]bool, len(e.Indexes))
+	for _, i := range e.Indexes {
+		duplicate[i] = true
+	}
+
+	stored := make([]model.Trace, 0, len(traces))
+	for i, trace := range traces {
+		if !duplicate[i] {
+			stored = append(stored, trace)
+		}
+	}
+
+	return stored
+}
+
+// IsTransient reports whether err is an outage that retrying the same write
+// later can get past: a network failure or timeout reaching MongoDB or the
+// blob store, or a cancelled context. Other errors, such as a document the
+// server rejects, fail every retry.
+func IsTransient(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.Canceled) || mongo.IsTimeout(err) || mongo.IsNetworkError(err) || errors.As(err, &netErr)
+}
+
 // TraceFilter defines filtering and pagination options for querying traces.
 type TraceFilter struct {
 	AgentName string
+	SessionID string
+	Status    string
 	From      *time.Time
 	To        *time.Time
-	Limit     int64
-	Offset    int64
+	// Tags selects traces carrying every listed tag.
+	Tags []string
+	// Metadata selects traces whose metadata has all the given key/value pairs.
+	Metadata map[string]string
+	Limit    int64
+	Offset   int64
+}
+
+// ExpiryFilter selects traces recorded before a cutoff, used to enforce retention.
+type ExpiryFilter struct {
+	Before        time.Time
+	AgentName     string
+	ExcludeAgents []string
+	// WithPayloads restricts the selection to traces whose payloads have not been purged yet.
+	WithPayloads bool
 }
 
 type TraceRepository interface {
 	InsertTrace(ctx context.Context, trace model.Trace) error
+	InsertTraces(ctx context.Context, traces []model.Trace) error
+	// MergeTraces stores each trace as a fragment of the document with the same
+	// trace_id, creating it if needed, so spans from several services end up in
+	// one trace.
+	MergeTraces(ctx context.Context, traces []model.Trace) error
 	GetTraces(ctx context.Context, filter TraceFilter) ([]model.Trace, error)
+	// IterateTraces calls fn for each trace matching filter, newest first,
+	// reading from a cursor so large result sets are never held in memory. A
+	// zero Limit means no limit. Iteration stops at the first error from fn.
+	IterateTraces(ctx context.Context, filter TraceFilter, fn func(model.Trace) error) error
 	GetByID(ctx context.Context, id string) (*model.Trace, error)
+	// ExistingTraceIDs reports which of traceIDs are already stored.
+	ExistingTraceIDs(ctx context.Context, traceIDs []string) (map[string]bool, error)
+	CountExpired(ctx context.Context, filter ExpiryFilter) (int64, error)
+	// IterateExpired calls fn for each trace matching filter as stored, e.g.
+	// to release what it references before it is deleted or purged.
+	IterateExpired(ctx context.Context, filter ExpiryFilter, fn func(model.Trace) error) error
+	DeleteExpired(ctx context.Context, filter ExpiryFilter) (int64, error)
+	PurgePayloads(ctx context.Context, filter ExpiryFilter) (int64, error)
+	DeleteByIDs(ctx context.Context, ids []string) (int64, error)
 }
@@ -2,6 +2,9 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
@@ -10,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zkropotkine/agent-trace/internal/model"
 )
 
@@ -65,6 +69,272 @@ func TestMongoTraceRepository_InsertTrace(t *testing.T) {
 
 		assert.Error(t, err, "Expected an error but got none")
 		assert.Contains(t, err.Error(), "duplicate key error")
+		assert.ErrorIs(t, err, ErrDuplicateTrace)
+	})
+}
+
+func TestMongoTraceRepository_InsertTraces(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("successful insert", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(2)}))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+		err := repo.InsertTraces(context.Background(), []model.Trace{{TraceID: "a"}, {TraceID: "b"}})
+
+		assert.NoError(t, err)
+	})
+
+	mt.Run("empty batch is a no-op", func(mt *mtest.T) {
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.NoError(t, repo.InsertTraces(context.Background(), nil))
+	})
+
+	mt.Run("duplicate key", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+		err := repo.InsertTraces(context.Background(), []model.Trace{{TraceID: "a"}})
+
+		assert.ErrorIs(t, err, ErrDuplicateTrace)
+	})
+
+	mt.Run("duplicates do not stop the rest of the batch", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   1,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+		batch := []model.Trace{{TraceID: "a"}, {TraceID: "b"}, {TraceID: "c"}}
+		err := repo.InsertTraces(context.Background(), batch)
+
+		assert.False(t, mt.GetStartedEvent().Command.Lookup("ordered").Boolean())
+		var dup *DuplicateError
+		require.ErrorAs(t, err, &dup)
+		assert.Equal(t, []int{1}, dup.Indexes)
+		assert.Equal(t, []model.Trace{{TraceID: "a"}, {TraceID: "c"}}, dup.Without(batch))
+	})
+
+	mt.Run("other write errors fail the batch", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(
+			mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"},
+			mtest.WriteError{Index: 1, Code: 2, Message: "bad value"},
+		))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+		err := repo.InsertTraces(context.Background(), []model.Trace{{TraceID: "a"}, {TraceID: "b"}})
+
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrDuplicateTrace)
+	})
+}
+
+func TestMongoTraceRepository_MergeTraces(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("upserts fragments", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: int32(2)},
+			bson.E{Key: "nModified", Value: int32(1)},
+		))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+		err := repo.MergeTraces(context.Background(), []model.Trace{{TraceID: "a"}, {TraceID: "a", ParentSpanID: "00f067aa0ba902b7"}})
+
+		assert.NoError(t, err)
+	})
+
+	mt.Run("empty batch is a no-op", func(mt *mtest.T) {
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.NoError(t, repo.MergeTraces(context.Background(), nil))
+	})
+
+	mt.Run("write error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "boom"}))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.Error(t, repo.MergeTraces(context.Background(), []model.Trace{{TraceID: "a"}}))
+	})
+
+	duplicate := mtest.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}
+	fragments := []model.Trace{
+		{TraceID: "a", SubSteps: []model.SubStep{{SpanID: "b7ad6b7169203331"}}},
+		{TraceID: "a", SubSteps: []model.SubStep{{SpanID: "00f067aa0ba902b7"}}},
+	}
+
+	mt.Run("retries a fragment that lost the race to create the trace", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(duplicate),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(1)}),
+		)
+
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.NoError(t, repo.MergeTraces(context.Background(), fragments))
+		mt.GetStartedEvent()
+		retry := mt.GetStartedEvent().Command.Lookup("updates").Array()
+		values, err := retry.Values()
+		assert.NoError(t, err)
+		assert.Len(t, values, 1)
+		assert.Equal(t, "span:00f067aa0ba902b7",
+			values[0].Document().Lookup("q", "fragments", "$nin").Array().Index(0).Value().StringValue())
+	})
+
+	mt.Run("skips a fragment already applied", func(mt *mtest.T) {
+		duplicate := duplicate
+		duplicate.Index = 0
+		mt.AddMockResponses(
+			mtest.CreateWriteErrorsResponse(duplicate),
+			mtest.CreateWriteErrorsResponse(duplicate),
+		)
+
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.NoError(t, repo.MergeTraces(context.Background(), fragments[:1]))
+	})
+
+	mt.Run("fails on other write errors", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 2, Message: "bad value"}))
+
+		repo := NewMongoTraceRepository(mt.Coll)
+
+		assert.Error(t, repo.MergeTraces(context.Background(), fragments))
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(fmt.Errorf("server selection: %w", context.DeadlineExceeded)))
+	assert.True(t, IsTransient(context.Canceled))
+	assert.True(t, IsTransient(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.False(t, IsTransient(errors.New("document too large")))
+	assert.False(t, IsTransient(nil))
+}
+
+func TestMergeFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"traceId": "a"}, mergeFilter(model.Trace{TraceID: "a", SubSteps: []model.SubStep{{Name: "plain"}}}))
+	assert.Equal(t, bson.M{
+		"traceId":   "a",
+		"fragments": bson.M{"$nin": []string{"span:b7ad6b7169203331"}},
+	}, mergeFilter(model.Trace{TraceID: "a", SubSteps: []model.SubStep{{SpanID: "b7ad6b7169203331"}, {Name: "plain"}}}))
+
+	id, _ := primitive.ObjectIDFromHex("64b0c2f4e13c0000aa000000")
+	assert.Equal(t, bson.M{
+		"traceId":   "a",
+		"fragments": bson.M{"$nin": []string{"id:64b0c2f4e13c0000aa000000"}},
+	}, mergeFilter(model.Trace{ID: id, TraceID: "a"}), "a replayed fragment keeps its ingestion ID")
+}
+
+func TestMergeUpdate(t *testing.T) {
+	ts := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	steps := []model.SubStep{{Name: "Tool", SpanID: "b7ad6b7169203331", ParentID: "00f067aa0ba902b7"}}
+
+	t.Run("remote fragment only fills trace fields on insert", func(t *testing.T) {
+		update := mergeUpdate(model.Trace{
+			TraceID:      "a",
+			AgentName:    "ToolService",
+			Status:       "success",
+			LatencyMS:    40,
+			TokenUsage:   model.TokenUsage{Input: 3, Output: 2, Total: 5},
+			SubSteps:     steps,
+			Timestamp:    ts,
+			ParentSpanID: "00f067aa0ba902b7",
+		})
+
+		assert.NotContains(t, update, "$set")
+		assert.NotContains(t, update, "$unset")
+		onInsert := update["$setOnInsert"].(bson.M)
+		assert.Equal(t, "ToolService", onInsert["agentName"])
+		assert.Equal(t, "success", onInsert["status"])
+		assert.Equal(t, "00f067aa0ba902b7", onInsert["parentSpanId"])
+		assert.Equal(t, bson.M{"substeps": bson.M{"$each": steps}}, update["$push"])
+		assert.Equal(t, bson.M{
+			"tokenUsage.inputTokens":  3,
+			"tokenUsage.outputTokens": 2,
+			"tokenUsage.total":        5,
+		}, update["$inc"])
+		assert.Equal(t, bson.M{"latencyMs": 40}, update["$max"])
+		assert.Equal(t, bson.M{"timestamp": ts}, update["$min"])
+		assert.Equal(t, bson.M{
+			"services":  bson.M{"$each": []string{"ToolService"}},
+			"fragments": bson.M{"$each": []string{"span:b7ad6b7169203331"}},
+		}, update["$addToSet"])
+	})
+
+	t.Run("any failure status of a fragment fails the trace", func(t *testing.T) {
+		for _, status := range []string{"error", "failed", "failure"} {
+			update := mergeUpdate(model.Trace{Status: status, Error: "timeout", ParentSpanID: "00f067aa0ba902b7"})
+			assert.Equal(t, status, update["$set"].(bson.M)["status"])
+			assert.Equal(t, "timeout", update["$set"].(bson.M)["error"])
+		}
+	})
+
+	t.Run("tags accumulate and metadata follows trace field ownership", func(t *testing.T) {
+		remote := mergeUpdate(model.Trace{ParentSpanID: "00f067aa0ba902b7", Tags: []string{"tool"}, Metadata: map[string]string{"region": "us"}})
+		assert.Equal(t, bson.M{"$each": []string{"tool"}}, remote["$addToSet"].(bson.M)["tags"])
+		assert.Equal(t, "us", remote["$setOnInsert"].(bson.M)["metadata.region"])
+
+		root := mergeUpdate(model.Trace{Metadata: map[string]string{"region": "eu"}})
+		assert.NotContains(t, root["$addToSet"], "tags")
+		assert.Equal(t, "eu", root["$set"].(bson.M)["metadata.region"])
+	})
+
+	t.Run("root fragment owns trace fields and errors always win", func(t *testing.T) {
+		update := mergeUpdate(model.Trace{
+			TraceID:     "a",
+			AgentName:   "Planner",
+			InputPrompt: "plan",
+			Status:      "error",
+			Error:       "boom",
+		})
+
+		set := update["$set"].(bson.M)
+		assert.Equal(t, "Planner", set["agentName"])
+		assert.Equal(t, "plan", set["inputPrompt"])
+		assert.Equal(t, "error", set["status"])
+		assert.Equal(t, "boom", set["error"])
+		assert.Equal(t, bson.M{"parentSpanId": ""}, update["$unset"])
+		assert.NotContains(t, update, "$setOnInsert")
+		assert.NotContains(t, update, "$min")
+		assert.Equal(t, bson.M{"substeps": bson.M{"$each": []model.SubStep{}}}, update["$push"])
+	})
+
+	t.Run("schema version is recorded on the merge path", func(t *testing.T) {
+		root := mergeUpdate(model.Trace{SchemaVersion: 2})
+		assert.Equal(t, 2, root["$set"].(bson.M)["schemaVersion"])
+
+		remote := mergeUpdate(model.Trace{SchemaVersion: 2, ParentSpanID: "00f067aa0ba902b7"})
+		assert.Equal(t, 2, remote["$setOnInsert"].(bson.M)["schemaVersion"])
+	})
+
+	t.Run("root fragment sets its LLM call", func(t *testing.T) {
+		temperature := 0.2
+		messages := []model.Message{{Role: "user", Content: "plan"}}
+		toolCalls := []model.ToolCall{{ID: "call_1", Name: "search"}}
+		params := &model.ModelParams{Model: "gpt-4o", Temperature: &temperature}
+
+		root := mergeUpdate(model.Trace{Messages: messages, ToolCalls: toolCalls, ModelParams: params})
+		set := root["$set"].(bson.M)
+		assert.Equal(t, messages, set["messages"])
+		assert.Equal(t, toolCalls, set["toolCalls"])
+		assert.Equal(t, params, set["modelParams"])
+
+		remote := mergeUpdate(model.Trace{ParentSpanID: "00f067aa0ba902b7", Messages: messages})
+		assert.Equal(t, messages, remote["$setOnInsert"].(bson.M)["messages"])
+
+		empty := mergeUpdate(model.Trace{})
+		assert.NotContains(t, empty["$set"], "schemaVersion")
+		assert.NotContains(t, empty["$set"], "messages")
+		assert.NotContains(t, empty["$set"], "toolCalls")
+		assert.NotContains(t, empty["$set"], "modelParams")
 	})
 }
 
@@ -140,3 +410,235 @@ func TestMongoTraceRepository_GetTraces(t *testing.T) {
 		assert.Equal(t, "1", res[0].TraceID)
 	})
 }
+
+func TestMongoTraceRepository_IterateTraces(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("reads every batch", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch, bson.D{{Key: "traceId", Value: "1"}}),
+			mtest.CreateCursorResponse(1, ns, mtest.NextBatch, bson.D{{Key: "traceId", Value: "2"}}),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch),
+		)
+
+		var ids []string
+		err := r.IterateTraces(context.Background(), TraceFilter{}, func(trace model.Trace) error {
+			ids = append(ids, trace.TraceID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"1", "2"}, ids)
+	})
+
+	mt.Run("stops on callback error", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+			bson.D{{Key: "traceId", Value: "1"}},
+			bson.D{{Key: "traceId", Value: "2"}},
+		))
+
+		calls := 0
+		stop := errors.New("client gone")
+		err := r.IterateTraces(context.Background(), TraceFilter{}, func(model.Trace) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestMongoTraceRepository_ExistingTraceIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("returns stored ids", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "traceId", Value: "a"}}))
+
+		existing, err := r.ExistingTraceIDs(context.Background(), []string{"a", "b"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]bool{"a": true}, existing)
+	})
+
+	mt.Run("skips the query for no ids", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		existing, err := r.ExistingTraceIDs(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Empty(t, existing)
+	})
+}
+
+func TestTraceQuery(t *testing.T) {
+	from := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bson.M{}, traceQuery(TraceFilter{}))
+	assert.Equal(t, bson.M{
+		"agentName":       "A",
+		"tags":            bson.M{"$all": []string{"beta", "eu"}},
+		"metadata.region": "eu",
+		"metadata.tier":   "gold",
+		"timestamp":       bson.M{"$gte": from},
+	}, traceQuery(TraceFilter{
+		AgentName: "A",
+		Tags:      []string{"beta", "eu"},
+		Metadata:  map[string]string{"region": "eu", "tier": "gold"},
+		From:      &from,
+	}))
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("creates trace id, tag and metadata indexes", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		assert.NoError(t, EnsureIndexes(context.Background(), mt.Coll, false))
+
+		cmd := mt.GetStartedEvent().Command
+		indexes := cmd.Lookup("indexes").Array()
+		values, err := indexes.Values()
+		assert.NoError(t, err)
+		assert.Len(t, values, 3)
+		assert.Equal(t, "traceId_1", values[0].Document().Lookup("name").StringValue())
+		assert.False(t, values[0].Document().Lookup("unique").Boolean())
+		assert.Equal(t, "metadata.$**_1", values[2].Document().Lookup("name").StringValue())
+	})
+
+	mt.Run("trace ids are unique when merging spans", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		assert.NoError(t, EnsureIndexes(context.Background(), mt.Coll, true))
+
+		values, err := mt.GetStartedEvent().Command.Lookup("indexes").Array().Values()
+		assert.NoError(t, err)
+		assert.True(t, values[0].Document().Lookup("unique").Boolean())
+	})
+}
+
+func TestMongoTraceRepository_Retention(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	cutoff := time.Now().UTC().Add(-24 * time.Hour)
+
+	mt.Run("count expired", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "n", Value: int32(7)}}))
+
+		n, err := r.CountExpired(context.Background(), ExpiryFilter{Before: cutoff, AgentName: "A"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(7), n)
+	})
+
+	mt.Run("delete expired", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(3)}))
+
+		n, err := r.DeleteExpired(context.Background(), ExpiryFilter{Before: cutoff, ExcludeAgents: []string{"B"}})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), n)
+	})
+
+	mt.Run("purge payloads", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(1)}, bson.E{Key: "nModified", Value: int32(1)}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(2)}, bson.E{Key: "nModified", Value: int32(2)}),
+		)
+
+		n, err := r.PurgePayloads(context.Background(), ExpiryFilter{Before: cutoff})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+
+		steps := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(t, "array", steps.Lookup("q", "substeps", "$type").StringValue(), "null substeps are skipped")
+		assert.Equal(t, "", steps.Lookup("u", "$set", "substeps.$[].input").StringValue())
+		traces := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		_, err = traces.LookupErr("q", "substeps")
+		assert.Error(t, err, "traces without steps are purged too")
+		assert.True(t, traces.Lookup("u", "$set", "payloadsPurged").Boolean())
+	})
+
+	mt.Run("purge payloads step error", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "boom"}))
+
+		_, err := r.PurgePayloads(context.Background(), ExpiryFilter{Before: cutoff})
+		assert.Error(t, err)
+	})
+
+	mt.Run("iterate expired", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "traceId", Value: "a"}}),
+		)
+
+		var ids []string
+		err := r.IterateExpired(context.Background(), ExpiryFilter{Before: cutoff, WithPayloads: true}, func(trace model.Trace) error {
+			ids = append(ids, trace.TraceID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, ids)
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		assert.True(t, filter.Lookup("payloadsPurged", "$ne").Boolean())
+	})
+
+	mt.Run("delete error", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "boom"}))
+
+		_, err := r.DeleteExpired(context.Background(), ExpiryFilter{Before: cutoff})
+		assert.Error(t, err)
+	})
+}
+
+func TestMongoTraceRepository_DeleteByIDs(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: int32(2)}))
+
+		n, err := r.DeleteByIDs(context.Background(), []string{"64b0c2f4e13c0000aa000000", "64b0c2f4e13c0000aa000001"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+	})
+
+	mt.Run("invalid id", func(mt *mtest.T) {
+		r := NewMongoTraceRepository(mt.Coll)
+
+		_, err := r.DeleteByIDs(context.Background(), []string{"invalid"})
+		assert.EqualError(t, err, "the provided hex string is not a valid ObjectID")
+	})
+}
+
+func TestExpiryQuery(t *testing.T) {
+	cutoff := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bson.M{
+		"timestamp": bson.M{"$lt": cutoff},
+		"agentName": "A",
+	}, expiryQuery(ExpiryFilter{Before: cutoff, AgentName: "A", ExcludeAgents: []string{"B"}}))
+
+	assert.Equal(t, bson.M{
+		"timestamp":      bson.M{"$lt": cutoff},
+		"agentName":      bson.M{"$nin": []string{"B"}},
+		"payloadsPurged": bson.M{"$ne": true},
+	}, expiryQuery(ExpiryFilter{Before: cutoff, ExcludeAgents: []string{"B"}, WithPayloads: true}))
+}
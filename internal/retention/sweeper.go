@@ -0,0 +1,172 @@
+package retention
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+const (
+	ActionDelete        = "delete"
+	ActionPurgePayloads = "purge_payloads"
+
+	ScopeGlobal = "global"
+)
+
+// Policy describes how long traces are kept. A zero duration disables the
+// corresponding rule. AgentMaxAge overrides MaxAge for the listed agents; an
+// override of zero keeps that agent's traces forever.
+type Policy struct {
+	MaxAge        time.Duration
+	AgentMaxAge   map[string]time.Duration
+	PayloadMaxAge time.Duration
+}
+
+// RuleResult reports how many traces a single retention rule matched.
+type RuleResult struct {
+	Scope   string    `json:"scope"`
+	Action  string    `json:"action"`
+	Before  time.Time `json:"before"`
+	Matched int64     `json:"matched"`
+}
+
+// Report summarises a sweep or a dry run.
+type Report struct {
+	DryRun bool         `json:"dry_run"`
+	Rules  []RuleResult `json:"rules"`
+}
+
+// Sweeper enforces a retention Policy against a TraceRepository.
+type Sweeper struct {
+	repo     repository.TraceRepository
+	policy   Policy
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewSweeper(repo repository.TraceRepository, policy Policy, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		repo:     repo,
+		policy:   policy,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Plan reports what a sweep would remove without modifying any data.
+func (s *Sweeper) Plan(ctx context.Context) (Report, error) {
+	report := Report{DryRun: true}
+	for _, r := range s.rules() {
+		matched, err := s.repo.CountExpired(ctx, r.filter)
+		if err != nil {
+			return report, err
+		}
+		report.Rules = append(report.Rules, r.result(matched))
+	}
+
+	return report, nil
+}
+
+// Sweep applies every rule of the policy once.
+func (s *Sweeper) Sweep(ctx context.Context) (Report, error) {
+	var report Report
+	for _, r := range s.rules() {
+		var (
+			matched int64
+			err     error
+		)
+		if r.action == ActionPurgePayloads {
+			matched, err = s.repo.PurgePayloads(ctx, r.filter)
+		} else {
+			matched, err = s.repo.DeleteExpired(ctx, r.filter)
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Rules = append(report.Rules, r.result(matched))
+	}
+
+	return report, nil
+}
+
+// Run sweeps on every interval until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	log := logger.FromContext(ctx)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Sweep(ctx)
+			if err != nil {
+				log.WithError(err).Error("retention sweep failed")
+				continue
+			}
+			for _, r := range report.Rules {
+				if r.Matched > 0 {
+					log.Infof("retention %s (%s): %d traces before %s", r.Action, r.Scope, r.Matched, r.Before.Format(time.RFC3339))
+				}
+			}
+		}
+	}
+}
+
+type rule struct {
+	scope  string
+	action string
+	filter repository.ExpiryFilter
+}
+
+func (r rule) result(matched int64) RuleResult {
+	return RuleResult{Scope: r.scope, Action: r.action, Before: r.filter.Before, Matched: matched}
+}
+
+// rules expands the policy into concrete repository filters relative to now.
+// Payload purging runs before deletion so its counts are not hidden by traces
+// that are about to be deleted anyway.
+func (s *Sweeper) rules() []rule {
+	now := s.now()
+	var rules []rule
+
+	if s.policy.PayloadMaxAge > 0 {
+		rules = append(rules, rule{
+			scope:  ScopeGlobal,
+			action: ActionPurgePayloads,
+			filter: repository.ExpiryFilter{Before: now.Add(-s.policy.PayloadMaxAge), WithPayloads: true},
+		})
+	}
+
+	agents := make([]string, 0, len(s.policy.AgentMaxAge))
+	for agent := range s.policy.AgentMaxAge {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+
+	for _, agent := range agents {
+		maxAge := s.policy.AgentMaxAge[agent]
+		if maxAge <= 0 {
+			continue
+		}
+		rules = append(rules, rule{
+			scope:  "agent:" + agent,
+			action: ActionDelete,
+			filter: repository.ExpiryFilter{Before: now.Add(-maxAge), AgentName: agent},
+		})
+	}
+
+	if s.policy.MaxAge > 0 {
+		rules = append(rules, rule{
+			scope:  ScopeGlobal,
+			action: ActionDelete,
+			filter: repository.ExpiryFilter{Before: now.Add(-s.policy.MaxAge), ExcludeAgents: agents},
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,111 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+type mockTraceRepo struct {
+	repository.TraceRepository
+	mock.Mock
+}
+
+func (m *mockTraceRepo) CountExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) DeleteExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) PurgePayloads(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+var now = time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestSweeper(repo repository.TraceRepository, policy Policy) *Sweeper {
+	s := NewSweeper(repo, policy, time.Minute)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSweeper_Plan(t *testing.T) {
+	repo := new(mockTraceRepo)
+	policy := Policy{
+		MaxAge:        30 * 24 * time.Hour,
+		AgentMaxAge:   map[string]time.Duration{"Planner": 7 * 24 * time.Hour, "Auditor": 0},
+		PayloadMaxAge: 24 * time.Hour,
+	}
+
+	repo.On("CountExpired", mock.Anything, repository.ExpiryFilter{
+		Before: now.Add(-24 * time.Hour), WithPayloads: true,
+	}).Return(int64(5), nil).Once()
+	repo.On("CountExpired", mock.Anything, repository.ExpiryFilter{
+		Before: now.Add(-7 * 24 * time.Hour), AgentName: "Planner",
+	}).Return(int64(2), nil).Once()
+	repo.On("CountExpired", mock.Anything, repository.ExpiryFilter{
+		Before: now.Add(-30 * 24 * time.Hour), ExcludeAgents: []string{"Auditor", "Planner"},
+	}).Return(int64(1), nil).Once()
+
+	report, err := newTestSweeper(repo, policy).Plan(context.Background())
+	require.NoError(t, err)
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []RuleResult{
+		{Scope: ScopeGlobal, Action: ActionPurgePayloads, Before: now.Add(-24 * time.Hour), Matched: 5},
+		{Scope: "agent:Planner", Action: ActionDelete, Before: now.Add(-7 * 24 * time.Hour), Matched: 2},
+		{Scope: ScopeGlobal, Action: ActionDelete, Before: now.Add(-30 * 24 * time.Hour), Matched: 1},
+	}, report.Rules)
+	repo.AssertExpectations(t)
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	t.Run("deletes and purges", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		policy := Policy{MaxAge: time.Hour, PayloadMaxAge: time.Minute}
+
+		repo.On("PurgePayloads", mock.Anything, repository.ExpiryFilter{
+			Before: now.Add(-time.Minute), WithPayloads: true,
+		}).Return(int64(3), nil).Once()
+		repo.On("DeleteExpired", mock.Anything, repository.ExpiryFilter{
+			Before: now.Add(-time.Hour), ExcludeAgents: []string{},
+		}).Return(int64(4), nil).Once()
+
+		report, err := newTestSweeper(repo, policy).Sweep(context.Background())
+		require.NoError(t, err)
+
+		assert.False(t, report.DryRun)
+		require.Len(t, report.Rules, 2)
+		assert.Equal(t, int64(3), report.Rules[0].Matched)
+		assert.Equal(t, int64(4), report.Rules[1].Matched)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("empty policy does nothing", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+
+		report, err := newTestSweeper(repo, Policy{}).Sweep(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, report.Rules)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("stops on repository error", func(t *testing.T) {
+		repo := new(mockTraceRepo)
+		repo.On("DeleteExpired", mock.Anything, mock.Anything).Return(int64(0), errors.New("db down")).Once()
+
+		_, err := newTestSweeper(repo, Policy{MaxAge: time.Hour}).Sweep(context.Background())
+		assert.EqualError(t, err, "db down")
+	})
+}
@@ -0,0 +1,17 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zkropotkine/agent-trace/internal/web"
+)
+
+// RegisterDashboardRoutes serves the embedded web dashboard under /ui.
+func RegisterDashboardRoutes(router *gin.Engine) {
+	router.StaticFS("/ui", http.FS(web.Assets()))
+	router.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/ui/")
+	})
+}
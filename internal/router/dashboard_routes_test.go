@@ -0,0 +1,50 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterDashboardRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	RegisterDashboardRoutes(r)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{"/ui/", http.StatusOK, "text/html", "<title>AgentTrace</title>"},
+		{"/ui/app.js", http.StatusOK, "javascript", `api("/traces"`},
+		{"/ui/style.css", http.StatusOK, "text/css", ".waterfall"},
+		{"/ui/missing.js", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.contentType != "" {
+				assert.Contains(t, rec.Header().Get("Content-Type"), tt.contentType)
+			}
+			assert.Contains(t, rec.Body.String(), tt.contains)
+		})
+	}
+
+	t.Run("redirects root to the dashboard", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/ui/", rec.Header().Get("Location"))
+	})
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/gin-gonic/gin"
 	"github.com/zkropotkine/agent-trace/internal/middleware"
@@ -11,12 +12,31 @@ import (
 )
 
 type RouteRegistry struct {
-	TraceHandler handler.TraceHandler
+	TraceHandler     handler.TraceHandler
+	RetentionHandler handler.RetentionHandler
+	AlertHandler     handler.AlertHandler
+	SchemaHandler    handler.SchemaHandler
+	ImportHandler    handler.ImportHandler
+	HealthHandler    handler.HealthHandler
+	IngestHandler    handler.IngestHandler
+	// IngestLimits run before the ingestion endpoints, e.g. rate and body
+	// size limits.
+	IngestLimits []gin.HandlerFunc
+	// ImportLimits run before the bulk import endpoint, which streams bodies
+	// too large for IngestLimits to buffer.
+	ImportLimits []gin.HandlerFunc
+	// TrustedProxies lists the proxy IPs or CIDRs whose X-Forwarded-For and
+	// X-Real-IP headers are believed when identifying clients. With none,
+	// clients are identified by the connection's remote address.
+	TrustedProxies []string
 }
 
-func SetupRouter(ctx context.Context, registry RouteRegistry) *gin.Engine {
+func SetupRouter(ctx context.Context, registry RouteRegistry) (*gin.Engine, error) {
 	log := logger.FromContext(ctx)
 	router := gin.Default()
+	if err := router.SetTrustedProxies(registry.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("set trusted proxies: %w", err)
+	}
 
 	router.Use(
 		gin.Recovery(), // catches panics and logs stack traces
@@ -24,6 +44,7 @@ func SetupRouter(ctx context.Context, registry RouteRegistry) *gin.Engine {
 	)
 
 	RegisterRoutes(router, registry)
+	RegisterDashboardRoutes(router)
 
-	return router
+	return router, nil
 }
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/middleware"
+	"github.com/zkropotkine/agent-trace/internal/ratelimit"
+)
+
+func TestSetupRouter_ForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		expectedStatus int
+	}{
+		{name: "spoofed header keeps the caller's bucket", expectedStatus: http.StatusTooManyRequests},
+		{name: "trusted proxy forwards the client IP", trustedProxies: []string{"10.0.0.0/8"}, expectedStatus: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(mockTraceRepo)
+			repo.On("InsertTrace", mock.Anything, mock.Anything).Return(nil)
+			limiter := ratelimit.New(ratelimit.Limit{Rate: 0.001, Burst: 1}, nil)
+
+			r, err := SetupRouter(context.Background(), RouteRegistry{
+				TraceHandler:   handler.NewTraceHandler(repo),
+				IngestLimits:   []gin.HandlerFunc{middleware.RateLimit(limiter)},
+				TrustedProxies: tt.trustedProxies,
+			})
+			require.NoError(t, err)
+
+			send := func(forwardedFor string) int {
+				req := httptest.NewRequest(http.MethodPost, "/api/traces", bytes.NewBufferString(`{"trace_id":"t1","agent_name":"AgentX"}`))
+				req.RemoteAddr = "10.0.0.1:1234"
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			assert.Equal(t, http.StatusCreated, send("203.0.113.1"))
+			assert.Equal(t, tt.expectedStatus, send("203.0.113.2"))
+		})
+	}
+}
+
+func TestSetupRouter_InvalidTrustedProxy(t *testing.T) {
+	_, err := SetupRouter(context.Background(), RouteRegistry{
+		TraceHandler:   handler.NewTraceHandler(new(mockTraceRepo)),
+		TrustedProxies: []string{"not-an-ip"},
+	})
+	assert.Error(t, err)
+}
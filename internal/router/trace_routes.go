@@ -5,11 +5,41 @@ import (
 )
 
 func RegisterRoutes(router *gin.Engine, deps RouteRegistry) {
+	if deps.HealthHandler != nil {
+		router.GET("/healthz", deps.HealthHandler.Live)
+		router.GET("/readyz", deps.HealthHandler.Ready)
+	}
+
 	api := router.Group("/api")
 	{
-		api.POST("/traces", deps.TraceHandler.PostTrace)
+		ingest := api.Group("", deps.IngestLimits...)
+		ingest.POST("/traces", deps.TraceHandler.PostTrace)
+		ingest.POST("/traces/batch", deps.TraceHandler.PostTraceBatch)
+		ingest.POST("/ingest/langsmith", deps.TraceHandler.PostLangSmithRuns)
+		ingest.POST("/traces/:id/replay", deps.TraceHandler.ReplayTrace)
+
 		api.GET("/traces", deps.TraceHandler.GetTraces)
+		api.GET("/traces/stream", deps.TraceHandler.StreamTraces)
+		api.GET("/traces/export", deps.TraceHandler.ExportTraces)
+		api.GET("/traces/diff", deps.TraceHandler.DiffTraces)
 		api.GET("/traces/:id", deps.TraceHandler.GetTraceByID)
+		api.GET("/traces/:id/timeline", deps.TraceHandler.GetTraceTimeline)
+
+		if deps.RetentionHandler != nil {
+			api.GET("/retention/dry-run", deps.RetentionHandler.DryRun)
+		}
+		if deps.AlertHandler != nil {
+			api.GET("/alerts", deps.AlertHandler.GetAlerts)
+		}
+		if deps.SchemaHandler != nil {
+			api.GET("/schema", deps.SchemaHandler.GetSchema)
+		}
+		if deps.ImportHandler != nil {
+			api.Group("", deps.ImportLimits...).POST("/import", deps.ImportHandler.PostImport)
+		}
+		if deps.IngestHandler != nil {
+			api.GET("/ingest/queue", deps.IngestHandler.GetQueueStats)
+		}
 		// RegisterEvaluationRoutes(api, deps) ← future
 	}
 }
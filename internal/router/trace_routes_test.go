@@ -5,8 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +16,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/importer"
+	"github.com/zkropotkine/agent-trace/internal/middleware"
 	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/ratelimit"
 	"github.com/zkropotkine/agent-trace/internal/repository"
 )
 
@@ -27,16 +32,66 @@ func (m *mockTraceRepo) InsertTrace(ctx context.Context, trace model.Trace) erro
 	return args.Error(0)
 }
 
+func (m *mockTraceRepo) InsertTraces(ctx context.Context, traces []model.Trace) error {
+	args := m.Called(ctx, traces)
+	return args.Error(0)
+}
+
+func (m *mockTraceRepo) MergeTraces(ctx context.Context, traces []model.Trace) error {
+	args := m.Called(ctx, traces)
+	return args.Error(0)
+}
+
 func (m *mockTraceRepo) GetTraces(ctx context.Context, filter repository.TraceFilter) ([]model.Trace, error) {
 	args := m.Called(ctx, filter)
 	return args.Get(0).([]model.Trace), args.Error(1)
 }
 
+func (m *mockTraceRepo) IterateTraces(ctx context.Context, filter repository.TraceFilter, fn func(model.Trace) error) error {
+	args := m.Called(ctx, filter)
+	for _, trace := range args.Get(0).([]model.Trace) {
+		if err := fn(trace); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
 func (m *mockTraceRepo) GetByID(ctx context.Context, id string) (*model.Trace, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*model.Trace), args.Error(1)
 }
 
+func (m *mockTraceRepo) ExistingTraceIDs(ctx context.Context, traceIDs []string) (map[string]bool, error) {
+	args := m.Called(ctx, traceIDs)
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+func (m *mockTraceRepo) CountExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) IterateExpired(ctx context.Context, filter repository.ExpiryFilter, fn func(model.Trace) error) error {
+	args := m.Called(ctx, filter, fn)
+	return args.Error(0)
+}
+
+func (m *mockTraceRepo) DeleteExpired(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) PurgePayloads(ctx context.Context, filter repository.ExpiryFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *mockTraceRepo) DeleteByIDs(ctx context.Context, ids []string) (int64, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func TestPostTrace(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -155,6 +210,23 @@ func TestGetTraceByIDRoute(t *testing.T) {
 	})
 }
 
+func TestStreamRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := new(mockTraceRepo)
+	r := gin.New()
+	RegisterRoutes(r, RouteRegistry{TraceHandler: handler.NewTraceHandler(repo)})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/traces/stream", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	// Routed to StreamTraces rather than GetTraceByID("stream").
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":"live tail is disabled"}`, rec.Body.String())
+	repo.AssertExpectations(t)
+}
+
 func TestRegisterRoutes(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -179,3 +251,76 @@ func TestRegisterRoutes(t *testing.T) {
 	assert.JSONEq(t, `{"message":"trace saved"}`, rec.Body.String())
 	repo.AssertExpectations(t)
 }
+
+func TestSchemaRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	RegisterRoutes(r, RouteRegistry{
+		TraceHandler:  handler.NewTraceHandler(new(mockTraceRepo)),
+		SchemaHandler: handler.NewSchemaHandler(),
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/schema", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/schema+json", rec.Header().Get("Content-Type"))
+}
+
+type countingImporter struct{}
+
+func (countingImporter) Import(_ context.Context, r io.Reader) (importer.Result, error) {
+	data, err := io.ReadAll(r)
+	return importer.Result{Imported: bytes.Count(data, []byte("\n"))}, err
+}
+
+func TestImportRouteLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	RegisterRoutes(r, RouteRegistry{
+		TraceHandler:  handler.NewTraceHandler(new(mockTraceRepo)),
+		ImportHandler: handler.NewImportHandler(countingImporter{}),
+		IngestLimits:  []gin.HandlerFunc{middleware.BodyLimit(16)},
+		ImportLimits:  []gin.HandlerFunc{middleware.StreamLimit(64)},
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/import", strings.NewReader(body))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Imports are not held to the ingestion body limit...
+	rec := send(strings.Repeat(`{"trace_id":"a"}`+"\n", 3))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"imported":3,"skipped":0,"invalid":0}`, rec.Body.String())
+
+	// ...but to their own.
+	rec = send(strings.Repeat(`{"trace_id":"a"}`+"\n", 4))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":"request body exceeds 64 bytes","result":{"imported":3,"skipped":0,"invalid":0}}`, rec.Body.String())
+}
+
+func TestReplayRouteIsRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := ratelimit.New(ratelimit.Limit{Rate: 0.001, Burst: 1}, nil)
+	r := gin.New()
+	RegisterRoutes(r, RouteRegistry{
+		TraceHandler: handler.NewTraceHandler(new(mockTraceRepo)),
+		IngestLimits: []gin.HandlerFunc{middleware.RateLimit(limiter)},
+	})
+
+	send := func() int {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/traces/abc/replay", nil))
+		return rec.Code
+	}
+
+	assert.NotEqual(t, http.StatusTooManyRequests, send())
+	assert.Equal(t, http.StatusTooManyRequests, send())
+}
@@ -0,0 +1,81 @@
+// Package server runs the HTTP server and stops it gracefully.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Options configures a Server.
+type Options struct {
+	// DrainTimeout bounds how long in-flight requests may take to finish once
+	// shutdown starts.
+	DrainTimeout      time.Duration
+	ReadHeaderTimeout time.Duration
+	// OnShutdown is called when shutdown starts, e.g. to end long-lived
+	// streams that would otherwise hold the drain until it times out.
+	OnShutdown func()
+}
+
+// Server is an http.Server that stops accepting connections when its context
+// is done and lets in-flight requests complete.
+type Server struct {
+	http *http.Server
+	opts Options
+}
+
+func New(addr string, handler http.Handler, opts Options) *Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+	}
+	if opts.OnShutdown != nil {
+		srv.RegisterOnShutdown(opts.OnShutdown)
+	}
+
+	return &Server{http: srv, opts: opts}
+}
+
+// Run listens on the configured address and serves until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln until ctx is done, then drains in-flight
+// requests. It returns nil after a clean drain, an error wrapping
+// context.DeadlineExceeded if requests were still running after
+// DrainTimeout, or the error that stopped the listener.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- s.http.Serve(ln)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.opts.DrainTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(drainCtx); err != nil {
+		_ = s.http.Close()
+		return fmt.Errorf("drain in-flight requests: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowHandler blocks each request until release is closed.
+func slowHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+}
+
+func start(t *testing.T, handler http.Handler, opts Options) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- New("", handler, opts).Serve(ctx, ln)
+	}()
+
+	return "http://" + ln.Addr().String(), cancel, stopped
+}
+
+func TestServer_InFlightRequestCompletes(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	shutdownStarted := make(chan struct{})
+	url, stop, stopped := start(t, slowHandler(started, release), Options{
+		DrainTimeout: 5 * time.Second,
+		OnShutdown:   func() { close(shutdownStarted) },
+	})
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		done <- result{string(body), err}
+	}()
+
+	<-started
+	stop()
+	<-shutdownStarted
+
+	_, err := http.Get(url)
+	assert.Error(t, err, "new connections are refused while draining")
+
+	close(release)
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-stopped)
+}
+
+func TestServer_DrainTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	url, stop, stopped := start(t, slowHandler(started, release), Options{DrainTimeout: 50 * time.Millisecond})
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	stop()
+	assert.ErrorIs(t, <-stopped, context.DeadlineExceeded)
+}
+
+func TestServer_ListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	err = New(ln.Addr().String(), http.NotFoundHandler(), Options{}).Run(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+package timeline
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+const (
+	svgWidth    = 960
+	labelWidth  = 240
+	rowHeight   = 22
+	barHeight   = 14
+	indentWidth = 12
+	headerSize  = 28
+)
+
+// RenderSVG draws the timeline as a standalone SVG waterfall. Critical path
+// steps are highlighted so the image can be pasted into incident documents.
+func RenderSVG(tl Timeline) []byte {
+	var buf bytes.Buffer
+	height := headerSize + rowHeight*len(tl.Steps) + 8
+	trackWidth := float64(svgWidth - labelWidth - 16)
+	scale := 0.0
+	if tl.DurationMS > 0 {
+		scale = trackWidth / float64(tl.DurationMS)
+	}
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`,
+		svgWidth, height, svgWidth, height)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#ffffff"/>`)
+	fmt.Fprintf(&buf, `<text x="8" y="18" font-weight="bold">%s</text>`, escape("Trace "+tl.TraceID))
+	fmt.Fprintf(&buf, `<text x="%d" y="18" text-anchor="end" fill="#6b7080">%d ms</text>`, svgWidth-8, tl.DurationMS)
+
+	for i, step := range tl.Steps {
+		y := headerSize + i*rowHeight
+		fill := "#3b6fd8"
+		if step.Critical {
+			fill = "#e07b24"
+		}
+		if model.IsFailure(step.Status) {
+			fill = "#d0443a"
+		}
+
+		width := float64(step.DurationMS) * scale
+		if width < 1 {
+			width = 1
+		}
+		x := float64(labelWidth) + float64(step.OffsetMS)*scale
+
+		fmt.Fprintf(&buf, `<text x="%d" y="%d">%s</text>`, 8+step.Depth*indentWidth, y+barHeight-2, escape(step.Name))
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%d" width="%.1f" height="%d" rx="2" fill="%s"><title>%s</title></rect>`,
+			x, y, width, barHeight, fill,
+			escape(fmt.Sprintf("%s: +%d ms, %d ms%s", step.Name, step.OffsetMS, step.DurationMS, criticalSuffix(step))))
+	}
+
+	buf.WriteString(`</svg>`)
+	return buf.Bytes()
+}
+
+func criticalSuffix(step Step) string {
+	if step.Critical {
+		return " (critical path)"
+	}
+	return ""
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,188 @@
+package timeline
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// Step is a substep positioned on the trace timeline.
+type Step struct {
+	// Index is the position of the substep in model.Trace.SubSteps.
+	Index      int    `json:"index"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	OffsetMS   int64  `json:"offset_ms"`
+	DurationMS int64  `json:"duration_ms"`
+	Depth      int    `json:"depth"`
+	Critical   bool   `json:"critical"`
+
+	start, end       time.Time
+	spanID, parentID string
+	children         []int
+}
+
+// Timeline lays out the timed substeps of a trace relative to its start.
+type Timeline struct {
+	TraceID    string    `json:"trace_id"`
+	Start      time.Time `json:"start"`
+	DurationMS int64     `json:"duration_ms"`
+	Steps      []Step    `json:"steps"`
+}
+
+// Build computes the timeline of trace. Substeps without a start time are
+// omitted. Nesting follows SpanID/ParentID when every step carries a span ID,
+// and otherwise the enclosing start/end intervals. The critical path is the
+// chain of steps that determined when the trace finished, found by walking
+// back from the last step to end at each level.
+func Build(trace model.Trace) Timeline {
+	tl := Timeline{TraceID: trace.TraceID, Steps: []Step{}}
+
+	for i, sub := range trace.SubSteps {
+		if sub.Start.IsZero() {
+			continue
+		}
+		end := sub.End
+		if end.Before(sub.Start) {
+			end = sub.Start
+		}
+		tl.Steps = append(tl.Steps, Step{
+			Index:    i,
+			Name:     sub.Name,
+			Status:   sub.Status,
+			start:    sub.Start,
+			end:      end,
+			spanID:   sub.SpanID,
+			parentID: sub.ParentID,
+		})
+	}
+
+	if len(tl.Steps) == 0 {
+		tl.Start = trace.Timestamp
+		return tl
+	}
+
+	// Sort so that enclosing steps precede the steps they contain.
+	sort.SliceStable(tl.Steps, func(i, j int) bool {
+		a, b := tl.Steps[i], tl.Steps[j]
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		return a.end.After(b.end)
+	})
+
+	tl.Start = tl.Steps[0].start
+	traceEnd := tl.Start
+
+	var parents []int
+	if hasSpanIDs(tl.Steps) {
+		parents = parentsBySpan(tl.Steps)
+	} else {
+		parents = parentsByInterval(tl.Steps)
+	}
+
+	var roots []int
+	for i := range tl.Steps {
+		step := &tl.Steps[i]
+		if parents[i] < 0 {
+			roots = append(roots, i)
+		} else {
+			tl.Steps[parents[i]].children = append(tl.Steps[parents[i]].children, i)
+		}
+		step.Depth = depth(parents, i)
+		step.OffsetMS = step.start.Sub(tl.Start).Milliseconds()
+		step.DurationMS = step.end.Sub(step.start).Milliseconds()
+		if step.end.After(traceEnd) {
+			traceEnd = step.end
+		}
+	}
+
+	tl.DurationMS = traceEnd.Sub(tl.Start).Milliseconds()
+	markCritical(tl.Steps, roots)
+
+	return tl
+}
+
+func hasSpanIDs(steps []Step) bool {
+	for _, s := range steps {
+		if s.spanID == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parentsBySpan resolves each step's parent from ParentID; steps whose parent
+// is unknown become roots.
+func parentsBySpan(steps []Step) []int {
+	bySpan := make(map[string]int, len(steps))
+	for i, s := range steps {
+		bySpan[s.spanID] = i
+	}
+
+	parents := make([]int, len(steps))
+	for i, s := range steps {
+		parent, ok := bySpan[s.parentID]
+		if !ok || parent == i {
+			parent = -1
+		}
+		parents[i] = parent
+	}
+
+	return parents
+}
+
+// parentsByInterval makes each step a child of the innermost step enclosing it.
+// steps must be sorted by start ascending and end descending.
+func parentsByInterval(steps []Step) []int {
+	parents := make([]int, len(steps))
+	var stack []int
+
+	for i := range steps {
+		for len(stack) > 0 && !encloses(steps[stack[len(stack)-1]], steps[i]) {
+			stack = stack[:len(stack)-1]
+		}
+		parents[i] = -1
+		if len(stack) > 0 {
+			parents[i] = stack[len(stack)-1]
+		}
+		stack = append(stack, i)
+	}
+
+	return parents
+}
+
+// depth counts ancestors, stopping on cycles in malformed parent references.
+func depth(parents []int, i int) int {
+	d := 0
+	for p := parents[i]; p >= 0 && d < len(parents); p = parents[p] {
+		d++
+	}
+
+	return d
+}
+
+func encloses(parent, child Step) bool {
+	return !child.start.Before(parent.start) && !child.end.After(parent.end)
+}
+
+// markCritical walks siblings from the one that ends last towards the start,
+// picking each step that finished before the previously picked one started.
+func markCritical(steps []Step, siblings []int) {
+	ordered := append([]int(nil), siblings...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return steps[ordered[i]].end.After(steps[ordered[j]].end)
+	})
+
+	var cursor time.Time
+	for n, i := range ordered {
+		if n > 0 && steps[i].end.After(cursor) {
+			continue
+		}
+		steps[i].Critical = true
+		markCritical(steps, steps[i].children)
+		cursor = steps[i].start
+	}
+}
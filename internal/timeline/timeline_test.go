@@ -0,0 +1,129 @@
+package timeline
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+var t0 = time.Date(2025, 5, 1, 1, 23, 0, 0, time.UTC)
+
+func at(ms int) time.Time {
+	return t0.Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestBuild(t *testing.T) {
+	trace := model.Trace{
+		TraceID: "abc123",
+		SubSteps: []model.SubStep{
+			{Name: "Planner", Start: at(0), End: at(100)},
+			{Name: "Retriever", Start: at(100), End: at(600)},
+			{Name: "Embed", Start: at(120), End: at(200)},
+			{Name: "Search", Start: at(200), End: at(550)},
+			{Name: "Cache", Start: at(110), End: at(300)},
+			{Name: "Untimed"},
+			{Name: "Summarize", Start: at(600), End: at(900), Status: "success"},
+		},
+	}
+
+	tl := Build(trace)
+
+	assert.Equal(t, "abc123", tl.TraceID)
+	assert.Equal(t, t0, tl.Start)
+	assert.Equal(t, int64(900), tl.DurationMS)
+
+	type row struct {
+		Index    int
+		Name     string
+		Offset   int64
+		Duration int64
+		Depth    int
+		Critical bool
+	}
+	var got []row
+	for _, s := range tl.Steps {
+		got = append(got, row{s.Index, s.Name, s.OffsetMS, s.DurationMS, s.Depth, s.Critical})
+	}
+
+	assert.Equal(t, []row{
+		{0, "Planner", 0, 100, 0, true},
+		{1, "Retriever", 100, 500, 0, true},
+		{4, "Cache", 110, 190, 1, false},
+		{2, "Embed", 120, 80, 2, false},
+		{3, "Search", 200, 350, 1, true},
+		{6, "Summarize", 600, 300, 0, true},
+	}, got)
+}
+
+func TestBuild_ParallelSteps(t *testing.T) {
+	tl := Build(model.Trace{SubSteps: []model.SubStep{
+		{Name: "fast", Start: at(0), End: at(100)},
+		{Name: "slow", Start: at(50), End: at(400)},
+		{Name: "bad-end", Start: at(50), End: at(10)},
+	}})
+
+	require.Len(t, tl.Steps, 3)
+	critical := map[string]bool{}
+	for _, s := range tl.Steps {
+		critical[s.Name] = s.Critical
+	}
+	assert.True(t, critical["slow"])
+	assert.False(t, critical["fast"])
+	assert.Equal(t, int64(0), tl.Steps[2].DurationMS, "End before Start is clamped")
+}
+
+func TestBuild_SpanTree(t *testing.T) {
+	// Parallel tool calls overlap, so intervals alone cannot tell which step
+	// called which; span IDs can.
+	tl := Build(model.Trace{SubSteps: []model.SubStep{
+		{Name: "agent", SpanID: "a", Start: at(0), End: at(500)},
+		{Name: "tool-1", SpanID: "b", ParentID: "a", Start: at(10), End: at(300)},
+		{Name: "tool-2", SpanID: "c", ParentID: "a", Start: at(20), End: at(450)},
+		{Name: "llm", SpanID: "d", ParentID: "c", Start: at(20), End: at(400)},
+		{Name: "orphan", SpanID: "e", ParentID: "zzz", Start: at(600), End: at(700)},
+	}})
+
+	depths := map[string]int{}
+	critical := map[string]bool{}
+	for _, s := range tl.Steps {
+		depths[s.Name] = s.Depth
+		critical[s.Name] = s.Critical
+	}
+
+	assert.Equal(t, map[string]int{"agent": 0, "tool-1": 1, "tool-2": 1, "llm": 2, "orphan": 0}, depths)
+	assert.Equal(t, map[string]bool{"agent": true, "tool-1": false, "tool-2": true, "llm": true, "orphan": true}, critical)
+}
+
+func TestBuild_NoSteps(t *testing.T) {
+	tl := Build(model.Trace{TraceID: "x", Timestamp: t0})
+
+	assert.Equal(t, t0, tl.Start)
+	assert.Empty(t, tl.Steps)
+	assert.NotNil(t, tl.Steps)
+}
+
+func TestRenderSVG(t *testing.T) {
+	tl := Build(model.Trace{TraceID: "<abc>", SubSteps: []model.SubStep{
+		{Name: "Retriever & co", Start: at(0), End: at(100)},
+		{Name: "Tool", Start: at(100), End: at(200), Status: "error"},
+	}})
+
+	out := RenderSVG(tl)
+
+	var doc struct {
+		XMLName xml.Name `xml:"svg"`
+		Rects   []struct {
+			Fill string `xml:"fill,attr"`
+		} `xml:"rect"`
+	}
+	require.NoError(t, xml.Unmarshal(out, &doc), "output is well-formed XML")
+	assert.Len(t, doc.Rects, 3)
+	assert.Equal(t, "#e07b24", doc.Rects[1].Fill)
+	assert.Equal(t, "#d0443a", doc.Rects[2].Fill)
+	assert.Contains(t, string(out), "Trace &lt;abc&gt;")
+	assert.Contains(t, string(out), "Retriever &amp; co")
+}
@@ -0,0 +1,80 @@
+// Package traceparent parses and formats W3C Trace Context traceparent headers
+// (https://www.w3.org/TR/trace-context/), shared by the server and the client SDK.
+package traceparent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Header is the HTTP header carrying the trace context.
+const Header = "traceparent"
+
+const (
+	version        = "00"
+	flagSampled    = "01"
+	traceIDLength  = 32
+	parentIDLength = 16
+)
+
+var ErrInvalid = errors.New("invalid traceparent")
+
+// Context identifies the remote caller: the trace it belongs to and the span
+// that made the call.
+type Context struct {
+	TraceID  string
+	ParentID string
+}
+
+// Parse decodes a traceparent value. Unknown future versions are accepted as
+// long as the first four fields are well formed, as the spec requires.
+func Parse(value string) (Context, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return Context{}, ErrInvalid
+	}
+	ver, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	if !isHex(ver, 2) || ver == "ff" || (ver == version && len(parts) != 4) {
+		return Context{}, ErrInvalid
+	}
+	if !isHex(traceID, traceIDLength) || isZero(traceID) {
+		return Context{}, fmt.Errorf("%w: bad trace-id", ErrInvalid)
+	}
+	if !isHex(parentID, parentIDLength) || isZero(parentID) {
+		return Context{}, fmt.Errorf("%w: bad parent-id", ErrInvalid)
+	}
+	if !isHex(flags, 2) {
+		return Context{}, fmt.Errorf("%w: bad trace-flags", ErrInvalid)
+	}
+
+	return Context{TraceID: traceID, ParentID: parentID}, nil
+}
+
+// Format encodes tc as a version 00 traceparent with the sampled flag set.
+func Format(tc Context) string {
+	return version + "-" + tc.TraceID + "-" + tc.ParentID + "-" + flagSampled
+}
+
+// Valid reports whether tc can be formatted into a well-formed header.
+func (tc Context) Valid() bool {
+	return isHex(tc.TraceID, traceIDLength) && !isZero(tc.TraceID) &&
+		isHex(tc.ParentID, parentIDLength) && !isZero(tc.ParentID)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
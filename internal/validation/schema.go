@@ -0,0 +1,11 @@
+package validation
+
+import _ "embed"
+
+//go:embed schema/trace.v1.json
+var schemaV1 []byte
+
+// Schema returns the JSON Schema describing the current ingestion payload.
+func Schema() []byte {
+	return schemaV1
+}
@@ -0,0 +1,50 @@
+package validation
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// TestSchema_CoversModel keeps the published schema in sync with the JSON
+// fields of model.Trace and model.SubStep.
+func TestSchema_CoversModel(t *testing.T) {
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Defs       map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+	require.NoError(t, json.Unmarshal(Schema(), &schema))
+
+	assert.ElementsMatch(t, jsonFields(model.Trace{}), keys(schema.Properties))
+	assert.ElementsMatch(t, jsonFields(model.SubStep{}), keys(schema.Defs["subStep"].Properties))
+	assert.ElementsMatch(t, jsonFields(model.Message{}), keys(schema.Defs["message"].Properties))
+	assert.ElementsMatch(t, jsonFields(model.ToolCall{}), keys(schema.Defs["toolCall"].Properties))
+	assert.ElementsMatch(t, jsonFields(model.ModelParams{}), keys(schema.Defs["modelParams"].Properties))
+	assert.ElementsMatch(t, jsonFields(model.TokenUsage{}), keys(schema.Defs["tokenUsage"].Properties))
+}
+
+func jsonFields(v any) []string {
+	typ := reflect.TypeOf(v)
+	fields := make([]string, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,266 @@
+// Package validation checks ingested traces and publishes the JSON Schema of
+// the ingestion payload.
+package validation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// CurrentSchemaVersion is the payload format produced by this server's SDK and
+// described by Schema. Payloads without schema_version are treated as this version.
+const CurrentSchemaVersion = 1
+
+// supportedVersions lists the schema versions the server accepts.
+var supportedVersions = map[int]bool{1: true}
+
+// messageRoles are the chat roles accepted in Message.Role.
+var messageRoles = map[string]bool{
+	"system":    true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// FieldError reports an invalid field by its JSON path, e.g. substeps[1].messages[0].role.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// tooLarge marks a field over its size limit.
+	tooLarge bool
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors is every problem found in a payload, in field order.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Prefix returns a copy of e with prefix prepended to every field, used to
+// locate errors within a batch.
+func (e Errors) Prefix(prefix string) Errors {
+	out := make(Errors, len(e))
+	for i, fe := range e {
+		out[i] = FieldError{Field: prefix + fe.Field, Message: fe.Message, tooLarge: fe.tooLarge}
+	}
+	return out
+}
+
+// TooLarge reports whether every error is a field over its size limit, so
+// the payload is well formed but must be shrunk.
+func (e Errors) TooLarge() bool {
+	for _, fe := range e {
+		if !fe.tooLarge {
+			return false
+		}
+	}
+	return len(e) > 0
+}
+
+// Limits bound the metadata and tags accepted on a trace or substep, and the
+// size of its input and output. A zero MaxInputBytes or MaxOutputBytes means
+// no limit.
+type Limits struct {
+	MaxKeys        int
+	MaxKeyLength   int
+	MaxValueLength int
+	MaxTags        int
+	MaxTagLength   int
+	// MaxInputBytes bounds input_prompt and each substep's input.
+	MaxInputBytes int
+	// MaxOutputBytes bounds output and each substep's output.
+	MaxOutputBytes int
+}
+
+// DefaultLimits are used by validators built with a zero Limits.
+var DefaultLimits = Limits{
+	MaxKeys:        32,
+	MaxKeyLength:   64,
+	MaxValueLength: 256,
+	MaxTags:        32,
+	MaxTagLength:   64,
+	MaxInputBytes:  1 << 20,
+	MaxOutputBytes: 1 << 20,
+}
+
+type Validator struct {
+	limits Limits
+}
+
+func New(limits Limits) *Validator {
+	if limits == (Limits{}) {
+		limits = DefaultLimits
+	}
+	return &Validator{limits: limits}
+}
+
+// Limits returns the limits v enforces.
+func (v *Validator) Limits() Limits {
+	return v.limits
+}
+
+// Validate returns every problem found in trace, or nil if it is valid.
+func (v *Validator) Validate(trace model.Trace) Errors {
+	var errs errorList
+
+	if trace.SchemaVersion != 0 && !supportedVersions[trace.SchemaVersion] {
+		errs.add("schema_version", fmt.Sprintf("unsupported version %d", trace.SchemaVersion))
+	}
+	if strings.TrimSpace(trace.TraceID) == "" {
+		errs.add("trace_id", "is required")
+	}
+	checkSize(&errs, "input_prompt", trace.InputPrompt, v.limits.MaxInputBytes)
+	checkSize(&errs, "output", trace.Output, v.limits.MaxOutputBytes)
+	if trace.LatencyMS < 0 {
+		errs.add("latency_ms", "must not be negative")
+	}
+	checkTokens(&errs, "token_usage", &trace.TokenUsage)
+	checkLLMFields(&errs, "", trace.Messages, trace.ToolCalls, trace.ModelParams)
+	checkMetadata(&errs, "", trace.Metadata, trace.Tags, v.limits)
+
+	for i, step := range trace.SubSteps {
+		prefix := fmt.Sprintf("substeps[%d].", i)
+		if strings.TrimSpace(step.Name) == "" {
+			errs.add(prefix+"name", "is required")
+		}
+		if !step.Start.IsZero() && !step.End.IsZero() && step.End.Before(step.Start) {
+			errs.add(prefix+"end", "must not be before start")
+		}
+		checkSize(&errs, prefix+"input", step.Input, v.limits.MaxInputBytes)
+		checkSize(&errs, prefix+"output", step.Output, v.limits.MaxOutputBytes)
+		checkTokens(&errs, prefix+"token_usage", step.TokenUsage)
+		checkLLMFields(&errs, prefix, step.Messages, step.ToolCalls, step.ModelParams)
+		checkMetadata(&errs, prefix, step.Metadata, step.Tags, v.limits)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return Errors(errs)
+}
+
+type errorList []FieldError
+
+func (l *errorList) add(field, message string) {
+	*l = append(*l, FieldError{Field: field, Message: message})
+}
+
+func checkSize(errs *errorList, field, value string, limit int) {
+	if limit > 0 && len(value) > limit {
+		*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf("exceeds %d bytes", limit), tooLarge: true})
+	}
+}
+
+func checkTokens(errs *errorList, field string, usage *model.TokenUsage) {
+	if usage == nil {
+		return
+	}
+	if usage.Input < 0 || usage.Output < 0 || usage.Total < 0 {
+		errs.add(field, "token counts must not be negative")
+		return
+	}
+	if usage.Total != usage.Input+usage.Output {
+		errs.add(field+".total", fmt.Sprintf("must equal input_tokens + output_tokens (%d)", usage.Input+usage.Output))
+	}
+}
+
+func checkLLMFields(errs *errorList, prefix string, messages []model.Message, calls []model.ToolCall, params *model.ModelParams) {
+	for i, msg := range messages {
+		field := fmt.Sprintf("%smessages[%d]", prefix, i)
+		if !messageRoles[msg.Role] {
+			errs.add(field+".role", fmt.Sprintf("unknown role %q", msg.Role))
+		}
+		if msg.Role == "tool" && msg.ToolCallID == "" && msg.Name == "" {
+			errs.add(field, "tool messages need a tool_call_id or name")
+		}
+	}
+
+	for i, call := range calls {
+		field := fmt.Sprintf("%stool_calls[%d]", prefix, i)
+		if call.Name == "" {
+			errs.add(field+".name", "is required")
+		}
+		if len(call.Arguments) > 0 && !isJSONObject(call.Arguments) && !isJSONNull(call.Arguments) {
+			errs.add(field+".arguments", "must be a JSON object")
+		}
+	}
+
+	if params == nil {
+		return
+	}
+	field := prefix + "model_params"
+	if t := params.Temperature; t != nil && (*t < 0 || *t > 2) {
+		errs.add(field+".temperature", "must be between 0 and 2")
+	}
+	if p := params.TopP; p != nil && (*p < 0 || *p > 1) {
+		errs.add(field+".top_p", "must be between 0 and 1")
+	}
+	if m := params.MaxTokens; m != nil && *m <= 0 {
+		errs.add(field+".max_tokens", "must be positive")
+	}
+}
+
+func checkMetadata(errs *errorList, prefix string, metadata map[string]string, tags []string, limits Limits) {
+	field := prefix + "metadata"
+	if len(metadata) > limits.MaxKeys {
+		errs.add(field, fmt.Sprintf("at most %d keys allowed", limits.MaxKeys))
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case !ValidMetadataKey(key):
+			errs.add(field, fmt.Sprintf("invalid key %q", key))
+		case len(key) > limits.MaxKeyLength:
+			errs.add(field, fmt.Sprintf("key %q exceeds %d bytes", key, limits.MaxKeyLength))
+		case len(metadata[key]) > limits.MaxValueLength:
+			errs.add(field+"."+key, fmt.Sprintf("value exceeds %d bytes", limits.MaxValueLength))
+		}
+	}
+
+	field = prefix + "tags"
+	if len(tags) > limits.MaxTags {
+		errs.add(field, fmt.Sprintf("at most %d tags allowed", limits.MaxTags))
+	}
+	for i, tag := range tags {
+		switch {
+		case tag == "":
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "must not be empty")
+		case len(tag) > limits.MaxTagLength:
+			errs.add(fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("exceeds %d bytes", limits.MaxTagLength))
+		}
+	}
+}
+
+// ValidMetadataKey rejects keys that cannot be stored as a Mongo field name or
+// addressed by the meta.<key> query filter.
+func ValidMetadataKey(key string) bool {
+	return key != "" && !strings.HasPrefix(key, "$") && !strings.Contains(key, ".")
+}
+
+func isJSONObject(raw []byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+func isJSONNull(raw []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
@@ -0,0 +1,263 @@
+package validation
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func TestValidate_StructuredFields(t *testing.T) {
+	temp := func(v float64) *float64 { return &v }
+	tokens := func(v int) *int { return &v }
+
+	tests := []struct {
+		name    string
+		trace   model.Trace
+		wantErr string
+	}{
+		{
+			name: "valid conversation",
+			trace: model.Trace{
+				Messages: []model.Message{
+					{Role: "system", Content: "Be brief."},
+					{Role: "user", Content: "Weather in Paris?"},
+					{Role: "assistant", Content: ""},
+					{Role: "tool", Name: "weather", ToolCallID: "call_1", Content: `{"temp": 18}`},
+				},
+				ToolCalls:   []model.ToolCall{{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{"city":"Paris"}`)}},
+				ModelParams: &model.ModelParams{Model: "gpt-4o", Temperature: temp(0), MaxTokens: tokens(256)},
+			},
+		},
+		{
+			name:    "unknown role",
+			trace:   model.Trace{Messages: []model.Message{{Role: "bot"}}},
+			wantErr: `messages[0].role: unknown role "bot"`,
+		},
+		{
+			name:    "tool message without reference",
+			trace:   model.Trace{Messages: []model.Message{{Role: "tool", Content: "42"}}},
+			wantErr: "messages[0]: tool messages need a tool_call_id or name",
+		},
+		{
+			name:    "tool call without name",
+			trace:   model.Trace{SubSteps: []model.SubStep{{Name: "a"}, {Name: "b", ToolCalls: []model.ToolCall{{Arguments: json.RawMessage(`{}`)}}}}},
+			wantErr: "substeps[1].tool_calls[0].name: is required",
+		},
+		{
+			name:    "non-object arguments",
+			trace:   model.Trace{ToolCalls: []model.ToolCall{{Name: "search", Arguments: json.RawMessage(`"q=x"`)}}},
+			wantErr: "tool_calls[0].arguments: must be a JSON object",
+		},
+		{
+			name:    "temperature out of range",
+			trace:   model.Trace{ModelParams: &model.ModelParams{Temperature: temp(2.5)}},
+			wantErr: "model_params.temperature: must be between 0 and 2",
+		},
+		{
+			name:    "non-positive max tokens on a substep",
+			trace:   model.Trace{SubSteps: []model.SubStep{{Name: "LLM", ModelParams: &model.ModelParams{MaxTokens: tokens(0)}}}},
+			wantErr: "substeps[0].model_params.max_tokens: must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.trace.TraceID = "t1"
+			errs := New(DefaultLimits).Validate(tt.trace)
+			if tt.wantErr == "" {
+				assert.Nil(t, errs)
+				return
+			}
+			assert.EqualError(t, errs, tt.wantErr)
+		})
+	}
+}
+
+func TestValidate_Metadata(t *testing.T) {
+	limits := Limits{MaxKeys: 2, MaxKeyLength: 8, MaxValueLength: 4, MaxTags: 2, MaxTagLength: 5}
+
+	tests := []struct {
+		name    string
+		trace   model.Trace
+		wantErr string
+	}{
+		{
+			name:  "within limits",
+			trace: model.Trace{Metadata: map[string]string{"region": "eu", "tier": "gold"}, Tags: []string{"beta"}},
+		},
+		{
+			name:    "too many keys",
+			trace:   model.Trace{Metadata: map[string]string{"a": "1", "b": "2", "c": "3"}},
+			wantErr: "metadata: at most 2 keys allowed",
+		},
+		{
+			name:    "dotted key",
+			trace:   model.Trace{Metadata: map[string]string{"git.sha": "abc"}},
+			wantErr: `metadata: invalid key "git.sha"`,
+		},
+		{
+			name:    "operator key",
+			trace:   model.Trace{Metadata: map[string]string{"$set": "x"}},
+			wantErr: `metadata: invalid key "$set"`,
+		},
+		{
+			name:    "long key",
+			trace:   model.Trace{Metadata: map[string]string{"experiment": "a"}},
+			wantErr: `metadata: key "experiment" exceeds 8 bytes`,
+		},
+		{
+			name:    "long value",
+			trace:   model.Trace{Metadata: map[string]string{"sha": "deadbeef"}},
+			wantErr: "metadata.sha: value exceeds 4 bytes",
+		},
+		{
+			name:    "too many tags on a substep",
+			trace:   model.Trace{SubSteps: []model.SubStep{{Name: "s", Tags: []string{"a", "b", "c"}}}},
+			wantErr: "substeps[0].tags: at most 2 tags allowed",
+		},
+		{
+			name:    "empty tag",
+			trace:   model.Trace{Tags: []string{"ok", ""}},
+			wantErr: "tags[1]: must not be empty",
+		},
+		{
+			name:    "long tag",
+			trace:   model.Trace{Tags: []string{strings.Repeat("x", 6)}},
+			wantErr: "tags[0]: exceeds 5 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.trace.TraceID = "t1"
+			errs := New(limits).Validate(tt.trace)
+			if tt.wantErr == "" {
+				assert.Nil(t, errs)
+				return
+			}
+			assert.EqualError(t, errs, tt.wantErr)
+		})
+	}
+}
+
+func TestValidate_CoreFields(t *testing.T) {
+	start := time.Date(2025, 5, 1, 1, 23, 0, 0, time.UTC)
+	valid := model.Trace{
+		TraceID:    "t1",
+		LatencyMS:  350,
+		TokenUsage: model.TokenUsage{Input: 100, Output: 200, Total: 300},
+		SubSteps: []model.SubStep{{
+			Name:       "Retriever",
+			Start:      start,
+			End:        start.Add(time.Second),
+			TokenUsage: &model.TokenUsage{Input: 1, Output: 1, Total: 2},
+		}},
+	}
+	require.Nil(t, New(Limits{}).Validate(valid))
+
+	t.Run("reports every problem with its field", func(t *testing.T) {
+		trace := model.Trace{
+			SchemaVersion: 7,
+			TraceID:       " ",
+			LatencyMS:     -1,
+			TokenUsage:    model.TokenUsage{Input: 1, Output: 2, Total: 4},
+			SubSteps: []model.SubStep{
+				{Name: "ok"},
+				{Start: start, End: start.Add(-time.Millisecond), TokenUsage: &model.TokenUsage{Input: -1}},
+			},
+		}
+
+		assert.Equal(t, Errors{
+			{Field: "schema_version", Message: "unsupported version 7"},
+			{Field: "trace_id", Message: "is required"},
+			{Field: "latency_ms", Message: "must not be negative"},
+			{Field: "token_usage.total", Message: "must equal input_tokens + output_tokens (3)"},
+			{Field: "substeps[1].name", Message: "is required"},
+			{Field: "substeps[1].end", Message: "must not be before start"},
+			{Field: "substeps[1].token_usage", Message: "token counts must not be negative"},
+		}, New(Limits{}).Validate(trace))
+	})
+
+	t.Run("accepts the current schema version", func(t *testing.T) {
+		trace := valid
+		trace.SchemaVersion = CurrentSchemaVersion
+		assert.Nil(t, New(Limits{}).Validate(trace))
+	})
+
+	t.Run("ignores end when a bound is unset", func(t *testing.T) {
+		trace := valid
+		trace.SubSteps = []model.SubStep{{Name: "open", Start: start}}
+		assert.Nil(t, New(Limits{}).Validate(trace))
+	})
+}
+
+func TestValidate_FieldSizes(t *testing.T) {
+	limits := Limits{MaxInputBytes: 4, MaxOutputBytes: 6}
+
+	tests := []struct {
+		name         string
+		trace        model.Trace
+		wantErr      string
+		wantTooLarge bool
+	}{
+		{
+			name:  "within limits",
+			trace: model.Trace{InputPrompt: "abcd", Output: "abcdef", SubSteps: []model.SubStep{{Name: "s", Input: "ab"}}},
+		},
+		{
+			name:         "long input prompt",
+			trace:        model.Trace{InputPrompt: "abcde"},
+			wantErr:      "input_prompt: exceeds 4 bytes",
+			wantTooLarge: true,
+		},
+		{
+			name:         "long substep output",
+			trace:        model.Trace{SubSteps: []model.SubStep{{Name: "s", Output: "abcdefg"}}},
+			wantErr:      "substeps[0].output: exceeds 6 bytes",
+			wantTooLarge: true,
+		},
+		{
+			name:    "mixed with other errors",
+			trace:   model.Trace{InputPrompt: "abcde", LatencyMS: -1},
+			wantErr: "input_prompt: exceeds 4 bytes; latency_ms: must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.trace.TraceID = "t1"
+			errs := New(limits).Validate(tt.trace)
+			if tt.wantErr == "" {
+				assert.Nil(t, errs)
+				return
+			}
+			assert.EqualError(t, errs, tt.wantErr)
+			assert.Equal(t, tt.wantTooLarge, errs.TooLarge())
+			assert.Equal(t, tt.wantTooLarge, errs.Prefix("[0].").TooLarge())
+		})
+	}
+}
+
+func TestErrors_Prefix(t *testing.T) {
+	errs := Errors{{Field: "trace_id", Message: "is required"}}
+
+	assert.Equal(t, Errors{{Field: "[2].trace_id", Message: "is required"}}, errs.Prefix("[2]."))
+	assert.Equal(t, "trace_id: is required", errs.Error())
+}
+
+func TestFromDecodeError(t *testing.T) {
+	var trace model.Trace
+
+	err := json.Unmarshal([]byte(`{"latency_ms":"slow"}`), &trace)
+	assert.Equal(t, Errors{{Field: "latency_ms", Message: "must be a number, got string"}}, FromDecodeError(err))
+
+	err = json.Unmarshal([]byte(`{"trace_id":`), &trace)
+	require.Error(t, err)
+	assert.Nil(t, FromDecodeError(err))
+}
@@ -0,0 +1,192 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/pkg/logger"
+)
+
+// ReplayOptions configures a Replayer.
+type ReplayOptions struct {
+	// Interval is how often the log is drained; it is also how soon after
+	// storage recovers the backlog is written.
+	Interval time.Duration
+	// MergeSpans writes with MergeTraces instead of InsertTraces.
+	MergeSpans bool
+	// Publishers receive each trace once it is stored.
+	Publishers []pubsub.Publisher
+	// MaxAttempts bounds how often a record is written before it is moved to
+	// the dead-letter file, so a record the repository always rejects does
+	// not hold back the ones after it. Transient failures, i.e. storage
+	// outages, are retried without limit.
+	MaxAttempts int
+}
+
+// Replayer drains a Log into the repository.
+type Replayer struct {
+	log  *Log
+	repo repository.TraceRepository
+	opts ReplayOptions
+
+	// failures counts the failed attempts of each record; dead holds the
+	// records moved to a dead-letter file, skipped if their segment is
+	// replayed again. Both are only used by the goroutine replaying.
+	failures map[record]int
+	dead     map[record]bool
+}
+
+// record identifies a record by its segment and position.
+type record struct {
+	seq   uint64
+	index int
+}
+
+func NewReplayer(log *Log, repo repository.TraceRepository, opts ReplayOptions) *Replayer {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	return &Replayer{
+		log:      log,
+		repo:     repo,
+		opts:     opts,
+		failures: make(map[record]int),
+		dead:     make(map[record]bool),
+	}
+}
+
+// Run replays the log at start, recovering segments left by a crash, and then
+// every interval until ctx is done.
+func (r *Replayer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Replay(ctx); err != nil {
+			logger.FromContext(ctx).Warnf("write-ahead log replay stopped, retrying in %s: %v", r.opts.Interval, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Replay writes every record in the log to the repository, oldest first, and
+// removes each segment once all its records are stored. It stops at the first
+// failed write, leaving that segment in place, unless the record has failed
+// MaxAttempts times without an outage: it is then moved to the segment's
+// dead-letter file. A segment with unreadable records is kept with a .corrupt
+// suffix once its intact records are stored. A segment interrupted by a
+// crash is replayed again from its start. Traces carry the ID assigned at
+// ingestion, so those already stored are skipped as duplicates and fragments
+// already merged are not merged twice; merged fragments may be published
+// again.
+func (r *Replayer) Replay(ctx context.Context) error {
+	segments, err := r.log.pending()
+	if err != nil {
+		return err
+	}
+
+	log := logger.FromContext(ctx)
+	for _, seq := range segments {
+		if ctx.Err() != nil {
+			return nil
+		}
+		replayed := 0
+		err := r.log.read(seq, func(index int, traces []model.Trace) error {
+			rec := record{seq: seq, index: index}
+			if r.dead[rec] {
+				return nil
+			}
+			err := r.store(ctx, traces)
+			if err == nil {
+				delete(r.failures, rec)
+				replayed += len(traces)
+				return nil
+			}
+			if repository.IsTransient(err) {
+				return err
+			}
+			r.failures[rec]++
+			if r.failures[rec] < r.opts.MaxAttempts {
+				return err
+			}
+			if derr := r.log.deadLetter(seq, traces); derr != nil {
+				return errors.Join(err, derr)
+			}
+			r.dead[rec] = true
+			log.WithError(err).Errorf("moved %d traces of write-ahead log segment %d to its dead-letter file after %d failed attempts", len(traces), seq, r.failures[rec])
+			return nil
+		})
+		switch {
+		case errors.Is(err, errCorrupt):
+			log.Warnf("write-ahead log segment %d has unreadable records; %d traces were replayed and the segment is kept as %s", seq, replayed, r.log.path(seq)+corruptExt)
+			err = r.log.quarantine(seq, replayed)
+		case err == nil:
+			err = r.log.remove(seq, replayed)
+		}
+		if err != nil {
+			return err
+		}
+		r.settle(seq)
+		if replayed > 0 {
+			log.Infof("replayed %d traces from write-ahead log segment %d", replayed, seq)
+		}
+	}
+	return nil
+}
+
+// settle drops the attempt counts of a segment once it is replayed.
+func (r *Replayer) settle(seq uint64) {
+	for rec := range r.failures {
+		if rec.seq == seq {
+			delete(r.failures, rec)
+		}
+	}
+	for rec := range r.dead {
+		if rec.seq == seq {
+			delete(r.dead, rec)
+		}
+	}
+}
+
+// store writes a record. Traces already stored, e.g. because the record was
+// partly replayed before a crash, are skipped and not published again.
+func (r *Replayer) store(ctx context.Context, traces []model.Trace) error {
+	if r.opts.MergeSpans {
+		if err := r.repo.MergeTraces(ctx, traces); err != nil {
+			return err
+		}
+		r.publish(traces)
+		return nil
+	}
+
+	stored := traces
+	err := r.repo.InsertTraces(ctx, traces)
+	var dup *repository.DuplicateError
+	if errors.As(err, &dup) {
+		stored, err = dup.Without(traces), nil
+	}
+	if err != nil {
+		return err
+	}
+	r.publish(stored)
+	return nil
+}
+
+func (r *Replayer) publish(traces []model.Trace) {
+	for _, trace := range traces {
+		for _, p := range r.opts.Publishers {
+			p.Publish(trace)
+		}
+	}
+}
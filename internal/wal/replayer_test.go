@@ -0,0 +1,270 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/pubsub"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+)
+
+// memRepo mirrors the semantics of the Mongo repository: inserts are
+// unordered and unique by ID, reporting the duplicates without failing the
+// rest of the batch, and merges skip fragments whose ID was already merged
+// into their trace. Writes fail with an outage while down is set, and batches
+// holding a rejected trace id always fail.
+type memRepo struct {
+	repository.TraceRepository
+
+	mu       sync.Mutex
+	traces   map[primitive.ObjectID]model.Trace
+	merged   map[string]*model.Trace
+	applied  map[primitive.ObjectID]bool
+	down     bool
+	rejected map[string]bool
+}
+
+var errOutage = fmt.Errorf("server selection: %w", context.DeadlineExceeded)
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		traces:  map[primitive.ObjectID]model.Trace{},
+		merged:  map[string]*model.Trace{},
+		applied: map[primitive.ObjectID]bool{},
+	}
+}
+
+func (m *memRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errOutage
+	}
+	for _, trace := range traces {
+		if m.rejected[trace.TraceID] {
+			return errors.New("document is invalid")
+		}
+	}
+	dup := &repository.DuplicateError{}
+	for i, trace := range traces {
+		if _, ok := m.traces[trace.ID]; ok {
+			dup.Indexes = append(dup.Indexes, i)
+			continue
+		}
+		m.traces[trace.ID] = trace
+	}
+	if len(dup.Indexes) > 0 {
+		return dup
+	}
+	return nil
+}
+
+func (m *memRepo) MergeTraces(_ context.Context, traces []model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errOutage
+	}
+	for _, fragment := range traces {
+		if m.applied[fragment.ID] {
+			continue
+		}
+		m.applied[fragment.ID] = true
+		trace, ok := m.merged[fragment.TraceID]
+		if !ok {
+			trace = &model.Trace{TraceID: fragment.TraceID}
+			m.merged[fragment.TraceID] = trace
+		}
+		trace.SubSteps = append(trace.SubSteps, fragment.SubSteps...)
+		trace.TokenUsage.Total += fragment.TokenUsage.Total
+	}
+	return nil
+}
+
+func (m *memRepo) setDown(down bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.down = down
+}
+
+func (m *memRepo) ids() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.traces))
+	for _, trace := range m.traces {
+		ids = append(ids, trace.TraceID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	traces []string
+}
+
+func (r *recorder) Publish(trace model.Trace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traces = append(r.traces, trace.TraceID)
+}
+
+func TestReplayer_KeepsSegmentsWhileStorageIsDown(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a", "b")))
+
+	repo := newMemRepo()
+	repo.setDown(true)
+	pub := &recorder{}
+	r := NewReplayer(l, repo, ReplayOptions{Publishers: []pubsub.Publisher{pub}})
+
+	assert.Error(t, r.Replay(context.Background()))
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.Empty(t, pub.traces)
+
+	repo.setDown(false)
+	require.NoError(t, r.Replay(context.Background()))
+	assert.Equal(t, []string{"a", "b"}, repo.ids())
+	assert.Equal(t, []string{"a", "b"}, pub.traces)
+	assert.Empty(t, segmentFiles(t, dir))
+}
+
+func TestReplayer_SkipsTracesAlreadyStored(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{})
+	require.NoError(t, err)
+	batch := traces("a", "b", "c")
+	require.NoError(t, l.Append(batch))
+
+	// "b" was stored before the process crashed mid-replay.
+	repo := newMemRepo()
+	require.NoError(t, repo.InsertTraces(context.Background(), batch[1:2]))
+	pub := &recorder{}
+
+	require.NoError(t, NewReplayer(l, repo, ReplayOptions{Publishers: []pubsub.Publisher{pub}}).Replay(context.Background()))
+	assert.Equal(t, []string{"a", "b", "c"}, repo.ids())
+	assert.Equal(t, []string{"a", "c"}, pub.traces)
+}
+
+func TestReplayer_ReplaysMergedFragmentsOnce(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	fragments := traces("a", "a")
+	for i := range fragments {
+		fragments[i].TokenUsage.Total = 10
+		fragments[i].SubSteps = []model.SubStep{{Name: "step"}}
+	}
+	require.NoError(t, l.Append(fragments))
+	require.NoError(t, l.Close())
+
+	// The process crashes after storing the segment but before removing it.
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	segment, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	repo := newMemRepo()
+	l, err = Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, NewReplayer(l, repo, ReplayOptions{MergeSpans: true}).Replay(context.Background()))
+	require.NoError(t, os.WriteFile(files[0], segment, 0o600))
+
+	l, err = Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, NewReplayer(l, repo, ReplayOptions{MergeSpans: true}).Replay(context.Background()))
+
+	trace := repo.merged["a"]
+	assert.Equal(t, 20, trace.TokenUsage.Total)
+	assert.Len(t, trace.SubSteps, 2)
+}
+
+func TestReplayer_RunDrainsOnceStorageRecovers(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+
+	repo := newMemRepo()
+	repo.setDown(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewReplayer(l, repo, ReplayOptions{Interval: 10 * time.Millisecond}).Run(ctx)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, repo.ids())
+	repo.setDown(false)
+
+	assert.Eventually(t, func() bool { return len(repo.ids()) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	assert.Empty(t, segmentFiles(t, dir))
+}
+
+func TestReplayer_DeadLettersRecordsThatKeepFailing(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Append(traces("bad")))
+	require.NoError(t, l.Append(traces("c")))
+
+	repo := newMemRepo()
+	repo.rejected = map[string]bool{"bad": true}
+	r := NewReplayer(l, repo, ReplayOptions{MaxAttempts: 2})
+
+	assert.Error(t, r.Replay(context.Background()))
+	assert.Equal(t, []string{"a"}, repo.ids())
+	require.NoError(t, r.Replay(context.Background()))
+
+	assert.Equal(t, []string{"a", "c"}, repo.ids())
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.Equal(t, int64(1), l.Stats().DeadLettered)
+
+	// The dead-letter file is a segment: renamed back, it replays.
+	dead, err := filepath.Glob(filepath.Join(dir, "*"+deadExt))
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	require.NoError(t, os.Rename(dead[0], filepath.Join(dir, "00000000000000000099"+segmentExt)))
+	delete(repo.rejected, "bad")
+	l, err = Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, NewReplayer(l, repo, ReplayOptions{}).Replay(context.Background()))
+	assert.Equal(t, []string{"a", "bad", "c"}, repo.ids())
+}
+
+func TestReplayer_RetriesOutagesWithoutLimit(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+
+	repo := newMemRepo()
+	repo.setDown(true)
+	r := NewReplayer(l, repo, ReplayOptions{MaxAttempts: 2})
+	for range 5 {
+		assert.ErrorIs(t, r.Replay(context.Background()), context.DeadlineExceeded)
+	}
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.Zero(t, l.Stats().DeadLettered)
+
+	repo.setDown(false)
+	require.NoError(t, r.Replay(context.Background()))
+	assert.Equal(t, []string{"a"}, repo.ids())
+}
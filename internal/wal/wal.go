@@ -0,0 +1,546 @@
+// Package wal implements a disk-backed write-ahead log that keeps traces the
+// repository failed to store until they can be replayed.
+package wal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/encryption"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+// ErrClosed is returned by Append once the log is closed.
+var ErrClosed = errors.New("write-ahead log is closed")
+
+// SyncPolicy controls when appended records are flushed to stable storage.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every append; no acknowledged trace is lost.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs at most once per Options.SyncInterval: an append
+	// within the interval of the last fsync is flushed when it ends, so a
+	// power failure loses at most that much.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy returns the policy named s.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(strings.ToLower(s)); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown WAL sync policy %q", s)
+}
+
+const (
+	segmentExt = ".wal"
+	// corruptExt is appended to a segment that had unreadable records once it
+	// is replayed, keeping it for inspection instead of deleting it.
+	corruptExt = ".corrupt"
+	// deadExt names the file next to a segment holding its records that kept
+	// failing to be stored, in the same format as the segment.
+	deadExt = ".dead"
+	// headerSize is the length and CRC-32C of the payload, big-endian.
+	headerSize = 8
+	// maxRecordBytes guards against allocating for a corrupt length.
+	maxRecordBytes = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Cipher encrypts records at rest, e.g. an *encryption.Keyring.
+type Cipher interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(value string) (string, error)
+}
+
+// Options configures a Log. Zero values fall back to the defaults below.
+type Options struct {
+	// SegmentBytes is the size past which the active segment is sealed and
+	// the next append starts a new one.
+	SegmentBytes int64
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// Cipher, if set, encrypts every record, so traces stored encrypted are
+	// not left in clear on disk while they wait.
+	Cipher Cipher
+}
+
+// Stats describe the traces waiting in the log.
+type Stats struct {
+	Segments int   `json:"segments"`
+	Bytes    int64 `json:"bytes"`
+	Appended int64 `json:"appended"`
+	Replayed int64 `json:"replayed"`
+	// Corrupt counts unreadable stretches of segments, e.g. a record torn by
+	// a crash mid-append, that were skipped.
+	Corrupt int64 `json:"corrupt"`
+	// DeadLettered counts traces moved to a dead-letter file after failing
+	// to be stored too many times.
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// Log appends batches of traces to numbered segment files in a directory.
+// Each record is the JSON-encoded batch framed by its length and checksum, so
+// a record torn by a crash is detected and skipped on replay. Segments are
+// sealed once they reach SegmentBytes and removed once replayed, or renamed
+// with a .corrupt suffix if some of their records were unreadable.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu         sync.Mutex
+	sealed     []uint64
+	active     segment
+	activeSeq  uint64
+	activeSize int64
+	nextSeq    uint64
+	lastSync   time.Time
+	// dirty is set while the active segment has appends not yet synced;
+	// syncTimer flushes them at the end of the sync interval.
+	dirty     bool
+	syncTimer *time.Timer
+	closed    bool
+	stats     Stats
+
+	// open creates a segment file; tests replace it to inject write faults.
+	open func(path string) (segment, error)
+}
+
+// segment is the active segment file.
+type segment interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+func openSegment(path string) (segment, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+}
+
+// Open opens the log in dir, creating the directory if needed. Segments left
+// by a previous run, including one that was active when it crashed, are
+// sealed and queued for replay.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 64 << 20
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncInterval
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, opts: opts, nextSeq: 1, open: openSegment}
+	for _, e := range entries {
+		seq, ok := parseSegmentName(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		l.sealed = append(l.sealed, seq)
+		l.nextSeq = max(l.nextSeq, seq+1)
+	}
+	sort.Slice(l.sealed, func(i, j int) bool { return l.sealed[i] < l.sealed[j] })
+
+	return l, nil
+}
+
+// Append writes traces as one record. It returns once the record is written
+// and, depending on the sync policy, flushed to disk.
+func (l *Log) Append(traces []model.Trace) error {
+	record, err := l.encode(traces)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if l.active == nil {
+		if err := l.create(); err != nil {
+			return err
+		}
+	}
+	if n, err := l.active.Write(record); err != nil {
+		l.discard(n)
+		return err
+	}
+	l.activeSize += int64(len(record))
+	switch {
+	case l.opts.Sync == SyncAlways || (l.opts.Sync == SyncInterval && time.Since(l.lastSync) >= l.opts.SyncInterval):
+		if err := l.sync(); err != nil {
+			return err
+		}
+	case l.opts.Sync == SyncInterval:
+		l.dirty = true
+		if l.syncTimer == nil {
+			l.syncTimer = time.AfterFunc(l.opts.SyncInterval-time.Since(l.lastSync), l.flush)
+		}
+	}
+	l.stats.Appended += int64(len(traces))
+
+	if l.activeSize >= l.opts.SegmentBytes {
+		return l.seal()
+	}
+	return nil
+}
+
+// Stats returns the log's counters and the size of its segments.
+func (l *Log) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := l.stats
+	stats.Segments = len(l.sealed)
+	for _, seq := range l.sealed {
+		if info, err := os.Stat(l.path(seq)); err == nil {
+			stats.Bytes += info.Size()
+		}
+	}
+	if l.active != nil {
+		stats.Segments++
+		stats.Bytes += l.activeSize
+	}
+	return stats
+}
+
+// Close flushes and closes the active segment. Its records are replayed
+// after the next Open.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.syncTimer != nil {
+		l.syncTimer.Stop()
+		l.syncTimer = nil
+	}
+	if l.active == nil {
+		return nil
+	}
+	return l.seal()
+}
+
+// sync flushes the active segment. Must be called with mu held.
+func (l *Log) sync() error {
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	l.lastSync = time.Now()
+	l.dirty = false
+	return nil
+}
+
+// flush syncs appends left unsynced by the interval policy once the interval
+// is over, so they are not left in the page cache while the log is idle. A
+// failed sync is retried an interval later.
+func (l *Log) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.syncTimer = nil
+	if l.closed || l.active == nil || !l.dirty {
+		return
+	}
+	if err := l.sync(); err != nil {
+		l.syncTimer = time.AfterFunc(l.opts.SyncInterval, l.flush)
+	}
+}
+
+// encode frames traces as a record, encrypting it if a cipher is set.
+func (l *Log) encode(traces []model.Trace) ([]byte, error) {
+	payload, err := json.Marshal(traces)
+	if err != nil {
+		return nil, err
+	}
+	if l.opts.Cipher != nil {
+		sealed, err := l.opts.Cipher.Encrypt(string(payload))
+		if err != nil {
+			return nil, err
+		}
+		payload = []byte(sealed)
+	}
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+	return record, nil
+}
+
+// create starts a new active segment. Must be called with mu held.
+func (l *Log) create() error {
+	f, err := l.open(l.path(l.nextSeq))
+	if err != nil {
+		return err
+	}
+	// Persist the directory entry too, or the segment may vanish on a crash.
+	if l.opts.Sync != SyncNever {
+		if err := syncDir(l.dir); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.active, l.activeSeq, l.activeSize = f, l.nextSeq, 0
+	l.nextSeq++
+	return nil
+}
+
+// discard removes the n bytes of a record that failed to be written in full,
+// so the next append does not follow a torn record. If that fails too, the
+// segment is sealed: its replay ends at the tear. Must be called with mu held.
+func (l *Log) discard(n int) {
+	if n == 0 {
+		return
+	}
+	if err := l.active.Truncate(l.activeSize); err != nil {
+		l.activeSize += int64(n)
+		_ = l.seal()
+	}
+}
+
+// seal flushes and closes the active segment and queues it for replay. Must
+// be called with mu held.
+func (l *Log) seal() error {
+	f := l.active
+	l.active = nil
+	l.dirty = false
+	l.sealed = append(l.sealed, l.activeSeq)
+
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// pending seals the active segment, if it has records, and returns the
+// sealed segments oldest first.
+func (l *Log) pending() ([]uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	if l.active != nil && l.activeSize > 0 {
+		err = l.seal()
+	}
+	return append([]uint64(nil), l.sealed...), err
+}
+
+// remove deletes a replayed segment.
+func (l *Log) remove(seq uint64, traces int) error {
+	if err := os.Remove(l.path(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l.forget(seq, traces)
+	return nil
+}
+
+// quarantine renames a replayed segment that had unreadable records, so they
+// can still be inspected or recovered by hand.
+func (l *Log) quarantine(seq uint64, traces int) error {
+	if err := os.Rename(l.path(seq), l.path(seq)+corruptExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l.forget(seq, traces)
+	return nil
+}
+
+// forget drops a replayed segment from the queue.
+func (l *Log) forget(seq uint64, traces int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, s := range l.sealed {
+		if s == seq {
+			l.sealed = append(l.sealed[:i], l.sealed[i+1:]...)
+			break
+		}
+	}
+	l.stats.Replayed += int64(traces)
+}
+
+// deadLetter appends traces, as a record, to the dead-letter file of segment
+// seq.
+func (l *Log) deadLetter(seq uint64, traces []model.Trace) error {
+	record, err := l.encode(traces)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path(seq)+deadExt, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(record)
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.DeadLettered += int64(len(traces))
+	return nil
+}
+
+// errCorrupt reports a segment with records that are truncated or fail
+// their checksum.
+var errCorrupt = errors.New("corrupt record")
+
+// read calls fn with each intact record of segment seq in order, along with
+// its position among them, stopping at the first error fn returns. An
+// unreadable stretch, such as a torn or corrupted record, is skipped up to the
+// next intact record, and read returns errCorrupt once every intact record
+// was delivered. Segments are bounded by SegmentBytes and read whole.
+func (l *Log) read(seq uint64, fn func(index int, traces []model.Trace) error) error {
+	data, err := os.ReadFile(l.path(seq))
+	if err != nil {
+		return err
+	}
+
+	corrupt := false
+	for off, index := 0, 0; off < len(data); {
+		payload, n, ok := frame(data[off:])
+		if !ok {
+			corrupt = true
+			l.countCorrupt()
+			off = resync(data, off)
+			continue
+		}
+		off += n
+		payload, err := l.decrypt(payload)
+		if err != nil {
+			return err
+		}
+		var traces []model.Trace
+		if err := json.Unmarshal(payload, &traces); err != nil {
+			corrupt = true
+			l.countCorrupt()
+			continue
+		}
+		if err := fn(index, traces); err != nil {
+			return err
+		}
+		index++
+	}
+	if corrupt {
+		return errCorrupt
+	}
+	return nil
+}
+
+// frame returns the payload of the record at the start of b and the record's
+// length, or false if b does not start with an intact record.
+func frame(b []byte) (payload []byte, n int, ok bool) {
+	if len(b) < headerSize {
+		return nil, 0, false
+	}
+	size := binary.BigEndian.Uint32(b[0:4])
+	if size == 0 || size > maxRecordBytes || int64(size) > int64(len(b)-headerSize) {
+		return nil, 0, false
+	}
+	payload = b[headerSize : headerSize+int(size)]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(b[4:8]) {
+		return nil, 0, false
+	}
+	return payload, headerSize + int(size), true
+}
+
+// resync returns the offset of the first intact record after the unreadable
+// one at off, or len(data) if there is none. The end of the unreadable
+// record, going by its length, is tried first: a damaged payload usually
+// leaves the header intact.
+func resync(data []byte, off int) int {
+	if len(data)-off >= headerSize {
+		next := off + headerSize + int(binary.BigEndian.Uint32(data[off:off+4]))
+		if next <= len(data) {
+			if _, _, ok := frame(data[next:]); ok || next == len(data) {
+				return next
+			}
+		}
+	}
+	for next := off + 1; next < len(data); next++ {
+		if _, _, ok := frame(data[next:]); ok {
+			return next
+		}
+	}
+	return len(data)
+}
+
+// decrypt opens an encrypted record. A record that cannot be opened, e.g.
+// because encryption was disabled or its key removed, fails the replay rather
+// than being skipped, so the segment is kept until the key is restored.
+func (l *Log) decrypt(payload []byte) ([]byte, error) {
+	if !encryption.IsEncrypted(string(payload)) {
+		return payload, nil
+	}
+	if l.opts.Cipher == nil {
+		return nil, errors.New("record is encrypted but no cipher is configured")
+	}
+	plaintext, err := l.opts.Cipher.Decrypt(string(payload))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt record: %w", err)
+	}
+	return []byte(plaintext), nil
+}
+
+func (l *Log) countCorrupt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.Corrupt++
+}
+
+func (l *Log) path(seq uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	base, ok := strings.CutSuffix(name, segmentExt)
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(base, 10, 64)
+	return seq, err == nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,315 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/zkropotkine/agent-trace/internal/encryption"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func traces(ids ...string) []model.Trace {
+	out := make([]model.Trace, len(ids))
+	for i, id := range ids {
+		out[i] = model.Trace{ID: primitive.NewObjectID(), TraceID: id, AgentName: "agent", InputPrompt: "prompt " + id}
+	}
+	return out
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SyncPolicy
+		wantErr bool
+	}{
+		{in: "always", want: SyncAlways},
+		{in: "Interval", want: SyncInterval},
+		{in: "never", want: SyncNever},
+		{in: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSyncPolicy(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLog_RecoversAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{Sync: SyncAlways})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	batch := traces("b", "c")
+	require.NoError(t, l.Append(batch))
+	// The process dies here: the log is never closed.
+
+	recovered, err := Open(dir, Options{})
+	require.NoError(t, err)
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+
+	assert.Equal(t, []string{"a", "b", "c"}, repo.ids())
+	assert.Equal(t, "prompt b", repo.traces[batch[0].ID].InputPrompt)
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.Equal(t, int64(3), recovered.Stats().Replayed)
+}
+
+func TestLog_SkipsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Append(traces("b")))
+	require.NoError(t, l.Close())
+
+	// Simulate a crash halfway through writing the second record.
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()-5))
+
+	recovered, err := Open(dir, Options{})
+	require.NoError(t, err)
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+
+	assert.Equal(t, []string{"a"}, repo.ids())
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.FileExists(t, files[0]+corruptExt)
+	assert.Equal(t, int64(1), recovered.Stats().Corrupt)
+}
+
+func TestLog_SkipsRecordFailingChecksum(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Append(traces("b")))
+	require.NoError(t, l.Append(traces("c")))
+	require.NoError(t, l.Close())
+
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	// The records are the same size; flip a payload byte of the second.
+	recordSize := len(data) / 3
+	data[recordSize+headerSize+2] ^= 0xff
+	require.NoError(t, os.WriteFile(files[0], data, 0o600))
+
+	recovered, err := Open(dir, Options{})
+	require.NoError(t, err)
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+
+	assert.Equal(t, []string{"a", "c"}, repo.ids(), "records after the corrupt one are replayed")
+	assert.Empty(t, segmentFiles(t, dir))
+	assert.FileExists(t, files[0]+corruptExt)
+	assert.Equal(t, int64(1), recovered.Stats().Corrupt)
+}
+
+func TestLog_ResyncsAfterCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Append(traces("b")))
+	require.NoError(t, l.Append(traces("c")))
+	require.NoError(t, l.Close())
+
+	files := segmentFiles(t, dir)
+	require.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	// Garble the length of the second record, so its end is unknown.
+	recordSize := len(data) / 3
+	copy(data[recordSize:], []byte{0x7f, 0xff, 0x00, 0x01})
+	require.NoError(t, os.WriteFile(files[0], data, 0o600))
+
+	recovered, err := Open(dir, Options{})
+	require.NoError(t, err)
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+
+	assert.Equal(t, []string{"a", "c"}, repo.ids())
+	assert.Equal(t, int64(1), recovered.Stats().Corrupt)
+}
+
+func TestLog_RotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{SegmentBytes: 64, Sync: SyncNever})
+	require.NoError(t, err)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, l.Append(traces(id)))
+	}
+	assert.Len(t, segmentFiles(t, dir), 4)
+
+	stats := l.Stats()
+	assert.Equal(t, 4, stats.Segments)
+	assert.Equal(t, int64(4), stats.Appended)
+	assert.Positive(t, stats.Bytes)
+
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(l, repo, ReplayOptions{}).Replay(context.Background()))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, repo.ids())
+	assert.Empty(t, segmentFiles(t, dir))
+
+	// Appends after a replay start a fresh segment.
+	require.NoError(t, l.Append(traces("e")))
+	assert.Len(t, segmentFiles(t, dir), 1)
+}
+
+func TestLog_AppendAfterClose(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	assert.ErrorIs(t, l.Append(traces("a")), ErrClosed)
+}
+
+func TestLog_SegmentsArePrivate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+
+	info, err := os.Stat(segmentFiles(t, dir)[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestLog_EncryptsRecords(t *testing.T) {
+	key := make([]byte, 32)
+	keyring, err := encryption.NewKeyring("k1", map[string][]byte{"k1": key})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	l, err := Open(dir, Options{Cipher: keyring})
+	require.NoError(t, err)
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(segmentFiles(t, dir)[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "prompt a")
+
+	t.Run("records are kept while they cannot be decrypted", func(t *testing.T) {
+		noKey, err := Open(dir, Options{})
+		require.NoError(t, err)
+		assert.ErrorContains(t, NewReplayer(noKey, newMemRepo(), ReplayOptions{}).Replay(context.Background()), "encrypted")
+		assert.Len(t, segmentFiles(t, dir), 1)
+		assert.Zero(t, noKey.Stats().Corrupt)
+	})
+
+	t.Run("records are replayed in clear", func(t *testing.T) {
+		recovered, err := Open(dir, Options{Cipher: keyring})
+		require.NoError(t, err)
+		repo := newMemRepo()
+		require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+		require.Len(t, repo.traces, 1)
+		for _, trace := range repo.traces {
+			assert.Equal(t, "prompt a", trace.InputPrompt)
+		}
+	})
+}
+
+// shortWriter writes only the first n bytes of the next write, then fails.
+type shortWriter struct {
+	segment
+	n int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if w.n < 0 {
+		return w.segment.Write(p)
+	}
+	n, _ := w.segment.Write(p[:w.n])
+	w.n = -1
+	return n, errors.New("no space left on device")
+}
+
+func TestLog_DiscardsPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	require.NoError(t, err)
+	l.open = func(path string) (segment, error) {
+		f, err := openSegment(path)
+		return &shortWriter{segment: f, n: -1}, err
+	}
+	require.NoError(t, l.Append(traces("a")))
+
+	l.active.(*shortWriter).n = 10
+	assert.Error(t, l.Append(traces("b")))
+	require.NoError(t, l.Append(traces("c")))
+	require.NoError(t, l.Close())
+
+	recovered, err := Open(dir, Options{})
+	require.NoError(t, err)
+	repo := newMemRepo()
+	require.NoError(t, NewReplayer(recovered, repo, ReplayOptions{}).Replay(context.Background()))
+
+	assert.Equal(t, []string{"a", "c"}, repo.ids(), "the record after the failed write is not lost")
+	assert.Zero(t, recovered.Stats().Corrupt)
+}
+
+// syncCounter counts the fsyncs of a segment.
+type syncCounter struct {
+	segment
+	mu    sync.Mutex
+	syncs int
+}
+
+func (c *syncCounter) Sync() error {
+	c.mu.Lock()
+	c.syncs++
+	c.mu.Unlock()
+	return c.segment.Sync()
+}
+
+func (c *syncCounter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.syncs
+}
+
+func TestLog_SyncsIdleLogAtTheEndOfTheInterval(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{Sync: SyncInterval, SyncInterval: 20 * time.Millisecond})
+	require.NoError(t, err)
+	var seg *syncCounter
+	l.open = func(path string) (segment, error) {
+		f, err := openSegment(path)
+		seg = &syncCounter{segment: f}
+		return seg, err
+	}
+
+	require.NoError(t, l.Append(traces("a")))
+	require.NoError(t, l.Append(traces("b")))
+	assert.Equal(t, 1, seg.count(), "the second append falls within the interval")
+
+	// No further append comes, yet the second record is flushed.
+	assert.Eventually(t, func() bool { return seg.count() == 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, l.Close())
+}
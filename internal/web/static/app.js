@@ -0,0 +1,254 @@
+// AgentTrace dashboard. Plain JS, no build step; everything is read from /api.
+(function () {
+  "use strict";
+
+  const app = document.getElementById("app");
+
+  // h builds a DOM element. Text is always assigned through text nodes so
+  // trace content can never be interpreted as HTML.
+  function h(tag, attrs, ...children) {
+    const el = document.createElement(tag);
+    for (const [key, value] of Object.entries(attrs || {})) {
+      if (value === undefined || value === null || value === false) continue;
+      if (key.startsWith("on")) el.addEventListener(key.slice(2), value);
+      else el.setAttribute(key, value === true ? "" : value);
+    }
+    for (const child of children.flat()) {
+      if (child === undefined || child === null || child === false) continue;
+      el.appendChild(child instanceof Node ? child : document.createTextNode(String(child)));
+    }
+    return el;
+  }
+
+  function svg(tag, attrs, ...children) {
+    const el = document.createElementNS("http://www.w3.org/2000/svg", tag);
+    for (const [key, value] of Object.entries(attrs || {})) el.setAttribute(key, value);
+    for (const child of children.flat()) {
+      if (child === undefined || child === null) continue;
+      el.appendChild(child instanceof Node ? child : document.createTextNode(String(child)));
+    }
+    return el;
+  }
+
+  async function api(path, params) {
+    const query = new URLSearchParams();
+    for (const [key, value] of Object.entries(params || {})) {
+      if (value) query.set(key, value);
+    }
+    const qs = query.toString();
+    const resp = await fetch("/api" + path + (qs ? "?" + qs : ""));
+    const body = await resp.json().catch(() => ({}));
+    if (!resp.ok) throw new Error(body.error || resp.statusText);
+    return body;
+  }
+
+  function render(...nodes) {
+    app.replaceChildren(...nodes);
+  }
+
+  function fail(err) {
+    render(h("p", { class: "error-message" }, "Error: " + err.message));
+  }
+
+  const fmtTime = (ts) => (ts && !ts.startsWith("0001") ? new Date(ts).toLocaleString() : "—");
+  const fmtMs = (ms) => (ms >= 1000 ? (ms / 1000).toFixed(2) + " s" : Math.round(ms) + " ms");
+  // Mirrors model.IsFailure on the server.
+  const isError = (status) => ["error", "failed", "failure"].includes(status);
+  const status = (s) => h("span", { class: "status " + (s || "") }, s || "—");
+  const traceLink = (t) => (t.id ? h("a", { href: "#/traces/" + t.id }, t.trace_id || t.id) : t.trace_id);
+  const sessionLink = (sid) => (sid ? h("a", { href: "#/sessions/" + encodeURIComponent(sid) }, sid) : "—");
+
+  function toRFC3339(local) {
+    return local ? new Date(local).toISOString().replace(/\.\d{3}Z$/, "Z") : "";
+  }
+
+  function traceTable(traces) {
+    if (!traces.length) return h("p", { class: "empty" }, "No traces found.");
+    return h("table", {},
+      h("thead", {}, h("tr", {},
+        ["Time", "Trace", "Agent", "Status", "Latency", "Tokens", "Steps", "Session"].map((c) => h("th", {}, c)))),
+      h("tbody", {}, traces.map((t) => h("tr", {},
+        h("td", {}, fmtTime(t.timestamp)),
+        h("td", {}, traceLink(t)),
+        h("td", {}, h("a", { href: "#/traces?agent=" + encodeURIComponent(t.agent_name || "") }, t.agent_name || "—")),
+        h("td", {}, status(t.status)),
+        h("td", {}, fmtMs(t.latency_ms || 0)),
+        h("td", {}, (t.token_usage && t.token_usage.total) || 0),
+        h("td", {}, (t.substeps || []).length),
+        h("td", {}, sessionLink(t.session_id)),
+      ))));
+  }
+
+  async function listView(params) {
+    const form = h("form", { class: "filters", onsubmit: (e) => {
+      e.preventDefault();
+      const data = new FormData(form);
+      const query = new URLSearchParams();
+      for (const [key, value] of data.entries()) {
+        if (value) query.set(key, key === "from" || key === "to" ? toRFC3339(value) : value);
+      }
+      location.hash = "#/traces?" + query.toString();
+    } },
+      h("input", { name: "agent", placeholder: "Agent", value: params.agent || "" }),
+      h("select", { name: "status" },
+        ["", "success", "error"].map((s) => h("option", { value: s, selected: params.status === s }, s || "Any status"))),
+      h("input", { name: "session", placeholder: "Session", value: params.session || "" }),
+      h("input", { name: "from", type: "datetime-local", title: "From" }),
+      h("input", { name: "to", type: "datetime-local", title: "To" }),
+      h("input", { name: "limit", type: "number", min: "1", max: "500", value: params.limit || "50" }),
+      h("button", { type: "submit" }, "Filter"));
+
+    render(form, h("p", { class: "empty" }, "Loading…"));
+    const { traces } = await api("/traces", params);
+    render(form, traceTable(traces || []));
+  }
+
+  function waterfall(trace) {
+    const steps = (trace.substeps || []).filter((s) => s.start && !s.start.startsWith("0001"));
+    if (!steps.length) return h("p", { class: "empty" }, "No timed substeps.");
+
+    const starts = steps.map((s) => Date.parse(s.start));
+    const ends = steps.map((s) => Date.parse(s.end) || Date.parse(s.start));
+    const origin = Math.min(...starts);
+    const span = Math.max(1, Math.max(...ends) - origin);
+
+    return h("div", { class: "waterfall" }, steps.flatMap((step, i) => {
+      const left = ((starts[i] - origin) / span) * 100;
+      const width = Math.max(0.5, ((ends[i] - starts[i]) / span) * 100);
+      const row = h("div", { class: "row", onclick: () => row.classList.toggle("open") },
+        h("span", {}, step.name || "step " + (i + 1)),
+        h("div", { class: "track" },
+          h("div", { class: "bar " + (step.status || ""), style: `left:${left}%;width:${width}%`, title: step.status || "" })),
+        h("span", {}, fmtMs(ends[i] - starts[i])));
+      const details = h("div", { class: "details" },
+        h("dl", { class: "meta" },
+          h("dt", {}, "Status"), h("dd", {}, status(step.status)),
+          h("dt", {}, "Offset"), h("dd", {}, fmtMs(starts[i] - origin))),
+        h("h3", {}, "Input"), h("pre", {}, step.input || ""),
+        h("h3", {}, "Output"), h("pre", {}, step.output || ""));
+      return [row, details];
+    }));
+  }
+
+  async function detailView(id) {
+    render(h("p", { class: "empty" }, "Loading…"));
+    const t = await api("/traces/" + encodeURIComponent(id));
+    const usage = t.token_usage || {};
+    render(
+      h("div", { class: "card" },
+        h("h2", {}, "Trace " + (t.trace_id || t.id)),
+        h("dl", { class: "meta" },
+          h("dt", {}, "Agent"), h("dd", {}, t.agent_name || "—"),
+          h("dt", {}, "Status"), h("dd", {}, status(t.status)),
+          h("dt", {}, "Time"), h("dd", {}, fmtTime(t.timestamp)),
+          h("dt", {}, "Latency"), h("dd", {}, fmtMs(t.latency_ms || 0)),
+          h("dt", {}, "Tokens"), h("dd", {}, `${usage.input_tokens || 0} in / ${usage.output_tokens || 0} out / ${usage.total || 0} total`),
+          h("dt", {}, "Session"), h("dd", {}, sessionLink(t.session_id)))),
+      h("div", { class: "card" }, h("h2", {}, "Input"), h("pre", {}, t.input_prompt || "")),
+      h("div", { class: "card" }, h("h2", {}, "Output"), h("pre", {}, t.output || "")),
+      h("div", { class: "card" },
+        h("h2", {}, "Substeps ",
+          h("a", { href: "/api/traces/" + encodeURIComponent(id) + "/timeline?format=svg", target: "_blank" }, "(SVG)")),
+        waterfall(t)));
+  }
+
+  async function sessionView(sessionID) {
+    render(h("p", { class: "empty" }, "Loading…"));
+    const { traces } = await api("/traces", { session: sessionID, limit: "500" });
+    const list = (traces || []).slice().reverse();
+    const totalTokens = list.reduce((n, t) => n + ((t.token_usage && t.token_usage.total) || 0), 0);
+    const totalLatency = list.reduce((n, t) => n + (t.latency_ms || 0), 0);
+    const errors = list.filter((t) => isError(t.status)).length;
+
+    render(
+      h("div", { class: "card" },
+        h("h2", {}, "Session " + sessionID),
+        h("dl", { class: "meta" },
+          h("dt", {}, "Traces"), h("dd", {}, list.length),
+          h("dt", {}, "Errors"), h("dd", {}, errors),
+          h("dt", {}, "Total latency"), h("dd", {}, fmtMs(totalLatency)),
+          h("dt", {}, "Total tokens"), h("dd", {}, totalTokens))),
+      traceTable(list));
+  }
+
+  function barChart(title, rows, format) {
+    const width = 400, height = 200, pad = 30;
+    const max = Math.max(1, ...rows.map((r) => r.value));
+    const barWidth = rows.length ? (width - pad) / rows.length : 0;
+    return h("div", { class: "card chart" }, h("h2", {}, title),
+      svg("svg", { viewBox: `0 0 ${width} ${height}`, preserveAspectRatio: "none" },
+        rows.map((r, i) => {
+          const barHeight = ((height - pad) * r.value) / max;
+          const x = pad + i * barWidth;
+          return svg("g", {},
+            svg("rect", { x: x + 2, y: height - pad - barHeight, width: Math.max(1, barWidth - 4), height: barHeight,
+              fill: r.color || "#3b6fd8" }, svg("title", {}, `${r.label}: ${format(r.value)}`)),
+            svg("text", { x: x + barWidth / 2, y: height - pad + 12, "text-anchor": "middle" }, r.label.slice(0, 12)));
+        }),
+        svg("text", { x: 0, y: 10 }, format(max))));
+  }
+
+  function lineChart(title, points, format) {
+    const width = 400, height = 200, pad = 30;
+    if (!points.length) return h("div", { class: "card chart" }, h("h2", {}, title), h("p", { class: "empty" }, "No data."));
+    const xs = points.map((p) => p.x), ys = points.map((p) => p.y);
+    const minX = Math.min(...xs), spanX = Math.max(1, Math.max(...xs) - minX), maxY = Math.max(1, ...ys);
+    const coords = points.map((p) => [
+      pad + ((p.x - minX) / spanX) * (width - pad - 4),
+      height - pad - (p.y / maxY) * (height - pad - 4),
+    ]);
+    return h("div", { class: "card chart" }, h("h2", {}, title),
+      svg("svg", { viewBox: `0 0 ${width} ${height}`, preserveAspectRatio: "none" },
+        svg("polyline", { points: coords.map((c) => c.join(",")).join(" "), fill: "none", stroke: "#3b6fd8", "stroke-width": 1.5 }),
+        coords.map((c, i) => svg("circle", { cx: c[0], cy: c[1], r: 2.5, fill: isError(points[i].status) ? "#d0443a" : "#3b6fd8" })),
+        svg("text", { x: 0, y: 10 }, format(maxY)),
+        svg("text", { x: pad, y: height - 8 }, new Date(minX).toLocaleTimeString()),
+        svg("text", { x: width - 4, y: height - 8, "text-anchor": "end" }, new Date(minX + spanX).toLocaleTimeString())));
+  }
+
+  async function metricsView(params) {
+    render(h("p", { class: "empty" }, "Loading…"));
+    const { traces } = await api("/traces", { agent: params.agent, limit: "500" });
+    const list = traces || [];
+
+    const byAgent = {};
+    for (const t of list) {
+      const a = (byAgent[t.agent_name || "unknown"] ||= { count: 0, errors: 0, tokens: 0 });
+      a.count++;
+      a.tokens += (t.token_usage && t.token_usage.total) || 0;
+      if (isError(t.status)) a.errors++;
+    }
+    const agents = Object.keys(byAgent).sort();
+
+    render(
+      h("p", {}, `Based on the latest ${list.length} traces` + (params.agent ? ` for ${params.agent}` : "") + "."),
+      h("div", { class: "charts" },
+        lineChart("Latency over time",
+          list.map((t) => ({ x: Date.parse(t.timestamp), y: t.latency_ms || 0, status: t.status })).sort((a, b) => a.x - b.x),
+          fmtMs),
+        barChart("Traces per agent", agents.map((a) => ({ label: a, value: byAgent[a].count })), String),
+        barChart("Error rate per agent",
+          agents.map((a) => ({ label: a, value: byAgent[a].errors / byAgent[a].count, color: "#d0443a" })),
+          (v) => (v * 100).toFixed(1) + "%"),
+        barChart("Avg tokens per trace",
+          agents.map((a) => ({ label: a, value: byAgent[a].tokens / byAgent[a].count })),
+          (v) => Math.round(v).toString())));
+  }
+
+  function route() {
+    const [path, qs] = (location.hash.slice(1) || "/traces").split("?");
+    const params = Object.fromEntries(new URLSearchParams(qs || ""));
+    const parts = path.split("/").filter(Boolean).map(decodeURIComponent);
+
+    let view;
+    if (parts[0] === "traces" && parts[1]) view = detailView(parts[1]);
+    else if (parts[0] === "sessions" && parts[1]) view = sessionView(parts[1]);
+    else if (parts[0] === "metrics") view = metricsView(params);
+    else view = listView(params);
+
+    view.catch(fail);
+  }
+
+  window.addEventListener("hashchange", route);
+  route();
+})();
@@ -0,0 +1,20 @@
+// Package web embeds the static dashboard served by the API binary.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var static embed.FS
+
+// Assets returns the dashboard files rooted at the static directory.
+func Assets() fs.FS {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	return assets
+}
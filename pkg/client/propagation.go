@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/zkropotkine/agent-trace/internal/traceparent"
+)
+
+// Inject writes a W3C traceparent header identifying the trace and innermost
+// step carried by ctx, so a downstream service can attach its spans to them.
+// It does nothing when ctx carries no trace.
+func Inject(ctx context.Context, header http.Header) {
+	ref, _ := ctx.Value(ctxKey{}).(spanRef)
+	if ref.trace == nil {
+		return
+	}
+
+	spanID := ref.trace.spanID
+	if ref.step != nil {
+		spanID = ref.step.data.SpanID
+	}
+
+	tc := traceparent.Context{TraceID: ref.trace.ID(), ParentID: spanID}
+	if tc.Valid() {
+		header.Set(traceparent.Header, traceparent.Format(tc))
+	}
+}
+
+// Extract returns a context carrying the caller described by the traceparent
+// header. Traces started from it with StartTrace join the caller's trace.
+// A missing or malformed header leaves ctx unchanged.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	tc, err := traceparent.Parse(header.Get(traceparent.Header))
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, ctxKey{}, spanRef{remote: tc})
+}
+
+// Middleware extracts the caller's trace context from incoming requests so
+// handlers can call StartTrace with r.Context().
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(Extract(r.Context(), r.Header)))
+	})
+}
+
+// Transport is an http.RoundTripper that injects the trace context of each
+// request's context before sending it.
+type Transport struct {
+	// Base defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if TraceFromContext(req.Context()) == nil {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	Inject(req.Context(), req.Header)
+
+	return base.RoundTrip(req)
+}
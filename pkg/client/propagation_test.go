@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+func TestInjectExtract(t *testing.T) {
+	tracer := NewTracer(Config{Endpoint: "http://unused"})
+	t.Cleanup(func() { _ = tracer.Shutdown(context.Background()) })
+
+	ctx, trace := tracer.StartTrace(context.Background(), "caller")
+	stepCtx, step := StartStep(ctx, "CallTool")
+
+	header := http.Header{}
+	Inject(ctx, header)
+	assert.Equal(t, "00-"+trace.ID()+"-"+trace.spanID+"-01", header.Get("traceparent"))
+
+	Inject(stepCtx, header)
+	assert.Equal(t, "00-"+trace.ID()+"-"+step.data.SpanID+"-01", header.Get("traceparent"))
+
+	remoteCtx, remote := tracer.StartTrace(Extract(context.Background(), header), "callee")
+	assert.Equal(t, trace.ID(), remote.ID())
+	assert.Equal(t, step.data.SpanID, remote.data.ParentSpanID)
+
+	_, child := StartStep(remoteCtx, "Lookup")
+	assert.Equal(t, step.data.SpanID, child.data.ParentID)
+
+	t.Run("no trace leaves header untouched", func(t *testing.T) {
+		h := http.Header{}
+		Inject(context.Background(), h)
+		assert.Empty(t, h)
+	})
+
+	t.Run("malformed header starts a fresh trace", func(t *testing.T) {
+		h := http.Header{"Traceparent": []string{"garbage"}}
+		_, fresh := tracer.StartTrace(Extract(context.Background(), h), "callee")
+		assert.NotEqual(t, trace.ID(), fresh.ID())
+		assert.Empty(t, fresh.data.ParentSpanID)
+	})
+}
+
+func TestDistributedTrace_MergedByServer(t *testing.T) {
+	srv, repo := newTestServer(t, nil, handler.WithSpanMerging())
+
+	toolTracer := NewTracer(Config{Endpoint: srv.URL})
+	tool := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, trace := toolTracer.StartTrace(r.Context(), "ToolService")
+		_, step := StartStep(ctx, "Lookup")
+		step.AddTokenUsage(5, 1)
+		step.End()
+		trace.End()
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	t.Cleanup(tool.Close)
+
+	agentTracer := NewTracer(Config{Endpoint: srv.URL})
+	httpClient := &http.Client{Transport: &Transport{}}
+
+	ctx, trace := agentTracer.StartTrace(context.Background(), "Planner")
+	callCtx, call := StartStep(ctx, "CallTool")
+	req, err := http.NewRequestWithContext(callCtx, http.MethodGet, tool.URL, nil)
+	require.NoError(t, err)
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, req.Header.Get("traceparent"), "transport must not mutate the caller's request")
+	call.End()
+	trace.End()
+
+	require.NoError(t, toolTracer.Shutdown(context.Background()))
+	require.NoError(t, agentTracer.Shutdown(context.Background()))
+
+	traces, _ := repo.snapshot()
+	require.Len(t, traces, 1)
+	merged := traces[0]
+	assert.Equal(t, trace.ID(), merged.TraceID)
+	assert.Equal(t, "Planner", merged.AgentName)
+	assert.Empty(t, merged.ParentSpanID)
+	assert.ElementsMatch(t, []string{"Planner", "ToolService"}, merged.Services)
+
+	steps := map[string]model.SubStep{}
+	for _, s := range merged.SubSteps {
+		steps[s.Name] = s
+	}
+	require.Len(t, steps, 2)
+	assert.Equal(t, steps["CallTool"].SpanID, steps["Lookup"].ParentID)
+}
@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/traceparent"
+)
+
+const (
+	statusSuccess = "success"
+	statusError   = "error"
+)
+
+type ctxKey struct{}
+
+// spanRef is stored in the context: the active trace and, inside a step, the
+// innermost open step. Before a trace starts it may hold only the remote
+// caller extracted from an incoming request.
+type spanRef struct {
+	trace  *Trace
+	step   *Step
+	remote traceparent.Context
+}
+
+// Trace is a single agent run. It is safe for concurrent use; steps may be
+// started from multiple goroutines.
+type Trace struct {
+	tracer *Tracer
+	start  time.Time
+	// spanID identifies the trace itself when it is propagated outside any step.
+	spanID string
+
+	mu    sync.Mutex
+	data  model.Trace
+	ended bool
+}
+
+// StartTrace begins a trace for agentName and returns a context carrying it;
+// pass that context to StartStep. If ctx carries a remote caller (see Extract),
+// the trace joins the caller's trace and its top-level steps are recorded as
+// children of the calling span.
+func (t *Tracer) StartTrace(ctx context.Context, agentName string) (context.Context, *Trace) {
+	now := time.Now()
+	trace := &Trace{
+		tracer: t,
+		start:  now,
+		spanID: newID(8),
+		data: model.Trace{
+			TraceID:   newID(16),
+			AgentName: agentName,
+			CreatedAt: now,
+			SubSteps:  []model.SubStep{},
+		},
+	}
+
+	if ref, _ := ctx.Value(ctxKey{}).(spanRef); ref.trace == nil && ref.remote.Valid() {
+		trace.data.TraceID = ref.remote.TraceID
+		trace.data.ParentSpanID = ref.remote.ParentID
+	}
+
+	return context.WithValue(ctx, ctxKey{}, spanRef{trace: trace}), trace
+}
+
+// TraceFromContext returns the trace carried by ctx, or nil.
+func TraceFromContext(ctx context.Context) *Trace {
+	ref, _ := ctx.Value(ctxKey{}).(spanRef)
+	return ref.trace
+}
+
+// ID returns the trace ID.
+func (tr *Trace) ID() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return tr.data.TraceID
+}
+
+func (tr *Trace) SetSessionID(id string) {
+	tr.update(func(d *model.Trace) { d.SessionID = id })
+}
+
+func (tr *Trace) SetInput(prompt string) {
+	tr.update(func(d *model.Trace) { d.InputPrompt = prompt })
+}
+
+func (tr *Trace) SetOutput(output string) {
+	tr.update(func(d *model.Trace) { d.Output = output })
+}
+
+// SetMetadata attaches a key/value attribute, such as a customer tier or git
+// SHA, that GetTraces can filter on.
+func (tr *Trace) SetMetadata(key, value string) {
+	tr.update(func(d *model.Trace) { d.Metadata = setMetadata(d.Metadata, key, value) })
+}
+
+func (tr *Trace) AddTags(tags ...string) {
+	tr.update(func(d *model.Trace) { d.Tags = append(d.Tags, tags...) })
+}
+
+// AddTokenUsage adds to the trace's token counters. Token usage recorded on
+// steps is added automatically.
+func (tr *Trace) AddTokenUsage(input, output int) {
+	tr.update(func(d *model.Trace) { addTokens(&d.TokenUsage, input, output) })
+}
+
+// SetError marks the trace as failed. A nil error is ignored.
+func (tr *Trace) SetError(err error) {
+	if err == nil {
+		return
+	}
+	tr.update(func(d *model.Trace) {
+		d.Status = statusError
+		d.Error = err.Error()
+	})
+}
+
+// End finishes the trace and queues it for delivery. Steps that end later are
+// not recorded. Calling End more than once has no effect.
+func (tr *Trace) End() {
+	tr.mu.Lock()
+	if tr.ended {
+		tr.mu.Unlock()
+		return
+	}
+	tr.ended = true
+	tr.data.LatencyMS = int(time.Since(tr.start).Milliseconds())
+	if tr.data.Status == "" {
+		tr.data.Status = statusSuccess
+	}
+	data := tr.data
+	data.SubSteps = append([]model.SubStep(nil), tr.data.SubSteps...)
+	tr.mu.Unlock()
+
+	tr.tracer.enqueue(data)
+}
+
+func (tr *Trace) update(fn func(*model.Trace)) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if !tr.ended {
+		fn(&tr.data)
+	}
+}
+
+// Step is a unit of work within a trace, such as a retrieval, tool call or
+// LLM request. Steps started from a context that carries no trace are no-ops.
+type Step struct {
+	trace *Trace
+	data  model.SubStep
+	ended bool
+}
+
+// StartStep opens a step under the trace or step carried by ctx and returns a
+// context in which further steps become its children.
+func StartStep(ctx context.Context, name string) (context.Context, *Step) {
+	ref, _ := ctx.Value(ctxKey{}).(spanRef)
+	if ref.trace == nil {
+		return ctx, &Step{}
+	}
+
+	step := &Step{
+		trace: ref.trace,
+		data: model.SubStep{
+			Name:   name,
+			SpanID: newID(8),
+			Start:  time.Now(),
+		},
+	}
+	if ref.step != nil {
+		step.data.ParentID = ref.step.data.SpanID
+	} else {
+		step.data.ParentID = ref.trace.data.ParentSpanID
+	}
+
+	return context.WithValue(ctx, ctxKey{}, spanRef{trace: ref.trace, step: step}), step
+}
+
+// StepFromContext returns the innermost step carried by ctx, or nil.
+func StepFromContext(ctx context.Context) *Step {
+	ref, _ := ctx.Value(ctxKey{}).(spanRef)
+	return ref.step
+}
+
+func (s *Step) SetInput(input string) {
+	s.update(func(d *model.SubStep) { d.Input = input })
+}
+
+func (s *Step) SetOutput(output string) {
+	s.update(func(d *model.SubStep) { d.Output = output })
+}
+
+func (s *Step) SetMetadata(key, value string) {
+	s.update(func(d *model.SubStep) { d.Metadata = setMetadata(d.Metadata, key, value) })
+}
+
+func (s *Step) AddTags(tags ...string) {
+	s.update(func(d *model.SubStep) { d.Tags = append(d.Tags, tags...) })
+}
+
+// AddTokenUsage records tokens consumed by this step and adds them to the trace.
+func (s *Step) AddTokenUsage(input, output int) {
+	if s.trace == nil {
+		return
+	}
+	s.update(func(d *model.SubStep) {
+		if d.TokenUsage == nil {
+			d.TokenUsage = &model.TokenUsage{}
+		}
+		addTokens(d.TokenUsage, input, output)
+	})
+	s.trace.AddTokenUsage(input, output)
+}
+
+// SetError marks the step as failed. A nil error is ignored.
+func (s *Step) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.update(func(d *model.SubStep) {
+		d.Status = statusError
+		d.Error = err.Error()
+	})
+}
+
+// End finishes the step and records it on its trace.
+func (s *Step) End() {
+	if s.trace == nil {
+		return
+	}
+
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+
+	if s.ended || s.trace.ended {
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if s.data.Status == "" {
+		s.data.Status = statusSuccess
+	}
+	s.trace.data.SubSteps = append(s.trace.data.SubSteps, s.data)
+}
+
+func (s *Step) update(fn func(*model.SubStep)) {
+	if s.trace == nil {
+		return
+	}
+
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+
+	if !s.ended {
+		fn(&s.data)
+	}
+}
+
+func setMetadata(m map[string]string, key, value string) map[string]string {
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[key] = value
+	return m
+}
+
+func addTokens(u *model.TokenUsage, input, output int) {
+	u.Input += input
+	u.Output += output
+	u.Total = u.Input + u.Output
+}
+
+// newID returns n random bytes hex-encoded, matching W3C trace (16) and span (8) ID sizes.
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
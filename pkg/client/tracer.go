@@ -0,0 +1,316 @@
+// Package client instruments agents and ships their traces to the AgentTrace API.
+//
+//	tracer := client.NewTracer(client.Config{Endpoint: "http://localhost:8080"})
+//	defer tracer.Shutdown(context.Background())
+//
+//	ctx, trace := tracer.StartTrace(ctx, "DocumentAgent")
+//	trace.SetInput(prompt)
+//	ctx, step := client.StartStep(ctx, "Retriever")
+//	...
+//	step.End()
+//	trace.SetOutput(answer)
+//	trace.End()
+//
+// Finished traces are queued in memory and flushed asynchronously in batches.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zkropotkine/agent-trace/internal/model"
+)
+
+var (
+	// ErrQueueFull is reported through Config.OnError when a finished trace is
+	// dropped because the in-memory queue is full.
+	ErrQueueFull = errors.New("agenttrace: queue full, trace dropped")
+	// ErrShutdown is reported when a trace ends after Shutdown was called.
+	ErrShutdown = errors.New("agenttrace: tracer is shut down")
+)
+
+// Config controls how traces are delivered. Zero values use the defaults noted
+// on each field.
+type Config struct {
+	// Endpoint is the base URL of the AgentTrace API, e.g. http://localhost:8080.
+	Endpoint string
+	// APIKey identifies the project to the server's rate limiter. Without
+	// it, requests are limited by client IP.
+	APIKey string
+	// HTTPClient defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// BatchSize is the maximum number of traces per request (default 50).
+	BatchSize int
+	// FlushInterval is how long a partial batch waits before it is sent (default 1s).
+	FlushInterval time.Duration
+	// MaxQueue bounds the number of finished traces held in memory (default 1000).
+	MaxQueue int
+	// MaxRetries is the number of retries for failed requests (default 3).
+	MaxRetries int
+	// RetryBackoff is the initial delay between retries, doubled each time (default 200ms).
+	RetryBackoff time.Duration
+	// OnError is called with delivery errors and dropped traces. It must not block.
+	OnError func(error)
+}
+
+func (c Config) withDefaults() Config {
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 50
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxQueue <= 0 {
+		c.MaxQueue = 1000
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 200 * time.Millisecond
+	}
+	if c.OnError == nil {
+		c.OnError = func(error) {}
+	}
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+
+	return c
+}
+
+// Tracer creates traces and delivers them in the background.
+type Tracer struct {
+	cfg Config
+
+	queue    chan model.Trace
+	flushReq chan chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+
+	// sendCtx is cancelled when Shutdown gives up so retries stop promptly.
+	sendCtx    context.Context
+	cancelSend context.CancelFunc
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+}
+
+// NewTracer starts a Tracer. Call Shutdown to flush pending traces before exit.
+func NewTracer(cfg Config) *Tracer {
+	cfg = cfg.withDefaults()
+	sendCtx, cancel := context.WithCancel(context.Background())
+
+	t := &Tracer{
+		cfg:        cfg,
+		queue:      make(chan model.Trace, cfg.MaxQueue),
+		flushReq:   make(chan chan struct{}),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+		sendCtx:    sendCtx,
+		cancelSend: cancel,
+	}
+	go t.loop()
+
+	return t
+}
+
+// Dropped returns the number of traces discarded because the queue was full
+// or delivery failed after all retries.
+func (t *Tracer) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+// Flush blocks until every trace queued before the call has been sent.
+func (t *Tracer) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case t.flushReq <- ack:
+	case <-t.done:
+		return ErrShutdown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting traces and flushes the queue. If ctx expires first,
+// pending deliveries are abandoned and ctx.Err() is returned.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.quit)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		t.cancelSend()
+		<-t.done
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) enqueue(trace model.Trace) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.closed {
+		t.dropped.Add(1)
+		t.cfg.OnError(ErrShutdown)
+		return
+	}
+
+	select {
+	case t.queue <- trace:
+	default:
+		t.dropped.Add(1)
+		t.cfg.OnError(ErrQueueFull)
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	defer t.cancelSend()
+
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]model.Trace, 0, t.cfg.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			t.send(batch)
+			batch = make([]model.Trace, 0, t.cfg.BatchSize)
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case trace := <-t.queue:
+				batch = append(batch, trace)
+				if len(batch) >= t.cfg.BatchSize {
+					flush()
+				}
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case trace := <-t.queue:
+			batch = append(batch, trace)
+			if len(batch) >= t.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case ack := <-t.flushReq:
+			drain()
+			close(ack)
+		case <-t.quit:
+			drain()
+			return
+		}
+	}
+}
+
+// send posts a batch, retrying network errors, 429 and 5xx responses. A
+// Retry-After header replaces the backoff for that attempt.
+func (t *Tracer) send(batch []model.Trace) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.fail(len(batch), err)
+		return
+	}
+
+	delay := t.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := t.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= t.cfg.MaxRetries {
+			t.fail(len(batch), err)
+			return
+		}
+
+		if wait <= 0 {
+			wait = delay
+		}
+		select {
+		case <-t.sendCtx.Done():
+			t.fail(len(batch), t.sendCtx.Err())
+			return
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// post sends one batch. wait is the delay the server asked for with
+// Retry-After, zero if it did not.
+func (t *Tracer) post(body []byte) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequestWithContext(t.sendCtx, http.MethodPost, t.cfg.Endpoint+"/api/traces/batch", bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", t.cfg.APIKey)
+	}
+
+	resp, err := t.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return t.sendCtx.Err() == nil, 0, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, retryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("agenttrace: server responded %d", resp.StatusCode)
+	}
+
+	return false, 0, fmt.Errorf("agenttrace: server rejected batch with status %d", resp.StatusCode)
+}
+
+// retryAfter parses a Retry-After header given in seconds, returning zero if
+// it is absent or not a number of seconds.
+func retryAfter(header string) time.Duration {
+	secs, err := strconv.Atoi(header)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func (t *Tracer) fail(n int, err error) {
+	t.dropped.Add(int64(n))
+	t.cfg.OnError(err)
+}
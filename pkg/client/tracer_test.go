@@ -0,0 +1,353 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zkropotkine/agent-trace/internal/handler"
+	"github.com/zkropotkine/agent-trace/internal/model"
+	"github.com/zkropotkine/agent-trace/internal/repository"
+	"github.com/zkropotkine/agent-trace/internal/router"
+)
+
+type memoryRepo struct {
+	repository.TraceRepository
+	mu      sync.Mutex
+	traces  []model.Trace
+	batches int
+}
+
+func (m *memoryRepo) InsertTrace(_ context.Context, trace model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.traces = append(m.traces, trace)
+	return nil
+}
+
+func (m *memoryRepo) InsertTraces(_ context.Context, traces []model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	m.traces = append(m.traces, traces...)
+	return nil
+}
+
+// MergeTraces folds fragments by trace ID, mimicking the Mongo upsert closely
+// enough for end-to-end propagation tests.
+func (m *memoryRepo) MergeTraces(_ context.Context, traces []model.Trace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+
+	for _, fragment := range traces {
+		merged := false
+		for i := range m.traces {
+			stored := &m.traces[i]
+			if stored.TraceID != fragment.TraceID {
+				continue
+			}
+			stored.SubSteps = append(stored.SubSteps, fragment.SubSteps...)
+			stored.Services = append(stored.Services, fragment.AgentName)
+			if fragment.ParentSpanID == "" {
+				stored.AgentName = fragment.AgentName
+				stored.ParentSpanID = ""
+			}
+			merged = true
+		}
+		if !merged {
+			fragment.Services = []string{fragment.AgentName}
+			m.traces = append(m.traces, fragment)
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) snapshot() ([]model.Trace, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.Trace(nil), m.traces...), m.batches
+}
+
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler, opts ...handler.TraceHandlerOption) (*httptest.Server, *memoryRepo) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	repo := &memoryRepo{}
+	engine := gin.New()
+	router.RegisterRoutes(engine, router.RouteRegistry{TraceHandler: handler.NewTraceHandler(repo, opts...)})
+
+	var h http.Handler = engine
+	if wrap != nil {
+		h = wrap(engine)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return srv, repo
+}
+
+func TestTracer_NestedStepsAndTokens(t *testing.T) {
+	srv, repo := newTestServer(t, nil)
+	tracer := NewTracer(Config{Endpoint: srv.URL, FlushInterval: time.Hour})
+
+	ctx, trace := tracer.StartTrace(context.Background(), "DocumentAgent")
+	trace.SetSessionID("sess-1")
+	trace.SetInput("summarize")
+	trace.SetMetadata("region", "eu")
+	trace.AddTags("beta")
+
+	ctx1, retriever := StartStep(ctx, "Retriever")
+	_, embed := StartStep(ctx1, "Embed")
+	embed.SetMetadata("model", "text-embedding-3")
+	embed.AddTags("cache-miss")
+	embed.AddTokenUsage(10, 0)
+	embed.End()
+	retriever.End()
+
+	_, llm := StartStep(ctx, "LLM")
+	llm.AddTokenUsage(100, 40)
+	llm.SetError(errors.New("rate limited"))
+	llm.End()
+
+	trace.SetOutput("summary")
+	trace.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	traces, _ := repo.snapshot()
+	require.Len(t, traces, 1)
+	got := traces[0]
+	assert.Equal(t, trace.ID(), got.TraceID)
+	assert.Equal(t, "DocumentAgent", got.AgentName)
+	assert.Equal(t, "sess-1", got.SessionID)
+	assert.Equal(t, "summarize", got.InputPrompt)
+	assert.Equal(t, "summary", got.Output)
+	assert.Equal(t, "success", got.Status)
+	assert.Equal(t, map[string]string{"region": "eu"}, got.Metadata)
+	assert.Equal(t, []string{"beta"}, got.Tags)
+	assert.Equal(t, model.TokenUsage{Input: 110, Output: 40, Total: 150}, got.TokenUsage)
+
+	require.Len(t, got.SubSteps, 3)
+	byName := map[string]model.SubStep{}
+	for _, s := range got.SubSteps {
+		byName[s.Name] = s
+	}
+	assert.Empty(t, byName["Retriever"].ParentID)
+	assert.Equal(t, byName["Retriever"].SpanID, byName["Embed"].ParentID)
+	assert.Equal(t, map[string]string{"model": "text-embedding-3"}, byName["Embed"].Metadata)
+	assert.Equal(t, []string{"cache-miss"}, byName["Embed"].Tags)
+	assert.Empty(t, byName["LLM"].ParentID)
+	assert.Equal(t, "error", byName["LLM"].Status)
+	assert.Equal(t, "rate limited", byName["LLM"].Error)
+	assert.Equal(t, &model.TokenUsage{Input: 100, Output: 40, Total: 140}, byName["LLM"].TokenUsage)
+}
+
+func TestTracer_TraceError(t *testing.T) {
+	srv, repo := newTestServer(t, nil)
+	tracer := NewTracer(Config{Endpoint: srv.URL})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.SetError(errors.New("boom"))
+	trace.End()
+	trace.End()
+
+	require.NoError(t, tracer.Flush(context.Background()))
+	traces, _ := repo.snapshot()
+	require.Len(t, traces, 1)
+	assert.Equal(t, "error", traces[0].Status)
+	assert.Equal(t, "boom", traces[0].Error)
+	require.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestTracer_Batching(t *testing.T) {
+	srv, repo := newTestServer(t, nil)
+	tracer := NewTracer(Config{Endpoint: srv.URL, BatchSize: 2, FlushInterval: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		_, trace := tracer.StartTrace(context.Background(), "agent")
+		trace.End()
+	}
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	traces, batches := repo.snapshot()
+	assert.Len(t, traces, 5)
+	assert.Equal(t, 3, batches)
+}
+
+func TestTracer_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv, repo := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	tracer := NewTracer(Config{Endpoint: srv.URL, RetryBackoff: time.Millisecond})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	traces, _ := repo.snapshot()
+	assert.Len(t, traces, 1)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Zero(t, tracer.Dropped())
+}
+
+func TestTracer_HonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	srv, repo := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	// The backoff alone would outlast the shutdown deadline.
+	tracer := NewTracer(Config{Endpoint: srv.URL, RetryBackoff: time.Hour})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.End()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tracer.Shutdown(ctx))
+
+	traces, _ := repo.snapshot()
+	assert.Len(t, traces, 1)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestTracer_SendsAPIKey(t *testing.T) {
+	var key atomic.Value
+	srv, repo := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key.Store(r.Header.Get("X-API-Key"))
+			next.ServeHTTP(w, r)
+		})
+	})
+	tracer := NewTracer(Config{Endpoint: srv.URL, APIKey: "team-a"})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	traces, _ := repo.snapshot()
+	assert.Len(t, traces, 1)
+	assert.Equal(t, "team-a", key.Load())
+}
+
+func TestTracer_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	var errs []error
+	srv, _ := newTestServer(t, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		})
+	})
+	tracer := NewTracer(Config{
+		Endpoint:     srv.URL,
+		RetryBackoff: time.Millisecond,
+		OnError:      func(err error) { errs = append(errs, err) },
+	})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, int64(1), tracer.Dropped())
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "400")
+}
+
+func TestTracer_BoundedQueueDrops(t *testing.T) {
+	release := make(chan struct{})
+	srv, repo := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	var queueFull atomic.Int32
+	tracer := NewTracer(Config{
+		Endpoint:      srv.URL,
+		BatchSize:     1,
+		MaxQueue:      1,
+		FlushInterval: time.Hour,
+		OnError: func(err error) {
+			if errors.Is(err, ErrQueueFull) {
+				queueFull.Add(1)
+			}
+		},
+	})
+
+	// The first trace is picked up by the worker, which blocks on the server;
+	// the second fills the queue and the rest are dropped.
+	_, first := tracer.StartTrace(context.Background(), "agent")
+	first.End()
+	require.Eventually(t, func() bool { return len(tracer.queue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 4; i++ {
+		_, trace := tracer.StartTrace(context.Background(), "agent")
+		trace.End()
+	}
+	close(release)
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	traces, _ := repo.snapshot()
+	assert.Len(t, traces, 2)
+	assert.Equal(t, int64(3), tracer.Dropped())
+	assert.Equal(t, int32(3), queueFull.Load())
+}
+
+func TestTracer_ShutdownTimeoutAbandonsRetries(t *testing.T) {
+	srv, _ := newTestServer(t, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	})
+	tracer := NewTracer(Config{Endpoint: srv.URL, MaxRetries: 10, RetryBackoff: time.Second})
+
+	_, trace := tracer.StartTrace(context.Background(), "agent")
+	trace.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tracer.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, int64(1), tracer.Dropped())
+
+	_, late := tracer.StartTrace(context.Background(), "agent")
+	late.End()
+	assert.Equal(t, int64(2), tracer.Dropped())
+	assert.ErrorIs(t, tracer.Flush(context.Background()), ErrShutdown)
+}
+
+func TestStartStep_WithoutTraceIsNoop(t *testing.T) {
+	ctx, step := StartStep(context.Background(), "orphan")
+	step.SetInput("x")
+	step.AddTokenUsage(1, 1)
+	step.SetError(errors.New("ignored"))
+	step.End()
+
+	assert.Nil(t, StepFromContext(ctx))
+	assert.Nil(t, TraceFromContext(ctx))
+}